@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/umwaribenie/final_user_management/models"
@@ -33,6 +34,30 @@ func (c *AuthController) CheckAuth(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
+// @Summary Introspect the current token
+// @Description Returns the caller's session details together with their current roles, groups and effective permissions, resolved at request time.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.IntrospectionResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /auth/introspect [get]
+func (c *AuthController) Introspect(ctx *gin.Context) {
+	response, err := c.authService.ForRequest(auditContext(ctx)).Introspect(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response.SessionID = ctx.GetString("sessionID")
+	response.ImpersonatorID = ctx.GetString("impersonatorID")
+	response.AMR = ctx.GetStringSlice("amr")
+	if authTime := ctx.GetTime("authTime"); !authTime.IsZero() {
+		response.AuthTime = &authTime
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
 // @Summary Confirm password reset OTP
 // @Description Confirms the OTP and sets a new password.
 // @Tags auth
@@ -48,7 +73,7 @@ func (c *AuthController) ConfirmPasswordResetOtp(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	response, err := c.authService.ConfirmPasswordResetOtp(request)
+	response, err := c.authService.ForRequest(auditContext(ctx)).ConfirmPasswordResetOtp(request, requestMeta(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
@@ -57,11 +82,12 @@ func (c *AuthController) ConfirmPasswordResetOtp(ctx *gin.Context) {
 }
 
 // @Summary Login a user
-// @Description Authenticates a user and returns a JWT access token.
+// @Description Authenticates a user and returns a JWT access token. Send the device token from a previous login in the X-Device-Token header (or device_token cookie) so the device is recognised; unrecognised devices receive a new one and trigger a sign-in notification email.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param loginData body models.LoginRequest true "Login credentials"
+// @Param X-Device-Token header string false "Signed device token from a previous login"
 // @Success 200 {object} models.LoginResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
@@ -72,14 +98,63 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	response, err := c.authService.Login(request)
+	response, err := c.authService.ForRequest(auditContext(ctx)).Login(request, requestMeta(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
 		return
 	}
+	if response.DeviceToken != "" {
+		// Remember this device for a year so future logins from it are recognised
+		ctx.SetSameSite(http.SameSiteLaxMode)
+		ctx.SetCookie(deviceTokenCookie, response.DeviceToken, 365*24*60*60, "/", "", ctx.Request.TLS != nil, true)
+	}
 	ctx.JSON(http.StatusOK, response)
 }
 
+// @Summary Confirm a suspicious login report
+// @Description Opened by the "this wasn't me" link in a new-device email. Shows a page asking the user to confirm, which submits the report with POST /auth/not-me; opening the link changes nothing.
+// @Tags auth
+// @Produce html
+// @Param token query string true "Security alert token from the email link"
+// @Success 200 {string} string "Confirmation page"
+// @Failure 400 {string} string "Error page"
+// @Router /auth/not-me [get]
+func (c *AuthController) ConfirmSuspiciousLogin(ctx *gin.Context) {
+	title := "Secure your account"
+	token := ctx.Query("token")
+	if token == "" {
+		renderLinkPage(ctx, http.StatusBadRequest, linkPageData{Title: title, Message: "This link is incomplete."})
+		return
+	}
+	renderLinkPage(ctx, http.StatusOK, linkPageData{
+		Title:   title,
+		Message: "If you did not just sign in, confirm below to sign your account out everywhere. We will email you a token to choose a new password.",
+		Action:  "not-me",
+		Token:   token,
+		Submit:  "Sign out everywhere",
+	})
+}
+
+// @Summary Report a suspicious login
+// @Description Submitted from the "this wasn't me" page: signs the user out of every session and emails a password reset token. Form submissions get a page in reply, JSON requests get JSON.
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce json,html
+// @Param report body models.ReportSuspiciousLoginRequest true "Security alert token from the email link"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Router /auth/not-me [post]
+func (c *AuthController) ReportSuspiciousLogin(ctx *gin.Context) {
+	title := "Secure your account"
+	var request models.ReportSuspiciousLoginRequest
+	if err := ctx.ShouldBind(&request); err != nil {
+		respondToLink(ctx, title, models.SuccessResponse{}, err)
+		return
+	}
+	response, err := c.authService.ForRequest(auditContext(ctx)).ReportSuspiciousLogin(request.Token, requestMeta(ctx))
+	respondToLink(ctx, title, response, err)
+}
+
 // @Summary Request password reset
 // @Description Sends a password reset OTP to the user's registered phone or email.
 // @Tags auth
@@ -95,7 +170,7 @@ func (c *AuthController) RequestPasswordReset(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	response, err := c.authService.RequestPasswordReset(request)
+	response, err := c.authService.ForRequest(auditContext(ctx)).RequestPasswordReset(request, requestMeta(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
@@ -118,7 +193,7 @@ func (c *AuthController) ResetPasswordViaEmail(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	response, err := c.authService.ResetPasswordViaEmail(request)
+	response, err := c.authService.ForRequest(auditContext(ctx)).ResetPasswordViaEmail(request, requestMeta(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
@@ -127,7 +202,7 @@ func (c *AuthController) ResetPasswordViaEmail(ctx *gin.Context) {
 }
 
 // @Summary Update password
-// @Description Allows an authenticated user to change their own password.
+// @Description Allows an authenticated user to change their own password. Requires a recent re-authentication.
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -135,7 +210,8 @@ func (c *AuthController) ResetPasswordViaEmail(ctx *gin.Context) {
 // @Param updatePassword body models.UpdatePasswordRequest true "Update password request"
 // @Success 200 {object} models.SuccessResponse
 // @Failure 400 {object} models.ErrorResponse
-// @Failure 401 {object} models.ErrorResponse
+// @Failure 401 {object} models.ReauthRequiredResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Router /auth/update-password [post]
 func (c *AuthController) UpdatePassword(ctx *gin.Context) {
 	// This endpoint should also be protected by middleware.
@@ -152,7 +228,7 @@ func (c *AuthController) UpdatePassword(ctx *gin.Context) {
 		return
 	}
 
-	response, err := c.authService.UpdatePassword(userID.(string), request)
+	response, err := c.authService.ForRequest(auditContext(ctx)).UpdatePassword(userID.(string), request, requestMeta(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
@@ -160,37 +236,106 @@ func (c *AuthController) UpdatePassword(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, response)
 }
 
-// @Summary Reset password with token
-// @Description Allows a user to reset their password using a provided token.
+// @Summary Show the password reset form
+// @Description Opened by the link in a password reset email. Shows a form for the new password, which submits it with POST /auth/reset-password; opening the link changes nothing.
 // @Tags auth
-// @Accept json
-// @Produce json
+// @Produce html
 // @Param token query string true "Password reset token"
+// @Success 200 {string} string "Password reset form"
+// @Failure 400 {string} string "Error page"
+// @Router /auth/reset-password [get]
+func (c *AuthController) ShowPasswordResetForm(ctx *gin.Context) {
+	title := "Choose a new password"
+	token := ctx.Query("token")
+	if token == "" {
+		renderLinkPage(ctx, http.StatusBadRequest, linkPageData{Title: title, Message: "This link is incomplete."})
+		return
+	}
+	renderLinkPage(ctx, http.StatusOK, linkPageData{
+		Title:   title,
+		Message: "Enter the password you want to sign in with from now on.",
+		Action:  "reset-password",
+		Token:   token,
+		Fields:  []linkPageField{{Label: "New password", Name: "newPassword", Type: "password"}},
+		Submit:  "Set password",
+	})
+}
+
+// @Summary Reset password with token
+// @Description Allows a user to reset their password using a provided token. The token comes from the query, or from the form submitted by the reset page, which gets a page in reply.
+// @Tags auth
+// @Accept json,x-www-form-urlencoded
+// @Produce json,html
+// @Param token query string false "Password reset token"
 // @Param newPassword body models.ResetPasswordWithTokenRequest true "New password"
 // @Success 200 {object} models.SuccessResponse
 // @Failure 400 {object} models.ErrorResponse
 // @Router /auth/reset-password [post]
 func (c *AuthController) ResetPasswordWithToken(ctx *gin.Context) {
-	// 1. Read "token" from query parameters
+	title := "Choose a new password"
+
+	// 1. Read "token" from query parameters, or the reset page's form
 	token := ctx.Query("token")
 	if token == "" {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "reset token is required"})
+		token = ctx.PostForm("token")
+	}
+	if token == "" {
+		respondToLink(ctx, title, models.SuccessResponse{}, errors.New("reset token is required"))
 		return
 	}
 
-	// 2. Bind the new password from the JSON body
+	// 2. Bind the new password from the JSON body or the form
 	var req models.ResetPasswordWithTokenRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+	if err := ctx.ShouldBind(&req); err != nil {
+		respondToLink(ctx, title, models.SuccessResponse{}, err)
 		return
 	}
 
 	// 3. Call the service
-	resp, err := c.authService.ResetPasswordWithToken(token, req.NewPassword)
+	resp, err := c.authService.ForRequest(auditContext(ctx)).ResetPasswordWithToken(token, req.NewPassword, requestMeta(ctx))
+	respondToLink(ctx, title, resp, err)
+}
+
+// @Summary Request a re-authentication code
+// @Description Emails a one-time code that can be used with /auth/reauthenticate instead of the password.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /auth/reauthenticate/otp [post]
+func (c *AuthController) SendReauthOtp(ctx *gin.Context) {
+	response, err := c.authService.ForRequest(auditContext(ctx)).SendReauthOtp(ctx.GetString("userID"), requestMeta(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
+	ctx.JSON(http.StatusOK, response)
+}
 
-	ctx.JSON(http.StatusOK, resp)
+// @Summary Re-authenticate
+// @Description Confirms the user's identity again with their password or an emailed OTP and returns a fresh access token for the same session, with an updated auth_time and amr. Use it when an endpoint responds with reauth_required.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param reauthenticate body models.ReauthenticateRequest true "Password or OTP"
+// @Success 200 {object} models.LoginResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /auth/reauthenticate [post]
+func (c *AuthController) Reauthenticate(ctx *gin.Context) {
+	var request models.ReauthenticateRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.authService.ForRequest(auditContext(ctx)).Reauthenticate(ctx.GetString("userID"), ctx.GetString("sessionID"), request, requestMeta(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
 }
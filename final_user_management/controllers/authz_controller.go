@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthzController struct {
+	authorizationService services.AuthorizationService
+}
+
+func NewAuthzController(authorizationService services.AuthorizationService) *AuthzController {
+	return &AuthzController{authorizationService}
+}
+
+// @Summary Check an access decision
+// @Description Evaluates whether a user may perform an action on another user and explains the decision, with the subject and resource attributes the policies saw. The subject defaults to the caller; other subjects are checked with their current permissions. Requires the roles:manage permission.
+// @Tags authz
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param check body models.AuthzCheckRequest true "Subject, action and resource"
+// @Success 200 {object} models.AuthorizationDecision
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /authz/check [post]
+func (c *AuthzController) Check(ctx *gin.Context) {
+	var request models.AuthzCheckRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	authorizationService := c.authorizationService.ForTenant(tenantID(ctx))
+	var decision models.AuthorizationDecision
+	var err error
+	if request.SubjectID == "" || request.SubjectID == ctx.GetString("userID") {
+		decision, err = authorizationService.Check(viewer(ctx), request.Action, request.ResourceID)
+	} else {
+		decision, err = authorizationService.CheckFor(request.SubjectID, request.Action, request.ResourceID)
+	}
+	if errors.Is(err, services.ErrSubjectNotFound) || errors.Is(err, services.ErrResourceNotFound) {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, decision)
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BulkController struct {
+	bulkUserService services.BulkUserService
+}
+
+func NewBulkController(bulkUserService services.BulkUserService) *BulkController {
+	return &BulkController{bulkUserService}
+}
+
+// @Summary Act on many users at once
+// @Description Deactivates, reactivates, deletes, assigns a role to, forces a password reset for, or signs out every selected user. Users are selected by ids or by a filter with the query parameters of GET /users (paging is ignored). Every user is checked with the access policies for the action's permission, and changed and audited as the single-user endpoints would. Users are changed in batches, each in one transaction. With dryRun the changes are made and checked, then rolled back. Up to BULK_SYNC_LIMIT users (100 by default) are handled in the request; larger selections run in the background and return 202, with the report as the job's result.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.BulkUserRequest true "Action, selection and options"
+// @Success 200 {object} models.BulkUserReport
+// @Success 202 {object} models.JobAcceptedResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ReauthRequiredResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/bulk [post]
+func (c *BulkController) BulkUpdateUsers(ctx *gin.Context) {
+	var request models.BulkUserRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	bulk := c.bulkUserService.ForRequest(auditContext(ctx))
+	userIDs, err := bulk.SelectUsers(request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if bulk.NeedsBackgroundRun(userIDs) {
+		job, err := bulk.StartApply(request, userIDs, viewer(ctx), requestMeta(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusAccepted, models.JobAcceptedResponse{Job: job})
+		return
+	}
+	ctx.JSON(http.StatusOK, bulk.Apply(request, userIDs, viewer(ctx), requestMeta(ctx)))
+}
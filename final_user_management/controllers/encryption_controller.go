@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EncryptionController struct {
+	fieldEncryptionService services.FieldEncryptionService
+}
+
+func NewEncryptionController(fieldEncryptionService services.FieldEncryptionService) *EncryptionController {
+	return &EncryptionController{fieldEncryptionService}
+}
+
+// @Summary Rotate the field encryption key
+// @Description Creates a new data key for encrypted user fields (national ID, passport number), makes it active and re-encrypts existing users in a background job. Poll the returned job for progress. Requires the keys:rotate permission.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 202 {object} models.JobAcceptedResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /admin/encryption/rotate-key [post]
+func (c *EncryptionController) RotateKey(ctx *gin.Context) {
+	job, err := c.fieldEncryptionService.ForRequest(auditContext(ctx)).RotateDataKey(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, models.JobAcceptedResponse{Job: job})
+}
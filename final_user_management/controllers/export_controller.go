@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportController struct {
+	dataExportService services.DataExportService
+	userExportService services.UserExportService
+}
+
+func NewExportController(dataExportService services.DataExportService, userExportService services.UserExportService) *ExportController {
+	return &ExportController{dataExportService, userExportService}
+}
+
+// exportContentTypes are the response types of the user export formats.
+var exportContentTypes = map[models.ExportFormat]string{
+	models.ExportCSV:    "text/csv; charset=utf-8",
+	models.ExportXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	models.ExportNDJSON: "application/x-ndjson",
+}
+
+// @Summary Export users
+// @Description Exports every user matching the same filters as GET /users, ignoring paging, as CSV, XLSX or NDJSON. Rows are streamed from the database as they are read. Fields are shown or masked as they are in GET /users for the caller, and columns can only name fields the caller may see. Exports of more than USER_EXPORT_SYNC_LIMIT users (10000 by default), or any export with async=true, run in the background and return 202 with a download link. Every export is recorded in the audit log.
+// @Tags users
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Produce application/x-ndjson
+// @Produce json
+// @Security ApiKeyAuth
+// @Param format query string false "File format" Enums(csv, xlsx, ndjson) default(csv)
+// @Param columns query string false "Comma-separated user fields to export, by JSON name"
+// @Param from query string false "Start date for user creation (YYYY-MM-DD)"
+// @Param to query string false "End date for user creation (YYYY-MM-DD)"
+// @Param search query string false "Search term for user details (first name, last name, email, username)"
+// @Param role query string false "Filter by primary role name"
+// @Param status query string false "Filter by user status" Enums(pending, active, suspended, locked, inactive, deleted)
+// @Param async query bool false "Always generate the export in the background"
+// @Success 200 {file} file
+// @Success 202 {object} models.JobAcceptedResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/export [get]
+func (c *ExportController) ExportUsers(ctx *gin.Context) {
+	var request models.ExportUsersRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	exports := c.userExportService.ForRequest(auditContext(ctx))
+	if _, err := exports.ExportColumns(request, viewer(ctx)); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	background, err := exports.NeedsBackgroundExport(request)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if background {
+		job, err := exports.StartExport(request, viewer(ctx), ctx.GetString("userID"))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusAccepted, models.JobAcceptedResponse{Job: job, DownloadURL: jobDownloadURL(job.ID)})
+		return
+	}
+
+	format := request.Format
+	if format == "" {
+		format = models.ExportCSV
+	}
+	ctx.Header("Content-Type", exportContentTypes[format])
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", services.UserExportFileName(request)))
+	if err := exports.WriteExport(request, viewer(ctx), ctx.Writer); err != nil {
+		// Headers may already be sent, so the best we can do is log and abort the stream
+		log.Printf("Failed to export users: %v", err)
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// @Summary Export a user's data
+// @Description Produces a ZIP of JSON files with everything held about the user (profile, sessions, known devices, login history, status changes). Small exports are returned directly; large ones, or any export with async=true, run in the background and return 202 with a download link. Every export is recorded in the user's security feed.
+// @Tags users
+// @Accept json
+// @Produce application/zip
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param async query bool false "Always generate the export in the background"
+// @Success 200 {file} file
+// @Success 202 {object} models.JobAcceptedResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/{id}/export [get]
+func (c *ExportController) ExportUserData(ctx *gin.Context) {
+	id := ctx.Param("id")
+	requesterID := ctx.GetString("userID")
+
+	background := ctx.Query("async") == "true"
+	if !background {
+		needsBackground, err := c.dataExportService.ForRequest(auditContext(ctx)).NeedsBackgroundExport(id)
+		if err != nil {
+			ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		background = needsBackground
+	}
+
+	if background {
+		job, err := c.dataExportService.ForRequest(auditContext(ctx)).StartUserExport(id, requesterID, requestMeta(ctx))
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusAccepted, models.JobAcceptedResponse{Job: job, DownloadURL: jobDownloadURL(job.ID)})
+		return
+	}
+
+	ctx.Header("Content-Type", "application/zip")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "user-"+id+"-export.zip"))
+	if err := c.dataExportService.ForRequest(auditContext(ctx)).WriteUserExport(id, requesterID, requestMeta(ctx), ctx.Writer); err != nil {
+		// Headers may already be sent, so the best we can do is log and abort the stream
+		log.Printf("Failed to export data for user %s: %v", id, err)
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+// jobDownloadURL is the path clients use to fetch a background job's file.
+func jobDownloadURL(jobID string) string {
+	return "/jobs/" + jobID + "/download"
+}
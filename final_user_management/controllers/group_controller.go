@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GroupController struct {
+	groupService services.GroupService
+}
+
+func NewGroupController(groupService services.GroupService) *GroupController {
+	return &GroupController{groupService}
+}
+
+// @Summary List groups
+// @Description Lists groups with pagination and an optional name search. Requires the groups:manage permission.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of groups per page" default(10)
+// @Param search query string false "Search by group name"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups [get]
+func (c *GroupController) GetGroups(ctx *gin.Context) {
+	var request models.GetAllGroupsRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.groupService.ForRequest(auditContext(ctx)).GetGroups(request)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Get a group
+// @Description Retrieves a group by ID.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Success 200 {object} models.Group
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /groups/{id} [get]
+func (c *GroupController) GetGroup(ctx *gin.Context) {
+	group, err := c.groupService.ForRequest(auditContext(ctx)).GetGroup(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, group)
+}
+
+// @Summary Create a group
+// @Description Creates a group, optionally nested under a parent group.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param group body models.CreateGroupRequest true "Group"
+// @Success 201 {object} models.Group
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups [post]
+func (c *GroupController) CreateGroup(ctx *gin.Context) {
+	var request models.CreateGroupRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	group, err := c.groupService.ForRequest(auditContext(ctx)).CreateGroup(request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, group)
+}
+
+// @Summary Update a group
+// @Description Renames a group or moves it under another parent. Members of the group and its subgroups are signed out when it moves, since the roles they inherit change.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param group body models.UpdateGroupRequest true "Changes"
+// @Success 200 {object} models.Group
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups/{id} [patch]
+func (c *GroupController) UpdateGroup(ctx *gin.Context) {
+	var request models.UpdateGroupRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	group, err := c.groupService.ForRequest(auditContext(ctx)).UpdateGroup(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, group)
+}
+
+// @Summary Delete a group
+// @Description Deletes a group with no subgroups, removing its members and roles.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups/{id} [delete]
+func (c *GroupController) DeleteGroup(ctx *gin.Context) {
+	response, err := c.groupService.ForRequest(auditContext(ctx)).DeleteGroup(ctx.Param("id"), ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary List group members
+// @Description Lists the users who belong directly to a group, with pagination and search.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of users per page" default(10)
+// @Param search query string false "Search by username, email, first name, or last name"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /groups/{id}/members [get]
+func (c *GroupController) GetMembers(ctx *gin.Context) {
+	var request models.GetAllUsersRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.groupService.ForRequest(auditContext(ctx)).GetMembers(ctx.Param("id"), request, viewer(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Add group members
+// @Description Adds users to a group. The caller must hold every permission the group's roles grant, including roles inherited from parent groups.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param members body models.AddGroupMembersRequest true "Users to add"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups/{id}/members [post]
+func (c *GroupController) AddMembers(ctx *gin.Context) {
+	var request models.AddGroupMembersRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.groupService.ForRequest(auditContext(ctx)).AddMembers(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Remove a group member
+// @Description Removes a user from a group. The last active admin cannot lose admin access this way.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param userId path string true "User ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups/{id}/members/{userId} [delete]
+func (c *GroupController) RemoveMember(ctx *gin.Context) {
+	response, err := c.groupService.ForRequest(auditContext(ctx)).RemoveMember(ctx.Param("id"), ctx.Param("userId"), ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Get a group's roles
+// @Description Lists the roles granted directly to a group.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Success 200 {array} models.Role
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /groups/{id}/roles [get]
+func (c *GroupController) GetGroupRoles(ctx *gin.Context) {
+	roles, err := c.groupService.ForRequest(auditContext(ctx)).GetGroupRoles(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
+
+// @Summary Grant roles to a group
+// @Description Grants the named roles to a group. Members of the group and its subgroups inherit them and are signed out so the new permissions take effect. Requires a recent re-authentication.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param roles body models.AssignRolesRequest true "Roles to grant"
+// @Success 200 {array} models.Role
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups/{id}/roles [post]
+func (c *GroupController) AddGroupRoles(ctx *gin.Context) {
+	var request models.AssignRolesRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	roles, err := c.groupService.ForRequest(auditContext(ctx)).AddGroupRoles(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
+
+// @Summary Revoke a role from a group
+// @Description Removes a role from a group and from everyone who inherited it through the group. Requires a recent re-authentication.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param roleId path string true "Role ID"
+// @Success 200 {array} models.Role
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /groups/{id}/roles/{roleId} [delete]
+func (c *GroupController) RemoveGroupRole(ctx *gin.Context) {
+	roles, err := c.groupService.ForRequest(auditContext(ctx)).RemoveGroupRole(ctx.Param("id"), ctx.Param("roleId"), ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
+
+// @Summary Get a user's groups
+// @Description Lists the groups a user belongs to, including the parents of groups they are a direct member of.
+// @Tags groups
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of groups per page" default(10)
+// @Param search query string false "Search by group name"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /users/{id}/groups [get]
+func (c *GroupController) GetUserGroups(ctx *gin.Context) {
+	var request models.GetAllGroupsRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.groupService.ForRequest(auditContext(ctx)).GetUserGroups(ctx.Param("id"), request)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImpersonationController struct {
+	impersonationService services.ImpersonationService
+}
+
+func NewImpersonationController(impersonationService services.ImpersonationService) *ImpersonationController {
+	return &ImpersonationController{impersonationService}
+}
+
+// @Summary Impersonate a user
+// @Description Issues a short-lived token that lets an admin (users:impersonate permission) act as the given user. Staff accounts, i.e. users holding any permission, cannot be impersonated. The token carries the admin in its "act" claim, cannot change passwords or email, and every request made with it is audit-logged.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} models.ImpersonationResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/impersonate [post]
+func (c *ImpersonationController) StartImpersonation(ctx *gin.Context) {
+	response, err := c.impersonationService.ForRequest(auditContext(ctx)).Start(ctx.GetString("userID"), ctx.Param("id"), requestMeta(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Stop impersonating
+// @Description Ends the impersonation session the request is made with. The token stops working immediately.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Router /auth/impersonation/stop [post]
+func (c *ImpersonationController) StopImpersonation(ctx *gin.Context) {
+	response, err := c.impersonationService.ForRequest(auditContext(ctx)).Stop(ctx.GetString("sessionID"), ctx.GetString("userID"), ctx.GetString("impersonatorID"), requestMeta(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
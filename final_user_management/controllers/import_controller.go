@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize is the largest import file accepted, 10 MiB.
+const maxImportSize = 10 << 20
+
+type ImportController struct {
+	userImportService services.UserImportService
+}
+
+func NewImportController(userImportService services.UserImportService) *ImportController {
+	return &ImportController{userImportService}
+}
+
+// @Summary Import users from a file
+// @Description Creates users in bulk from a CSV (with a header row), NDJSON or JSON array file of up to 10 MiB. Each row holds the fields of POST /users/registerusersbyadmin; mapping renames the file's columns to those fields, as in {"E-mail": "email"}. Rows are checked for invalid values, users that already exist, duplicates within the file and roles the caller cannot grant. With dryRun=true nothing is created. With sendInvitations=true, rows without a password are created as pending users and invited, as with POST /invitations, to choose one. The import runs in the background; the job's result is a report with the outcome of every row.
+// @Tags users
+// @Accept multipart/form-data
+// @Produce json
+// @Security ApiKeyAuth
+// @Param file formData file true "CSV, NDJSON or JSON file of users"
+// @Param format formData string false "File format, taken from the file extension when omitted" Enums(csv, ndjson, json)
+// @Param mapping formData string false "JSON object from column names to user fields"
+// @Param dryRun formData bool false "Only check the rows"
+// @Param sendInvitations formData bool false "Invite users without a password to choose one"
+// @Success 202 {object} models.JobAcceptedResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 413 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/import [post]
+func (c *ImportController) ImportUsers(ctx *gin.Context) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportSize+1<<20)
+
+	var request models.ImportUsersRequest
+	if err := ctx.ShouldBind(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	file, header, err := ctx.Request.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "an import file is required"})
+		return
+	}
+	defer file.Close()
+	if header.Size > maxImportSize {
+		ctx.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{Error: "import files are limited to 10 MiB"})
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	job, err := c.userImportService.ForRequest(auditContext(ctx)).StartImport(request, header.Filename, data, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, models.JobAcceptedResponse{Job: job, DownloadURL: jobDownloadURL(job.ID)})
+}
@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type InvitationController struct {
+	userService services.UserService
+}
+
+func NewInvitationController(userService services.UserService) *InvitationController {
+	return &InvitationController{userService}
+}
+
+// @Summary Invite a user
+// @Description Creates the user in the pending status without a password and emails them a link to choose one, which activates their account. The link works for INVITATION_TTL_HOURS (7 days by default). The caller must be able to grant the user's role.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param invitation body models.InviteUserRequest true "User to invite"
+// @Success 201 {object} models.Invitation
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /invitations [post]
+func (c *InvitationController) InviteUser(ctx *gin.Context) {
+	var request models.InviteUserRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	invitation, err := c.userService.ForRequest(auditContext(ctx)).InviteUser(request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, invitation)
+}
+
+// @Summary List invitations
+// @Description Lists invitations, newest first, optionally only those with a status. Pending invitations can still be accepted.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of invitations per page" default(10)
+// @Param status query string false "Filter by status" Enums(pending, accepted, expired, revoked)
+// @Param search query string false "Search by email"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /invitations [get]
+func (c *InvitationController) GetInvitations(ctx *gin.Context) {
+	var request models.GetInvitationsRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.userService.ForRequest(auditContext(ctx)).GetInvitations(request)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Resend an invitation
+// @Description Emails a pending or expired invitation's user a new link, which works for another full period. Links sent earlier stop working.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Invitation ID"
+// @Success 200 {object} models.Invitation
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /invitations/{id}/resend [post]
+func (c *InvitationController) ResendInvitation(ctx *gin.Context) {
+	invitation, err := c.userService.ForRequest(auditContext(ctx)).ResendInvitation(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+// @Summary Expire an invitation
+// @Description Makes a pending invitation's link stop working now. The user stays pending, so the invitation can be resent later.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Invitation ID"
+// @Success 200 {object} models.Invitation
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /invitations/{id}/expire [post]
+func (c *InvitationController) ExpireInvitation(ctx *gin.Context) {
+	invitation, err := c.userService.ForRequest(auditContext(ctx)).ExpireInvitation(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+// @Summary Revoke an invitation
+// @Description Withdraws an invitation that has not been accepted and deletes the pending user it was for.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Invitation ID"
+// @Success 200 {object} models.Invitation
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /invitations/{id} [delete]
+func (c *InvitationController) RevokeInvitation(ctx *gin.Context) {
+	invitation, err := c.userService.ForRequest(auditContext(ctx)).RevokeInvitation(ctx.Param("id"), ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, invitation)
+}
+
+// @Summary Look up an invitation
+// @Description Shows the invitee which account the invitation link is for, so they can confirm it before choosing a password.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Param token query string true "Token from the invitation link"
+// @Success 200 {object} models.InvitationPreview
+// @Failure 404 {object} models.ErrorResponse
+// @Router /invitations/accept [get]
+func (c *InvitationController) GetInvitationPreview(ctx *gin.Context) {
+	preview, err := c.userService.ForRequest(auditContext(ctx)).GetInvitationPreview(ctx.Query("token"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, preview)
+}
+
+// @Summary Accept an invitation
+// @Description Sets the invitee's password and activates their account. Each link can only be used once.
+// @Tags invitations
+// @Accept json
+// @Produce json
+// @Param accept body models.AcceptInvitationRequest true "Token from the invitation link and the new password"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Router /invitations/accept [post]
+func (c *InvitationController) AcceptInvitation(ctx *gin.Context) {
+	var request models.AcceptInvitationRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.userService.ForRequest(auditContext(ctx)).AcceptInvitation(request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
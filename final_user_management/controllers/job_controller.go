@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/middleware"
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobController struct {
+	jobService services.JobService
+}
+
+func NewJobController(jobService services.JobService) *JobController {
+	return &JobController{jobService}
+}
+
+// @Summary Get a background job
+// @Description Returns the status of a background job started by the caller (holders of jobs:read can see all jobs except data exports, which only their requester can see).
+// @Tags jobs
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Job ID"
+// @Success 200 {object} models.Job
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /jobs/{id} [get]
+func (c *JobController) GetJob(ctx *gin.Context) {
+	job, err := c.jobService.ForTenant(tenantID(ctx)).GetJob(ctx.Param("id"), ctx.GetString("userID"), middleware.HasPermission(ctx, models.PermJobsRead))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, job)
+}
+
+// @Summary Download a background job's file
+// @Description Downloads the file produced by a completed background job, until it expires. A data export can only be downloaded by whoever requested it.
+// @Tags jobs
+// @Produce application/octet-stream
+// @Security ApiKeyAuth
+// @Param id path string true "Job ID"
+// @Success 200 {file} file
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Router /jobs/{id}/download [get]
+func (c *JobController) DownloadJobFile(ctx *gin.Context) {
+	job, err := c.jobService.ForTenant(tenantID(ctx)).GetJob(ctx.Param("id"), ctx.GetString("userID"), middleware.HasPermission(ctx, models.PermJobsRead))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if job.Status != models.JobCompleted {
+		ctx.JSON(http.StatusConflict, models.ErrorResponse{Error: "job is " + string(job.Status)})
+		return
+	}
+	path := c.jobService.FilePath(job)
+	if path == "" {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: "file is no longer available"})
+		return
+	}
+	ctx.FileAttachment(path, job.FileName)
+}
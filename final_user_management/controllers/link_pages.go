@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umwaribenie/final_user_management/models"
+)
+
+// Links in emails open these pages. Email clients and link scanners follow links with GET, so
+// the pages only show a form; the action happens when the user submits it.
+var linkPage = template.Must(template.New("link").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .Action}}<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+{{range .Fields}}<p><label>{{.Label}} <input type="{{.Type}}" name="{{.Name}}" required></label></p>
+{{end}}<button type="submit">{{.Submit}}</button>
+</form>{{end}}
+</body>
+</html>
+`))
+
+// linkPageData fills linkPage. Without an Action it is a plain message.
+type linkPageData struct {
+	Title   string
+	Message string
+	Action  string
+	Token   string
+	Fields  []linkPageField
+	Submit  string
+}
+
+type linkPageField struct {
+	Label string
+	Name  string
+	Type  string
+}
+
+func renderLinkPage(ctx *gin.Context, status int, data linkPageData) {
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Referrer-Policy", "no-referrer")
+	ctx.Status(status)
+	if err := linkPage.Execute(ctx.Writer, data); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// respondToLink answers a link page's form with a page, and API clients with JSON.
+func respondToLink(ctx *gin.Context, title string, response models.SuccessResponse, err error) {
+	fromPage := ctx.ContentType() == gin.MIMEPOSTForm
+	switch {
+	case err != nil && fromPage:
+		renderLinkPage(ctx, http.StatusBadRequest, linkPageData{Title: title, Message: err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+	case fromPage:
+		renderLinkPage(ctx, http.StatusOK, linkPageData{Title: title, Message: response.Message})
+	default:
+		ctx.JSON(http.StatusOK, response)
+	}
+}
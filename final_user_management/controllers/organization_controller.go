@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationController struct {
+	organizationService services.OrganizationService
+}
+
+func NewOrganizationController(organizationService services.OrganizationService) *OrganizationController {
+	return &OrganizationController{organizationService}
+}
+
+// @Summary List organizations
+// @Description Lists every tenant. Requires the organizations:manage permission, which only the default organization's admins hold.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} models.Organization
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /organizations [get]
+func (c *OrganizationController) GetOrganizations(ctx *gin.Context) {
+	organizations, err := c.organizationService.GetOrganizations()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, organizations)
+}
+
+// @Summary Get an organization
+// @Description Retrieves a tenant by ID.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Organization ID"
+// @Success 200 {object} models.Organization
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /organizations/{id} [get]
+func (c *OrganizationController) GetOrganization(ctx *gin.Context) {
+	organization, err := c.organizationService.GetOrganization(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, organization)
+}
+
+// @Summary Create an organization
+// @Description Creates a tenant with the built-in roles and its first admin account. The slug is used as the tenant's subdomain and in the X-Tenant header.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param organization body models.CreateOrganizationRequest true "Organization"
+// @Success 201 {object} models.Organization
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /organizations [post]
+func (c *OrganizationController) CreateOrganization(ctx *gin.Context) {
+	var request models.CreateOrganizationRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	organization, err := c.organizationService.ForRequest(auditContext(ctx)).CreateOrganization(request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, organization)
+}
+
+// @Summary Update an organization
+// @Description Renames a tenant, deactivates it or changes who may register with it. Requests for an inactive tenant are refused, and its users' tokens stop working. Domain-restricted registration needs at least one allowed email domain.
+// @Tags organizations
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Organization ID"
+// @Param organization body models.UpdateOrganizationRequest true "Changes"
+// @Success 200 {object} models.Organization
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /organizations/{id} [patch]
+func (c *OrganizationController) UpdateOrganization(ctx *gin.Context) {
+	var request models.UpdateOrganizationRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	organization, err := c.organizationService.ForRequest(auditContext(ctx)).UpdateOrganization(ctx.Param("id"), request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, organization)
+}
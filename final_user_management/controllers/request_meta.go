@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/umwaribenie/final_user_management/models"
+)
+
+// requestMeta extracts the client details that services record alongside security events.
+func requestMeta(ctx *gin.Context) models.RequestMeta {
+	return models.RequestMeta{
+		IP:          ctx.ClientIP(),
+		UserAgent:   ctx.Request.UserAgent(),
+		DeviceToken: deviceToken(ctx),
+	}
+}
+
+// viewer identifies the caller for response shaping. It is anonymous when no token was sent.
+func viewer(ctx *gin.Context) models.Viewer {
+	return models.Viewer{
+		UserID:      ctx.GetString("userID"),
+		Permissions: ctx.GetStringSlice("permissions"),
+	}
+}
+
+// tenantID is the organization the request is served from, set by ResolveTenant and, for
+// authenticated requests, pinned to the token's organization by AuthMiddleware.
+func tenantID(ctx *gin.Context) string {
+	return ctx.GetString("tenantID")
+}
+
+// auditContext identifies who is acting for the audit log: the authenticated user (or nobody),
+// the admin behind an impersonation token, the client and the request ID.
+func auditContext(ctx *gin.Context) models.AuditContext {
+	return models.AuditContext{
+		OrganizationID: tenantID(ctx),
+		ActorID:        ctx.GetString("userID"),
+		ImpersonatorID: ctx.GetString("impersonatorID"),
+		IP:             ctx.ClientIP(),
+		UserAgent:      ctx.Request.UserAgent(),
+		RequestID:      ctx.GetString("requestID"),
+	}
+}
+
+// deviceTokenCookie holds the signed device token used to recognise returning devices.
+const deviceTokenCookie = "device_token"
+
+// deviceToken reads the device token from the X-Device-Token header, falling back to the cookie.
+func deviceToken(ctx *gin.Context) string {
+	if token := ctx.GetHeader("X-Device-Token"); token != "" {
+		return token
+	}
+	token, _ := ctx.Cookie(deviceTokenCookie)
+	return token
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct {
+	roleService services.RoleService
+}
+
+func NewRoleController(roleService services.RoleService) *RoleController {
+	return &RoleController{roleService}
+}
+
+// @Summary List roles
+// @Description Lists all roles with their permissions. Requires the roles:manage permission.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} models.Role
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /roles [get]
+func (c *RoleController) GetRoles(ctx *gin.Context) {
+	roles, err := c.roleService.ForRequest(auditContext(ctx)).GetRoles()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
+
+// @Summary Get a role
+// @Description Retrieves a role by ID. Requires the roles:manage permission.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Role ID"
+// @Success 200 {object} models.Role
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /roles/{id} [get]
+func (c *RoleController) GetRole(ctx *gin.Context) {
+	role, err := c.roleService.ForRequest(auditContext(ctx)).GetRole(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, role)
+}
+
+// @Summary Create a role
+// @Description Creates a named set of permissions. The caller must hold every permission they grant.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param role body models.CreateRoleRequest true "Role"
+// @Success 201 {object} models.Role
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /roles [post]
+func (c *RoleController) CreateRole(ctx *gin.Context) {
+	var request models.CreateRoleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	role, err := c.roleService.ForRequest(auditContext(ctx)).CreateRole(request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, role)
+}
+
+// @Summary Update a role
+// @Description Changes a role's name, description or permissions. System roles cannot be renamed and the admin role's permissions are fixed. Members of the role are signed out when its permissions change.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Role ID"
+// @Param role body models.UpdateRoleRequest true "Changes"
+// @Success 200 {object} models.Role
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /roles/{id} [patch]
+func (c *RoleController) UpdateRole(ctx *gin.Context) {
+	var request models.UpdateRoleRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	role, err := c.roleService.ForRequest(auditContext(ctx)).UpdateRole(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, role)
+}
+
+// @Summary Delete a role
+// @Description Deletes a role and removes it from every user holding it. System roles cannot be deleted.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Role ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /roles/{id} [delete]
+func (c *RoleController) DeleteRole(ctx *gin.Context) {
+	response, err := c.roleService.ForRequest(auditContext(ctx)).DeleteRole(ctx.Param("id"), ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary List users with a role
+// @Description Lists the users holding a role, with pagination.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Role ID"
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of users per page" default(10)
+// @Param status query string false "Filter by user status" Enums(pending, active, suspended, locked, inactive, deleted)
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /roles/{id}/users [get]
+func (c *RoleController) GetRoleUsers(ctx *gin.Context) {
+	var request models.GetAllUsersRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.roleService.ForRequest(auditContext(ctx)).GetRoleUsers(ctx.Param("id"), request, viewer(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Get a user's roles
+// @Description Lists the roles assigned to a user.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {array} models.Role
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /users/{id}/roles [get]
+func (c *RoleController) GetUserRoles(ctx *gin.Context) {
+	roles, err := c.roleService.ForRequest(auditContext(ctx)).GetUserRoles(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
+
+// @Summary Assign roles to a user
+// @Description Adds the named roles to the user's existing roles. The caller must hold every permission the roles grant. The user is signed out so the new permissions take effect. Requires a recent re-authentication.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param roles body models.AssignRolesRequest true "Roles to assign"
+// @Success 200 {array} models.Role
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/roles [post]
+func (c *RoleController) AssignRoles(ctx *gin.Context) {
+	var request models.AssignRolesRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	roles, err := c.roleService.ForRequest(auditContext(ctx)).AssignRoles(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
+
+// @Summary Remove a role from a user
+// @Description Removes one role from the user. The last active admin cannot lose the admin role. Requires a recent re-authentication.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param roleId path string true "Role ID"
+// @Success 200 {array} models.Role
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/roles/{roleId} [delete]
+func (c *RoleController) UnassignRole(ctx *gin.Context) {
+	roles, err := c.roleService.ForRequest(auditContext(ctx)).UnassignRole(ctx.Param("id"), ctx.Param("roleId"), ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, roles)
+}
@@ -0,0 +1,545 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/scim"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scimBasePath is where the SCIM endpoints are mounted.
+const scimBasePath = "/scim/v2"
+
+type ScimController struct {
+	scimService services.ScimService
+}
+
+func NewScimController(scimService services.ScimService) *ScimController {
+	return &ScimController{scimService}
+}
+
+// service returns the SCIM service acting with the request's token, set by middleware.ScimAuth.
+func (c *ScimController) service(ctx *gin.Context) services.ScimService {
+	token := ctx.MustGet("scimToken").(*models.ScimToken)
+	return c.scimService.ForToken(token, auditContext(ctx))
+}
+
+// scimBaseURL is the absolute URL of the SCIM root, used in resource locations. It is built from
+// APP_BASE_URL when set, and from the request otherwise.
+func scimBaseURL(ctx *gin.Context) string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/") + scimBasePath
+	}
+	scheme := "http"
+	if ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host + scimBasePath
+}
+
+func scimJSON(ctx *gin.Context, status int, body interface{}) {
+	ctx.Header("Content-Type", scim.ContentType)
+	ctx.JSON(status, body)
+}
+
+// scimError responds with a SCIM error. Errors that are not protocol errors are logged and
+// reported as internal errors.
+func scimError(ctx *gin.Context, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		log.Printf("SCIM %s %s failed: %v", ctx.Request.Method, ctx.Request.URL.Path, err)
+		scimErr = scim.NewError(http.StatusInternalServerError, "", "internal error")
+	}
+	scimJSON(ctx, scimErr.StatusCode(), scimErr)
+}
+
+// scimResource responds with the resource limited to the attributes the request asks for.
+func scimResource(ctx *gin.Context, status int, resource interface{}) {
+	projected, err := scim.NewProjection(ctx.Query("attributes"), ctx.Query("excludedAttributes")).Apply(resource)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	scimJSON(ctx, status, projected)
+}
+
+// bindScim decodes a SCIM request body, answering with an invalidSyntax error when it cannot.
+func bindScim(ctx *gin.Context, body interface{}) bool {
+	if err := ctx.ShouldBindJSON(body); err != nil {
+		scimError(ctx, scim.NewError(http.StatusBadRequest, scim.InvalidSyntax, err.Error()))
+		return false
+	}
+	return true
+}
+
+func listQuery(ctx *gin.Context) (scim.ListQuery, error) {
+	return scim.ParseListQuery(ctx.Query("filter"), ctx.Query("startIndex"), ctx.Query("count"), ctx.Query("attributes"), ctx.Query("excludedAttributes"))
+}
+
+func userLocation(ctx *gin.Context, user *scim.User) *scim.User {
+	user.Meta.Location = scimBaseURL(ctx) + "/Users/" + user.ID
+	return user
+}
+
+func groupLocation(ctx *gin.Context, group *scim.Group) *scim.Group {
+	group.Meta.Location = scimBaseURL(ctx) + "/Groups/" + group.ID
+	return group
+}
+
+// @Summary SCIM service provider configuration
+// @Description Describes the SCIM 2.0 features supported: PATCH and filtering, but not bulk operations, sorting or ETags.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} scim.Error
+// @Router /scim/v2/ServiceProviderConfig [get]
+func (c *ScimController) GetServiceProviderConfig(ctx *gin.Context) {
+	scimJSON(ctx, http.StatusOK, scim.ServiceProviderConfig(scimBaseURL(ctx)))
+}
+
+// @Summary SCIM resource types
+// @Description Lists the User and Group resource types.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} scim.ListResponse
+// @Failure 401 {object} scim.Error
+// @Router /scim/v2/ResourceTypes [get]
+func (c *ScimController) GetResourceTypes(ctx *gin.Context) {
+	scimJSON(ctx, http.StatusOK, scim.Resources(scim.ResourceTypes(scimBaseURL(ctx))))
+}
+
+// @Summary Get a SCIM resource type
+// @Description Describes the User or Group resource type.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User or Group"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/ResourceTypes/{id} [get]
+func (c *ScimController) GetResourceType(ctx *gin.Context) {
+	resourceType, ok := scim.ResourceType(scimBaseURL(ctx), ctx.Param("id"))
+	if !ok {
+		scimError(ctx, scim.NotFound("ResourceType", ctx.Param("id")))
+		return
+	}
+	scimJSON(ctx, http.StatusOK, resourceType)
+}
+
+// @Summary SCIM schemas
+// @Description Lists the attributes of the User, enterprise User and Group schemas.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} scim.ListResponse
+// @Failure 401 {object} scim.Error
+// @Router /scim/v2/Schemas [get]
+func (c *ScimController) GetSchemas(ctx *gin.Context) {
+	scimJSON(ctx, http.StatusOK, scim.Resources(scim.Schemas(scimBaseURL(ctx))))
+}
+
+// @Summary Get a SCIM schema
+// @Description Describes the attributes of one schema, by URN.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Schema URN"
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Schemas/{id} [get]
+func (c *ScimController) GetSchema(ctx *gin.Context) {
+	schema, ok := scim.Schema(scimBaseURL(ctx), ctx.Param("id"))
+	if !ok {
+		scimError(ctx, scim.NotFound("Schema", ctx.Param("id")))
+		return
+	}
+	scimJSON(ctx, http.StatusOK, schema)
+}
+
+// @Summary List SCIM users
+// @Description Lists users matching a SCIM filter, such as userName eq "jdoe" or emails[type eq "work" and value co "@example.com"]. Supports startIndex, count (at most 1000), attributes and excludedAttributes.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Param filter query string false "SCIM filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size"
+// @Param attributes query string false "Attributes to return"
+// @Param excludedAttributes query string false "Attributes to leave out"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Router /scim/v2/Users [get]
+func (c *ScimController) GetUsers(ctx *gin.Context) {
+	query, err := listQuery(ctx)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	users, total, err := c.service(ctx).GetUsers(query)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	resources := make([]interface{}, 0, len(users))
+	for i := range users {
+		resource, err := query.Projection.Apply(userLocation(ctx, &users[i]))
+		if err != nil {
+			scimError(ctx, err)
+			return
+		}
+		resources = append(resources, resource)
+	}
+	scimJSON(ctx, http.StatusOK, scim.NewListResponse(resources, total, query.StartIndex))
+}
+
+// @Summary Get a SCIM user
+// @Description Retrieves a user as a SCIM resource.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} scim.User
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [get]
+func (c *ScimController) GetUser(ctx *gin.Context) {
+	user, err := c.service(ctx).GetUser(ctx.Param("id"))
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	scimResource(ctx, http.StatusOK, userLocation(ctx, user))
+}
+
+// @Summary Provision a SCIM user
+// @Description Creates a user with the user role. userName and an email address (or a userName that is one) are required. Without a password the user can only sign in through the identity provider. active false creates an inactive user.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param user body scim.User true "User"
+// @Success 201 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Users [post]
+func (c *ScimController) CreateUser(ctx *gin.Context) {
+	var resource scim.User
+	if !bindScim(ctx, &resource) {
+		return
+	}
+	user, err := c.service(ctx).CreateUser(resource)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	userLocation(ctx, user)
+	ctx.Header("Location", user.Meta.Location)
+	scimResource(ctx, http.StatusCreated, user)
+}
+
+// @Summary Replace a SCIM user
+// @Description Replaces a user's attributes; attributes left out are cleared. active false deactivates the user and signs them out, active true reactivates a deactivated user.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param user body scim.User true "User"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Users/{id} [put]
+func (c *ScimController) ReplaceUser(ctx *gin.Context) {
+	var resource scim.User
+	if !bindScim(ctx, &resource) {
+		return
+	}
+	user, err := c.service(ctx).ReplaceUser(ctx.Param("id"), resource)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	scimResource(ctx, http.StatusOK, userLocation(ctx, user))
+}
+
+// @Summary Patch a SCIM user
+// @Description Adds, replaces or removes user attributes with a PatchOp message, for example {"op": "replace", "path": "active", "value": false}.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param patch body scim.PatchRequest true "PatchOp"
+// @Success 200 {object} scim.User
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Users/{id} [patch]
+func (c *ScimController) PatchUser(ctx *gin.Context) {
+	var patch scim.PatchRequest
+	if !bindScim(ctx, &patch) {
+		return
+	}
+	user, err := c.service(ctx).PatchUser(ctx.Param("id"), patch)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	scimResource(ctx, http.StatusOK, userLocation(ctx, user))
+}
+
+// @Summary Deprovision a SCIM user
+// @Description Soft-deletes the user and signs them out. They can be restored by an administrator until they are purged.
+// @Tags scim
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Users/{id} [delete]
+func (c *ScimController) DeleteUser(ctx *gin.Context) {
+	if err := c.service(ctx).DeleteUser(ctx.Param("id")); err != nil {
+		scimError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// @Summary List SCIM groups
+// @Description Lists groups matching a SCIM filter, such as displayName eq "Engineering" or members[value eq "{user ID}"]. Exclude members to leave out large member lists.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Param filter query string false "SCIM filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size"
+// @Param attributes query string false "Attributes to return"
+// @Param excludedAttributes query string false "Attributes to leave out"
+// @Success 200 {object} scim.ListResponse
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Router /scim/v2/Groups [get]
+func (c *ScimController) GetGroups(ctx *gin.Context) {
+	query, err := listQuery(ctx)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	groups, total, err := c.service(ctx).GetGroups(query)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	resources := make([]interface{}, 0, len(groups))
+	for i := range groups {
+		resource, err := query.Projection.Apply(groupLocation(ctx, &groups[i]))
+		if err != nil {
+			scimError(ctx, err)
+			return
+		}
+		resources = append(resources, resource)
+	}
+	scimJSON(ctx, http.StatusOK, scim.NewListResponse(resources, total, query.StartIndex))
+}
+
+// @Summary Get a SCIM group
+// @Description Retrieves a group with its direct members as a SCIM resource.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Success 200 {object} scim.Group
+// @Failure 401 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [get]
+func (c *ScimController) GetGroup(ctx *gin.Context) {
+	projection := scim.NewProjection(ctx.Query("attributes"), ctx.Query("excludedAttributes"))
+	group, err := c.service(ctx).GetGroup(ctx.Param("id"), projection)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	scimResource(ctx, http.StatusOK, groupLocation(ctx, group))
+}
+
+// @Summary Provision a SCIM group
+// @Description Creates a top-level group with the given members, which must be users.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param group body scim.Group true "Group"
+// @Success 201 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Groups [post]
+func (c *ScimController) CreateGroup(ctx *gin.Context) {
+	var resource scim.Group
+	if !bindScim(ctx, &resource) {
+		return
+	}
+	group, err := c.service(ctx).CreateGroup(resource)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	groupLocation(ctx, group)
+	ctx.Header("Location", group.Meta.Location)
+	scimResource(ctx, http.StatusCreated, group)
+}
+
+// @Summary Replace a SCIM group
+// @Description Renames a group and sets its direct members. Changing the members of a group with roles requires the token's creator to hold those roles' permissions.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param group body scim.Group true "Group"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Failure 409 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [put]
+func (c *ScimController) ReplaceGroup(ctx *gin.Context) {
+	var resource scim.Group
+	if !bindScim(ctx, &resource) {
+		return
+	}
+	group, err := c.service(ctx).ReplaceGroup(ctx.Param("id"), resource)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	scimResource(ctx, http.StatusOK, groupLocation(ctx, group))
+}
+
+// @Summary Patch a SCIM group
+// @Description Renames a group or adds and removes members with a PatchOp message, for example {"op": "remove", "path": "members[value eq \"{user ID}\"]"}.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Param patch body scim.PatchRequest true "PatchOp"
+// @Success 200 {object} scim.Group
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [patch]
+func (c *ScimController) PatchGroup(ctx *gin.Context) {
+	var patch scim.PatchRequest
+	if !bindScim(ctx, &patch) {
+		return
+	}
+	group, err := c.service(ctx).PatchGroup(ctx.Param("id"), patch)
+	if err != nil {
+		scimError(ctx, err)
+		return
+	}
+	scimResource(ctx, http.StatusOK, groupLocation(ctx, group))
+}
+
+// @Summary Deprovision a SCIM group
+// @Description Deletes a group that has no subgroups, with its memberships and role grants.
+// @Tags scim
+// @Security ApiKeyAuth
+// @Param id path string true "Group ID"
+// @Success 204
+// @Failure 400 {object} scim.Error
+// @Failure 401 {object} scim.Error
+// @Failure 403 {object} scim.Error
+// @Failure 404 {object} scim.Error
+// @Router /scim/v2/Groups/{id} [delete]
+func (c *ScimController) DeleteGroup(ctx *gin.Context) {
+	if err := c.service(ctx).DeleteGroup(ctx.Param("id")); err != nil {
+		scimError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// @Summary List SCIM tokens
+// @Description Lists the organization's SCIM tokens, including revoked and expired ones. Requires the scim:manage permission.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} models.ScimToken
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /scim/tokens [get]
+func (c *ScimController) GetTokens(ctx *gin.Context) {
+	tokens, err := c.scimService.ForRequest(auditContext(ctx)).GetTokens()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, tokens)
+}
+
+// @Summary Create a SCIM token
+// @Description Issues a bearer token for an identity provider to call the SCIM API with. The token acts for the caller: group changes it makes can only pass on roles the caller holds. The token is only returned here.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param token body models.CreateScimTokenRequest true "Token"
+// @Success 201 {object} models.CreatedScimToken
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /scim/tokens [post]
+func (c *ScimController) CreateToken(ctx *gin.Context) {
+	var request models.CreateScimTokenRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	token, err := c.scimService.ForRequest(auditContext(ctx)).CreateToken(request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, token)
+}
+
+// @Summary Revoke a SCIM token
+// @Description Revokes a SCIM token. Requests made with it are refused from then on.
+// @Tags scim
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Token ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /scim/tokens/{id} [delete]
+func (c *ScimController) RevokeToken(ctx *gin.Context) {
+	response, err := c.scimService.ForRequest(auditContext(ctx)).RevokeToken(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
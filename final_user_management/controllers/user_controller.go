@@ -1,8 +1,10 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/umwaribenie/final_user_management/middleware"
 	"github.com/umwaribenie/final_user_management/models"
 	"github.com/umwaribenie/final_user_management/services"
 
@@ -10,25 +12,27 @@ import (
 )
 
 type UserController struct {
-	userService services.UserService
+	userService          services.UserService
+	authorizationService services.AuthorizationService
 }
 
-func NewUserController(userService services.UserService) *UserController {
-	return &UserController{userService}
+func NewUserController(userService services.UserService, authorizationService services.AuthorizationService) *UserController {
+	return &UserController{userService, authorizationService}
 }
 
 // @Summary Get all users
-// @Description Retrieves a list of all users, with support for pagination, searching, and filtering.
+// @Description Retrieves a list of all users, with support for pagination, searching, and filtering. Fields are shaped for the caller: staff with users:read see contact details (identity documents and phone masked without users:read-pii), anonymous callers and other users see public profiles only.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param pageNumber query int false "Page number for pagination" default(1)
 // @Param pageSize query int false "Number of users per page" default(10)
 // @Param from query string false "Start date for user creation (YYYY-MM-DD)"
 // @Param to query string false "End date for user creation (YYYY-MM-DD)"
 // @Param search query string false "Search term for user details (first name, last name, email, username)"
-// @Param role query string false "Filter by user role" Enums(user, admin)
-// @Param status query string false "Filter by user status" Enums(active, inactive, deleted)
+// @Param role query string false "Filter by primary role name"
+// @Param status query string false "Filter by user status" Enums(pending, active, suspended, locked, inactive, deleted)
 // @Success 200 {object} models.PaginatedResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /users [get]
@@ -39,7 +43,7 @@ func (c *UserController) GetAllUsers(ctx *gin.Context) {
 		return
 	}
 
-	response, err := c.userService.GetAllUsers(request)
+	response, err := c.userService.ForRequest(auditContext(ctx)).GetAllUsers(request, viewer(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
 		return
@@ -48,13 +52,14 @@ func (c *UserController) GetAllUsers(ctx *gin.Context) {
 }
 
 // @Summary Register a new user
-// @Description Creates a new user account with a default 'user' role.
+// @Description Creates a new user account with a default 'user' role and a generated client ID, if the organization's registration mode allows it. Invite-only organizations refuse all registrations and domain-restricted ones those from other email domains. Under the approval mode the account is created with the pending_approval status and cannot sign in until an admin approves it. Reserved names such as admin, root and support cannot be used as usernames or email local parts.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param user body models.CreateUserRequest true "User data"
-// @Success 201 {object} models.User
+// @Success 201 {object} models.UserView
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /users/register [post]
 func (c *UserController) RegisterUser(ctx *gin.Context) {
@@ -63,22 +68,113 @@ func (c *UserController) RegisterUser(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	user, err := c.userService.RegisterUser(request)
+	user, err := c.userService.ForRequest(auditContext(ctx)).RegisterUser(request)
+	if errors.Is(err, services.ErrRegistrationClosed) || errors.Is(err, services.ErrEmailDomainNotAllowed) {
+		ctx.JSON(http.StatusForbidden, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, services.NewUserView(user, viewer(ctx)))
+}
+
+// @Summary Get registrations pending approval
+// @Description Lists the users who registered while the organization required approval and are waiting for it, with the same filters as listing users.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of users per page" default(10)
+// @Param from query string false "Start date for user registration (YYYY-MM-DD)"
+// @Param to query string false "End date for user registration (YYYY-MM-DD)"
+// @Param search query string false "Search term for user details (first name, last name, email, username)"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/registrations [get]
+func (c *UserController) GetPendingRegistrations(ctx *gin.Context) {
+	var request models.GetAllUsersRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.userService.ForRequest(auditContext(ctx)).GetPendingRegistrations(request, viewer(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Approve a registration
+// @Description Activates a user whose registration is pending approval and emails them that they can sign in.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param approve body models.ChangeStatusRequest false "Reason"
+// @Success 200 {object} models.UserView
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/approve [post]
+func (c *UserController) ApproveRegistration(ctx *gin.Context) {
+	var request models.ChangeStatusRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	user, err := c.userService.ForRequest(auditContext(ctx)).ApproveRegistration(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
+}
+
+// @Summary Reject a registration
+// @Description Deletes a user whose registration is pending approval and emails them that it was not approved. Their username and email can be registered again.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param reject body models.ChangeStatusRequest false "Reason"
+// @Success 200 {object} models.UserView
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/reject [post]
+func (c *UserController) RejectRegistration(ctx *gin.Context) {
+	var request models.ChangeStatusRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	user, err := c.userService.ForRequest(auditContext(ctx)).RejectRegistration(ctx.Param("id"), request, ctx.GetString("userID"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusCreated, user)
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
 }
 
 // @Summary Register a new user by admin
-// @Description Creates a new user account with a specified role.
+// @Description Creates a new user account with a specified role. Requires the users:write permission; the caller must hold every permission the role grants.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param user body models.CreateUserByAdminRequest true "User data"
-// @Success 201 {object} models.User
+// @Success 201 {object} models.UserView
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /users/registerusersbyadmin [post]
 func (c *UserController) RegisterUserByAdmin(ctx *gin.Context) {
@@ -87,42 +183,46 @@ func (c *UserController) RegisterUserByAdmin(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	user, err := c.userService.RegisterUserByAdmin(request)
+	user, err := c.userService.ForRequest(auditContext(ctx)).RegisterUserByAdmin(request, ctx.GetString("userID"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusCreated, user)
+	ctx.JSON(http.StatusCreated, services.NewUserView(user, viewer(ctx)))
 }
 
 // @Summary Find a user by slug
-// @Description Retrieves a user's details using their URL-friendly slug.
+// @Description Retrieves a user's details using their URL-friendly slug, shaped for the caller like GET /users/{id}.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param slug path string true "User Slug"
-// @Success 200 {object} models.User
+// @Success 200 {object} models.UserView
 // @Failure 404 {object} models.ErrorResponse
 // @Router /users/slug/{slug} [get]
 func (c *UserController) GetUserBySlug(ctx *gin.Context) {
 	slug := ctx.Param("slug")
-	user, err := c.userService.GetUserBySlug(slug)
+	user, err := c.userService.ForRequest(auditContext(ctx)).GetUserBySlug(slug)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: "user not found"})
 		return
 	}
-	ctx.JSON(http.StatusOK, user)
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
 }
 
 // @Summary Update password by admin
-// @Description Allows an admin to update a user's password.
+// @Description Allows staff with the users:reset-password permission to update a user's password, unless an access policy denies it (by default only admins may reset an admin's password).
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param id path string true "User ID"
 // @Param updatePassword body models.UpdatePasswordRequest true "Update password request"
 // @Success 200 {object} models.SuccessResponse
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Router /users/{id}/update-password/admin [post]
 func (c *UserController) UpdatePasswordByAdmin(ctx *gin.Context) {
 	userID := ctx.Param("id")
@@ -131,7 +231,7 @@ func (c *UserController) UpdatePasswordByAdmin(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	response, err := c.userService.UpdatePasswordByAdmin(userID, request)
+	response, err := c.userService.ForRequest(auditContext(ctx)).UpdatePasswordByAdmin(userID, request, requestMeta(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
@@ -140,36 +240,45 @@ func (c *UserController) UpdatePasswordByAdmin(ctx *gin.Context) {
 }
 
 // @Summary Get a user by ID
-// @Description Retrieves a user's details using their unique ID.
+// @Description Retrieves a user's details using their unique ID. The user and staff with users:read-pii get the full record, staff with users:read get identity documents and phone masked (****1234), everyone else gets the public profile.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param id path string true "User ID"
-// @Success 200 {object} models.User
+// @Success 200 {object} models.UserView
 // @Failure 404 {object} models.ErrorResponse
 // @Router /users/{id} [get]
 func (c *UserController) GetUserByID(ctx *gin.Context) {
 	id := ctx.Param("id")
-	user, err := c.userService.GetUserByID(id)
+	user, err := c.userService.ForRequest(auditContext(ctx)).GetUserByID(id)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: "user not found"})
 		return
 	}
-	ctx.JSON(http.StatusOK, user)
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
 }
 
 // @Summary Delete a user
-// @Description Deletes a user by their unique ID.
+// @Description Deletes a user by their unique ID. Requires the users:delete permission and a recent re-authentication.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param id path string true "User ID"
 // @Success 200 {object} models.SuccessResponse
+// @Failure 401 {object} models.ReauthRequiredResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /users/{id} [delete]
 func (c *UserController) DeleteUser(ctx *gin.Context) {
 	id := ctx.Param("id")
-	response, err := c.userService.DeleteUser(id)
+	response, err := c.userService.ForRequest(auditContext(ctx)).DeleteUser(id, ctx.GetString("userID"))
+	if errors.Is(err, services.ErrLastAdmin) {
+		ctx.JSON(http.StatusConflict, models.ErrorResponse{Error: err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
 		return
@@ -178,14 +287,18 @@ func (c *UserController) DeleteUser(ctx *gin.Context) {
 }
 
 // @Summary Update a user
-// @Description Updates a user's details by their unique ID.
+// @Description Updates a user's details by their unique ID. Allowed with the users:write permission or when an access policy allows it (by default users may edit themselves). Changing the email or role requires a recent re-authentication. Changing the role needs roles:manage and replaces all of the user's roles; changing the department needs users:write.
 // @Tags users
 // @Accept json
 // @Produce json
+// @Security ApiKeyAuth
 // @Param id path string true "User ID"
 // @Param user body models.UpdateUserRequest true "User data to update"
-// @Success 200 {object} models.User
+// @Success 200 {object} models.UserView
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ReauthRequiredResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Router /users/{id} [patch]
 func (c *UserController) UpdateUser(ctx *gin.Context) {
@@ -195,10 +308,225 @@ func (c *UserController) UpdateUser(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	user, err := c.userService.UpdateUser(id, request)
+	if request.Email != nil && ctx.GetString("impersonatorID") != "" {
+		ctx.JSON(http.StatusForbidden, models.ErrorResponse{Error: "email cannot be changed while impersonating"})
+		return
+	}
+	if request.Role != nil && !middleware.CheckAuthorization(ctx, c.authorizationService, models.PermRolesManage, id) {
+		return
+	}
+	// Policies may let users edit themselves or their department, but moving someone between
+	// departments needs the permission itself, or a manager could adopt anyone
+	if request.Department != nil && !middleware.HasPermission(ctx, models.PermUsersWrite) {
+		ctx.JSON(http.StatusForbidden, models.ErrorResponse{Error: "you are not allowed to change departments"})
+		return
+	}
+	// Email and role changes need a recent login, other profile edits do not
+	if (request.Email != nil || request.Role != nil) && !middleware.CheckRecentAuth(ctx, middleware.ReauthMaxAge) {
+		return
+	}
+	user, err := c.userService.ForRequest(auditContext(ctx)).UpdateUser(id, request, ctx.GetString("userID"))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusOK, user)
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
+}
+
+// @Summary Get a user's login history
+// @Description Retrieves the user's login attempts and security events (password resets and changes), newest first.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of events per page" default(10)
+// @Param type query string false "Filter by event type" Enums(login, password_reset_requested, password_reset_completed, password_changed, password_changed_by_admin)
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /users/{id}/login-history [get]
+func (c *UserController) GetLoginHistory(ctx *gin.Context) {
+	id := ctx.Param("id")
+	var request models.GetLoginHistoryRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response, err := c.userService.ForRequest(auditContext(ctx)).GetLoginHistory(id, request)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Suspend a user
+// @Description Suspends a user with a reason, optionally until a given time. Suspended users cannot log in and their existing tokens stop working.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param suspend body models.SuspendUserRequest true "Suspension reason and optional end time"
+// @Success 200 {object} models.UserView
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/suspend [post]
+func (c *UserController) SuspendUser(ctx *gin.Context) {
+	var request models.SuspendUserRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	user, err := c.userService.ForRequest(auditContext(ctx)).SuspendUser(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
+}
+
+// @Summary Reactivate a user
+// @Description Moves a suspended or inactive user back to active.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param reactivate body models.ChangeStatusRequest false "Reason"
+// @Success 200 {object} models.UserView
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/reactivate [post]
+func (c *UserController) ReactivateUser(ctx *gin.Context) {
+	var request models.ChangeStatusRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	user, err := c.userService.ForRequest(auditContext(ctx)).ReactivateUser(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
+}
+
+// @Summary Unlock a user
+// @Description Unlocks a user who was locked out after too many failed logins.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param unlock body models.ChangeStatusRequest false "Reason"
+// @Success 200 {object} models.UserView
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/unlock [post]
+func (c *UserController) UnlockUser(ctx *gin.Context) {
+	var request models.ChangeStatusRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	user, err := c.userService.ForRequest(auditContext(ctx)).UnlockUser(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
+}
+
+// @Summary Get deleted users
+// @Description Lists soft-deleted users with when they were deleted and when they will be permanently purged.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of users per page" default(10)
+// @Param from query string false "Start date for user deletion (YYYY-MM-DD)"
+// @Param to query string false "End date for user deletion (YYYY-MM-DD)"
+// @Param search query string false "Search term for user details (first name, last name, email, username)"
+// @Param role query string false "Filter by primary role name"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/deleted [get]
+func (c *UserController) GetDeletedUsers(ctx *gin.Context) {
+	var request models.GetAllUsersRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	response, err := c.userService.ForRequest(auditContext(ctx)).GetDeletedUsers(request, viewer(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Restore a deleted user
+// @Description Restores a soft-deleted user and makes them active again, as long as their email and username have not been taken in the meantime.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "User ID"
+// @Param restore body models.ChangeStatusRequest false "Reason"
+// @Success 200 {object} models.UserView
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/{id}/restore [post]
+func (c *UserController) RestoreUser(ctx *gin.Context) {
+	var request models.ChangeStatusRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil && ctx.Request.ContentLength > 0 {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	user, err := c.userService.ForRequest(auditContext(ctx)).RestoreUser(ctx.Param("id"), request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, services.NewUserView(user, viewer(ctx)))
+}
+
+// @Summary Delete my account
+// @Description Schedules the authenticated user's account for deletion after a grace period and signs them out. Logging back in before then cancels the deletion; afterwards personal data is anonymized and the account deleted.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param deleteAccount body models.DeleteAccountRequest true "Password confirmation"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /users/me [delete]
+func (c *UserController) DeleteOwnAccount(ctx *gin.Context) {
+	var request models.DeleteAccountRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.userService.ForRequest(auditContext(ctx)).ScheduleAccountDeletion(ctx.GetString("userID"), request, requestMeta(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookController struct {
+	webhookService services.WebhookService
+}
+
+func NewWebhookController(webhookService services.WebhookService) *WebhookController {
+	return &WebhookController{webhookService}
+}
+
+// @Summary List webhooks
+// @Description Lists the organization's webhook endpoints. Requires the webhooks:manage permission.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} models.WebhookEndpoint
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /webhooks [get]
+func (c *WebhookController) GetWebhooks(ctx *gin.Context) {
+	endpoints, err := c.webhookService.ForRequest(auditContext(ctx)).GetEndpoints()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, endpoints)
+}
+
+// @Summary Get a webhook
+// @Description Retrieves a webhook endpoint by ID.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} models.WebhookEndpoint
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /webhooks/{id} [get]
+func (c *WebhookController) GetWebhook(ctx *gin.Context) {
+	endpoint, err := c.webhookService.ForRequest(auditContext(ctx)).GetEndpoint(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, endpoint)
+}
+
+// @Summary Register a webhook
+// @Description Registers a URL to receive user events: user.created, user.updated, user.deleted, user.password_changed, user.role_changed and user.login. Leave events empty to receive all of them. Each delivery is a JSON POST with X-Webhook-Event, X-Webhook-Event-Id, X-Webhook-Delivery and X-Webhook-Timestamp headers, and X-Webhook-Signature set to "sha256=" and the hex HMAC-SHA256 of "{timestamp}.{body}" keyed with the secret. The secret is only returned here. Deliveries that fail are retried with exponential backoff.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param webhook body models.CreateWebhookRequest true "Webhook"
+// @Success 201 {object} models.CreatedWebhookEndpoint
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /webhooks [post]
+func (c *WebhookController) CreateWebhook(ctx *gin.Context) {
+	var request models.CreateWebhookRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	endpoint, err := c.webhookService.ForRequest(auditContext(ctx)).CreateEndpoint(request, ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, endpoint)
+}
+
+// @Summary Update a webhook
+// @Description Changes a webhook's URL, description or events, or disables it. Deliveries to a disabled webhook fail without being sent.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Webhook ID"
+// @Param webhook body models.UpdateWebhookRequest true "Changes"
+// @Success 200 {object} models.WebhookEndpoint
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /webhooks/{id} [patch]
+func (c *WebhookController) UpdateWebhook(ctx *gin.Context) {
+	var request models.UpdateWebhookRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	endpoint, err := c.webhookService.ForRequest(auditContext(ctx)).UpdateEndpoint(ctx.Param("id"), request)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, endpoint)
+}
+
+// @Summary Delete a webhook
+// @Description Deletes a webhook endpoint and its delivery log.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Webhook ID"
+// @Success 200 {object} models.SuccessResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /webhooks/{id} [delete]
+func (c *WebhookController) DeleteWebhook(ctx *gin.Context) {
+	response, err := c.webhookService.ForRequest(auditContext(ctx)).DeleteEndpoint(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary List a webhook's deliveries
+// @Description Lists the deliveries made to a webhook, newest first, with the status, attempt count and response of the latest attempt.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Webhook ID"
+// @Param pageNumber query int false "Page number for pagination" default(1)
+// @Param pageSize query int false "Number of deliveries per page" default(10)
+// @Param status query string false "Filter by delivery status" Enums(pending, succeeded, failed)
+// @Param event query string false "Filter by event, e.g. user.created"
+// @Success 200 {object} models.PaginatedResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Router /webhooks/{id}/deliveries [get]
+func (c *WebhookController) GetDeliveries(ctx *gin.Context) {
+	var request models.GetWebhookDeliveriesRequest
+	if err := ctx.ShouldBindQuery(&request); err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	response, err := c.webhookService.ForRequest(auditContext(ctx)).GetDeliveries(ctx.Param("id"), request)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// @Summary Redeliver a webhook event
+// @Description Sends a delivery's payload to the webhook again, as a new delivery with the same event ID. The webhook must be enabled.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path string true "Webhook ID"
+// @Param deliveryId path string true "Delivery ID"
+// @Success 202 {object} models.WebhookDelivery
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Router /webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func (c *WebhookController) Redeliver(ctx *gin.Context) {
+	delivery, err := c.webhookService.ForRequest(auditContext(ctx)).Redeliver(ctx.Param("id"), ctx.Param("deliveryId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusAccepted, delivery)
+}
@@ -0,0 +1,49 @@
+package events
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/umwaribenie/final_user_management/models"
+)
+
+// Handler reacts to a domain event. An event is handed to every handler again when any of them
+// fails, so handlers must tolerate seeing an event twice.
+type Handler func(event models.OutboxEvent) error
+
+// Bus is an in-process sink: it hands each event to the handlers subscribed to its type, after the
+// change it describes has been committed.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[models.DomainEventType][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[models.DomainEventType][]Handler)}
+}
+
+// Subscribe registers handler for events of the type, or for every event when eventType is empty.
+func (b *Bus) Subscribe(eventType models.DomainEventType, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+func (b *Bus) Name() string {
+	return "bus"
+}
+
+// Publish calls every handler for the event, and returns their errors.
+func (b *Bus) Publish(event models.OutboxEvent) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.handlers[event.Type]...), b.handlers[""]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
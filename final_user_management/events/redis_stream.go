@@ -0,0 +1,71 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	defaultStreamMaxLen = 100000
+	redisPublishTimeout = 5 * time.Second
+)
+
+// RedisStreamSink appends events to a Redis stream, trimmed to about maxLen entries. Each entry
+// carries the event's fields, with the payload as JSON.
+type RedisStreamSink struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+func NewRedisStreamSink(client *redis.Client, stream string, maxLen int64) *RedisStreamSink {
+	return &RedisStreamSink{client, stream, maxLen}
+}
+
+// RedisStreamFromEnv returns a sink for the stream named by OUTBOX_REDIS_STREAM, trimmed to
+// OUTBOX_REDIS_STREAM_MAXLEN entries (default 100000), or nil when no stream is set.
+func RedisStreamFromEnv(client *redis.Client) *RedisStreamSink {
+	stream := os.Getenv("OUTBOX_REDIS_STREAM")
+	if stream == "" {
+		return nil
+	}
+	maxLen, err := strconv.ParseInt(os.Getenv("OUTBOX_REDIS_STREAM_MAXLEN"), 10, 64)
+	if err != nil || maxLen <= 0 {
+		maxLen = defaultStreamMaxLen
+	}
+	return NewRedisStreamSink(client, stream, maxLen)
+}
+
+func (s *RedisStreamSink) Name() string {
+	return "redis:" + s.stream
+}
+
+func (s *RedisStreamSink) Publish(event models.OutboxEvent) error {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisPublishTimeout)
+	defer cancel()
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.stream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":             event.ID,
+			"type":           string(event.Type),
+			"aggregateType":  event.AggregateType,
+			"aggregateId":    event.AggregateID,
+			"organizationId": event.OrganizationID,
+			"occurredAt":     event.OccurredAt.UTC().Format(time.RFC3339Nano),
+			"payload":        string(payload),
+		},
+	}).Err()
+}
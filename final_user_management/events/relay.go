@@ -0,0 +1,108 @@
+package events
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultRetentionDays = 7
+)
+
+// Sink is a destination domain events are published to. Publish may be called again for an event
+// it has already accepted, so sinks pass the event ID on for consumers to drop duplicates.
+type Sink interface {
+	Name() string
+	Publish(event models.OutboxEvent) error
+}
+
+// Relay publishes the events written to the outbox to every sink, at least once. An event is only
+// marked published once all sinks have accepted it; when one fails, the event is retried on the
+// next run along with the sinks that already took it. Events about the same aggregate are
+// published in the order they were written: after a failure, the aggregate's later events wait
+// for the next run.
+type Relay struct {
+	outbox repositories.OutboxRepository
+	sinks  []Sink
+}
+
+func NewRelay(outbox repositories.OutboxRepository, sinks ...Sink) *Relay {
+	return &Relay{outbox, sinks}
+}
+
+// RelayBatch publishes the oldest pending events and returns how many were published. It does
+// nothing while another server is relaying.
+func (r *Relay) RelayBatch() (int, error) {
+	published := 0
+	_, err := r.outbox.WithRelayLock(func(outbox repositories.OutboxRepository) error {
+		events, err := outbox.FindPending(defaultBatchSize)
+		if err != nil {
+			return err
+		}
+		blocked := make(map[string]bool)
+		for _, event := range events {
+			aggregate := event.AggregateType + ":" + event.AggregateID
+			if blocked[aggregate] {
+				continue
+			}
+			if err := r.publish(event); err != nil {
+				blocked[aggregate] = true
+				// Log the first failure and every tenth after it rather than every run
+				if event.Attempts%10 == 0 {
+					log.Printf("Failed to publish %s event %s (attempt %d): %v", event.Type, event.ID, event.Attempts+1, err)
+				}
+				if err := outbox.MarkFailed(event.Position, err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := outbox.MarkPublished(event.Position); err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	return published, err
+}
+
+func (r *Relay) publish(event models.OutboxEvent) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(event); err != nil {
+			return &sinkError{sink.Name(), err}
+		}
+	}
+	return nil
+}
+
+// Cleanup deletes published events older than OUTBOX_RETENTION_DAYS (default 7) and returns how
+// many were deleted.
+func (r *Relay) Cleanup() (int64, error) {
+	return r.outbox.DeletePublishedBefore(time.Now().AddDate(0, 0, -retentionDaysFromEnv()))
+}
+
+func retentionDaysFromEnv() int {
+	if days, err := strconv.Atoi(os.Getenv("OUTBOX_RETENTION_DAYS")); err == nil && days > 0 {
+		return days
+	}
+	return defaultRetentionDays
+}
+
+type sinkError struct {
+	sink string
+	err  error
+}
+
+func (e *sinkError) Error() string {
+	return e.sink + ": " + e.err.Error()
+}
+
+func (e *sinkError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+)
+
+// webhookEvents maps domain events to the webhook events endpoints subscribe to. Domain events
+// missing here are not sent to webhooks.
+var webhookEvents = map[models.DomainEventType]models.WebhookEvent{
+	models.DomainUserRegistered:      models.WebhookUserCreated,
+	models.DomainUserCreated:         models.WebhookUserCreated,
+	models.DomainUserUpdated:         models.WebhookUserUpdated,
+	models.DomainUserStatusChanged:   models.WebhookUserUpdated,
+	models.DomainUserRestored:        models.WebhookUserUpdated,
+	models.DomainUserDeleted:         models.WebhookUserDeleted,
+	models.DomainUserPasswordChanged: models.WebhookUserPasswordChanged,
+	models.DomainUserRolesChanged:    models.WebhookUserRoleChanged,
+}
+
+// WebhookSink queues deliveries of domain events to the organization's webhook endpoints. The
+// delivery's event ID is the domain event's ID, so an event published twice reaches endpoints as
+// a duplicate they can recognize.
+type WebhookSink struct {
+	webhooks services.WebhookService
+}
+
+func NewWebhookSink(webhooks services.WebhookService) *WebhookSink {
+	return &WebhookSink{webhooks}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhooks"
+}
+
+func (s *WebhookSink) Publish(event models.OutboxEvent) error {
+	webhookEvent, ok := webhookEvents[event.Type]
+	if !ok || event.OrganizationID == "" {
+		return nil
+	}
+	data := map[string]interface{}{"domainEvent": event.Type}
+	for key, value := range event.Payload {
+		data[key] = value
+	}
+	return s.webhooks.Publish(event.OrganizationID, webhookEvent, event.ID, data)
+}
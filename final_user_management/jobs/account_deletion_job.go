@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/services"
+)
+
+// StartAccountDeletionJob carries out self-service account deletions whose grace period has ended.
+// It runs once at startup and then on every interval, in the background.
+func StartAccountDeletionJob(userService services.UserService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			deleted, err := userService.ProcessScheduledDeletions()
+			if err != nil {
+				log.Printf("Account deletion job failed: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Account deletion job anonymized and deleted %d accounts", deleted)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,32 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/services"
+)
+
+// StartJobCleanup deletes background jobs whose output files have expired and fails the ones
+// that were interrupted, such as by a restart. It runs once at startup and then on every
+// interval, in the background.
+func StartJobCleanup(jobService services.JobService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if failed, err := jobService.FailInterrupted(); err != nil {
+				log.Printf("Failed to fail interrupted jobs: %v", err)
+			} else if failed > 0 {
+				log.Printf("Marked %d interrupted jobs as failed", failed)
+			}
+			removed, err := jobService.CleanupExpired()
+			if err != nil {
+				log.Printf("Job cleanup failed: %v", err)
+			} else if removed > 0 {
+				log.Printf("Job cleanup removed %d expired jobs", removed)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/events"
+)
+
+// StartOutboxRelay publishes outbox events to the relay's sinks, in the background. It drains
+// everything pending, then waits for the interval.
+func StartOutboxRelay(relay *events.Relay, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for {
+				published, err := relay.RelayBatch()
+				if err != nil {
+					log.Printf("Outbox relay failed: %v", err)
+					break
+				}
+				if published == 0 {
+					break
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// StartOutboxCleanup deletes published outbox events once they are past retention.
+// It runs once at startup and then on every interval, in the background.
+func StartOutboxCleanup(relay *events.Relay, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			removed, err := relay.Cleanup()
+			if err != nil {
+				log.Printf("Outbox cleanup failed: %v", err)
+			} else if removed > 0 {
+				log.Printf("Outbox cleanup removed %d published events", removed)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,26 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/services"
+)
+
+// StartPurgeJob permanently removes users whose post-deletion retention period has passed.
+// It runs once at startup and then on every interval, in the background.
+func StartPurgeJob(userService services.UserService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			purged, err := userService.PurgeDeletedUsers()
+			if err != nil {
+				log.Printf("Purge job failed: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purge job permanently deleted %d users", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
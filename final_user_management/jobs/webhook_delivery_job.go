@@ -0,0 +1,34 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/services"
+)
+
+// StartWebhookDelivery sends queued webhook deliveries, in the background. It drains everything
+// that is due, then waits until new deliveries are queued or the interval passes, which is when
+// retries come due.
+func StartWebhookDelivery(webhookService services.WebhookService, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for {
+				attempted, err := webhookService.DeliverDue()
+				if err != nil {
+					log.Printf("Webhook delivery failed: %v", err)
+					break
+				}
+				if attempted == 0 {
+					break
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-webhookService.Due():
+			}
+		}
+	}()
+}
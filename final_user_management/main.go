@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	// Project imports (ensure these match your go.mod module name)
 	"github.com/umwaribenie/final_user_management/controllers"
 	"github.com/umwaribenie/final_user_management/docs" // Import generated docs for Swagger
-	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/events"
+	"github.com/umwaribenie/final_user_management/jobs"
+	"github.com/umwaribenie/final_user_management/policy"
 	"github.com/umwaribenie/final_user_management/repositories"
 	"github.com/umwaribenie/final_user_management/routes"
 	"github.com/umwaribenie/final_user_management/services"
+	"github.com/umwaribenie/final_user_management/siem"
 	"github.com/umwaribenie/final_user_management/utils"
 
 	// External libraries
@@ -58,6 +63,12 @@ func main() {
 
 	// 3. Set JWT secret key
 	utils.SetJWTSecret(os.Getenv("JWT_SECRET_KEY"))
+	utils.SetDeviceTokenSecret(os.Getenv("DEVICE_TOKEN_SECRET"))
+	// Anyone who knows this secret can forge password reset and "this wasn't me" links
+	if os.Getenv("PASSWORD_RESET_SECRET") == "" {
+		log.Fatal("PASSWORD_RESET_SECRET must be set")
+	}
+	utils.SetPasswordResetSecret(os.Getenv("PASSWORD_RESET_SECRET"))
 
 	// 4. Initialize Redis client
 	redisClient := redis.NewClient(&redis.Options{
@@ -90,33 +101,120 @@ func main() {
 	log.Println("Successfully connected to the database!")
 
 	// 6. Auto migrate the database models
-	err = db.AutoMigrate(&models.User{})
+	err = repositories.Migrate(db)
 	if err != nil {
 		log.Fatal("Failed to auto migrate:", err)
 	}
 	log.Println("Database migration completed.")
 
+	// Security events and audit entries are also sent to the SIEM sinks set up in the environment
+	siemExporter, err := siem.NewExporterFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure SIEM export:", err)
+	}
+	if sinks := siemExporter.Sinks(); len(sinks) > 0 {
+		log.Printf("Exporting security events to %s", strings.Join(sinks, ", "))
+	}
+
 	// 7. Initialize repositories
 	userRepo := repositories.NewUserRepository(db)
+	securityEventRepo := siem.SecurityEvents(repositories.NewSecurityEventRepository(db), siemExporter)
+	sessionRepo := repositories.NewSessionRepository(db)
+	jobRepo := repositories.NewJobRepository(db)
+	dataKeyRepo := repositories.NewDataKeyRepository(db)
+	roleRepo := repositories.NewRoleRepository(db)
+	groupRepo := repositories.NewGroupRepository(db)
+	organizationRepo := repositories.NewOrganizationRepository(db)
+	auditRepo := siem.AuditLog(repositories.NewAuditRepository(db), siemExporter)
+	webhookRepo := repositories.NewWebhookRepository(db)
+	outboxRepo := repositories.NewOutboxRepository(db)
+	scimTokenRepo := repositories.NewScimTokenRepository(db)
+	invitationRepo := repositories.NewInvitationRepository(db)
+	transactor := repositories.NewTransactor(db)
 
 	// 8. Initialize services
+	// Sign-ins are sent to webhook endpoints as they happen; other user events come from the outbox
+	webhookService := services.NewWebhookService(webhookRepo, userRepo, auditRepo, transactor)
+	securityEventRepo = services.WebhookSecurityEvents(securityEventRepo, webhookService)
+
 	// THIS IS THE FIX: Pass the redisClient to the auth service constructor
-	authService := services.NewAuthService(userRepo, redisClient)
-	userService := services.NewUserService(userRepo)
+	sessionService := services.NewSessionService(sessionRepo, userRepo, organizationRepo, auditRepo, transactor, outboxRepo)
+	authService := services.NewAuthService(userRepo, securityEventRepo, sessionRepo, sessionService, roleRepo, groupRepo, redisClient, auditRepo, transactor, outboxRepo)
+	userService := services.NewUserService(userRepo, securityEventRepo, sessionService, roleRepo, invitationRepo, organizationRepo, auditRepo, transactor, outboxRepo)
+	impersonationService := services.NewImpersonationService(userRepo, securityEventRepo, sessionService, roleRepo, auditRepo, transactor)
+	jobService := services.NewJobService(jobRepo)
+	dataExportService := services.NewDataExportService(userRepo, sessionRepo, securityEventRepo, jobService, auditRepo)
+	fieldEncryptionService := services.NewFieldEncryptionService(dataKeyRepo, userRepo, jobService, auditRepo, transactor)
+	roleService := services.NewRoleService(roleRepo, userRepo, sessionService, auditRepo, transactor, outboxRepo)
+	groupService := services.NewGroupService(groupRepo, roleRepo, userRepo, sessionService, auditRepo, transactor)
+	organizationService := services.NewOrganizationService(organizationRepo, userService, auditRepo, transactor)
+	userExportService := services.NewUserExportService(userRepo, jobService, auditRepo)
+	userImportService := services.NewUserImportService(userService, userRepo, roleRepo, jobService)
+	scimService := services.NewScimService(scimTokenRepo, userRepo, groupRepo, roleRepo, sessionService, auditRepo, transactor, outboxRepo)
+
+	// Access policies come from AUTHZ_POLICY_FILE, or the built-in defaults when it is unset
+	policyEngine, err := policy.Load(os.Getenv("AUTHZ_POLICY_FILE"))
+	if err != nil {
+		log.Fatal("Failed to load access policies:", err)
+	}
+	authorizationService := services.NewAuthorizationService(policyEngine, userRepo, roleRepo, groupRepo)
+	auditService := services.NewAuditService(auditRepo)
+	bulkUserService := services.NewBulkUserService(userRepo, roleRepo, securityEventRepo, sessionService, authorizationService, jobService, auditRepo, transactor, outboxRepo)
+
+	// Domain events written to the outbox are relayed to the in-process bus, webhooks and, when
+	// OUTBOX_REDIS_STREAM is set, a Redis stream
+	eventBus := events.NewBus()
+	eventSinks := []events.Sink{eventBus, events.NewWebhookSink(webhookService)}
+	if stream := events.RedisStreamFromEnv(redisClient); stream != nil {
+		eventSinks = append(eventSinks, stream)
+	}
+	outboxRelay := events.NewRelay(outboxRepo, eventSinks...)
+
+	// Load the field encryption keys and encrypt any rows still stored in plaintext
+	if err := fieldEncryptionService.LoadKeys(); err != nil {
+		log.Fatal("Failed to load field encryption keys:", err)
+	}
+	if count, err := fieldEncryptionService.ReencryptUsers(); err != nil {
+		log.Fatal("Failed to encrypt existing user fields:", err)
+	} else if count > 0 {
+		log.Printf("Encrypted sensitive fields for %d users.", count)
+	}
 
 	// 9. Initialize controllers
-	userController := controllers.NewUserController(userService)
+	userController := controllers.NewUserController(userService, authorizationService)
 	authController := controllers.NewAuthController(authService)
+	impersonationController := controllers.NewImpersonationController(impersonationService)
+	exportController := controllers.NewExportController(dataExportService, userExportService)
+	jobController := controllers.NewJobController(jobService)
+	encryptionController := controllers.NewEncryptionController(fieldEncryptionService)
+	roleController := controllers.NewRoleController(roleService)
+	groupController := controllers.NewGroupController(groupService)
+	organizationController := controllers.NewOrganizationController(organizationService)
+	authzController := controllers.NewAuthzController(authorizationService)
+	auditController := controllers.NewAuditController(auditService)
+	webhookController := controllers.NewWebhookController(webhookService)
+	scimController := controllers.NewScimController(scimService)
+	importController := controllers.NewImportController(userImportService)
+	bulkController := controllers.NewBulkController(bulkUserService)
+	invitationController := controllers.NewInvitationController(userService)
 
 	// 10. Set up router and routes
 	router := gin.Default()
-	routes.SetupRouter(router, userController, authController)
+	routes.SetupRouter(router, userController, authController, impersonationController, exportController, jobController, encryptionController, roleController, groupController, organizationController, authzController, auditController, webhookController, scimController, importController, bulkController, invitationController, sessionService, impersonationService, organizationService, authorizationService, scimService)
+
+	// 11. Start background jobs
+	jobs.StartPurgeJob(userService, time.Hour)
+	jobs.StartAccountDeletionJob(userService, time.Hour)
+	jobs.StartJobCleanup(jobService, time.Hour)
+	jobs.StartWebhookDelivery(webhookService, 15*time.Second)
+	jobs.StartOutboxRelay(outboxRelay, time.Second)
+	jobs.StartOutboxCleanup(outboxRelay, time.Hour)
 
-	// 11. Setup Swagger
+	// 12. Setup Swagger
 	docs.SwaggerInfo.BasePath = "/"
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	// 12. Start server
+	// 13. Start server
 	log.Printf("Server running on port %s", serverPort)
 	log.Fatal(router.Run(":" + serverPort))
 }
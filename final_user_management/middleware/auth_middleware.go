@@ -1,32 +1,206 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
 	"github.com/umwaribenie/final_user_management/utils"
 )
 
-// AuthMiddleware validates the JWT in the Authorization header
-// and puts "userID" into the Gin context.
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware validates the JWT in the Authorization header, checks that its
+// session is still active, and puts "userID", "tenantID", "role", "permissions" and "sessionID"
+// into the Gin context.
+// For impersonation tokens, "userID" is the impersonated user and "impersonatorID" is the
+// acting admin; every such request is recorded once the handler has run.
+func AuthMiddleware(sessionService services.SessionService, impersonationService services.ImpersonationService) gin.HandlerFunc {
+	return authenticate(sessionService, impersonationService, true)
+}
+
+// OptionalAuthMiddleware is AuthMiddleware for public endpoints whose response depends on
+// who is asking: requests without a valid token carry on anonymously instead of being rejected.
+func OptionalAuthMiddleware(sessionService services.SessionService, impersonationService services.ImpersonationService) gin.HandlerFunc {
+	return authenticate(sessionService, impersonationService, false)
+}
+
+func authenticate(sessionService services.SessionService, impersonationService services.ImpersonationService, required bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		reject := func() {
+			if required {
+				c.AbortWithStatusJSON(401, models.ErrorResponse{Error: "invalid token"})
+				return
+			}
+			c.Next()
+		}
+
 		auth := c.GetHeader("Authorization")
 		if !strings.HasPrefix(auth, "Bearer ") {
-			c.AbortWithStatusJSON(401, models.ErrorResponse{Error: "invalid token"})
+			reject()
 			return
 		}
 
 		tokenString := strings.TrimPrefix(auth, "Bearer ")
 		claims, err := utils.ValidateJWT(tokenString)
 		if err != nil {
-			c.AbortWithStatusJSON(401, models.ErrorResponse{Error: "invalid token"})
+			reject()
+			return
+		}
+		if err := sessionService.Validate(claims); err != nil {
+			reject()
+			return
+		}
+		// A token only works in its own organization. A request that names another one is
+		// refused rather than served from either.
+		if c.GetBool("tenantExplicit") && c.GetString("tenantID") != claims.OrgID {
+			reject()
 			return
 		}
 
 		// put the user ID into context so handlers can retrieve it
 		c.Set("userID", claims.UserID)
+		c.Set("tenantID", claims.OrgID)
+		c.Set("role", claims.Role)
+		c.Set("permissions", claims.Perms)
+		c.Set("sessionID", claims.ID)
+		c.Set("amr", claims.AMR)
+		if claims.AuthTime != nil {
+			c.Set("authTime", claims.AuthTime.Time)
+		}
+
+		if claims.Act == nil {
+			c.Next()
+			return
+		}
+
+		c.Set("impersonatorID", claims.Act.Sub)
+		c.Next()
+		impersonationService.RecordRequest(
+			claims.UserID, claims.Act.Sub, c.Request.Method, c.Request.URL.Path, c.Writer.Status(),
+			models.RequestMeta{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()},
+		)
+	}
+}
+
+// HasPermission reports whether the authenticated user's token grants the permission.
+func HasPermission(c *gin.Context, permission models.Permission) bool {
+	return models.HasPermission(c.GetStringSlice("permissions"), permission)
+}
+
+// RequirePermission only lets the request through when the token grants every one of the
+// permissions. It must run after AuthMiddleware.
+func RequirePermission(permissions ...models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if !HasPermission(c, permission) {
+				c.AbortWithStatusJSON(403, models.ErrorResponse{Error: "forbidden"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// DenyImpersonation blocks requests made with an impersonation token, for operations
+// (password, MFA, email changes) that only the real account holder may perform.
+// It must run after AuthMiddleware.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("impersonatorID") != "" {
+			c.AbortWithStatusJSON(403, models.ErrorResponse{Error: "not allowed while impersonating"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrPermission only lets the request through when the authenticated user
+// is the one named by the ":id" path parameter, or holds the permission.
+// It must run after AuthMiddleware.
+func RequireSelfOrPermission(permission models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("userID") == c.Param("id") || HasPermission(c, permission) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(403, models.ErrorResponse{Error: "forbidden"})
+	}
+}
+
+// Authorize only lets the request through when the policy engine allows the authenticated user
+// to perform the action on the user named by the ":id" path parameter. Role permissions grant
+// actions as before, attribute policies can allow more (such as users editing themselves) or
+// deny what a role would allow. It must run after AuthMiddleware.
+func Authorize(authorizationService services.AuthorizationService, action models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CheckAuthorization(c, authorizationService, action, c.Param("id")) {
+			return
+		}
 		c.Next()
 	}
 }
+
+// CheckAuthorization is the inline form of Authorize for handlers whose action depends on the
+// request. It writes the error response and returns false when the action is not allowed.
+func CheckAuthorization(c *gin.Context, authorizationService services.AuthorizationService, action models.Permission, resourceID string) bool {
+	viewer := models.Viewer{UserID: c.GetString("userID"), Permissions: c.GetStringSlice("permissions")}
+	decision, err := authorizationService.ForTenant(c.GetString("tenantID")).Check(viewer, string(action), resourceID)
+	if errors.Is(err, services.ErrResourceNotFound) {
+		c.AbortWithStatusJSON(404, models.ErrorResponse{Error: err.Error()})
+		return false
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(500, models.ErrorResponse{Error: err.Error()})
+		return false
+	}
+	if !decision.Allowed {
+		c.AbortWithStatusJSON(403, models.ErrorResponse{Error: "forbidden: " + decision.Reason})
+		return false
+	}
+	return true
+}
+
+// ReauthMaxAge is how recently a user must have authenticated to perform sensitive operations.
+const ReauthMaxAge = 5 * time.Minute
+
+// RequireRecentAuth rejects the request with a structured "reauth_required" error unless the
+// token's auth_time is within maxAge and, when methods are given, its amr includes one of them.
+// It must run after AuthMiddleware.
+func RequireRecentAuth(maxAge time.Duration, methods ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !CheckRecentAuth(c, maxAge, methods...) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// CheckRecentAuth is the inline form of RequireRecentAuth for handlers that only need step-up
+// for some requests. It writes the "reauth_required" response and returns false when the check fails.
+func CheckRecentAuth(c *gin.Context, maxAge time.Duration, methods ...string) bool {
+	authTime := c.GetTime("authTime")
+	recent := !authTime.IsZero() && time.Since(authTime) <= maxAge
+	if recent && len(methods) > 0 {
+		recent = false
+		for _, used := range c.GetStringSlice("amr") {
+			for _, method := range methods {
+				if used == method {
+					recent = true
+				}
+			}
+		}
+	}
+	if recent {
+		return true
+	}
+
+	c.AbortWithStatusJSON(401, models.ReauthRequiredResponse{
+		Error:   "reauth_required",
+		Message: "this operation requires you to re-authenticate",
+		MaxAge:  int(maxAge.Seconds()),
+		Methods: methods,
+	})
+	return false
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umwaribenie/final_user_management/scim"
+	"github.com/umwaribenie/final_user_management/services"
+)
+
+// ScimAuth authenticates SCIM requests with one of an organization's SCIM tokens and puts the
+// token into the Gin context as "scimToken" and its organization as "tenantID". Like
+// AuthMiddleware, it refuses a request that names another organization. Failures are answered
+// with SCIM error messages.
+func ScimAuth(scimService services.ScimService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found {
+			abortScim(c, scim.NewError(401, "", "a SCIM bearer token is required"))
+			return
+		}
+		token, err := scimService.Authenticate(value)
+		if err != nil || (c.GetBool("tenantExplicit") && c.GetString("tenantID") != token.OrganizationID) {
+			abortScim(c, scim.NewError(401, "", "invalid token"))
+			return
+		}
+
+		c.Set("scimToken", token)
+		c.Set("tenantID", token.OrganizationID)
+		c.Next()
+	}
+}
+
+func abortScim(c *gin.Context, err *scim.Error) {
+	if err.StatusCode() == 401 {
+		c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+	}
+	c.Header("Content-Type", scim.ContentType)
+	c.AbortWithStatusJSON(err.StatusCode(), err)
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
+)
+
+// TenantHeader names the organization, by slug or ID, that a request is for.
+const TenantHeader = "X-Tenant"
+
+// ResolveTenant works out which organization the request is for and puts its ID into the Gin
+// context as "tenantID". The X-Tenant header wins over the subdomain of the Host, which is only
+// considered when TENANT_BASE_DOMAIN is set; requests naming neither are served from the
+// default organization. AuthMiddleware then pins authenticated requests to the organization
+// in their token.
+func ResolveTenant(organizationService services.OrganizationService) gin.HandlerFunc {
+	baseDomain := strings.ToLower(strings.Trim(os.Getenv("TENANT_BASE_DOMAIN"), "."))
+	return func(c *gin.Context) {
+		identifier := strings.TrimSpace(c.GetHeader(TenantHeader))
+		if identifier == "" {
+			identifier = subdomain(c.Request.Host, baseDomain)
+		}
+
+		var organization *models.Organization
+		var err error
+		if identifier == "" {
+			organization, err = organizationService.Default()
+		} else {
+			organization, err = organizationService.Resolve(identifier)
+			c.Set("tenantExplicit", true)
+		}
+		if err != nil {
+			status := 404
+			if errors.Is(err, services.ErrOrganizationInactive) {
+				status = 403
+			}
+			c.AbortWithStatusJSON(status, models.ErrorResponse{Error: err.Error()})
+			return
+		}
+
+		c.Set("tenantID", organization.ID)
+		c.Next()
+	}
+}
+
+// subdomain returns the part of host in front of baseDomain, or "" when host is not a
+// subdomain of it.
+func subdomain(host string, baseDomain string) string {
+	if baseDomain == "" {
+		return ""
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	if !strings.HasSuffix(host, "."+baseDomain) {
+		return ""
+	}
+	return strings.TrimSuffix(host, "."+baseDomain)
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// AuditAction names an administrative or security action recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditUserRegistered         AuditAction = "user.registered"
+	AuditUserCreated            AuditAction = "user.created"
+	AuditUserUpdated            AuditAction = "user.updated"
+	AuditUserStatusChanged      AuditAction = "user.status_changed"
+	AuditUserDeleted            AuditAction = "user.deleted"
+	AuditUserRestored           AuditAction = "user.restored"
+	AuditUserPasswordChanged    AuditAction = "user.password_changed"
+	AuditUserPasswordReset      AuditAction = "user.password_reset"
+	AuditUserPasswordResetAdmin AuditAction = "user.password_reset_by_admin"
+	AuditUserDeletionScheduled  AuditAction = "user.deletion_scheduled"
+	AuditUserAnonymized         AuditAction = "user.anonymized"
+	AuditUserRolesChanged       AuditAction = "user.roles_changed"
+	AuditUserPasswordForceReset AuditAction = "user.password_force_reset"
+	AuditUserSessionsRevoked    AuditAction = "user.sessions_revoked"
+	AuditUserDataExported       AuditAction = "user.data_exported"
+	AuditUsersExported          AuditAction = "users.exported"
+	AuditRoleCreated            AuditAction = "role.created"
+	AuditRoleUpdated            AuditAction = "role.updated"
+	AuditRoleDeleted            AuditAction = "role.deleted"
+	AuditGroupCreated           AuditAction = "group.created"
+	AuditGroupUpdated           AuditAction = "group.updated"
+	AuditGroupDeleted           AuditAction = "group.deleted"
+	AuditGroupMembersChanged    AuditAction = "group.members_changed"
+	AuditGroupRolesChanged      AuditAction = "group.roles_changed"
+	AuditOrganizationCreated    AuditAction = "organization.created"
+	AuditOrganizationUpdated    AuditAction = "organization.updated"
+	AuditImpersonationStarted   AuditAction = "impersonation.started"
+	AuditImpersonationStopped   AuditAction = "impersonation.stopped"
+	AuditEncryptionKeyRotated   AuditAction = "encryption.key_rotated"
+	AuditWebhookCreated         AuditAction = "webhook.created"
+	AuditWebhookUpdated         AuditAction = "webhook.updated"
+	AuditWebhookDeleted         AuditAction = "webhook.deleted"
+	AuditScimTokenCreated       AuditAction = "scim_token.created"
+	AuditScimTokenRevoked       AuditAction = "scim_token.revoked"
+	AuditInvitationCreated      AuditAction = "invitation.created"
+	AuditInvitationResent       AuditAction = "invitation.resent"
+	AuditInvitationExpired      AuditAction = "invitation.expired"
+	AuditInvitationRevoked      AuditAction = "invitation.revoked"
+	AuditInvitationAccepted     AuditAction = "invitation.accepted"
+)
+
+// AuditTargetType is the kind of record an audit entry is about.
+type AuditTargetType string
+
+const (
+	AuditTargetUser         AuditTargetType = "user"
+	AuditTargetRole         AuditTargetType = "role"
+	AuditTargetGroup        AuditTargetType = "group"
+	AuditTargetOrganization AuditTargetType = "organization"
+	AuditTargetDataKey      AuditTargetType = "data_key"
+	AuditTargetWebhook      AuditTargetType = "webhook"
+	AuditTargetScimToken    AuditTargetType = "scim_token"
+	AuditTargetInvitation   AuditTargetType = "invitation"
+)
+
+// AuditContext is who is acting and from where, as recorded on every audit entry of a request.
+// ActorID is empty for anonymous requests and for actions the system takes on its own.
+type AuditContext struct {
+	OrganizationID string
+	ActorID        string
+	ImpersonatorID string
+	IP             string
+	UserAgent      string
+	RequestID      string
+}
+
+// AuditChange is the old and new value of one field.
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// AuditRedacted replaces the values of secret fields in audit changes.
+const AuditRedacted = "[redacted]"
+
+// AuditEntry is one record in an organization's append-only audit log.
+//
+// Entries form a hash chain per organization: Hash covers the entry's contents and the previous
+// entry's hash, so editing, removing or reordering an entry breaks every hash after it.
+type AuditEntry struct {
+	ID             string                 `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string                 `gorm:"type:uuid;not null;uniqueIndex:idx_audit_entries_org_sequence,priority:1" json:"organizationId"`
+	Sequence       int64                  `gorm:"not null;uniqueIndex:idx_audit_entries_org_sequence,priority:2" json:"sequence"`
+	ActorID        *string                `gorm:"type:uuid;index" json:"actorId,omitempty"`
+	ImpersonatorID *string                `gorm:"type:uuid" json:"impersonatorId,omitempty"`
+	Action         AuditAction            `gorm:"type:varchar(64);index" json:"action"`
+	TargetType     AuditTargetType        `gorm:"type:varchar(32)" json:"targetType"`
+	TargetID       string                 `gorm:"index" json:"targetId"`
+	Changes        map[string]AuditChange `gorm:"type:text;serializer:json" json:"changes,omitempty"`
+	Details        map[string]interface{} `gorm:"type:text;serializer:json" json:"details,omitempty"`
+	IP             string                 `json:"ip"`
+	UserAgent      string                 `json:"userAgent"`
+	RequestID      string                 `json:"requestId"`
+	CreatedAt      time.Time              `gorm:"index" json:"createdAt"`
+	PrevHash       string                 `gorm:"type:varchar(64)" json:"prevHash"`
+	Hash           string                 `gorm:"type:varchar(64)" json:"hash"`
+}
+
+// ComputeHash returns the SHA-256 of the entry's contents and PrevHash, hex encoded.
+func (e *AuditEntry) ComputeHash() string {
+	content, _ := json.Marshal(struct {
+		OrganizationID string                 `json:"organizationId"`
+		Sequence       int64                  `json:"sequence"`
+		ActorID        *string                `json:"actorId"`
+		ImpersonatorID *string                `json:"impersonatorId"`
+		Action         AuditAction            `json:"action"`
+		TargetType     AuditTargetType        `json:"targetType"`
+		TargetID       string                 `json:"targetId"`
+		Changes        map[string]AuditChange `json:"changes"`
+		Details        map[string]interface{} `json:"details"`
+		IP             string                 `json:"ip"`
+		UserAgent      string                 `json:"userAgent"`
+		RequestID      string                 `json:"requestId"`
+		CreatedAt      string                 `json:"createdAt"`
+		PrevHash       string                 `json:"prevHash"`
+	}{
+		e.OrganizationID, e.Sequence, e.ActorID, e.ImpersonatorID, e.Action, e.TargetType, e.TargetID,
+		e.Changes, e.Details, e.IP, e.UserAgent, e.RequestID, e.CreatedAt.UTC().Format(time.RFC3339Nano), e.PrevHash,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
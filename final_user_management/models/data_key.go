@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// DataKeyPurpose says what a data key is used for.
+type DataKeyPurpose string
+
+const (
+	// DataKeyEncryption keys encrypt sensitive columns. Only one is active at a time;
+	// retired keys are kept so older values can still be decrypted.
+	DataKeyEncryption DataKeyPurpose = "encryption"
+	// DataKeyBlindIndex is the single key behind the blind indexes of encrypted columns.
+	DataKeyBlindIndex DataKeyPurpose = "blind_index"
+)
+
+// DataKey is a randomly generated key stored wrapped (encrypted) by the master key.
+// MasterKeyID is the fingerprint of the master key that wrapped it.
+type DataKey struct {
+	ID          string         `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Purpose     DataKeyPurpose `gorm:"type:varchar(20);index" json:"purpose"`
+	WrappedKey  []byte         `gorm:"not null" json:"-"`
+	MasterKeyID string         `gorm:"type:varchar(16)" json:"masterKeyId"`
+	Active      bool           `gorm:"default:false" json:"active"`
+	CreatedAt   time.Time      `gorm:"autoCreateTime" json:"createdAt"`
+	RetiredAt   *time.Time     `json:"retiredAt,omitempty"`
+}
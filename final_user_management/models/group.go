@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Group is a team of users. Groups can be nested: members of a subgroup count as members of
+// every group above it, and so inherit the roles granted to any of those groups.
+type Group struct {
+	ID             string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string    `gorm:"type:uuid;not null;uniqueIndex:idx_groups_org_name,priority:1" json:"organizationId"`
+	Name           string    `gorm:"uniqueIndex:idx_groups_org_name,priority:2" json:"name"`
+	Description    string    `json:"description"`
+	ParentID       *string   `gorm:"type:uuid;index" json:"parentId,omitempty"`
+	ExternalID     *string   `gorm:"index" json:"externalId,omitempty"`
+	CreatedAt      time.Time `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+// GroupMember links a user directly to a group.
+type GroupMember struct {
+	GroupID   string    `gorm:"primaryKey;type:uuid" json:"groupId"`
+	UserID    string    `gorm:"primaryKey;type:uuid;index" json:"userId"`
+	AddedBy   *string   `gorm:"type:uuid" json:"addedBy,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+// GroupRole grants a role to every member of a group and of its subgroups.
+type GroupRole struct {
+	GroupID   string    `gorm:"primaryKey;type:uuid" json:"groupId"`
+	RoleID    string    `gorm:"primaryKey;type:uuid;index" json:"roleId"`
+	GrantedBy *string   `gorm:"type:uuid" json:"grantedBy,omitempty"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"createdAt"`
+}
@@ -0,0 +1,85 @@
+package models
+
+import "time"
+
+// InvitationStatus is where an invitation is in its life. Only pending invitations can be
+// accepted.
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationExpired  InvitationStatus = "expired"
+	InvitationRevoked  InvitationStatus = "revoked"
+)
+
+// Invitation asks someone an administrator created as a pending user to choose a password and
+// activate their account. The invitee gets a link with a random token; only its hash is stored,
+// and resending the invitation replaces it.
+type Invitation struct {
+	ID             string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string `gorm:"type:uuid;not null;index" json:"organizationId"`
+	UserID         string `gorm:"type:uuid;not null;index" json:"userId"`
+	Email          string `gorm:"not null" json:"email"`
+	TokenHash      string `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	InvitedBy      string `gorm:"type:uuid;not null" json:"invitedBy"`
+	// SentCount is how many times the invitation email has been sent, including resends.
+	SentCount  int              `gorm:"not null;default:0" json:"sentCount"`
+	LastSentAt *time.Time       `json:"lastSentAt,omitempty"`
+	ExpiresAt  time.Time        `gorm:"not null;index" json:"expiresAt"`
+	AcceptedAt *time.Time       `json:"acceptedAt,omitempty"`
+	RevokedAt  *time.Time       `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time        `gorm:"autoCreateTime" json:"createdAt"`
+	Status     InvitationStatus `gorm:"-" json:"status"`
+}
+
+// StatusAt works out the invitation's status at the time.
+func (i *Invitation) StatusAt(now time.Time) InvitationStatus {
+	switch {
+	case i.AcceptedAt != nil:
+		return InvitationAccepted
+	case i.RevokedAt != nil:
+		return InvitationRevoked
+	case !now.Before(i.ExpiresAt):
+		return InvitationExpired
+	default:
+		return InvitationPending
+	}
+}
+
+// InviteUserRequest is the body of POST /invitations: the user to create, without a password.
+type InviteUserRequest struct {
+	Department     string   `json:"department"`
+	Email          string   `json:"email" binding:"required,email"`
+	FirstName      string   `json:"firstName" binding:"required"`
+	LastName       string   `json:"lastName" binding:"required"`
+	NationalID     *string  `json:"nationalId"`
+	PassportNumber *string  `json:"passportNumber"`
+	Phone          string   `json:"phone" binding:"required"`
+	ProfilePicture *string  `json:"profilePicture"`
+	Role           UserRole `json:"role" binding:"required"`
+	Username       string   `json:"username" binding:"required"`
+}
+
+// GetInvitationsRequest maps to the query parameters for listing invitations.
+type GetInvitationsRequest struct {
+	PageNumber int    `form:"pageNumber"`
+	PageSize   int    `form:"pageSize"`
+	Status     string `form:"status" binding:"omitempty,oneof=pending accepted expired revoked"`
+	Search     string `form:"search"`
+}
+
+// AcceptInvitationRequest is the body of POST /invitations/accept.
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// InvitationPreview is what the invitee sees before accepting: who the account is for.
+type InvitationPreview struct {
+	Email     string    `json:"email"`
+	Username  string    `json:"username"`
+	FirstName string    `json:"firstName"`
+	LastName  string    `json:"lastName"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
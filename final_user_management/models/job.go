@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// JobType identifies what a background job produces.
+type JobType string
+
+const (
+	JobTypeDataExport  JobType = "data_export"
+	JobTypeKeyRotation JobType = "key_rotation"
+	JobTypeUserImport  JobType = "user_import"
+	JobTypeUserExport  JobType = "user_export"
+	JobTypeUserBulk    JobType = "user_bulk"
+)
+
+// JobStatus tracks a background job from creation to completion.
+type JobStatus string
+
+const (
+	JobPending    JobStatus = "pending"
+	JobProcessing JobStatus = "processing"
+	JobCompleted  JobStatus = "completed"
+	JobFailed     JobStatus = "failed"
+)
+
+// Job is a long-running task run in the background. When it produces a file,
+// the file can be downloaded from /jobs/{id}/download until ExpiresAt.
+type Job struct {
+	ID          string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Type        JobType   `gorm:"type:varchar(50);index" json:"type"`
+	Status      JobStatus `gorm:"type:varchar(20);index" json:"status"`
+	RequestedBy string    `gorm:"type:uuid;index" json:"requestedBy"`
+	// OrganizationID is the tenant the job works on, or nil for deployment-wide jobs.
+	OrganizationID *string    `gorm:"type:uuid;index" json:"organizationId,omitempty"`
+	SubjectID      *string    `gorm:"type:uuid" json:"subjectId,omitempty"`
+	FilePath       string     `json:"-"`
+	FileName       string     `json:"fileName,omitempty"`
+	Result         string     `gorm:"type:text" json:"result,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"createdAt"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	// HeartbeatAt is refreshed while the job runs. A job whose heartbeat stops was interrupted,
+	// for instance by a restart, and is marked failed.
+	HeartbeatAt *time.Time `json:"-"`
+}
+
+// JobAcceptedResponse is returned with 202 when work has been queued as a background job.
+type JobAcceptedResponse struct {
+	Job         *Job   `json:"job"`
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// DefaultOrganizationSlug names the organization created by migrations. Existing data is
+// moved into it, and requests that do not name a tenant are served from it.
+const DefaultOrganizationSlug = "default"
+
+// RegistrationMode decides who may sign up through POST /users/register.
+type RegistrationMode string
+
+const (
+	// RegistrationOpen lets anyone sign up, with their account active straight away.
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationInviteOnly turns self-registration off; users join by invitation.
+	RegistrationInviteOnly RegistrationMode = "invite_only"
+	// RegistrationDomainRestricted lets anyone with an email address at an allowed domain sign up.
+	RegistrationDomainRestricted RegistrationMode = "domain_restricted"
+	// RegistrationApproval lets anyone sign up, but their account waits for an admin to approve it.
+	RegistrationApproval RegistrationMode = "approval"
+)
+
+// Organization is a tenant. Users, roles and groups each belong to exactly one organization,
+// and usernames, emails and other unique fields only need to be unique within it.
+type Organization struct {
+	ID     string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Name   string `json:"name"`
+	Slug   string `gorm:"uniqueIndex" json:"slug"`
+	Active bool   `gorm:"default:true" json:"active"`
+	// RegistrationMode, AllowedEmailDomains and ReservedNames are the tenant's self-registration
+	// policy. ReservedNames are refused as usernames and email local parts on top of the
+	// built-in ones such as admin and root.
+	RegistrationMode    RegistrationMode `gorm:"type:varchar(50);default:'open'" json:"registrationMode"`
+	AllowedEmailDomains []string         `gorm:"type:text;serializer:json" json:"allowedEmailDomains"`
+	ReservedNames       []string         `gorm:"type:text;serializer:json" json:"reservedNames"`
+	CreatedAt           time.Time        `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt           time.Time        `gorm:"autoUpdateTime" json:"updatedAt"`
+}
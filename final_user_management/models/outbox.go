@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// DomainEventType names something that happened to an aggregate, in "aggregate.past_tense" form.
+type DomainEventType string
+
+const (
+	DomainUserRegistered      DomainEventType = "user.registered"
+	DomainUserCreated         DomainEventType = "user.created"
+	DomainUserUpdated         DomainEventType = "user.updated"
+	DomainUserRolesChanged    DomainEventType = "user.roles_changed"
+	DomainUserStatusChanged   DomainEventType = "user.status_changed"
+	DomainUserDeleted         DomainEventType = "user.deleted"
+	DomainUserRestored        DomainEventType = "user.restored"
+	DomainUserPasswordChanged DomainEventType = "user.password_changed"
+)
+
+// AggregateUser is the aggregate type of events about a user.
+const AggregateUser = "user"
+
+// OutboxEvent is a domain event in the transactional outbox. It is written in the same transaction
+// as the change it describes and published afterwards by the relay, at least once. Position orders
+// the events; events about the same aggregate are published in that order.
+type OutboxEvent struct {
+	Position       int64                  `gorm:"primaryKey;autoIncrement" json:"position"`
+	ID             string                 `gorm:"type:uuid;not null;uniqueIndex;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string                 `gorm:"type:uuid;index" json:"organizationId"`
+	AggregateType  string                 `gorm:"type:varchar(32);not null" json:"aggregateType"`
+	AggregateID    string                 `gorm:"not null;index" json:"aggregateId"`
+	Type           DomainEventType        `gorm:"type:varchar(64);not null" json:"type"`
+	Payload        map[string]interface{} `gorm:"type:text;serializer:json" json:"payload"`
+	OccurredAt     time.Time              `gorm:"not null" json:"occurredAt"`
+	PublishedAt    *time.Time             `gorm:"index" json:"publishedAt,omitempty"`
+	Attempts       int                    `json:"attempts"`
+	LastError      string                 `json:"lastError,omitempty"`
+}
@@ -0,0 +1,86 @@
+package models
+
+// Permission names a single action a role may perform, in "resource:action" form.
+type Permission string
+
+const (
+	// PermUsersRead lets the holder see other users' account details, deleted users and login history.
+	PermUsersRead Permission = "users:read"
+	// PermUsersReadPII lets the holder see other users' identity documents and phone numbers unmasked.
+	PermUsersReadPII       Permission = "users:read-pii"
+	PermUsersWrite         Permission = "users:write"
+	PermUsersDelete        Permission = "users:delete"
+	PermUsersResetPassword Permission = "users:reset-password"
+	PermUsersManageStatus  Permission = "users:manage-status"
+	PermUsersImpersonate   Permission = "users:impersonate"
+	PermRolesManage        Permission = "roles:manage"
+	PermGroupsManage       Permission = "groups:manage"
+	PermKeysRotate         Permission = "keys:rotate"
+	// PermOrganizationsManage lets the holder create and deactivate tenants.
+	PermOrganizationsManage Permission = "organizations:manage"
+	// PermJobsRead lets the holder see background jobs started by other users.
+	PermJobsRead Permission = "jobs:read"
+	// PermAuditRead lets the holder read and verify the organization's audit log.
+	PermAuditRead Permission = "audit:read"
+	// PermWebhooksManage lets the holder register webhook endpoints and redeliver events.
+	PermWebhooksManage Permission = "webhooks:manage"
+	// PermScimManage lets the holder issue and revoke the tokens identity providers provision
+	// users with.
+	PermScimManage Permission = "scim:manage"
+)
+
+// AllPermissions lists every permission the system knows about.
+var AllPermissions = []Permission{
+	PermUsersRead,
+	PermUsersReadPII,
+	PermUsersWrite,
+	PermUsersDelete,
+	PermUsersResetPassword,
+	PermUsersManageStatus,
+	PermUsersImpersonate,
+	PermRolesManage,
+	PermGroupsManage,
+	PermKeysRotate,
+	PermJobsRead,
+	PermOrganizationsManage,
+	PermAuditRead,
+	PermWebhooksManage,
+	PermScimManage,
+}
+
+// PlatformPermissions act on the whole deployment rather than one tenant. Only roles in the
+// default organization are given them.
+var PlatformPermissions = []Permission{
+	PermKeysRotate,
+	PermOrganizationsManage,
+}
+
+// IsPlatform reports whether the permission acts on the whole deployment.
+func (p Permission) IsPlatform() bool {
+	for _, platform := range PlatformPermissions {
+		if platform == p {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValid reports whether the permission is one the system knows about.
+func (p Permission) IsValid() bool {
+	for _, known := range AllPermissions {
+		if known == p {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the granted permission names include the permission.
+func HasPermission(granted []string, permission Permission) bool {
+	for _, name := range granted {
+		if name == string(permission) {
+			return true
+		}
+	}
+	return false
+}
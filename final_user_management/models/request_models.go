@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // GetAllUsersRequest maps to the query parameters for fetching all users.
 type GetAllUsersRequest struct {
 	PageNumber int    `form:"pageNumber"`
@@ -11,9 +13,50 @@ type GetAllUsersRequest struct {
 	Status     string `form:"status"`
 }
 
-// CreateUserRequest is the model for self-registration.
+// GetAllGroupsRequest maps to the query parameters for listing groups.
+type GetAllGroupsRequest struct {
+	PageNumber int    `form:"pageNumber"`
+	PageSize   int    `form:"pageSize"`
+	Search     string `form:"search"`
+}
+
+// GetLoginHistoryRequest maps to the query parameters for a user's login history.
+type GetLoginHistoryRequest struct {
+	PageNumber int    `form:"pageNumber"`
+	PageSize   int    `form:"pageSize"`
+	Type       string `form:"type"`
+}
+
+// GetWebhookDeliveriesRequest maps to the query parameters for an endpoint's delivery log.
+type GetWebhookDeliveriesRequest struct {
+	PageNumber int    `form:"pageNumber"`
+	PageSize   int    `form:"pageSize"`
+	Status     string `form:"status"`
+	Event      string `form:"event"`
+}
+
+// GetAuditLogRequest maps to the query parameters for searching the audit log.
+type GetAuditLogRequest struct {
+	PageNumber int    `form:"pageNumber"`
+	PageSize   int    `form:"pageSize"`
+	ActorID    string `form:"actorId"`
+	TargetID   string `form:"targetId"`
+	TargetType string `form:"targetType"`
+	Action     string `form:"action"`
+	From       string `form:"from"`
+	To         string `form:"to"`
+}
+
+// RequestMeta carries details about the HTTP client that services record alongside events.
+type RequestMeta struct {
+	IP          string
+	UserAgent   string
+	DeviceToken string
+}
+
+// CreateUserRequest is the model for self-registration. The user's client ID is generated by
+// the server.
 type CreateUserRequest struct {
-	ClientID       string  `json:"clientId" binding:"required"`
 	Email          string  `json:"email" binding:"required,email"`
 	FirstName      string  `json:"firstName" binding:"required"`
 	LastName       string  `json:"lastName" binding:"required"`
@@ -27,6 +70,7 @@ type CreateUserRequest struct {
 
 // CreateUserByAdminRequest is the model for admin-driven user creation.
 type CreateUserByAdminRequest struct {
+	Department     string   `json:"department"`
 	Email          string   `json:"email" binding:"required,email"`
 	FirstName      string   `json:"firstName" binding:"required"`
 	LastName       string   `json:"lastName" binding:"required"`
@@ -35,7 +79,7 @@ type CreateUserByAdminRequest struct {
 	Password       string   `json:"password" binding:"required,min=6"`
 	Phone          string   `json:"phone" binding:"required"`
 	ProfilePicture *string  `json:"profilePicture"`
-	Role           UserRole `json:"role" binding:"required,oneof=user admin"` // Updated validation
+	Role           UserRole `json:"role" binding:"required"`
 	Username       string   `json:"username" binding:"required"`
 }
 
@@ -47,6 +91,7 @@ type UpdatePasswordRequest struct {
 
 // UpdateUserRequest is for patching a user's details.
 type UpdateUserRequest struct {
+	Department     *string   `json:"department,omitempty"`
 	Email          *string   `json:"email,omitempty"`
 	FirstName      *string   `json:"firstName,omitempty"`
 	LastName       *string   `json:"lastName,omitempty"`
@@ -54,10 +99,115 @@ type UpdateUserRequest struct {
 	PassportNumber *string   `json:"passportNumber,omitempty"`
 	Phone          *string   `json:"phone,omitempty"`
 	ProfilePicture *string   `json:"profilePicture,omitempty"`
-	Role           *UserRole `json:"role,omitempty" binding:"omitempty,oneof=user admin"`
+	Role           *UserRole `json:"role,omitempty"`
 	Username       *string   `json:"username,omitempty"`
 }
 
+// CreateRoleRequest defines a new role.
+type CreateRoleRequest struct {
+	Name        string       `json:"name" binding:"required"`
+	Description string       `json:"description"`
+	Permissions []Permission `json:"permissions" binding:"required"`
+}
+
+// UpdateRoleRequest changes a role. Omitted fields are left as they are.
+type UpdateRoleRequest struct {
+	Name        *string      `json:"name,omitempty"`
+	Description *string      `json:"description,omitempty"`
+	Permissions []Permission `json:"permissions,omitempty"`
+}
+
+// CreateWebhookRequest registers an endpoint. Leaving events empty subscribes it to all events.
+type CreateWebhookRequest struct {
+	URL         string         `json:"url" binding:"required,url"`
+	Description string         `json:"description"`
+	Events      []WebhookEvent `json:"events"`
+}
+
+// CreateScimTokenRequest issues a SCIM token. Without ExpiresInDays the token does not expire.
+type CreateScimTokenRequest struct {
+	Name          string `json:"name" binding:"required"`
+	ExpiresInDays int    `json:"expiresInDays" binding:"omitempty,min=1"`
+}
+
+// UpdateWebhookRequest changes an endpoint. Omitted fields are left as they are.
+type UpdateWebhookRequest struct {
+	URL         *string        `json:"url,omitempty" binding:"omitempty,url"`
+	Description *string        `json:"description,omitempty"`
+	Events      []WebhookEvent `json:"events,omitempty"`
+	Active      *bool          `json:"active,omitempty"`
+}
+
+// AssignRolesRequest names roles to add to a user.
+type AssignRolesRequest struct {
+	Roles []string `json:"roles" binding:"required,min=1"`
+}
+
+// CreateGroupRequest defines a new group, optionally nested under a parent group.
+type CreateGroupRequest struct {
+	Name        string  `json:"name" binding:"required"`
+	Description string  `json:"description"`
+	ParentID    *string `json:"parentId,omitempty"`
+}
+
+// UpdateGroupRequest changes a group. Omitted fields are left as they are; send
+// "moveToTop": true to make a subgroup top-level.
+type UpdateGroupRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	ParentID    *string `json:"parentId,omitempty"`
+	MoveToTop   bool    `json:"moveToTop,omitempty"`
+}
+
+// AddGroupMembersRequest lists users to add to a group.
+type AddGroupMembersRequest struct {
+	UserIDs []string `json:"userIds" binding:"required,min=1"`
+}
+
+// CreateOrganizationRequest defines a new tenant together with its first admin account.
+// Slug is used as the tenant's subdomain and in the X-Tenant header.
+type CreateOrganizationRequest struct {
+	Name  string                   `json:"name" binding:"required"`
+	Slug  string                   `json:"slug" binding:"required"`
+	Admin OrganizationAdminRequest `json:"admin" binding:"required"`
+}
+
+// OrganizationAdminRequest describes the admin account created with an organization.
+type OrganizationAdminRequest struct {
+	Email     string `json:"email" binding:"required,email"`
+	FirstName string `json:"firstName" binding:"required"`
+	LastName  string `json:"lastName" binding:"required"`
+	Password  string `json:"password" binding:"required,min=6"`
+	Phone     string `json:"phone" binding:"required"`
+	Username  string `json:"username" binding:"required"`
+}
+
+// UpdateOrganizationRequest renames or (de)activates a tenant, or changes its registration policy.
+// Omitted fields are left as they are.
+type UpdateOrganizationRequest struct {
+	Name                *string           `json:"name,omitempty"`
+	Active              *bool             `json:"active,omitempty"`
+	RegistrationMode    *RegistrationMode `json:"registrationMode,omitempty" binding:"omitempty,oneof=open invite_only domain_restricted approval"`
+	AllowedEmailDomains *[]string         `json:"allowedEmailDomains,omitempty"`
+	ReservedNames       *[]string         `json:"reservedNames,omitempty"`
+}
+
+// SuspendUserRequest suspends a user, optionally only until a given time.
+type SuspendUserRequest struct {
+	Reason string     `json:"reason" binding:"required"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+// ChangeStatusRequest carries the reason for reactivating or unlocking a user.
+type ChangeStatusRequest struct {
+	Reason string `json:"reason"`
+}
+
+// DeleteAccountRequest confirms a self-service account deletion with the user's password.
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
 // Auth-related request models
 type ConfirmOtpRequest struct {
 	Otp      string `json:"otp" binding:"required"`
@@ -80,6 +230,25 @@ type ResetPasswordRequest struct {
 	Username string `json:"username"`
 }
 
+// ReauthenticateRequest proves the user's identity again with either their password or an emailed OTP.
+type ReauthenticateRequest struct {
+	Otp      string `json:"otp"`
+	Password string `json:"password"`
+}
+
+// ReportSuspiciousLoginRequest carries the token of a "this wasn't me" link.
+type ReportSuspiciousLoginRequest struct {
+	Token string `json:"token" form:"token" binding:"required"`
+}
+
 type ResetPasswordWithTokenRequest struct {
-	NewPassword string `json:"newPassword" binding:"required,min=6"`
+	NewPassword string `json:"newPassword" form:"newPassword" binding:"required,min=6"`
+}
+
+// AuthzCheckRequest asks whether a user may perform an action on another user.
+// SubjectID defaults to the caller.
+type AuthzCheckRequest struct {
+	SubjectID  string `json:"subjectId"`
+	Action     string `json:"action" binding:"required"`
+	ResourceID string `json:"resourceId" binding:"required"`
 }
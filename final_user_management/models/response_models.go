@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type PaginatedResponse struct {
 	CurrentPage  int         `json:"currentPage"`
 	LastPage     int         `json:"lastPage"`
@@ -20,4 +22,59 @@ type ErrorResponse struct {
 
 type LoginResponse struct {
 	AccessToken string `json:"accessToken"`
+	DeviceToken string `json:"deviceToken,omitempty"`
+}
+
+type ImpersonationResponse struct {
+	AccessToken    string    `json:"accessToken"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	ImpersonatorID string    `json:"impersonatorId"`
+	UserID         string    `json:"userId"`
+}
+
+// ReauthRequiredResponse is returned with 401 when an operation needs a more recent login.
+// Clients should call POST /auth/reauthenticate with one of Methods and retry.
+type ReauthRequiredResponse struct {
+	Error   string   `json:"error" example:"reauth_required"`
+	Message string   `json:"message"`
+	MaxAge  int      `json:"maxAge"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// IntrospectionResponse describes the caller's token together with their current effective
+// access. Permissions and groups are resolved at request time, so they may be newer than the
+// "perms" claim in the token.
+type IntrospectionResponse struct {
+	Active         bool       `json:"active"`
+	UserID         string     `json:"userId"`
+	Username       string     `json:"username"`
+	Role           string     `json:"role"`
+	Roles          []string   `json:"roles"`
+	Groups         []string   `json:"groups"`
+	Permissions    []string   `json:"permissions"`
+	SessionID      string     `json:"sessionId"`
+	ImpersonatorID string     `json:"impersonatorId,omitempty"`
+	AuthTime       *time.Time `json:"authTime,omitempty"`
+	AMR            []string   `json:"amr,omitempty"`
+}
+
+// AuthorizationDecision is the outcome of an access check, with the attributes it was made on.
+// PolicyID names the policy that decided it; it is empty when role permissions alone did.
+type AuthorizationDecision struct {
+	Allowed  bool                   `json:"allowed"`
+	Effect   string                 `json:"effect"`
+	PolicyID string                 `json:"policyId,omitempty"`
+	Reason   string                 `json:"reason"`
+	Action   string                 `json:"action"`
+	Subject  map[string]interface{} `json:"subject"`
+	Resource map[string]interface{} `json:"resource"`
+}
+
+// AuditVerification is the result of checking an organization's audit hash chain.
+// FirstInvalidSequence and Reason say where and why the chain is broken when Valid is false.
+type AuditVerification struct {
+	Valid                bool   `json:"valid"`
+	Checked              int64  `json:"checked"`
+	FirstInvalidSequence *int64 `json:"firstInvalidSequence,omitempty"`
+	Reason               string `json:"reason,omitempty"`
 }
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Role is a named set of permissions. Users get permissions by being assigned roles.
+// System roles are created for every organization and cannot be deleted.
+type Role struct {
+	ID             string       `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string       `gorm:"type:uuid;not null;uniqueIndex:idx_roles_org_name,priority:1" json:"organizationId"`
+	Name           string       `gorm:"uniqueIndex:idx_roles_org_name,priority:2" json:"name"`
+	Description    string       `json:"description"`
+	Permissions    []Permission `gorm:"type:text;serializer:json" json:"permissions"`
+	System         bool         `gorm:"default:false" json:"system"`
+	CreatedAt      time.Time    `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt      time.Time    `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+// UserRoleAssignment links a user to one of their roles. AssignedBy is nil for
+// assignments made by the system (registration, migrations).
+type UserRoleAssignment struct {
+	UserID     string    `gorm:"primaryKey;type:uuid" json:"userId"`
+	RoleID     string    `gorm:"primaryKey;type:uuid;index" json:"roleId"`
+	AssignedBy *string   `gorm:"type:uuid" json:"assignedBy,omitempty"`
+	CreatedAt  time.Time `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+func (UserRoleAssignment) TableName() string {
+	return "user_roles"
+}
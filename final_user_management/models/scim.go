@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// ScimToken lets an identity provider provision an organization's users and groups through the
+// SCIM API. Only a hash of the token is stored; the token itself is shown once, when it is
+// created.
+type ScimToken struct {
+	ID             string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string `gorm:"type:uuid;not null;index" json:"organizationId"`
+	Name           string `gorm:"not null" json:"name"`
+	TokenHash      string `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+	// CreatedBy is the administrator the token acts for: it can only grant the roles they hold.
+	CreatedBy  string     `gorm:"type:uuid;not null" json:"createdBy"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"createdAt"`
+}
+
+// IsUsable reports whether the token may still authenticate requests.
+func (t *ScimToken) IsUsable(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// CreatedScimToken is a new token together with its value, which is never shown again.
+type CreatedScimToken struct {
+	ScimToken
+	Token string `json:"token"`
+}
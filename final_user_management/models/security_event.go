@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// SecurityEventType identifies what kind of authentication or account security event was recorded.
+type SecurityEventType string
+
+const (
+	EventLogin                   SecurityEventType = "login"
+	EventPasswordResetRequested  SecurityEventType = "password_reset_requested"
+	EventPasswordResetCompleted  SecurityEventType = "password_reset_completed"
+	EventPasswordChanged         SecurityEventType = "password_changed"
+	EventPasswordChangedByAdmin  SecurityEventType = "password_changed_by_admin"
+	EventNewDeviceLogin          SecurityEventType = "new_device_login"
+	EventSuspiciousLoginReported SecurityEventType = "suspicious_login_reported"
+	EventImpersonationStarted    SecurityEventType = "impersonation_started"
+	EventImpersonationStopped    SecurityEventType = "impersonation_stopped"
+	EventImpersonatedRequest     SecurityEventType = "impersonated_request"
+	EventReauthentication        SecurityEventType = "reauthentication"
+	EventAccountLocked           SecurityEventType = "account_locked"
+	EventDeletionScheduled       SecurityEventType = "account_deletion_scheduled"
+	EventDeletionCancelled       SecurityEventType = "account_deletion_cancelled"
+	EventDataExported            SecurityEventType = "data_exported"
+)
+
+// SecurityEventOutcome records whether the attempt succeeded or failed.
+type SecurityEventOutcome string
+
+const (
+	OutcomeSuccess SecurityEventOutcome = "success"
+	OutcomeFailure SecurityEventOutcome = "failure"
+)
+
+// SecurityEvent is a single entry in a user's login history / security feed.
+// UserID is nil when the attempt could not be tied to an account (e.g. unknown username).
+// ActorID is set when someone other than the user (an impersonating admin) performed the action.
+type SecurityEvent struct {
+	ID            string               `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID        *string              `gorm:"type:uuid;index" json:"userId,omitempty"`
+	ActorID       *string              `gorm:"type:uuid;index" json:"actorId,omitempty"`
+	Identifier    string               `json:"identifier,omitempty"`
+	Type          SecurityEventType    `gorm:"type:varchar(50);index" json:"type"`
+	Outcome       SecurityEventOutcome `gorm:"type:varchar(20)" json:"outcome"`
+	FailureReason *string              `json:"failureReason,omitempty"`
+	IP            string               `json:"ip"`
+	UserAgent     string               `json:"userAgent"`
+	CreatedAt     time.Time            `gorm:"autoCreateTime;index" json:"createdAt"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Session tracks an issued access token so it can be listed and revoked server-side.
+// The session ID is carried in the token's "jti" claim.
+type Session struct {
+	ID        string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID    string `gorm:"type:uuid;index" json:"userId"`
+	DeviceID  string `gorm:"index" json:"deviceId"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"userAgent"`
+	// ImpersonatorID is set when an admin opened this session on the user's behalf.
+	ImpersonatorID *string    `gorm:"type:uuid;index" json:"impersonatorId,omitempty"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"createdAt"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+}
+
+// KnownDevice records a device and network range a user has successfully logged in from.
+// A login from a device or IP range with no matching row triggers a new-device notification.
+type KnownDevice struct {
+	ID          string    `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID      string    `gorm:"type:uuid;uniqueIndex:idx_known_devices_user_device_prefix" json:"userId"`
+	DeviceID    string    `gorm:"uniqueIndex:idx_known_devices_user_device_prefix" json:"deviceId"`
+	IPPrefix    string    `gorm:"uniqueIndex:idx_known_devices_user_device_prefix" json:"ipPrefix"`
+	UserAgent   string    `json:"userAgent"`
+	FirstSeenAt time.Time `gorm:"autoCreateTime" json:"firstSeenAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+}
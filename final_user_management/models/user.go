@@ -6,39 +6,115 @@ import (
 	"gorm.io/gorm"
 )
 
-// UserRole defines the type for user roles which can be user or admin.
+// UserRole is the name of a role (see Role). These are the built-in ones created by migrations.
 type UserRole string
 
 const (
-	RoleUser  UserRole = "user"
-	RoleAdmin UserRole = "admin"
+	RoleUser     UserRole = "user"
+	RoleHelpdesk UserRole = "helpdesk"
+	RoleAdmin    UserRole = "admin"
 )
 
-// UserStatus defines the type for user statuses (active,inactive and deleted).
+// UserStatus defines the type for user statuses (pending, pending approval, active, suspended, locked,
+// inactive and deleted). Pending users were invited and have not chosen a password yet; pending
+// approval users registered themselves and wait for an admin to approve them.
 type UserStatus string
 
 const (
-	ActiveStatus   UserStatus = "active"
-	InactiveStatus UserStatus = "inactive"
-	DeletedStatus  UserStatus = "deleted"
+	PendingStatus         UserStatus = "pending"
+	PendingApprovalStatus UserStatus = "pending_approval"
+	ActiveStatus          UserStatus = "active"
+	SuspendedStatus       UserStatus = "suspended"
+	LockedStatus          UserStatus = "locked"
+	InactiveStatus        UserStatus = "inactive"
+	DeletedStatus         UserStatus = "deleted"
 )
 
+// statusTransitions lists the statuses each status may move to.
+var statusTransitions = map[UserStatus][]UserStatus{
+	PendingStatus:         {ActiveStatus, DeletedStatus},
+	PendingApprovalStatus: {ActiveStatus, DeletedStatus},
+	ActiveStatus:          {SuspendedStatus, LockedStatus, InactiveStatus, DeletedStatus},
+	SuspendedStatus:       {ActiveStatus, InactiveStatus, DeletedStatus},
+	LockedStatus:          {ActiveStatus, SuspendedStatus, DeletedStatus},
+	InactiveStatus:        {ActiveStatus, DeletedStatus},
+	DeletedStatus:         {ActiveStatus},
+}
+
+// CanTransitionTo reports whether a user in this status may be moved to next.
+func (s UserStatus) CanTransitionTo(next UserStatus) bool {
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// User is an account in one organization. The unique fields are unique per organization, so
+// the same email or username can be registered with different tenants. Client IDs and phone
+// numbers only have to be unique when set, since users provisioned over SCIM may have neither.
 type User struct {
-	ID             string         `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
-	ClientID       string         `gorm:"unique" json:"clientId"`
-	Email          string         `gorm:"uniqueIndex" json:"email"`
-	FirstName      string         `json:"firstName"`
-	LastName       string         `json:"lastName"`
-	NationalID     *string        `gorm:"unique" json:"nationalId,omitempty"`
-	PassportNumber *string        `gorm:"unique" json:"passportNumber,omitempty"`
-	Password       string         `json:"-"`
-	Phone          string         `gorm:"unique" json:"phone"`
-	ProfilePicture *string        `json:"profilePicture,omitempty"`
-	Username       string         `gorm:"uniqueIndex" json:"username"`
-	Slug           string         `gorm:"uniqueIndex" json:"slug"`
-	Role           UserRole       `gorm:"type:varchar(50);default:'user'" json:"role"`
-	Status         UserStatus     `gorm:"type:varchar(50);default:'active'" json:"status"`
-	CreatedAt      time.Time      `gorm:"autoCreateTime" json:"createdAt"`
-	UpdatedAt      time.Time      `gorm:"autoUpdateTime" json:"updatedAt"`
-	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
+	ID             string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string `gorm:"type:uuid;not null;index;uniqueIndex:idx_users_org_client_id_present,priority:1;uniqueIndex:idx_users_org_email_active,priority:1;uniqueIndex:idx_users_org_national_id_index_active,priority:1;uniqueIndex:idx_users_org_passport_number_index_active,priority:1;uniqueIndex:idx_users_org_phone_present,priority:1;uniqueIndex:idx_users_org_username_active,priority:1;uniqueIndex:idx_users_org_slug_active,priority:1" json:"organizationId"`
+	ClientID       string `gorm:"uniqueIndex:idx_users_org_client_id_present,priority:2,where:deleted_at IS NULL AND client_id <> ''" json:"clientId"`
+	Email          string `gorm:"uniqueIndex:idx_users_org_email_active,priority:2,where:deleted_at IS NULL" json:"email"`
+	FirstName      string `json:"firstName"`
+	LastName       string `json:"lastName"`
+	// NationalID and PassportNumber are encrypted at rest. Their blind indexes (keyed hashes)
+	// enforce uniqueness and support exact-match lookups; the repository keeps them in sync.
+	NationalID          *string `gorm:"serializer:encrypted" json:"nationalId,omitempty"`
+	NationalIDIndex     *string `gorm:"type:varchar(64);uniqueIndex:idx_users_org_national_id_index_active,priority:2,where:deleted_at IS NULL" json:"-"`
+	PassportNumber      *string `gorm:"serializer:encrypted" json:"passportNumber,omitempty"`
+	PassportNumberIndex *string `gorm:"type:varchar(64);uniqueIndex:idx_users_org_passport_number_index_active,priority:2,where:deleted_at IS NULL" json:"-"`
+	Password            string  `json:"-"`
+	Phone               string  `gorm:"uniqueIndex:idx_users_org_phone_present,priority:2,where:deleted_at IS NULL AND phone <> ''" json:"phone"`
+	ProfilePicture      *string `json:"profilePicture,omitempty"`
+	Username            string  `gorm:"uniqueIndex:idx_users_org_username_active,priority:2,where:deleted_at IS NULL" json:"username"`
+	Slug                string  `gorm:"uniqueIndex:idx_users_org_slug_active,priority:2,where:deleted_at IS NULL" json:"slug"`
+	// ExternalID is the identifier an identity provider that provisions the user over SCIM
+	// knows them by.
+	ExternalID *string `gorm:"index" json:"externalId,omitempty"`
+	// Department is an attribute for access policies, such as managers editing their own department.
+	Department string `gorm:"index" json:"department"`
+	// Role is the user's primary role, shown in responses. What the user may do is decided by
+	// the permissions of all their role assignments (user_roles).
+	Role           UserRole   `gorm:"type:varchar(50);default:'user'" json:"role"`
+	Status         UserStatus `gorm:"type:varchar(50);default:'active'" json:"status"`
+	StatusReason   *string    `json:"statusReason,omitempty"`
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"`
+	LockedUntil    *time.Time `json:"lockedUntil,omitempty"`
+	FailedLogins   int        `gorm:"default:0" json:"-"`
+	// DeletionScheduledAt is when a self-service account deletion will be carried out.
+	// Logging in before then cancels it.
+	DeletionScheduledAt *time.Time     `gorm:"index" json:"deletionScheduledAt,omitempty"`
+	LastLoginAt         *time.Time     `json:"lastLoginAt,omitempty"`
+	LastLoginIP         *string        `json:"lastLoginIp,omitempty"`
+	CreatedAt           time.Time      `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt           time.Time      `gorm:"autoUpdateTime" json:"updatedAt"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// AnonymizedPlaceholder is written over the unique identifiers of an anonymized user, keeping
+// them unique.
+func AnonymizedPlaceholder(userID string) string {
+	return "deleted-" + userID
+}
+
+// IsAnonymized reports whether the user's personal data has been overwritten. Such accounts can
+// no longer be restored.
+func (u *User) IsAnonymized() bool {
+	return u.Username == AnonymizedPlaceholder(u.ID)
+}
+
+// UserStatusChange records who moved a user between statuses, when and why.
+// ChangedBy is nil for automatic changes such as lockouts after failed logins.
+type UserStatusChange struct {
+	ID         string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	UserID     string     `gorm:"type:uuid;index" json:"userId"`
+	FromStatus UserStatus `gorm:"type:varchar(50)" json:"fromStatus"`
+	ToStatus   UserStatus `gorm:"type:varchar(50)" json:"toStatus"`
+	Reason     string     `json:"reason"`
+	ChangedBy  *string    `gorm:"type:uuid" json:"changedBy,omitempty"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime" json:"createdAt"`
 }
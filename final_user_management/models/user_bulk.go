@@ -0,0 +1,56 @@
+package models
+
+// BulkAction is what POST /users/bulk does to each selected user.
+type BulkAction string
+
+const (
+	BulkDeactivate BulkAction = "deactivate"
+	BulkReactivate BulkAction = "reactivate"
+	BulkDelete     BulkAction = "delete"
+	// BulkAssignRole adds Role to the users' roles.
+	BulkAssignRole BulkAction = "assign_role"
+	// BulkForcePasswordReset replaces the users' passwords with random ones, signs them out and
+	// emails them a link to choose a new one.
+	BulkForcePasswordReset BulkAction = "force_password_reset"
+	BulkRevokeSessions     BulkAction = "revoke_sessions"
+)
+
+// BulkUserRequest is the body of POST /users/bulk. Users are selected either by IDs or by a
+// Filter with the query parameters of GET /users, whose paging is ignored. Role is required by
+// assign_role, and Reason is recorded with status changes. A DryRun makes and checks every change,
+// then rolls them back.
+type BulkUserRequest struct {
+	Action BulkAction          `json:"action" binding:"required,oneof=deactivate reactivate delete assign_role force_password_reset revoke_sessions"`
+	IDs    []string            `json:"ids" binding:"omitempty,dive,required"`
+	Filter *GetAllUsersRequest `json:"filter"`
+	Role   string              `json:"role"`
+	Reason string              `json:"reason"`
+	DryRun bool                `json:"dryRun"`
+}
+
+// BulkUserStatus is the outcome of a bulk action for one user.
+type BulkUserStatus string
+
+const (
+	BulkUserApplied BulkUserStatus = "applied"
+	// BulkUserValid users would have been changed, had it not been a dry run.
+	BulkUserValid  BulkUserStatus = "valid"
+	BulkUserFailed BulkUserStatus = "failed"
+)
+
+// BulkUserResult is what happened to one selected user.
+type BulkUserResult struct {
+	UserID string         `json:"userId"`
+	Status BulkUserStatus `json:"status"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// BulkUserReport is the result of a bulk action, returned directly or as a job's result.
+type BulkUserReport struct {
+	Action    BulkAction       `json:"action"`
+	DryRun    bool             `json:"dryRun"`
+	Total     int              `json:"total"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkUserResult `json:"results"`
+}
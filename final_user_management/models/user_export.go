@@ -0,0 +1,28 @@
+package models
+
+// ExportFormat is the file format of a user export.
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "csv"
+	ExportXLSX   ExportFormat = "xlsx"
+	ExportNDJSON ExportFormat = "ndjson"
+)
+
+// ExportUsersRequest maps to the query parameters of GET /users/export. The filters are those of
+// GetAllUsers; paging is ignored and every matching user is exported. Columns is a
+// comma-separated list of user fields, by JSON name, and defaults to every field the caller may
+// see. With Async the export always runs as a background job.
+type ExportUsersRequest struct {
+	GetAllUsersRequest
+	Format  ExportFormat `form:"format" binding:"omitempty,oneof=csv xlsx ndjson"`
+	Columns string       `form:"columns"`
+	Async   bool         `form:"async"`
+}
+
+// UserExportResult is the result of a background user export job.
+type UserExportResult struct {
+	Format  ExportFormat `json:"format"`
+	Columns []string     `json:"columns"`
+	Rows    int64        `json:"rows"`
+}
@@ -0,0 +1,52 @@
+package models
+
+// ImportFormat is the file format of a user import.
+type ImportFormat string
+
+const (
+	ImportCSV    ImportFormat = "csv"
+	ImportNDJSON ImportFormat = "ndjson"
+	ImportJSON   ImportFormat = "json"
+)
+
+// ImportUsersRequest is the form sent with the file of POST /users/import. Format is worked out
+// from the file name when it is left out. Mapping is a JSON object from the file's column names
+// (or NDJSON keys) to CreateUserByAdminRequest fields; columns already named after a field need
+// no mapping. With SendInvitations, rows may leave out the password: those users are invited as
+// POST /invitations would, to choose one themselves.
+type ImportUsersRequest struct {
+	Format          ImportFormat `form:"format" binding:"omitempty,oneof=csv ndjson json"`
+	Mapping         string       `form:"mapping"`
+	DryRun          bool         `form:"dryRun"`
+	SendInvitations bool         `form:"sendInvitations"`
+}
+
+// ImportRowStatus is the outcome of one row of an import.
+type ImportRowStatus string
+
+const (
+	// ImportRowValid rows passed every check in a dry run.
+	ImportRowValid   ImportRowStatus = "valid"
+	ImportRowCreated ImportRowStatus = "created"
+	// ImportRowInvited rows were created as pending users and sent an invitation.
+	ImportRowInvited ImportRowStatus = "invited"
+	ImportRowFailed  ImportRowStatus = "failed"
+)
+
+// ImportRowResult is what happened to one row. Row is the row's line number in the file.
+type ImportRowResult struct {
+	Row    int             `json:"row"`
+	Status ImportRowStatus `json:"status"`
+	Email  string          `json:"email,omitempty"`
+	UserID string          `json:"userId,omitempty"`
+	Errors []string        `json:"errors,omitempty"`
+}
+
+// UserImportReport is the result of a user import job.
+type UserImportReport struct {
+	DryRun    bool              `json:"dryRun"`
+	Total     int               `json:"total"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Rows      []ImportRowResult `json:"rows"`
+}
@@ -0,0 +1,89 @@
+package models
+
+// Viewer identifies who a user record is being shown to. It is the zero value for anonymous callers.
+type Viewer struct {
+	UserID      string
+	Permissions []string
+}
+
+// Audience is the class of viewer a field visibility rule applies to.
+type Audience int
+
+const (
+	// AudiencePublic is anyone who is neither the user nor staff, including anonymous callers.
+	AudiencePublic Audience = iota
+	// AudienceAdmin is staff with PermUsersRead but not PermUsersReadPII.
+	AudienceAdmin
+	// AudiencePIIReader is staff with PermUsersReadPII.
+	AudiencePIIReader
+	// AudienceOwner is the user themselves.
+	AudienceOwner
+)
+
+// FieldVisibility says how a field is rendered for an audience. The zero value hides the field.
+type FieldVisibility int
+
+const (
+	FieldHidden FieldVisibility = iota
+	FieldMasked
+	FieldVisible
+)
+
+// FieldRule gives a field's visibility for each audience.
+type FieldRule struct {
+	Public    FieldVisibility
+	Admin     FieldVisibility
+	PIIReader FieldVisibility
+	Owner     FieldVisibility
+}
+
+// For returns the field's visibility for the audience.
+func (r FieldRule) For(audience Audience) FieldVisibility {
+	switch audience {
+	case AudienceOwner:
+		return r.Owner
+	case AudiencePIIReader:
+		return r.PIIReader
+	case AudienceAdmin:
+		return r.Admin
+	default:
+		return r.Public
+	}
+}
+
+var (
+	everyone     = FieldRule{Public: FieldVisible, Admin: FieldVisible, PIIReader: FieldVisible, Owner: FieldVisible}
+	ownerOrAdmin = FieldRule{Admin: FieldVisible, PIIReader: FieldVisible, Owner: FieldVisible}
+	sensitive    = FieldRule{Admin: FieldMasked, PIIReader: FieldVisible, Owner: FieldVisible}
+)
+
+// UserFieldPolicy decides which fields of a User, by JSON name, each audience sees.
+// Fields missing from the policy are never shown, so new fields stay private until listed here.
+var UserFieldPolicy = map[string]FieldRule{
+	"id":                  everyone,
+	"username":            everyone,
+	"slug":                everyone,
+	"firstName":           everyone,
+	"lastName":            everyone,
+	"profilePicture":      everyone,
+	"createdAt":           everyone,
+	"clientId":            ownerOrAdmin,
+	"email":               ownerOrAdmin,
+	"role":                ownerOrAdmin,
+	"department":          ownerOrAdmin,
+	"externalId":          ownerOrAdmin,
+	"status":              ownerOrAdmin,
+	"statusReason":        ownerOrAdmin,
+	"suspendedUntil":      ownerOrAdmin,
+	"lockedUntil":         ownerOrAdmin,
+	"deletionScheduledAt": ownerOrAdmin,
+	"lastLoginAt":         ownerOrAdmin,
+	"updatedAt":           ownerOrAdmin,
+	"nationalId":          sensitive,
+	"passportNumber":      sensitive,
+	"phone":               sensitive,
+	"lastLoginIp":         sensitive,
+}
+
+// UserView is a User shaped for a particular viewer by UserFieldPolicy.
+type UserView map[string]interface{}
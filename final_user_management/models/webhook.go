@@ -0,0 +1,110 @@
+package models
+
+import "time"
+
+// WebhookEvent names a change to a user that webhook endpoints can subscribe to.
+type WebhookEvent string
+
+const (
+	WebhookUserCreated         WebhookEvent = "user.created"
+	WebhookUserUpdated         WebhookEvent = "user.updated"
+	WebhookUserDeleted         WebhookEvent = "user.deleted"
+	WebhookUserPasswordChanged WebhookEvent = "user.password_changed"
+	WebhookUserRoleChanged     WebhookEvent = "user.role_changed"
+	WebhookUserLogin           WebhookEvent = "user.login"
+)
+
+// AllWebhookEvents lists every event an endpoint can subscribe to.
+var AllWebhookEvents = []WebhookEvent{
+	WebhookUserCreated,
+	WebhookUserUpdated,
+	WebhookUserDeleted,
+	WebhookUserPasswordChanged,
+	WebhookUserRoleChanged,
+	WebhookUserLogin,
+}
+
+// IsValid reports whether the event is one endpoints can subscribe to.
+func (e WebhookEvent) IsValid() bool {
+	for _, known := range AllWebhookEvents {
+		if known == e {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEndpoint is a URL an organization's events are posted to. An endpoint with no events
+// listed receives all of them. Secret signs every payload; it is only shown when the endpoint is
+// created.
+type WebhookEndpoint struct {
+	ID             string         `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string         `gorm:"type:uuid;not null;index" json:"organizationId"`
+	URL            string         `gorm:"not null" json:"url"`
+	Description    string         `json:"description"`
+	Events         []WebhookEvent `gorm:"type:text;serializer:json" json:"events"`
+	Secret         string         `gorm:"serializer:encrypted" json:"-"`
+	Active         bool           `gorm:"not null;default:true" json:"active"`
+	CreatedBy      *string        `gorm:"type:uuid" json:"createdBy,omitempty"`
+	CreatedAt      time.Time      `gorm:"autoCreateTime" json:"createdAt"`
+	UpdatedAt      time.Time      `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+// Subscribes reports whether the endpoint wants the event.
+func (e *WebhookEndpoint) Subscribes(event WebhookEvent) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range e.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDeliveryStatus tracks a delivery through its attempts.
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event sent to one endpoint, with the outcome of its latest attempt.
+// Pending deliveries are attempted at NextAttemptAt. EventID is the same for every endpoint and
+// every redelivery of an event, so receivers can ignore duplicates.
+type WebhookDelivery struct {
+	ID             string                `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	OrganizationID string                `gorm:"type:uuid;not null;index" json:"organizationId"`
+	EndpointID     string                `gorm:"type:uuid;not null;index" json:"endpointId"`
+	EventID        string                `gorm:"index" json:"eventId"`
+	Event          WebhookEvent          `gorm:"type:varchar(64)" json:"event"`
+	Payload        string                `gorm:"type:text" json:"payload"`
+	Status         WebhookDeliveryStatus `gorm:"type:varchar(20);index" json:"status"`
+	Attempts       int                   `json:"attempts"`
+	NextAttemptAt  *time.Time            `gorm:"index" json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time            `json:"lastAttemptAt,omitempty"`
+	ResponseStatus int                   `json:"responseStatus,omitempty"`
+	ResponseBody   string                `gorm:"type:text" json:"responseBody,omitempty"`
+	Error          string                `json:"error,omitempty"`
+	RedeliveryOf   *string               `gorm:"type:uuid" json:"redeliveryOf,omitempty"`
+	CreatedAt      time.Time             `gorm:"autoCreateTime;index" json:"createdAt"`
+	UpdatedAt      time.Time             `gorm:"autoUpdateTime" json:"updatedAt"`
+}
+
+// WebhookPayload is the JSON body posted to endpoints.
+type WebhookPayload struct {
+	ID             string                 `json:"id"`
+	Event          WebhookEvent           `json:"event"`
+	OrganizationID string                 `json:"organizationId"`
+	OccurredAt     time.Time              `json:"occurredAt"`
+	Data           map[string]interface{} `json:"data"`
+}
+
+// CreatedWebhookEndpoint is a new endpoint together with its signing secret.
+type CreatedWebhookEndpoint struct {
+	WebhookEndpoint
+	Secret string `json:"secret"`
+}
@@ -0,0 +1,190 @@
+// Package policy decides whether a subject may perform an action on a resource, combining the
+// permissions granted by roles with attribute-based rules written as condition expressions.
+package policy
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Effect is what a matching policy does to the decision.
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Policy is one rule. It applies to the listed actions (exact names, "*" or a "users:*" prefix)
+// when its condition holds. The condition sees "subject", "resource" and "action".
+type Policy struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description,omitempty"`
+	Effect      Effect   `json:"effect"`
+	Actions     []string `json:"actions"`
+	Condition   string   `json:"condition"`
+
+	expression *Expression
+}
+
+// Input is what a decision is made about. Subject and Resource are attribute maps; the subject's
+// "permissions" attribute holds the permissions its roles grant.
+type Input struct {
+	Action   string
+	Subject  map[string]interface{}
+	Resource map[string]interface{}
+}
+
+// Decision is the outcome of evaluating an input. PolicyID is empty when the decision came from
+// role permissions alone, or when nothing granted the action.
+type Decision struct {
+	Allowed  bool
+	Effect   Effect
+	PolicyID string
+	Reason   string
+}
+
+// Engine evaluates inputs against a fixed set of policies.
+//
+// Deny policies win over everything: a subject whose role grants an action is still refused when
+// a deny policy matches. Otherwise the action is allowed when the subject's roles grant it or an
+// allow policy matches. A deny condition that fails to evaluate counts as matching, so a broken
+// rule never lets a request through.
+type Engine struct {
+	policies []Policy
+}
+
+//go:embed policies.json
+var defaultPolicies []byte
+
+// NewEngine compiles the policies.
+func NewEngine(policies []Policy) (*Engine, error) {
+	seen := map[string]bool{}
+	for i := range policies {
+		p := &policies[i]
+		if p.ID == "" {
+			return nil, fmt.Errorf("policy %d has no id", i)
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("duplicate policy id %q", p.ID)
+		}
+		seen[p.ID] = true
+		if p.Effect != Allow && p.Effect != Deny {
+			return nil, fmt.Errorf("policy %q: effect must be allow or deny", p.ID)
+		}
+		if len(p.Actions) == 0 {
+			return nil, fmt.Errorf("policy %q has no actions", p.ID)
+		}
+		condition := p.Condition
+		if strings.TrimSpace(condition) == "" {
+			condition = "true"
+		}
+		expression, err := Compile(condition)
+		if err != nil {
+			return nil, fmt.Errorf("policy %q: %w", p.ID, err)
+		}
+		p.expression = expression
+	}
+	return &Engine{policies: policies}, nil
+}
+
+// Parse reads policies from JSON, either a list of policies or {"policies": [...]}.
+func Parse(data []byte) (*Engine, error) {
+	var policies []Policy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		var document struct {
+			Policies []Policy `json:"policies"`
+		}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("invalid policy file: %w", err)
+		}
+		policies = document.Policies
+	}
+	return NewEngine(policies)
+}
+
+// Load reads the policies from a file, or the built-in defaults when path is empty.
+func Load(path string) (*Engine, error) {
+	if path == "" {
+		return Parse(defaultPolicies)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Policies returns the loaded policies.
+func (e *Engine) Policies() []Policy {
+	return e.policies
+}
+
+// Evaluate decides the input.
+func (e *Engine) Evaluate(input Input) Decision {
+	vars := map[string]interface{}{
+		"subject":  input.Subject,
+		"resource": input.Resource,
+		"action":   input.Action,
+	}
+
+	for _, p := range e.policies {
+		if p.Effect != Deny || !p.appliesTo(input.Action) {
+			continue
+		}
+		matched, err := p.expression.Eval(vars)
+		if err != nil {
+			return Decision{Effect: Deny, PolicyID: p.ID, Reason: fmt.Sprintf("policy %s could not be evaluated: %v", p.ID, err)}
+		}
+		if matched {
+			return Decision{Effect: Deny, PolicyID: p.ID, Reason: p.reason("denied by policy")}
+		}
+	}
+
+	if grants(input.Subject["permissions"], input.Action) {
+		return Decision{Allowed: true, Effect: Allow, Reason: "granted by role permission " + input.Action}
+	}
+
+	for _, p := range e.policies {
+		if p.Effect != Allow || !p.appliesTo(input.Action) {
+			continue
+		}
+		if matched, err := p.expression.Eval(vars); err == nil && matched {
+			return Decision{Allowed: true, Effect: Allow, PolicyID: p.ID, Reason: p.reason("allowed by policy")}
+		}
+	}
+
+	return Decision{Effect: Deny, Reason: "no role or policy grants " + input.Action}
+}
+
+func (p Policy) appliesTo(action string) bool {
+	for _, pattern := range p.Actions {
+		if pattern == "*" || pattern == action {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(action, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Policy) reason(prefix string) string {
+	if p.Description != "" {
+		return fmt.Sprintf("%s %s: %s", prefix, p.ID, p.Description)
+	}
+	return fmt.Sprintf("%s %s", prefix, p.ID)
+}
+
+func grants(permissions interface{}, action string) bool {
+	list, _ := permissions.([]string)
+	for _, permission := range list {
+		if permission == action {
+			return true
+		}
+	}
+	return false
+}
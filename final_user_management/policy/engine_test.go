@@ -0,0 +1,47 @@
+package policy
+
+import "testing"
+
+// TestDepartmentManagerDefaultPolicy checks that the default department-manager policy lets a
+// manager edit ordinary users in their department, but not admins or users who hold
+// permissions the manager lacks.
+func TestDepartmentManagerDefaultPolicy(t *testing.T) {
+	engine, err := Load("")
+	if err != nil {
+		t.Fatalf("Load() = %v", err)
+	}
+	manager := map[string]interface{}{
+		"id":          "manager",
+		"roles":       []string{"manager"},
+		"department":  "sales",
+		"permissions": []string{"users:read"},
+	}
+	tests := []struct {
+		name     string
+		resource map[string]interface{}
+		want     bool
+	}{
+		{"user in the department", map[string]interface{}{
+			"id": "user", "roles": []string{"user"}, "department": "sales", "permissions": []string{},
+		}, true},
+		{"user in another department", map[string]interface{}{
+			"id": "user", "roles": []string{"user"}, "department": "support", "permissions": []string{},
+		}, false},
+		{"admin in the department", map[string]interface{}{
+			"id": "admin", "roles": []string{"admin"}, "department": "sales", "permissions": []string{"users:read"},
+		}, false},
+		{"user holding a permission the manager lacks", map[string]interface{}{
+			"id": "auditor", "roles": []string{"auditor"}, "department": "sales", "permissions": []string{"users:read", "users:read-pii"},
+		}, false},
+	}
+
+	for _, test := range tests {
+		decision := engine.Evaluate(Input{Action: "users:write", Subject: manager, Resource: test.resource})
+		if decision.Allowed != test.want {
+			t.Errorf("%s: allowed = %v (%s), want %v", test.name, decision.Allowed, decision.Reason, test.want)
+		}
+		if test.want && decision.PolicyID != "department-manager" {
+			t.Errorf("%s: decided by %q, want department-manager", test.name, decision.PolicyID)
+		}
+	}
+}
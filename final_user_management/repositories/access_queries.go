@@ -0,0 +1,92 @@
+package repositories
+
+import (
+	"errors"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+// ErrLastAdmin is returned when a change would leave an organization with no active user
+// holding its admin role, whether assigned directly or inherited through a group.
+var ErrLastAdmin = errors.New("this change would leave no active admin")
+
+// userGroupTreeCTE selects, as "tree", the groups the user belongs to directly and every
+// group above them.
+const userGroupTreeCTE = `WITH RECURSIVE tree AS (
+		SELECT group_id AS id FROM group_members WHERE user_id = @user
+		UNION
+		SELECT groups.parent_id FROM groups JOIN tree ON groups.id = tree.id WHERE groups.parent_id IS NOT NULL
+	)`
+
+// groupSubtreeCTE selects, as "subtree", the group and every group nested below it.
+const groupSubtreeCTE = `WITH RECURSIVE subtree AS (
+		SELECT id FROM groups WHERE id = @group
+		UNION
+		SELECT groups.id FROM groups JOIN subtree ON groups.parent_id = subtree.id
+	)`
+
+// findEffectiveRoles returns the roles assigned to the user directly or through their groups.
+func findEffectiveRoles(db *gorm.DB, userID string) ([]models.Role, error) {
+	var roles []models.Role
+	err := db.Raw(userGroupTreeCTE+`
+		SELECT * FROM roles
+		WHERE id IN (SELECT role_id FROM user_roles WHERE user_id = @user)
+		OR id IN (SELECT role_id FROM group_roles WHERE group_id IN (SELECT id FROM tree))
+		ORDER BY name ASC`, map[string]interface{}{"user": userID}).Scan(&roles).Error
+	return roles, err
+}
+
+// countActiveRoleHolders counts active users holding the role directly or through a group.
+func countActiveRoleHolders(db *gorm.DB, roleID string) (int64, error) {
+	var total int64
+	err := db.Raw(`WITH RECURSIVE granted AS (
+			SELECT group_id AS id FROM group_roles WHERE role_id = @role
+			UNION
+			SELECT groups.id FROM groups JOIN granted ON groups.parent_id = granted.id
+		)
+		SELECT COUNT(*) FROM users
+		WHERE status = @status AND deleted_at IS NULL AND (
+			id IN (SELECT user_id FROM user_roles WHERE role_id = @role)
+			OR id IN (SELECT user_id FROM group_members WHERE group_id IN (SELECT id FROM granted))
+		)`, map[string]interface{}{"role": roleID, "status": models.ActiveStatus}).Scan(&total).Error
+	return total, err
+}
+
+// guardLastAdmin runs change inside the transaction and undoes it with ErrLastAdmin if it
+// leaves an organization that had active admins with none. Guarded changes to the same
+// organization are serialized with an advisory lock, so two admins removed at once cannot each
+// count the other as the one who remains.
+func guardLastAdmin(tx *gorm.DB, organizationID string, change func(tx *gorm.DB) error) error {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('admins:' || ?))", organizationID).Error; err != nil {
+		return err
+	}
+
+	var admins []models.Role
+	if err := inOrganization(tx, "roles", organizationID).Where("name = ?", models.RoleAdmin).Find(&admins).Error; err != nil {
+		return err
+	}
+
+	before := make([]int64, len(admins))
+	for i, admin := range admins {
+		count, err := countActiveRoleHolders(tx, admin.ID)
+		if err != nil {
+			return err
+		}
+		before[i] = count
+	}
+	if err := change(tx); err != nil {
+		return err
+	}
+	for i, admin := range admins {
+		after, err := countActiveRoleHolders(tx, admin.ID)
+		if err != nil {
+			return err
+		}
+		if before[i] > 0 && after == 0 {
+			return ErrLastAdmin
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+// AuditRepository stores the audit log. It can only add entries: there is no way to change or
+// remove one, and the database refuses updates and deletes on the table (see Migrate).
+type AuditRepository interface {
+	Append(entry *models.AuditEntry) error
+	FindAll(params models.GetAuditLogRequest) ([]models.AuditEntry, int64, error)
+	FindChain(organizationID string, afterSequence int64, limit int) ([]models.AuditEntry, error)
+	FindByUser(userID string) ([]models.AuditEntry, error)
+	WithTenant(organizationID string) AuditRepository
+	WithTx(tx *gorm.DB) AuditRepository
+}
+
+type auditRepository struct {
+	db             *gorm.DB
+	organizationID string
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+// WithTenant returns the repository limited to one organization's audit log.
+func (r *auditRepository) WithTenant(organizationID string) AuditRepository {
+	return &auditRepository{db: r.db, organizationID: organizationID}
+}
+
+// WithTx returns the repository working inside the transaction. Entries appended through it
+// commit or roll back with the change they describe, and the organization's chain stays locked
+// until then.
+func (r *auditRepository) WithTx(tx *gorm.DB) AuditRepository {
+	return &auditRepository{db: tx, organizationID: r.organizationID}
+}
+
+// Append numbers the entry, links it to the last entry of its organization's chain and stores
+// it. Appends to the same organization are serialized with an advisory lock so the chain never
+// forks.
+func (r *auditRepository) Append(entry *models.AuditEntry) error {
+	if entry.OrganizationID == "" {
+		return errors.New("audit entry has no organization")
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('audit:' || ?))", entry.OrganizationID).Error; err != nil {
+			return err
+		}
+
+		var last models.AuditEntry
+		err := tx.Where("organization_id = ?", entry.OrganizationID).Order("sequence DESC").First(&last).Error
+		switch {
+		case err == nil:
+			entry.Sequence = last.Sequence + 1
+			entry.PrevHash = last.Hash
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			entry.Sequence = 1
+			entry.PrevHash = ""
+		default:
+			return err
+		}
+
+		// Postgres keeps microseconds, so hash the time as it will be read back
+		entry.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+		entry.Hash = entry.ComputeHash()
+		return tx.Create(entry).Error
+	})
+}
+
+func (r *auditRepository) FindAll(params models.GetAuditLogRequest) ([]models.AuditEntry, int64, error) {
+	var entries []models.AuditEntry
+	var total int64
+
+	query := inOrganization(r.db.Model(&models.AuditEntry{}), "audit_entries", r.organizationID)
+	if params.ActorID != "" {
+		query = query.Where("actor_id = ?", params.ActorID)
+	}
+	if params.TargetID != "" {
+		query = query.Where("target_id = ?", params.TargetID)
+	}
+	if params.TargetType != "" {
+		query = query.Where("target_type = ?", params.TargetType)
+	}
+	if params.Action != "" {
+		query = query.Where("action = ?", params.Action)
+	}
+	if params.From != "" {
+		query = query.Where("created_at >= ?", params.From)
+	}
+	if params.To != "" {
+		query = query.Where("created_at <= ?", params.To)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Order("created_at DESC, sequence DESC").Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// FindByUser returns, in order, the entries about the user and those of actions the user took,
+// including while impersonating someone else.
+func (r *auditRepository) FindByUser(userID string) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	err := inOrganization(r.db, "audit_entries", r.organizationID).
+		Where("(target_type = ? AND target_id = ?) OR actor_id = ? OR impersonator_id = ?", models.AuditTargetUser, userID, userID, userID).
+		Order("sequence").Find(&entries).Error
+	return entries, err
+}
+
+// FindChain returns up to limit entries of the organization's chain after the given sequence
+// number, in order.
+func (r *auditRepository) FindChain(organizationID string, afterSequence int64, limit int) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	err := r.db.Where("organization_id = ? AND sequence > ?", organizationID, afterSequence).
+		Order("sequence").Limit(limit).Find(&entries).Error
+	return entries, err
+}
@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+type DataKeyRepository interface {
+	FindAll() ([]models.DataKey, error)
+	FindByID(id string) (*models.DataKey, error)
+	Create(key *models.DataKey) error
+	Activate(id string) error
+	Rewrap(id string, wrappedKey []byte, masterKeyID string) error
+	WithTx(tx *gorm.DB) DataKeyRepository
+}
+
+type dataKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewDataKeyRepository(db *gorm.DB) DataKeyRepository {
+	return &dataKeyRepository{db}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *dataKeyRepository) WithTx(tx *gorm.DB) DataKeyRepository {
+	return &dataKeyRepository{tx}
+}
+
+func (r *dataKeyRepository) FindAll() ([]models.DataKey, error) {
+	var keys []models.DataKey
+	if err := r.db.Order("created_at ASC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *dataKeyRepository) FindByID(id string) (*models.DataKey, error) {
+	var key models.DataKey
+	if err := r.db.First(&key, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *dataKeyRepository) Create(key *models.DataKey) error {
+	return r.db.Create(key).Error
+}
+
+// Activate makes the encryption key the one new values are written with and retires the others.
+func (r *dataKeyRepository) Activate(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.DataKey{}).
+			Where("purpose = ? AND active AND id <> ?", models.DataKeyEncryption, id).
+			Updates(map[string]interface{}{"active": false, "retired_at": time.Now()}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.DataKey{}).Where("id = ?", id).Update("active", true).Error
+	})
+}
+
+// Rewrap replaces a key's wrapped form after the master key has been rotated.
+func (r *dataKeyRepository) Rewrap(id string, wrappedKey []byte, masterKeyID string) error {
+	return r.db.Model(&models.DataKey{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"wrapped_key":   wrappedKey,
+		"master_key_id": masterKeyID,
+	}).Error
+}
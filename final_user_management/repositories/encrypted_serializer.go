@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", encryptedSerializer{})
+}
+
+// encryptedSerializer stores string and *string fields encrypted with utils.EncryptField.
+// Use it with the `gorm:"serializer:encrypted"` tag. Reads also accept legacy plaintext.
+type encryptedSerializer struct{}
+
+func (encryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	fieldValue := reflect.New(field.FieldType)
+	if dbValue != nil {
+		var stored string
+		switch v := dbValue.(type) {
+		case string:
+			stored = v
+		case []byte:
+			stored = string(v)
+		default:
+			return fmt.Errorf("unsupported value for encrypted field %s: %#v", field.Name, dbValue)
+		}
+
+		plaintext, err := utils.DecryptField(stored)
+		if err != nil {
+			return fmt.Errorf("decrypting %s: %w", field.Name, err)
+		}
+		if field.FieldType.Kind() == reflect.Ptr {
+			fieldValue.Elem().Set(reflect.ValueOf(&plaintext))
+		} else {
+			fieldValue.Elem().SetString(plaintext)
+		}
+	}
+	return field.Set(ctx, dst, fieldValue.Elem().Interface())
+}
+
+func (encryptedSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	var plaintext string
+	switch v := fieldValue.(type) {
+	case *string:
+		if v == nil {
+			return nil, nil
+		}
+		plaintext = *v
+	case string:
+		plaintext = v
+	default:
+		return nil, fmt.Errorf("unsupported type for encrypted field %s: %T", field.Name, fieldValue)
+	}
+	return utils.EncryptField(plaintext)
+}
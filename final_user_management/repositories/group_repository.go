@@ -0,0 +1,345 @@
+package repositories
+
+import (
+	"errors"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/scim"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GroupRepository interface {
+	FindAll(params models.GetAllGroupsRequest) ([]models.Group, int64, error)
+	FindByID(id string) (*models.Group, error)
+	FindByName(name string) (*models.Group, error)
+	FindByScimFilter(filter scim.Filter, offset int, limit int) ([]models.Group, int64, error)
+	Create(group *models.Group) error
+	Update(group *models.Group) error
+	Delete(id string) error
+	HasSubgroups(id string) (bool, error)
+	IsInSubtree(groupID string, rootID string) (bool, error)
+	FindMembers(groupID string, params models.GetAllUsersRequest) ([]models.User, int64, error)
+	FindAllMembers(groupID string) ([]models.User, error)
+	FindSubtreeMemberIDs(groupID string) ([]string, error)
+	AddMembers(groupID string, userIDs []string, addedBy *string) error
+	RemoveMember(groupID string, userID string) error
+	FindByUserID(userID string, params models.GetAllGroupsRequest) ([]models.Group, int64, error)
+	FindAllByUserID(userID string) ([]models.Group, error)
+	FindRoles(groupID string) ([]models.Role, error)
+	FindInheritedRoles(groupID string) ([]models.Role, error)
+	AddRoles(groupID string, roleIDs []string, grantedBy *string) error
+	RemoveRole(groupID string, roleID string) error
+	WithTenant(organizationID string) GroupRepository
+	WithTx(tx *gorm.DB) GroupRepository
+}
+
+type groupRepository struct {
+	db             *gorm.DB
+	organizationID string
+}
+
+// NewGroupRepository returns a repository over the groups of every organization. Request
+// handling must go through WithTenant.
+func NewGroupRepository(db *gorm.DB) GroupRepository {
+	return &groupRepository{db: db}
+}
+
+// WithTenant returns a repository that only reads and writes the organization's groups.
+func (r *groupRepository) WithTenant(organizationID string) GroupRepository {
+	return &groupRepository{db: r.db, organizationID: organizationID}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *groupRepository) WithTx(tx *gorm.DB) GroupRepository {
+	return &groupRepository{db: tx, organizationID: r.organizationID}
+}
+
+// scope limits a query to the groups this repository may see.
+func (r *groupRepository) scope(db *gorm.DB) *gorm.DB {
+	return inOrganization(db, "groups", r.organizationID)
+}
+
+func (r *groupRepository) FindAll(params models.GetAllGroupsRequest) ([]models.Group, int64, error) {
+	return r.findPage(r.scope(r.db).Model(&models.Group{}), params)
+}
+
+// findPage applies the search and pagination of GetAllGroupsRequest to a group query.
+func (r *groupRepository) findPage(query *gorm.DB, params models.GetAllGroupsRequest) ([]models.Group, int64, error) {
+	var groups []models.Group
+	var total int64
+
+	if params.Search != "" {
+		search := "%" + params.Search + "%"
+		query = query.Where("name LIKE ? OR description LIKE ?", search, search)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Order("name ASC").Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&groups).Error; err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+func (r *groupRepository) FindByID(id string) (*models.Group, error) {
+	var group models.Group
+	if err := r.scope(r.db).First(&group, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) FindByName(name string) (*models.Group, error) {
+	var group models.Group
+	if err := r.scope(r.db).First(&group, "name = ?", name).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) Create(group *models.Group) error {
+	if r.organizationID != "" {
+		group.OrganizationID = r.organizationID
+	}
+	if group.OrganizationID == "" {
+		return errors.New("group has no organization")
+	}
+	return r.db.Create(group).Error
+}
+
+// Update saves the group's name, description, parent and external ID. Moving a group changes the roles
+// its members inherit, so it is refused if it would leave no active admin.
+func (r *groupRepository) Update(group *models.Group) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return guardLastAdmin(tx, r.organizationID, func(tx *gorm.DB) error {
+			result := r.scope(tx).Model(group).Select("name", "description", "parent_id", "external_id").Updates(group)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return nil
+		})
+	})
+}
+
+// Delete removes the group with its memberships and role grants.
+func (r *groupRepository) Delete(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return guardLastAdmin(tx, r.organizationID, func(tx *gorm.DB) error {
+			if err := r.scope(tx).First(&models.Group{}, "id = ?", id).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("group_id = ?", id).Delete(&models.GroupMember{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("group_id = ?", id).Delete(&models.GroupRole{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&models.Group{}, "id = ?", id).Error
+		})
+	})
+}
+
+func (r *groupRepository) HasSubgroups(id string) (bool, error) {
+	var total int64
+	err := r.scope(r.db).Model(&models.Group{}).Where("parent_id = ?", id).Count(&total).Error
+	return total > 0, err
+}
+
+// IsInSubtree reports whether groupID is rootID or nested anywhere below it.
+func (r *groupRepository) IsInSubtree(groupID string, rootID string) (bool, error) {
+	var total int64
+	err := r.db.Raw(groupSubtreeCTE+` SELECT COUNT(*) FROM subtree WHERE id = @candidate`,
+		map[string]interface{}{"group": rootID, "candidate": groupID}).Scan(&total).Error
+	return total > 0, err
+}
+
+// FindMembers returns the group's direct members, searched and paginated like GetAllUsers.
+func (r *groupRepository) FindMembers(groupID string, params models.GetAllUsersRequest) ([]models.User, int64, error) {
+	var users []models.User
+	var total int64
+
+	query := inOrganization(r.db, "users", r.organizationID).Model(&models.User{}).
+		Joins("JOIN group_members ON group_members.user_id = users.id").
+		Where("group_members.group_id = ?", groupID)
+
+	if params.From != "" && params.To != "" {
+		query = query.Where("users.created_at BETWEEN ? AND ?", params.From, params.To)
+	}
+	if params.Search != "" {
+		search := "%" + params.Search + "%"
+		query = query.Where("users.first_name LIKE ? OR users.last_name LIKE ? OR users.email LIKE ? OR users.username LIKE ?", search, search, search, search)
+	}
+	if params.Role != "" {
+		query = query.Where("users.role = ?", params.Role)
+	}
+	if params.Status != "" {
+		query = query.Where("users.status = ?", params.Status)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// FindAllMembers returns every direct member of the group.
+func (r *groupRepository) FindAllMembers(groupID string) ([]models.User, error) {
+	var users []models.User
+	err := inOrganization(r.db, "users", r.organizationID).
+		Joins("JOIN group_members ON group_members.user_id = users.id").
+		Where("group_members.group_id = ?", groupID).
+		Order("group_members.created_at ASC").
+		Find(&users).Error
+	return users, err
+}
+
+// FindSubtreeMemberIDs returns the members of the group and of all its subgroups.
+func (r *groupRepository) FindSubtreeMemberIDs(groupID string) ([]string, error) {
+	var userIDs []string
+	err := r.db.Raw(groupSubtreeCTE+` SELECT DISTINCT user_id FROM group_members WHERE group_id IN (SELECT id FROM subtree)`,
+		map[string]interface{}{"group": groupID}).Scan(&userIDs).Error
+	return userIDs, err
+}
+
+func (r *groupRepository) AddMembers(groupID string, userIDs []string, addedBy *string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, userID := range userIDs {
+			member := models.GroupMember{GroupID: groupID, UserID: userID, AddedBy: addedBy}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *groupRepository) RemoveMember(groupID string, userID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return guardLastAdmin(tx, r.organizationID, func(tx *gorm.DB) error {
+			result := tx.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&models.GroupMember{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return nil
+		})
+	})
+}
+
+// FindByUserID returns the groups the user belongs to, directly or through a subgroup,
+// searched and paginated.
+func (r *groupRepository) FindByUserID(userID string, params models.GetAllGroupsRequest) ([]models.Group, int64, error) {
+	groupIDs, err := r.userGroupIDs(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return r.findPage(r.scope(r.db).Model(&models.Group{}).Where("id IN ?", groupIDs), params)
+}
+
+// FindAllByUserID returns every group the user belongs to, directly or through a subgroup.
+func (r *groupRepository) FindAllByUserID(userID string) ([]models.Group, error) {
+	groupIDs, err := r.userGroupIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+	var groups []models.Group
+	if len(groupIDs) == 0 {
+		return groups, nil
+	}
+	if err := r.scope(r.db).Where("id IN ?", groupIDs).Order("name ASC").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (r *groupRepository) userGroupIDs(userID string) ([]string, error) {
+	groupIDs := []string{}
+	err := r.db.Raw(userGroupTreeCTE+` SELECT id FROM tree`, map[string]interface{}{"user": userID}).Scan(&groupIDs).Error
+	return groupIDs, err
+}
+
+// FindRoles returns the roles granted to the group itself.
+func (r *groupRepository) FindRoles(groupID string) ([]models.Role, error) {
+	var roles []models.Role
+	err := inOrganization(r.db, "roles", r.organizationID).Joins("JOIN group_roles ON group_roles.role_id = roles.id").
+		Where("group_roles.group_id = ?", groupID).
+		Order("roles.name ASC").
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// FindInheritedRoles returns the roles members of the group receive: those granted to the
+// group and to every group above it.
+func (r *groupRepository) FindInheritedRoles(groupID string) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Raw(`WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM groups WHERE id = @group
+			UNION
+			SELECT groups.id, groups.parent_id FROM groups JOIN ancestors ON groups.id = ancestors.parent_id
+		)
+		SELECT DISTINCT roles.* FROM roles JOIN group_roles ON group_roles.role_id = roles.id
+		WHERE group_roles.group_id IN (SELECT id FROM ancestors)
+		ORDER BY roles.name ASC`, map[string]interface{}{"group": groupID}).Scan(&roles).Error
+	return roles, err
+}
+
+func (r *groupRepository) AddRoles(groupID string, roleIDs []string, grantedBy *string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, roleID := range roleIDs {
+			grant := models.GroupRole{GroupID: groupID, RoleID: roleID, GrantedBy: grantedBy}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&grant).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *groupRepository) RemoveRole(groupID string, roleID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return guardLastAdmin(tx, r.organizationID, func(tx *gorm.DB) error {
+			result := tx.Where("group_id = ? AND role_id = ?", groupID, roleID).Delete(&models.GroupRole{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return nil
+		})
+	})
+}
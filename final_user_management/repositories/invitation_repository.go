@@ -0,0 +1,161 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+type InvitationRepository interface {
+	FindAll(params models.GetInvitationsRequest, now time.Time) ([]models.Invitation, int64, error)
+	FindByID(id string) (*models.Invitation, error)
+	FindByHash(tokenHash string) (*models.Invitation, error)
+	Create(invitation *models.Invitation) error
+	Resend(id string, tokenHash string, expiresAt time.Time, sentAt time.Time) error
+	Expire(id string, at time.Time) error
+	Revoke(id string, at time.Time) error
+	Accept(id string, at time.Time) error
+	WithTenant(organizationID string) InvitationRepository
+	WithTx(tx *gorm.DB) InvitationRepository
+}
+
+type invitationRepository struct {
+	db             *gorm.DB
+	organizationID string
+}
+
+// NewInvitationRepository returns a repository over the invitations of every organization.
+// Invitees are not signed in, so invitations are looked up by token hash before the organization
+// is known; everything else must go through WithTenant.
+func NewInvitationRepository(db *gorm.DB) InvitationRepository {
+	return &invitationRepository{db: db}
+}
+
+// WithTenant returns a repository that only reads and writes the organization's invitations.
+func (r *invitationRepository) WithTenant(organizationID string) InvitationRepository {
+	return &invitationRepository{db: r.db, organizationID: organizationID}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *invitationRepository) WithTx(tx *gorm.DB) InvitationRepository {
+	return &invitationRepository{db: tx, organizationID: r.organizationID}
+}
+
+// scope limits a query to the invitations this repository may see.
+func (r *invitationRepository) scope(db *gorm.DB) *gorm.DB {
+	return inOrganization(db, "invitations", r.organizationID)
+}
+
+// stillOpen limits a query to invitations that can still be accepted at the time.
+func stillOpen(query *gorm.DB, now time.Time) *gorm.DB {
+	return query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", now)
+}
+
+// FindAll lists invitations, newest first. The status filter is worked out at now.
+func (r *invitationRepository) FindAll(params models.GetInvitationsRequest, now time.Time) ([]models.Invitation, int64, error) {
+	var invitations []models.Invitation
+	var total int64
+
+	query := r.scope(r.db).Model(&models.Invitation{})
+	switch models.InvitationStatus(params.Status) {
+	case models.InvitationPending:
+		query = stillOpen(query, now)
+	case models.InvitationAccepted:
+		query = query.Where("accepted_at IS NOT NULL")
+	case models.InvitationRevoked:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NOT NULL")
+	case models.InvitationExpired:
+		query = query.Where("accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= ?", now)
+	}
+	if params.Search != "" {
+		query = query.Where("email LIKE ?", "%"+params.Search+"%")
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Order("created_at DESC").Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&invitations).Error; err != nil {
+		return nil, 0, err
+	}
+	return invitations, total, nil
+}
+
+func (r *invitationRepository) FindByID(id string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := r.scope(r.db).First(&invitation, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// FindByHash looks in every organization, even on a tenant's repository: the invitee may open
+// their link on any host, and token hashes are unique across organizations.
+func (r *invitationRepository) FindByHash(tokenHash string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := r.db.First(&invitation, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (r *invitationRepository) Create(invitation *models.Invitation) error {
+	if r.organizationID != "" {
+		invitation.OrganizationID = r.organizationID
+	}
+	if invitation.OrganizationID == "" {
+		return errors.New("invitation has no organization")
+	}
+	return r.db.Create(invitation).Error
+}
+
+// Resend replaces the invitation's token, so that links sent earlier stop working, and counts
+// the new email. Accepted and revoked invitations cannot be resent.
+func (r *invitationRepository) Resend(id string, tokenHash string, expiresAt time.Time, sentAt time.Time) error {
+	return r.update(r.scope(r.db).Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id), map[string]interface{}{
+		"token_hash":   tokenHash,
+		"expires_at":   expiresAt,
+		"last_sent_at": sentAt,
+		"sent_count":   gorm.Expr("sent_count + 1"),
+	})
+}
+
+// Expire makes a pending invitation expire now.
+func (r *invitationRepository) Expire(id string, at time.Time) error {
+	return r.update(stillOpen(r.scope(r.db).Where("id = ?", id), at), map[string]interface{}{"expires_at": at})
+}
+
+// Revoke revokes an invitation that has not been accepted.
+func (r *invitationRepository) Revoke(id string, at time.Time) error {
+	return r.update(r.scope(r.db).Where("id = ? AND accepted_at IS NULL AND revoked_at IS NULL", id), map[string]interface{}{"revoked_at": at})
+}
+
+// Accept marks a pending invitation accepted. Only one of two concurrent acceptances succeeds.
+func (r *invitationRepository) Accept(id string, at time.Time) error {
+	return r.update(stillOpen(r.scope(r.db).Where("id = ?", id), at), map[string]interface{}{"accepted_at": at})
+}
+
+// update applies the updates to the invitation the query selects, failing with
+// gorm.ErrRecordNotFound when it selects none.
+func (r *invitationRepository) update(query *gorm.DB, updates map[string]interface{}) error {
+	result := query.Model(&models.Invitation{}).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+type JobRepository interface {
+	Create(job *models.Job) error
+	FindByID(id string) (*models.Job, error)
+	Save(job *models.Job) error
+	FindExpired(now time.Time) ([]models.Job, error)
+	Delete(id string) error
+	Heartbeat(id string, at time.Time) error
+	FailStale(before time.Time, message string, now time.Time) (int64, error)
+}
+
+type jobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) JobRepository {
+	return &jobRepository{db}
+}
+
+func (r *jobRepository) Create(job *models.Job) error {
+	return r.db.Create(job).Error
+}
+
+func (r *jobRepository) FindByID(id string) (*models.Job, error) {
+	var job models.Job
+	if err := r.db.First(&job, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *jobRepository) Save(job *models.Job) error {
+	return r.db.Save(job).Error
+}
+
+func (r *jobRepository) FindExpired(now time.Time) ([]models.Job, error) {
+	var jobs []models.Job
+	if err := r.db.Where("expires_at IS NOT NULL AND expires_at < ?", now).Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *jobRepository) Delete(id string) error {
+	return r.db.Delete(&models.Job{}, "id = ?", id).Error
+}
+
+// Heartbeat records that the job is still running.
+func (r *jobRepository) Heartbeat(id string, at time.Time) error {
+	return r.db.Model(&models.Job{}).Where("id = ? AND status = ?", id, models.JobProcessing).Update("heartbeat_at", at).Error
+}
+
+// FailStale marks failed the unfinished jobs whose last heartbeat, or creation if they never
+// started, is older than before. It returns how many it failed.
+func (r *jobRepository) FailStale(before time.Time, message string, now time.Time) (int64, error) {
+	result := r.db.Model(&models.Job{}).
+		Where("status IN ? AND COALESCE(heartbeat_at, created_at) < ?", []models.JobStatus{models.JobPending, models.JobProcessing}, before).
+		Updates(map[string]interface{}{"status": models.JobFailed, "error": message, "completed_at": now})
+	return result.RowsAffected, result.Error
+}
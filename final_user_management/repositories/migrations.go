@@ -0,0 +1,225 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// legacyUserUniqueColumns had plain unique constraints/indexes that also covered soft-deleted
+// rows. They are replaced by partial unique indexes limited to rows where deleted_at IS NULL.
+var legacyUserUniqueColumns = []string{"client_id", "email", "national_id", "passport_number", "phone", "username", "slug"}
+
+// replacedIndexes were superseded: the identity document indexes by the blind index columns
+// once national IDs and passport numbers became encrypted, the next ones by per-organization
+// indexes when users, roles and groups became tenant-scoped, and the client ID and phone indexes
+// by ones that ignore empty values.
+var replacedIndexes = []string{
+	"idx_users_national_id_active",
+	"idx_users_passport_number_active",
+	"idx_users_client_id_active",
+	"idx_users_email_active",
+	"idx_users_national_id_index_active",
+	"idx_users_passport_number_index_active",
+	"idx_users_phone_active",
+	"idx_users_username_active",
+	"idx_users_slug_active",
+	"idx_roles_name",
+	"idx_groups_name",
+	"idx_users_org_client_id_active",
+	"idx_users_org_phone_active",
+}
+
+// tenantScopedTables gained an organization_id column with multi-tenancy.
+var tenantScopedTables = []string{"users", "roles", "groups"}
+
+// Migrate brings the database schema up to date.
+func Migrate(db *gorm.DB) error {
+	if db.Migrator().HasTable(&models.User{}) {
+		for _, column := range legacyUserUniqueColumns {
+			statements := []string{
+				fmt.Sprintf("ALTER TABLE users DROP CONSTRAINT IF EXISTS uni_users_%s", column),
+				fmt.Sprintf("ALTER TABLE users DROP CONSTRAINT IF EXISTS users_%s_key", column),
+				fmt.Sprintf("DROP INDEX IF EXISTS idx_users_%s", column),
+			}
+			for _, statement := range statements {
+				if err := db.Exec(statement).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for _, index := range replacedIndexes {
+		if err := db.Exec(fmt.Sprintf("DROP INDEX IF EXISTS %s", index)).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.AutoMigrate(&models.Organization{}); err != nil {
+		return err
+	}
+	defaultOrganization, err := ensureDefaultOrganization(db)
+	if err != nil {
+		return err
+	}
+
+	// Rows created before multi-tenancy are moved into the default organization before the
+	// column is made NOT NULL.
+	for _, table := range tenantScopedTables {
+		if !db.Migrator().HasTable(table) || db.Migrator().HasColumn(table, "organization_id") {
+			continue
+		}
+		if err := db.Exec("ALTER TABLE ? ADD COLUMN organization_id uuid", clause.Table{Name: table}).Error; err != nil {
+			return err
+		}
+		if err := db.Exec("UPDATE ? SET organization_id = ?", clause.Table{Name: table}, defaultOrganization.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	// Users are given role assignments matching their old role column the first time
+	// the user_roles table is created.
+	backfillUserRoles := !db.Migrator().HasTable(&models.UserRoleAssignment{})
+
+	if err := db.AutoMigrate(
+		&models.User{},
+		&models.SecurityEvent{},
+		&models.Session{},
+		&models.KnownDevice{},
+		&models.UserStatusChange{},
+		&models.Job{},
+		&models.DataKey{},
+		&models.Role{},
+		&models.UserRoleAssignment{},
+		&models.Group{},
+		&models.GroupMember{},
+		&models.GroupRole{},
+		&models.AuditEntry{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.OutboxEvent{},
+		&models.ScimToken{},
+		&models.Invitation{},
+	); err != nil {
+		return err
+	}
+	if err := protectAuditLog(db); err != nil {
+		return err
+	}
+
+	var organizations []models.Organization
+	if err := db.Find(&organizations).Error; err != nil {
+		return err
+	}
+	for _, organization := range organizations {
+		if err := seedRoles(db, &organization); err != nil {
+			return err
+		}
+	}
+
+	if !backfillUserRoles {
+		return nil
+	}
+	return db.Exec(`INSERT INTO user_roles (user_id, role_id, created_at)
+		SELECT users.id, roles.id, NOW() FROM users
+		JOIN roles ON roles.name = users.role AND roles.organization_id = users.organization_id
+		ON CONFLICT DO NOTHING`).Error
+}
+
+// protectAuditLog makes the database refuse to change or delete audit entries, so even code
+// with direct table access can only append to the log.
+func protectAuditLog(db *gorm.DB) error {
+	statements := []string{
+		`CREATE OR REPLACE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_entries is append-only';
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries`,
+		`CREATE TRIGGER audit_entries_append_only BEFORE UPDATE OR DELETE ON audit_entries
+		FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only()`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureDefaultOrganization returns the default organization, creating it if needed.
+func ensureDefaultOrganization(db *gorm.DB) (*models.Organization, error) {
+	var organization models.Organization
+	err := db.First(&organization, "slug = ?", models.DefaultOrganizationSlug).Error
+	if err == nil {
+		return &organization, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	organization = models.Organization{Name: "Default", Slug: models.DefaultOrganizationSlug, Active: true}
+	if err := db.Create(&organization).Error; err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// builtinRoles are the roles every organization starts with. The admin role's permissions
+// are filled in per organization by adminPermissions.
+var builtinRoles = []models.Role{
+	{
+		Name:        string(models.RoleUser),
+		Description: "Regular account holder. Can manage their own account only.",
+		Permissions: []models.Permission{},
+	},
+	{
+		Name:        string(models.RoleHelpdesk),
+		Description: "Support staff. Can look users up and reset their passwords.",
+		Permissions: []models.Permission{models.PermUsersRead, models.PermUsersResetPassword},
+	},
+	{
+		Name:        string(models.RoleAdmin),
+		Description: "Full access.",
+	},
+}
+
+// adminPermissions is every permission for the default organization's admins, and every
+// permission except the deployment-wide ones for other organizations.
+func adminPermissions(organization *models.Organization) []models.Permission {
+	if organization.Slug == models.DefaultOrganizationSlug {
+		return models.AllPermissions
+	}
+	permissions := []models.Permission{}
+	for _, permission := range models.AllPermissions {
+		if !permission.IsPlatform() {
+			permissions = append(permissions, permission)
+		}
+	}
+	return permissions
+}
+
+// seedRoles creates any of the organization's missing built-in roles and keeps its admin
+// role holding every permission it may have.
+func seedRoles(db *gorm.DB, organization *models.Organization) error {
+	for _, role := range builtinRoles {
+		role.OrganizationID = organization.ID
+		role.System = true
+		if role.Name == string(models.RoleAdmin) {
+			role.Permissions = adminPermissions(organization)
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "organization_id"}, {Name: "name"}},
+			DoNothing: true,
+		}).Create(&role).Error
+		if err != nil {
+			return err
+		}
+	}
+	return db.Model(&models.Role{}).
+		Where("organization_id = ? AND name = ?", organization.ID, models.RoleAdmin).
+		Updates(&models.Role{Permissions: adminPermissions(organization)}).Error
+}
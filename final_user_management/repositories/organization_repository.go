@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"errors"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+type OrganizationRepository interface {
+	FindAll() ([]models.Organization, error)
+	FindByID(id string) (*models.Organization, error)
+	FindBySlug(slug string) (*models.Organization, error)
+	Create(organization *models.Organization) error
+	Update(organization *models.Organization) error
+	Delete(id string) error
+	WithTx(tx *gorm.DB) OrganizationRepository
+}
+
+type organizationRepository struct {
+	db *gorm.DB
+}
+
+func NewOrganizationRepository(db *gorm.DB) OrganizationRepository {
+	return &organizationRepository{db}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *organizationRepository) WithTx(tx *gorm.DB) OrganizationRepository {
+	return &organizationRepository{tx}
+}
+
+func (r *organizationRepository) FindAll() ([]models.Organization, error) {
+	var organizations []models.Organization
+	if err := r.db.Order("name ASC").Find(&organizations).Error; err != nil {
+		return nil, err
+	}
+	return organizations, nil
+}
+
+func (r *organizationRepository) FindByID(id string) (*models.Organization, error) {
+	var organization models.Organization
+	if err := r.db.First(&organization, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+func (r *organizationRepository) FindBySlug(slug string) (*models.Organization, error) {
+	var organization models.Organization
+	if err := r.db.First(&organization, "slug = ?", slug).Error; err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// Create adds the organization together with its built-in roles.
+func (r *organizationRepository) Create(organization *models.Organization) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(organization).Error; err != nil {
+			return err
+		}
+		return seedRoles(tx, organization)
+	})
+}
+
+func (r *organizationRepository) Update(organization *models.Organization) error {
+	return r.db.Model(organization).Select("name", "active", "registration_mode", "allowed_email_domains", "reserved_names").Updates(organization).Error
+}
+
+// Delete removes an organization that has no users yet, along with its roles and groups.
+func (r *organizationRepository) Delete(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var users int64
+		if err := tx.Unscoped().Model(&models.User{}).Where("organization_id = ?", id).Count(&users).Error; err != nil {
+			return err
+		}
+		if users > 0 {
+			return errors.New("organization still has users")
+		}
+		groups := tx.Model(&models.Group{}).Select("id").Where("organization_id = ?", id)
+		if err := tx.Where("group_id IN (?)", groups).Delete(&models.GroupRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&models.Group{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&models.Role{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Organization{}, "id = ?", id).Error
+	})
+}
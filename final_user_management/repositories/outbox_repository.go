@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+// outboxRelayLock is the advisory lock held by the relay, so that only one server publishes at a
+// time and events about an aggregate cannot overtake each other.
+const outboxRelayLock = "outbox:relay"
+
+// OutboxRepository stores domain events until the relay has published them.
+type OutboxRepository interface {
+	Append(events []models.OutboxEvent) error
+	FindPending(limit int) ([]models.OutboxEvent, error)
+	MarkPublished(position int64) error
+	MarkFailed(position int64, reason string) error
+	DeletePublishedBefore(cutoff time.Time) (int64, error)
+	WithRelayLock(fn func(outbox OutboxRepository) error) (bool, error)
+	WithTx(tx *gorm.DB) OutboxRepository
+}
+
+type outboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) OutboxRepository {
+	return &outboxRepository{db}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *outboxRepository) WithTx(tx *gorm.DB) OutboxRepository {
+	return &outboxRepository{tx}
+}
+
+func (r *outboxRepository) Append(events []models.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return r.db.Create(&events).Error
+}
+
+// FindPending returns up to limit unpublished events in the order they were written.
+func (r *outboxRepository) FindPending(limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := r.db.Where("published_at IS NULL").Order("position").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+func (r *outboxRepository) MarkPublished(position int64) error {
+	return r.db.Model(&models.OutboxEvent{}).Where("position = ?", position).Updates(map[string]interface{}{
+		"published_at": time.Now(),
+		"last_error":   "",
+	}).Error
+}
+
+func (r *outboxRepository) MarkFailed(position int64, reason string) error {
+	return r.db.Model(&models.OutboxEvent{}).Where("position = ?", position).Updates(map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": reason,
+	}).Error
+}
+
+// DeletePublishedBefore removes events published before the cutoff and returns how many.
+func (r *outboxRepository) DeletePublishedBefore(cutoff time.Time) (int64, error) {
+	result := r.db.Where("published_at < ?", cutoff).Delete(&models.OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// WithRelayLock runs fn in a transaction holding the relay lock, with a repository working in that
+// transaction. It returns false without calling fn when another server holds the lock.
+func (r *outboxRepository) WithRelayLock(fn func(outbox OutboxRepository) error) (bool, error) {
+	acquired := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(hashtext(?))", outboxRelayLock).Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+		return fn(r.WithTx(tx))
+	})
+	return acquired, err
+}
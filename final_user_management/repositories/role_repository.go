@@ -0,0 +1,265 @@
+package repositories
+
+import (
+	"errors"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoleRepository interface {
+	FindAll() ([]models.Role, error)
+	FindByID(id string) (*models.Role, error)
+	FindByName(name string) (*models.Role, error)
+	FindByNames(names []string) ([]models.Role, error)
+	FindByUserID(userID string) ([]models.Role, error)
+	FindEffectiveByUserID(userID string) ([]models.Role, error)
+	Create(role *models.Role) error
+	Update(role *models.Role) error
+	Delete(id string) error
+	SetUserRoles(userID string, roleNames []string, assignedBy *string) error
+	AddUserRoles(userID string, roleIDs []string, assignedBy *string) error
+	RemoveUserRole(userID string, roleID string) error
+	FindUserIDsByRole(roleID string) ([]string, error)
+	FindUsersByRole(roleID string, params models.GetAllUsersRequest) ([]models.User, int64, error)
+	WithTenant(organizationID string) RoleRepository
+	WithTx(tx *gorm.DB) RoleRepository
+}
+
+type roleRepository struct {
+	db             *gorm.DB
+	organizationID string
+}
+
+// NewRoleRepository returns a repository over the roles of every organization. Request
+// handling must go through WithTenant.
+func NewRoleRepository(db *gorm.DB) RoleRepository {
+	return &roleRepository{db: db}
+}
+
+// WithTenant returns a repository that only reads and writes the organization's roles.
+func (r *roleRepository) WithTenant(organizationID string) RoleRepository {
+	return &roleRepository{db: r.db, organizationID: organizationID}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *roleRepository) WithTx(tx *gorm.DB) RoleRepository {
+	return &roleRepository{db: tx, organizationID: r.organizationID}
+}
+
+// scope limits a query to the roles this repository may see.
+func (r *roleRepository) scope(db *gorm.DB) *gorm.DB {
+	return inOrganization(db, "roles", r.organizationID)
+}
+
+func (r *roleRepository) FindAll() ([]models.Role, error) {
+	var roles []models.Role
+	if err := r.scope(r.db).Order("name ASC").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) FindByID(id string) (*models.Role, error) {
+	var role models.Role
+	if err := r.scope(r.db).First(&role, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *roleRepository) FindByName(name string) (*models.Role, error) {
+	var role models.Role
+	if err := r.scope(r.db).First(&role, "name = ?", name).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *roleRepository) FindByNames(names []string) ([]models.Role, error) {
+	var roles []models.Role
+	if err := r.scope(r.db).Where("name IN ?", names).Order("name ASC").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) FindByUserID(userID string) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.scope(r.db).Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name ASC").
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// FindEffectiveByUserID returns the user's roles including those inherited through groups.
+func (r *roleRepository) FindEffectiveByUserID(userID string) ([]models.Role, error) {
+	return findEffectiveRoles(r.db, userID)
+}
+
+func (r *roleRepository) Create(role *models.Role) error {
+	if r.organizationID != "" {
+		role.OrganizationID = r.organizationID
+	}
+	if role.OrganizationID == "" {
+		return errors.New("role has no organization")
+	}
+	return r.db.Create(role).Error
+}
+
+// Update saves the role's name, description and permissions, and refreshes the primary role
+// shown for its members in case the name changed.
+func (r *roleRepository) Update(role *models.Role) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := r.scope(tx).Model(role).Select("name", "description", "permissions").Updates(role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return syncPrimaryRoles(tx, tx.Model(&models.UserRoleAssignment{}).Select("user_id").Where("role_id = ?", role.ID))
+	})
+}
+
+// Delete removes the role along with its user assignments and group grants.
+func (r *roleRepository) Delete(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := r.scope(tx).First(&models.Role{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		var userIDs []string
+		if err := tx.Model(&models.UserRoleAssignment{}).Where("role_id = ?", id).Pluck("user_id", &userIDs).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&models.UserRoleAssignment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", id).Delete(&models.GroupRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Role{}, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if len(userIDs) == 0 {
+			return nil
+		}
+		return syncPrimaryRoles(tx, userIDs)
+	})
+}
+
+// SetUserRoles replaces all of the user's role assignments with the named roles.
+// It fails with ErrLastAdmin rather than take the admin role from the last active admin.
+func (r *roleRepository) SetUserRoles(userID string, roleNames []string, assignedBy *string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return guardLastAdmin(tx, r.organizationID, func(tx *gorm.DB) error {
+			return r.setUserRoles(tx, userID, roleNames, assignedBy)
+		})
+	})
+}
+
+func (r *roleRepository) setUserRoles(tx *gorm.DB, userID string, roleNames []string, assignedBy *string) error {
+	var roles []models.Role
+	if err := r.scope(tx).Where("name IN ?", roleNames).Find(&roles).Error; err != nil {
+		return err
+	}
+	if len(roles) != len(roleNames) {
+		return gorm.ErrRecordNotFound
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.UserRoleAssignment{}).Error; err != nil {
+		return err
+	}
+	for _, role := range roles {
+		assignment := models.UserRoleAssignment{UserID: userID, RoleID: role.ID, AssignedBy: assignedBy}
+		if err := tx.Create(&assignment).Error; err != nil {
+			return err
+		}
+	}
+	return syncPrimaryRoles(tx, []string{userID})
+}
+
+// AddUserRoles assigns the roles to the user, ignoring ones they already hold.
+func (r *roleRepository) AddUserRoles(userID string, roleIDs []string, assignedBy *string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for _, roleID := range roleIDs {
+			assignment := models.UserRoleAssignment{UserID: userID, RoleID: roleID, AssignedBy: assignedBy}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&assignment).Error; err != nil {
+				return err
+			}
+		}
+		return syncPrimaryRoles(tx, []string{userID})
+	})
+}
+
+// RemoveUserRole takes one role from the user. It fails with ErrLastAdmin rather than take
+// the admin role from the last active admin.
+func (r *roleRepository) RemoveUserRole(userID string, roleID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return guardLastAdmin(tx, r.organizationID, func(tx *gorm.DB) error {
+			result := tx.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&models.UserRoleAssignment{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return syncPrimaryRoles(tx, []string{userID})
+		})
+	})
+}
+
+func (r *roleRepository) FindUserIDsByRole(roleID string) ([]string, error) {
+	var userIDs []string
+	if err := r.db.Model(&models.UserRoleAssignment{}).Where("role_id = ?", roleID).Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+func (r *roleRepository) FindUsersByRole(roleID string, params models.GetAllUsersRequest) ([]models.User, int64, error) {
+	var users []models.User
+	var total int64
+
+	query := inOrganization(r.db, "users", r.organizationID).Model(&models.User{}).
+		Joins("JOIN user_roles ON user_roles.user_id = users.id").
+		Where("user_roles.role_id = ?", roleID)
+	if params.Status != "" {
+		query = query.Where("users.status = ?", params.Status)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Order("users.created_at ASC").Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// syncPrimaryRoles sets users.role for the given users (a list of IDs or a subquery) to the
+// assigned role granting the most permissions, or empty when they have no roles left.
+func syncPrimaryRoles(tx *gorm.DB, userIDs interface{}) error {
+	return tx.Exec(`UPDATE users SET role = COALESCE((
+			SELECT roles.name FROM roles JOIN user_roles ON user_roles.role_id = roles.id
+			WHERE user_roles.user_id = users.id
+			ORDER BY COALESCE(json_array_length(roles.permissions::json), 0) DESC, roles.name ASC
+			LIMIT 1
+		), '') WHERE id IN (?)`, userIDs).Error
+}
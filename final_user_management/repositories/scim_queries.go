@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/scim"
+
+	"gorm.io/gorm"
+)
+
+// scimUserColumns are the User attributes SCIM clients can filter on, by scim.AttrPath key.
+// Every address is a work address: users have one email and one phone number.
+var scimUserColumns = map[string]scim.Column{
+	"id":                 {Expr: "users.id::text", CaseExact: true},
+	"externalid":         {Expr: "users.external_id", CaseExact: true},
+	"username":           {Expr: "users.username"},
+	"name.givenname":     {Expr: "users.first_name"},
+	"name.familyname":    {Expr: "users.last_name"},
+	"name.formatted":     {Expr: "(users.first_name || ' ' || users.last_name)"},
+	"displayname":        {Expr: "(users.first_name || ' ' || users.last_name)"},
+	"emails.value":       {Expr: "users.email"},
+	"emails.type":        {Expr: "'work'"},
+	"phonenumbers.value": {Expr: "users.phone"},
+	"phonenumbers.type":  {Expr: "'work'"},
+	"active":             {Expr: "(users.status = 'active')", Type: scim.BooleanAttr},
+	"meta.created":       {Expr: "users.created_at", Type: scim.DateTimeAttr},
+	"meta.lastmodified":  {Expr: "users.updated_at", Type: scim.DateTimeAttr},
+	scim.AttrPath{Schema: scim.EnterpriseUserSchema, Attr: "department"}.Key(): {Expr: "users.department"},
+	"groups.value": {
+		Expr:      "group_members.group_id::text",
+		CaseExact: true,
+		Exists:    "EXISTS (SELECT 1 FROM group_members WHERE group_members.user_id = users.id AND %s)",
+	},
+}
+
+// scimGroupColumns are the Group attributes SCIM clients can filter on.
+var scimGroupColumns = map[string]scim.Column{
+	"id":                {Expr: "groups.id::text", CaseExact: true},
+	"externalid":        {Expr: "groups.external_id", CaseExact: true},
+	"displayname":       {Expr: "groups.name"},
+	"meta.created":      {Expr: "groups.created_at", Type: scim.DateTimeAttr},
+	"meta.lastmodified": {Expr: "groups.updated_at", Type: scim.DateTimeAttr},
+	"members.value": {
+		Expr:      "group_members.user_id::text",
+		CaseExact: true,
+		Exists:    "EXISTS (SELECT 1 FROM group_members WHERE group_members.group_id = groups.id AND %s)",
+	},
+}
+
+// FindByScimFilter returns a page of the users matching the SCIM filter, oldest first, and how
+// many match in all. A nil filter matches every user.
+func (r *userRepository) FindByScimFilter(filter scim.Filter, offset int, limit int) ([]models.User, int64, error) {
+	var users []models.User
+	total, err := findByScimFilter(r.scope(r.db).Model(&models.User{}), "users", scimUserColumns, filter, offset, limit, &users)
+	return users, total, err
+}
+
+// FindByScimFilter returns a page of the groups matching the SCIM filter, oldest first, and how
+// many match in all. A nil filter matches every group.
+func (r *groupRepository) FindByScimFilter(filter scim.Filter, offset int, limit int) ([]models.Group, int64, error) {
+	var groups []models.Group
+	total, err := findByScimFilter(r.scope(r.db).Model(&models.Group{}), "groups", scimGroupColumns, filter, offset, limit, &groups)
+	return groups, total, err
+}
+
+func findByScimFilter(query *gorm.DB, table string, columns map[string]scim.Column, filter scim.Filter, offset int, limit int, dest interface{}) (int64, error) {
+	if filter != nil {
+		condition, args, err := scim.SQL(filter, columns)
+		if err != nil {
+			return 0, err
+		}
+		query = query.Where(condition, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return 0, err
+	}
+	// A count of zero asks only for the number of matches
+	if limit == 0 {
+		return total, nil
+	}
+	err := query.Order(table + ".created_at ASC, " + table + ".id ASC").Offset(offset).Limit(limit).Find(dest).Error
+	return total, err
+}
@@ -0,0 +1,96 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+type ScimTokenRepository interface {
+	FindAll() ([]models.ScimToken, error)
+	FindByID(id string) (*models.ScimToken, error)
+	FindByHash(tokenHash string) (*models.ScimToken, error)
+	Create(token *models.ScimToken) error
+	Revoke(id string, at time.Time) error
+	TouchLastUsed(id string, at time.Time) error
+	WithTenant(organizationID string) ScimTokenRepository
+	WithTx(tx *gorm.DB) ScimTokenRepository
+}
+
+type scimTokenRepository struct {
+	db             *gorm.DB
+	organizationID string
+}
+
+// NewScimTokenRepository returns a repository over the SCIM tokens of every organization. Tokens
+// are looked up by hash before the organization is known; everything else must go through
+// WithTenant.
+func NewScimTokenRepository(db *gorm.DB) ScimTokenRepository {
+	return &scimTokenRepository{db: db}
+}
+
+// WithTenant returns a repository that only reads and writes the organization's tokens.
+func (r *scimTokenRepository) WithTenant(organizationID string) ScimTokenRepository {
+	return &scimTokenRepository{db: r.db, organizationID: organizationID}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *scimTokenRepository) WithTx(tx *gorm.DB) ScimTokenRepository {
+	return &scimTokenRepository{db: tx, organizationID: r.organizationID}
+}
+
+// scope limits a query to the tokens this repository may see.
+func (r *scimTokenRepository) scope(db *gorm.DB) *gorm.DB {
+	return inOrganization(db, "scim_tokens", r.organizationID)
+}
+
+func (r *scimTokenRepository) FindAll() ([]models.ScimToken, error) {
+	var tokens []models.ScimToken
+	err := r.scope(r.db).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *scimTokenRepository) FindByID(id string) (*models.ScimToken, error) {
+	var token models.ScimToken
+	if err := r.scope(r.db).First(&token, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *scimTokenRepository) FindByHash(tokenHash string) (*models.ScimToken, error) {
+	var token models.ScimToken
+	if err := r.scope(r.db).First(&token, "token_hash = ?", tokenHash).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *scimTokenRepository) Create(token *models.ScimToken) error {
+	if r.organizationID != "" {
+		token.OrganizationID = r.organizationID
+	}
+	if token.OrganizationID == "" {
+		return errors.New("SCIM token has no organization")
+	}
+	return r.db.Create(token).Error
+}
+
+// Revoke marks the token revoked. Revoking it again keeps the first revocation time.
+func (r *scimTokenRepository) Revoke(id string, at time.Time) error {
+	result := r.scope(r.db).Model(&models.ScimToken{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *scimTokenRepository) TouchLastUsed(id string, at time.Time) error {
+	return r.db.Model(&models.ScimToken{}).Where("id = ?", id).Update("last_used_at", at).Error
+}
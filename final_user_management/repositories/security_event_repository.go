@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+)
+
+type SecurityEventRepository interface {
+	Create(event *models.SecurityEvent) error
+	FindByUserID(userID string, params models.GetLoginHistoryRequest) ([]models.SecurityEvent, int64, error)
+	FindAllByUserID(userID string) ([]models.SecurityEvent, error)
+	CountByUserID(userID string) (int64, error)
+}
+
+type securityEventRepository struct {
+	db *gorm.DB
+}
+
+func NewSecurityEventRepository(db *gorm.DB) SecurityEventRepository {
+	return &securityEventRepository{db}
+}
+
+func (r *securityEventRepository) Create(event *models.SecurityEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *securityEventRepository) FindByUserID(userID string, params models.GetLoginHistoryRequest) ([]models.SecurityEvent, int64, error) {
+	var events []models.SecurityEvent
+	var total int64
+
+	query := r.db.Model(&models.SecurityEvent{}).Where("user_id = ?", userID)
+	if params.Type != "" {
+		query = query.Where("type = ?", params.Type)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Order("created_at DESC").Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&events).Error; err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
+
+func (r *securityEventRepository) FindAllByUserID(userID string) ([]models.SecurityEvent, error) {
+	var events []models.SecurityEvent
+	if err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *securityEventRepository) CountByUserID(userID string) (int64, error) {
+	var total int64
+	err := r.db.Model(&models.SecurityEvent{}).Where("user_id = ?", userID).Count(&total).Error
+	return total, err
+}
@@ -0,0 +1,82 @@
+package repositories
+
+import (
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SessionRepository interface {
+	Create(session *models.Session) error
+	FindByID(id string) (*models.Session, error)
+	FindByUserID(userID string) ([]models.Session, error)
+	Revoke(id string) error
+	RevokeAllForUser(userID string) error
+	FindKnownDevices(userID string) ([]models.KnownDevice, error)
+	TouchKnownDevice(device *models.KnownDevice) error
+	WithTx(tx *gorm.DB) SessionRepository
+}
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *sessionRepository) WithTx(tx *gorm.DB) SessionRepository {
+	return &sessionRepository{tx}
+}
+
+func (r *sessionRepository) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *sessionRepository) FindByID(id string) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.First(&session, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *sessionRepository) Revoke(id string) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *sessionRepository) RevokeAllForUser(userID string) error {
+	return r.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *sessionRepository) FindKnownDevices(userID string) ([]models.KnownDevice, error) {
+	var devices []models.KnownDevice
+	if err := r.db.Where("user_id = ?", userID).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// TouchKnownDevice inserts the device/prefix pair or bumps its last-seen time if it already exists.
+func (r *sessionRepository) TouchKnownDevice(device *models.KnownDevice) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "device_id"}, {Name: "ip_prefix"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_seen_at", "user_agent"}),
+	}).Create(device).Error
+}
+
+func (r *sessionRepository) FindByUserID(userID string) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
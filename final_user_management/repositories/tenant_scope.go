@@ -0,0 +1,13 @@
+package repositories
+
+import "gorm.io/gorm"
+
+// inOrganization limits a query on table to the rows of one organization. An empty
+// organizationID leaves the query across all tenants; only the repositories handed to
+// background jobs and startup tasks are built that way.
+func inOrganization(db *gorm.DB, table string, organizationID string) *gorm.DB {
+	if organizationID == "" {
+		return db
+	}
+	return db.Where(table+".organization_id = ?", organizationID)
+}
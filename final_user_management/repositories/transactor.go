@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Transactor runs work in a database transaction. Repositories take part in it through their
+// WithTx method, so that changes made through several of them commit or roll back together.
+type Transactor interface {
+	Transaction(fn func(tx *gorm.DB) error) error
+}
+
+type transactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) Transactor {
+	return &transactor{db}
+}
+
+// Transaction runs fn in a transaction, or in a savepoint when the transactor was made from a
+// transaction. Work registered with AfterCommit runs once the outermost transaction commits, and
+// is dropped with the transaction or savepoint it was registered in when that rolls back.
+func (t *transactor) Transaction(fn func(tx *gorm.DB) error) error {
+	hooks := &afterCommitHooks{}
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		return fn(tx.WithContext(context.WithValue(tx.Statement.Context, afterCommitKey{}, hooks)))
+	})
+	if err != nil {
+		return err
+	}
+	if parent := afterCommitHooksOf(t.db); parent != nil {
+		parent.fns = append(parent.fns, hooks.fns...)
+		return nil
+	}
+	for _, fn := range hooks.fns {
+		fn()
+	}
+	return nil
+}
+
+type afterCommitKey struct{}
+
+type afterCommitHooks struct {
+	fns []func()
+}
+
+func afterCommitHooksOf(db *gorm.DB) *afterCommitHooks {
+	if db.Statement == nil || db.Statement.Context == nil {
+		return nil
+	}
+	hooks, _ := db.Statement.Context.Value(afterCommitKey{}).(*afterCommitHooks)
+	return hooks
+}
+
+// AfterCommit runs fn once the Transactor transaction that db belongs to has committed, or
+// never if it rolls back. Outside such a transaction fn runs straight away.
+func AfterCommit(db *gorm.DB, fn func()) {
+	if hooks := afterCommitHooksOf(db); hooks != nil {
+		hooks.fns = append(hooks.fns, fn)
+		return
+	}
+	fn()
+}
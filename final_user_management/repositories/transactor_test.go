@@ -0,0 +1,57 @@
+package repositories
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestTransactorRunsAfterCommitHooksOnceCommitted(t *testing.T) {
+	db := openTestDB(t)
+	var ran []string
+	record := func(name string) func() { return func() { ran = append(ran, name) } }
+
+	err := NewTransactor(db).Transaction(func(tx *gorm.DB) error {
+		AfterCommit(tx, record("outer"))
+		err := NewTransactor(tx).Transaction(func(tx *gorm.DB) error {
+			AfterCommit(tx, record("rolled back savepoint"))
+			return errors.New("roll back")
+		})
+		if err == nil {
+			t.Fatalf("savepoint did not fail")
+		}
+		if err := NewTransactor(tx).Transaction(func(tx *gorm.DB) error {
+			AfterCommit(tx, record("savepoint"))
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(ran) != 0 {
+			t.Errorf("hooks ran before the commit: %v", ran)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Transaction: %v", err)
+	}
+	if want := []string{"outer", "savepoint"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %v, want %v", ran, want)
+	}
+
+	ran = nil
+	_ = NewTransactor(db).Transaction(func(tx *gorm.DB) error {
+		AfterCommit(tx, record("rolled back"))
+		return errors.New("roll back")
+	})
+	if len(ran) != 0 {
+		t.Errorf("hooks of a rolled-back transaction ran: %v", ran)
+	}
+
+	ran = nil
+	AfterCommit(db, record("outside"))
+	if want := []string{"outside"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("outside a transaction ran %v, want %v", ran, want)
+	}
+}
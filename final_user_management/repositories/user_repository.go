@@ -1,36 +1,82 @@
 package repositories
 
 import (
+	"errors"
+	"time"
+
 	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/scim"
+	"github.com/umwaribenie/final_user_management/utils"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
 	FindAll(params models.GetAllUsersRequest) ([]models.User, int64, error)
+	CountAll(params models.GetAllUsersRequest) (int64, error)
+	FindAllIDs(params models.GetAllUsersRequest) ([]string, error)
+	EachUser(params models.GetAllUsersRequest, fn func(user *models.User) error) error
 	FindByID(id string) (*models.User, error)
 	FindBySlug(slug string) (*models.User, error)
+	FindByClientID(clientID string) (*models.User, error)
 	FindByUsername(username string) (*models.User, error)
 	FindByEmail(email string) (*models.User, error)
+	FindByPhone(phone string) (*models.User, error)
+	FindByNationalID(nationalID string) (*models.User, error)
+	FindByPassportNumber(passportNumber string) (*models.User, error)
 	Create(user *models.User) error
 	Update(id string, user *models.User) error
+	UpdateFields(user *models.User, columns ...string) error
 	Delete(id string) error
 	UpdatePassword(id string, password string) error
+	UpdateLastLogin(id string, at time.Time, ip string) error
+	IncrementFailedLogins(id string) (int, error)
+	ChangeStatus(id string, updates map[string]interface{}, change *models.UserStatusChange) error
+	CheckCanRemove(id string) error
+	FindDeleted(params models.GetAllUsersRequest) ([]models.User, int64, error)
+	FindDeletedByID(id string) (*models.User, error)
+	PurgeDeletedBefore(cutoff time.Time) (int64, error)
+	SetDeletionSchedule(id string, at *time.Time) error
+	FindDueForDeletion(now time.Time) ([]models.User, error)
+	Anonymize(id string) error
+	FindStatusChanges(userID string) ([]models.UserStatusChange, error)
+	FindNeedingReencryption(activeKeyID string, afterID string, limit int) ([]models.User, error)
+	UpdateEncryptedFields(user *models.User) error
+	FindByScimFilter(filter scim.Filter, offset int, limit int) ([]models.User, int64, error)
+	WithTenant(organizationID string) UserRepository
+	WithTx(tx *gorm.DB) UserRepository
 }
 
 type userRepository struct {
-	db *gorm.DB
+	db             *gorm.DB
+	organizationID string
 }
 
+// NewUserRepository returns a repository over the users of every organization. Request
+// handling must go through WithTenant; the unscoped repository is for background jobs.
 func NewUserRepository(db *gorm.DB) UserRepository {
-	return &userRepository{db}
+	return &userRepository{db: db}
 }
 
-func (r *userRepository) FindAll(params models.GetAllUsersRequest) ([]models.User, int64, error) {
-	var users []models.User
-	var total int64
+// WithTenant returns a repository that only reads and writes the organization's users.
+func (r *userRepository) WithTenant(organizationID string) UserRepository {
+	return &userRepository{db: r.db, organizationID: organizationID}
+}
 
-	query := r.db.Model(&models.User{})
+// WithTx returns the repository working inside the transaction.
+func (r *userRepository) WithTx(tx *gorm.DB) UserRepository {
+	return &userRepository{db: tx, organizationID: r.organizationID}
+}
+
+// scope limits a query to the users this repository may see.
+func (r *userRepository) scope(db *gorm.DB) *gorm.DB {
+	return inOrganization(db, "users", r.organizationID)
+}
+
+// filtered applies the GetAllUsersRequest filters; paging is left to the caller.
+func (r *userRepository) filtered(params models.GetAllUsersRequest) *gorm.DB {
+	query := r.scope(r.db).Model(&models.User{})
 
 	if params.From != "" && params.To != "" {
 		query = query.Where("created_at BETWEEN ? AND ?", params.From, params.To)
@@ -45,7 +91,14 @@ func (r *userRepository) FindAll(params models.GetAllUsersRequest) ([]models.Use
 	if params.Status != "" {
 		query = query.Where("status = ?", params.Status)
 	}
+	return query
+}
 
+func (r *userRepository) FindAll(params models.GetAllUsersRequest) ([]models.User, int64, error) {
+	var users []models.User
+	var total int64
+
+	query := r.filtered(params)
 	if err := query.Count(&total).Error; err != nil {
 		return nil, 0, err
 	}
@@ -65,9 +118,46 @@ func (r *userRepository) FindAll(params models.GetAllUsersRequest) ([]models.Use
 	return users, total, nil
 }
 
+// CountAll counts the users matching the filters, ignoring paging.
+func (r *userRepository) CountAll(params models.GetAllUsersRequest) (int64, error) {
+	var total int64
+	err := r.filtered(params).Count(&total).Error
+	return total, err
+}
+
+// FindAllIDs returns the IDs of the users matching the filters, oldest first, ignoring paging.
+func (r *userRepository) FindAllIDs(params models.GetAllUsersRequest) ([]string, error) {
+	var ids []string
+	err := r.filtered(params).Order("created_at, id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+// EachUser calls fn with every user matching the filters, oldest first, reading them one at a
+// time from a database cursor so that any number of users can be walked in constant memory.
+// Paging is ignored. An error from fn stops the walk and is returned.
+func (r *userRepository) EachUser(params models.GetAllUsersRequest, fn func(user *models.User) error) error {
+	query := r.filtered(params).Order("created_at, id")
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.User
+		if err := query.ScanRows(rows, &user); err != nil {
+			return err
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 func (r *userRepository) FindByID(id string) (*models.User, error) {
 	var user models.User
-	if err := r.db.First(&user, "id = ?", id).Error; err != nil {
+	if err := r.scope(r.db).First(&user, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -75,7 +165,15 @@ func (r *userRepository) FindByID(id string) (*models.User, error) {
 
 func (r *userRepository) FindBySlug(slug string) (*models.User, error) {
 	var user models.User
-	if err := r.db.First(&user, "slug = ?", slug).Error; err != nil {
+	if err := r.scope(r.db).First(&user, "slug = ?", slug).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) FindByClientID(clientID string) (*models.User, error) {
+	var user models.User
+	if err := r.scope(r.db).First(&user, "client_id = ?", clientID).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -83,7 +181,7 @@ func (r *userRepository) FindBySlug(slug string) (*models.User, error) {
 
 func (r *userRepository) FindByUsername(username string) (*models.User, error) {
 	var user models.User
-	if err := r.db.First(&user, "username = ?", username).Error; err != nil {
+	if err := r.scope(r.db).First(&user, "username = ?", username).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -91,24 +189,347 @@ func (r *userRepository) FindByUsername(username string) (*models.User, error) {
 
 func (r *userRepository) FindByEmail(email string) (*models.User, error) {
 	var user models.User
-	if err := r.db.First(&user, "email = ?", email).Error; err != nil {
+	if err := r.scope(r.db).First(&user, "email = ?", email).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) FindByPhone(phone string) (*models.User, error) {
+	var user models.User
+	if err := r.scope(r.db).First(&user, "phone = ?", phone).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindByNationalID looks a user up by national ID through its blind index.
+func (r *userRepository) FindByNationalID(nationalID string) (*models.User, error) {
+	index, err := utils.BlindIndex(nationalID)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := r.scope(r.db).First(&user, "national_id_index = ?", index).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// FindByPassportNumber looks a user up by passport number through its blind index.
+func (r *userRepository) FindByPassportNumber(passportNumber string) (*models.User, error) {
+	index, err := utils.BlindIndex(passportNumber)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	if err := r.scope(r.db).First(&user, "passport_number_index = ?", index).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
 func (r *userRepository) Create(user *models.User) error {
+	if err := setBlindIndexes(user); err != nil {
+		return err
+	}
+	if r.organizationID != "" {
+		user.OrganizationID = r.organizationID
+	}
+	if user.OrganizationID == "" {
+		return errors.New("user has no organization")
+	}
 	return r.db.Create(user).Error
 }
 
 func (r *userRepository) Update(id string, user *models.User) error {
-	return r.db.Model(&models.User{}).Where("id = ?", id).Updates(user).Error
+	if err := setBlindIndexes(user); err != nil {
+		return err
+	}
+	return r.scope(r.db).Model(&models.User{}).Omit("organization_id").Where("id = ?", id).Updates(user).Error
+}
+
+// UpdateFields saves the named columns of the user, including those set to their zero value,
+// which Update leaves alone.
+func (r *userRepository) UpdateFields(user *models.User, columns ...string) error {
+	result := r.scope(r.db).Model(&models.User{}).Where("id = ?", user.ID).Select(columns).Updates(user)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// setBlindIndexes recomputes the blind indexes of the user's encrypted columns.
+func setBlindIndexes(user *models.User) error {
+	var err error
+	if user.NationalIDIndex, err = blindIndexOf(user.NationalID); err != nil {
+		return err
+	}
+	user.PassportNumberIndex, err = blindIndexOf(user.PassportNumber)
+	return err
+}
+
+func blindIndexOf(value *string) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	index, err := utils.BlindIndex(*value)
+	if err != nil {
+		return nil, err
+	}
+	return &index, nil
 }
 
 func (r *userRepository) Delete(id string) error {
-	return r.db.Delete(&models.User{}, "id = ?", id).Error
+	return r.scope(r.db).Delete(&models.User{}, "id = ?", id).Error
 }
 
 func (r *userRepository) UpdatePassword(id string, password string) error {
-	return r.db.Model(&models.User{}).Where("id = ?", id).Update("password", password).Error
+	return r.scope(r.db).Model(&models.User{}).Where("id = ?", id).Update("password", password).Error
+}
+
+func (r *userRepository) UpdateLastLogin(id string, at time.Time, ip string) error {
+	return r.scope(r.db).Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"last_login_at": at,
+		"last_login_ip": ip,
+		"failed_logins": 0,
+	}).Error
+}
+
+// IncrementFailedLogins bumps the user's consecutive failed login counter and returns the new value.
+func (r *userRepository) IncrementFailedLogins(id string) (int, error) {
+	var user models.User
+	err := r.scope(r.db).Model(&user).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_logins"}}}).
+		Where("id = ?", id).
+		Update("failed_logins", gorm.Expr("failed_logins + 1")).Error
+	return user.FailedLogins, err
+}
+
+// ChangeStatus applies the status-related column updates and records the change in one transaction.
+// It also reaches soft-deleted rows so that deletion and restore go through the same path.
+// Moving a user out of the active status fails with ErrLastAdmin if it would leave their
+// organization with no active admin. Lockouts are exempt: refusing them would let anyone guess
+// the last admin's password without limit.
+func (r *userRepository) ChangeStatus(id string, updates map[string]interface{}, change *models.UserStatusChange) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		apply := func(tx *gorm.DB) error {
+			result := r.scope(tx).Unscoped().Model(&models.User{}).Where("id = ?", id).Updates(updates)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return tx.Create(change).Error
+		}
+		if change.ToStatus == models.ActiveStatus || change.ToStatus == models.LockedStatus {
+			return apply(tx)
+		}
+		organizationID, err := r.organizationOf(tx, id)
+		if err != nil {
+			return err
+		}
+		return guardLastAdmin(tx, organizationID, apply)
+	})
+}
+
+// errDryRun rolls back a transaction that only checked whether a change would be allowed.
+var errDryRun = errors.New("dry run")
+
+// CheckCanRemove fails with ErrLastAdmin if moving the user out of the active status would leave
+// their organization with no active admin. Nothing is changed; it lets a removal that only
+// happens later, such as a scheduled deletion, be refused when it is asked for.
+func (r *userRepository) CheckCanRemove(id string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		organizationID, err := r.organizationOf(tx, id)
+		if err != nil {
+			return err
+		}
+		err = guardLastAdmin(tx, organizationID, func(tx *gorm.DB) error {
+			return tx.Model(&models.User{}).Where("id = ?", id).Update("status", models.InactiveStatus).Error
+		})
+		if err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return nil
+	}
+	return err
+}
+
+// organizationOf returns the organization of the user, who may be soft-deleted.
+func (r *userRepository) organizationOf(tx *gorm.DB, id string) (string, error) {
+	var user models.User
+	if err := r.scope(tx).Unscoped().Select("organization_id").First(&user, "id = ?", id).Error; err != nil {
+		return "", err
+	}
+	return user.OrganizationID, nil
+}
+
+func (r *userRepository) FindDeleted(params models.GetAllUsersRequest) ([]models.User, int64, error) {
+	var users []models.User
+	var total int64
+
+	query := r.scope(r.db).Unscoped().Model(&models.User{}).Where("deleted_at IS NOT NULL")
+
+	if params.From != "" && params.To != "" {
+		query = query.Where("deleted_at BETWEEN ? AND ?", params.From, params.To)
+	}
+	if params.Search != "" {
+		search := "%" + params.Search + "%"
+		query = query.Where("first_name LIKE ? OR last_name LIKE ? OR email LIKE ? OR username LIKE ?", search, search, search, search)
+	}
+	if params.Role != "" {
+		query = query.Where("role = ?", params.Role)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Order("deleted_at DESC").Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *userRepository) FindDeletedByID(id string) (*models.User, error) {
+	var user models.User
+	if err := r.scope(r.db).Unscoped().First(&user, "id = ? AND deleted_at IS NOT NULL", id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// PurgeDeletedBefore permanently removes users soft-deleted before the cutoff, along with
+// their sessions, known devices, security events, status history and invitations. Where they
+// acted on other users, they are no longer named. Jobs they requested or that were about them
+// expire at once, so that the job cleanup removes them together with their files. It returns
+// how many users were purged.
+func (r *userRepository) PurgeDeletedBefore(cutoff time.Time) (int64, error) {
+	var purged int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		expired := r.scope(tx).Unscoped().Model(&models.User{}).Select("id").Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff)
+		for _, model := range []interface{}{
+			&models.Session{},
+			&models.KnownDevice{},
+			&models.UserRoleAssignment{},
+			&models.GroupMember{},
+			&models.SecurityEvent{},
+			&models.UserStatusChange{},
+			&models.Invitation{},
+		} {
+			if err := tx.Where("user_id IN (?)", expired).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.SecurityEvent{}).Where("actor_id IN (?)", expired).Update("actor_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.UserStatusChange{}).Where("changed_by IN (?)", expired).Update("changed_by", nil).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&models.Job{}).Where("requested_by IN (?) OR subject_id IN (?)", expired, expired).Updates(map[string]interface{}{
+			"subject_id": nil,
+			"result":     "",
+			"expires_at": time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		result := r.scope(tx).Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).Delete(&models.User{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
+
+func (r *userRepository) SetDeletionSchedule(id string, at *time.Time) error {
+	return r.scope(r.db).Unscoped().Model(&models.User{}).Where("id = ?", id).Update("deletion_scheduled_at", at).Error
+}
+
+func (r *userRepository) FindDueForDeletion(now time.Time) ([]models.User, error) {
+	var users []models.User
+	if err := r.scope(r.db).Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", now).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Anonymize overwrites the user's personal data with placeholders derived from the ID,
+// keeping unique columns unique.
+func (r *userRepository) Anonymize(id string) error {
+	placeholder := models.AnonymizedPlaceholder(id)
+	return r.scope(r.db).Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"client_id":             placeholder,
+		"email":                 placeholder + "@deleted.invalid",
+		"first_name":            "Deleted",
+		"last_name":             "User",
+		"national_id":           nil,
+		"national_id_index":     nil,
+		"passport_number":       nil,
+		"passport_number_index": nil,
+		"phone":                 placeholder,
+		"profile_picture":       nil,
+		"username":              placeholder,
+		"slug":                  placeholder,
+		"last_login_ip":         nil,
+		"password":              "",
+	}).Error
+}
+
+func (r *userRepository) FindStatusChanges(userID string) ([]models.UserStatusChange, error) {
+	var changes []models.UserStatusChange
+	users := r.scope(r.db).Unscoped().Model(&models.User{}).Select("id")
+	if err := r.db.Where("user_id = ? AND user_id IN (?)", userID, users).Order("created_at DESC").Find(&changes).Error; err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// FindNeedingReencryption returns users, including soft-deleted ones, whose encrypted columns
+// are still plaintext, use a key other than the active one, or are missing their blind index.
+// Results are ordered by ID and start after afterID so callers can walk the table in batches.
+func (r *userRepository) FindNeedingReencryption(activeKeyID string, afterID string, limit int) ([]models.User, error) {
+	current := utils.FieldCiphertextPrefix(activeKeyID) + "%"
+	query := r.scope(r.db).Unscoped().Where(
+		"(national_id IS NOT NULL AND (national_id NOT LIKE ? OR national_id_index IS NULL)) OR "+
+			"(passport_number IS NOT NULL AND (passport_number NOT LIKE ? OR passport_number_index IS NULL))",
+		current, current,
+	)
+	if afterID != "" {
+		query = query.Where("id > ?", afterID)
+	}
+
+	var users []models.User
+	if err := query.Order("id ASC").Limit(limit).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// UpdateEncryptedFields rewrites the user's encrypted columns with the active key and
+// refreshes their blind indexes.
+func (r *userRepository) UpdateEncryptedFields(user *models.User) error {
+	if err := setBlindIndexes(user); err != nil {
+		return err
+	}
+	return r.scope(r.db).Unscoped().Model(user).
+		Select("national_id", "national_id_index", "passport_number", "passport_number_index").
+		Updates(user).Error
 }
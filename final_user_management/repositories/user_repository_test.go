@@ -0,0 +1,292 @@
+package repositories
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/scim"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB migrates the Postgres database named by TEST_DATABASE_URL and returns a transaction
+// on it that is rolled back when the test ends. Tests needing a database are skipped without one.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatalf("uuid-ossp: %v", err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	indexKey, _ := utils.GenerateDataKey()
+	dataKey, _ := utils.GenerateDataKey()
+	utils.SetFieldKeyring("test", map[string][]byte{"test": dataKey}, indexKey, nil)
+
+	tx := db.Begin()
+	t.Cleanup(func() { tx.Rollback() })
+	return tx
+}
+
+// tenant is an organization seeded with a user whose identifying values are shared with the
+// user of every other seeded organization, and a user deleted a day ago.
+type tenant struct {
+	organization models.Organization
+	user         models.User
+	deleted      models.User
+}
+
+func seedTenant(t *testing.T, db *gorm.DB, name string) tenant {
+	t.Helper()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	organization := models.Organization{Name: name, Slug: name + "-" + hex.EncodeToString(suffix)}
+	if err := db.Create(&organization).Error; err != nil {
+		t.Fatalf("create organization: %v", err)
+	}
+	repo := NewUserRepository(db).WithTenant(organization.ID)
+
+	nationalID, passportNumber := "1199080012345678", "PC123456"
+	due := time.Now().Add(-time.Hour)
+	user := models.User{
+		ClientID:            "client-shared",
+		Email:               "shared@example.com",
+		FirstName:           "Shared",
+		LastName:            name,
+		NationalID:          &nationalID,
+		PassportNumber:      &passportNumber,
+		Phone:               "+250788000000",
+		Username:            "shared",
+		Slug:                "shared",
+		Status:              models.ActiveStatus,
+		DeletionScheduledAt: &due,
+	}
+	if err := repo.Create(&user); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	change := models.UserStatusChange{UserID: user.ID, FromStatus: models.PendingStatus, ToStatus: models.ActiveStatus, Reason: "seeded"}
+	if err := db.Create(&change).Error; err != nil {
+		t.Fatalf("create status change: %v", err)
+	}
+
+	deleted := models.User{Email: "gone@example.com", Username: "gone", Slug: "gone", Status: models.DeletedStatus}
+	if err := repo.Create(&deleted); err != nil {
+		t.Fatalf("create deleted user: %v", err)
+	}
+	if err := db.Model(&deleted).Update("deleted_at", time.Now().Add(-24*time.Hour)).Error; err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+	event := models.SecurityEvent{UserID: &deleted.ID, Identifier: "gone", Type: models.EventLogin, Outcome: models.OutcomeSuccess}
+	if err := db.Create(&event).Error; err != nil {
+		t.Fatalf("create security event: %v", err)
+	}
+	return tenant{organization: organization, user: user, deleted: deleted}
+}
+
+// TestUserRepositoryTenantIsolation seeds two organizations whose users share every unique value
+// and checks that each UserRepository method of one tenant neither sees nor changes the other's.
+func TestUserRepositoryTenantIsolation(t *testing.T) {
+	db := openTestDB(t)
+	a, b := seedTenant(t, db, "tenant-a"), seedTenant(t, db, "tenant-b")
+	repo := NewUserRepository(db).WithTenant(a.organization.ID)
+
+	ownUser := func(method string, user *models.User, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", method, err)
+		} else if user.ID != a.user.ID {
+			t.Errorf("%s found user %s of organization %s, want %s", method, user.ID, user.OrganizationID, a.user.ID)
+		}
+	}
+	notFound := func(method string, err error) {
+		t.Helper()
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("%s on another tenant's user: got %v, want record not found", method, err)
+		}
+	}
+	ownUsers := func(method string, users []models.User, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", method, err)
+			return
+		}
+		if len(users) == 0 {
+			t.Errorf("%s found none of the tenant's users", method)
+		}
+		for _, user := range users {
+			if user.OrganizationID != a.organization.ID {
+				t.Errorf("%s returned user %s of organization %s", method, user.ID, user.OrganizationID)
+			}
+		}
+	}
+
+	t.Run("lookups", func(t *testing.T) {
+		user, err := repo.FindByID(a.user.ID)
+		ownUser("FindByID", user, err)
+		_, err = repo.FindByID(b.user.ID)
+		notFound("FindByID", err)
+		user, err = repo.FindBySlug("shared")
+		ownUser("FindBySlug", user, err)
+		user, err = repo.FindByClientID("client-shared")
+		ownUser("FindByClientID", user, err)
+		user, err = repo.FindByUsername("shared")
+		ownUser("FindByUsername", user, err)
+		user, err = repo.FindByEmail("shared@example.com")
+		ownUser("FindByEmail", user, err)
+		user, err = repo.FindByPhone("+250788000000")
+		ownUser("FindByPhone", user, err)
+		user, err = repo.FindByNationalID("1199080012345678")
+		ownUser("FindByNationalID", user, err)
+		user, err = repo.FindByPassportNumber("PC123456")
+		ownUser("FindByPassportNumber", user, err)
+
+		deleted, err := repo.FindDeletedByID(a.deleted.ID)
+		if err != nil || deleted.ID != a.deleted.ID {
+			t.Errorf("FindDeletedByID = %v, %v", deleted, err)
+		}
+		_, err = repo.FindDeletedByID(b.deleted.ID)
+		notFound("FindDeletedByID", err)
+	})
+
+	t.Run("lists", func(t *testing.T) {
+		users, total, err := repo.FindAll(models.GetAllUsersRequest{PageSize: 100})
+		ownUsers("FindAll", users, err)
+		if total != 1 {
+			t.Errorf("FindAll total = %d, want 1", total)
+		}
+		if count, err := repo.CountAll(models.GetAllUsersRequest{}); err != nil || count != 1 {
+			t.Errorf("CountAll = %d, %v, want 1", count, err)
+		}
+		ids, err := repo.FindAllIDs(models.GetAllUsersRequest{})
+		if err != nil || len(ids) != 1 || ids[0] != a.user.ID {
+			t.Errorf("FindAllIDs = %v, %v, want [%s]", ids, err, a.user.ID)
+		}
+		var walked []models.User
+		err = repo.EachUser(models.GetAllUsersRequest{}, func(user *models.User) error {
+			walked = append(walked, *user)
+			return nil
+		})
+		ownUsers("EachUser", walked, err)
+
+		users, total, err = repo.FindDeleted(models.GetAllUsersRequest{PageSize: 100})
+		ownUsers("FindDeleted", users, err)
+		if total != 1 {
+			t.Errorf("FindDeleted total = %d, want 1", total)
+		}
+		users, err = repo.FindDueForDeletion(time.Now())
+		ownUsers("FindDueForDeletion", users, err)
+		users, err = repo.FindNeedingReencryption("rotated", "", 100)
+		ownUsers("FindNeedingReencryption", users, err)
+
+		filter, err := scim.ParseFilter(`userName eq "shared"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, total, err = repo.FindByScimFilter(filter, 0, 100)
+		ownUsers("FindByScimFilter", users, err)
+		if total != 1 {
+			t.Errorf("FindByScimFilter total = %d, want 1", total)
+		}
+
+		changes, err := repo.FindStatusChanges(a.user.ID)
+		if err != nil || len(changes) != 1 {
+			t.Errorf("FindStatusChanges = %v, %v, want the seeded change", changes, err)
+		}
+		if changes, err := repo.FindStatusChanges(b.user.ID); err != nil || len(changes) != 0 {
+			t.Errorf("FindStatusChanges on another tenant's user = %v, %v, want none", changes, err)
+		}
+	})
+
+	t.Run("writes", func(t *testing.T) {
+		other := b.user.ID
+		if err := repo.Update(other, &models.User{FirstName: "Changed"}); err != nil {
+			t.Errorf("Update: %v", err)
+		}
+		notFound("UpdateFields", repo.UpdateFields(&models.User{ID: other, Department: "Changed"}, "department"))
+		if err := repo.UpdatePassword(other, "changed"); err != nil {
+			t.Errorf("UpdatePassword: %v", err)
+		}
+		if err := repo.UpdateLastLogin(other, time.Now(), "203.0.113.1"); err != nil {
+			t.Errorf("UpdateLastLogin: %v", err)
+		}
+		if _, err := repo.IncrementFailedLogins(other); err != nil {
+			t.Errorf("IncrementFailedLogins: %v", err)
+		}
+		notFound("ChangeStatus", repo.ChangeStatus(other,
+			map[string]interface{}{"status": models.SuspendedStatus},
+			&models.UserStatusChange{UserID: other, FromStatus: models.ActiveStatus, ToStatus: models.SuspendedStatus}))
+		if err := repo.SetDeletionSchedule(other, nil); err != nil {
+			t.Errorf("SetDeletionSchedule: %v", err)
+		}
+		changedID := "0000000000000000"
+		if err := repo.UpdateEncryptedFields(&models.User{ID: other, NationalID: &changedID}); err != nil {
+			t.Errorf("UpdateEncryptedFields: %v", err)
+		}
+		if err := repo.Anonymize(other); err != nil {
+			t.Errorf("Anonymize: %v", err)
+		}
+		if err := repo.Delete(other); err != nil {
+			t.Errorf("Delete: %v", err)
+		}
+
+		var stored models.User
+		if err := db.Unscoped().First(&stored, "id = ?", other).Error; err != nil {
+			t.Fatalf("reload: %v", err)
+		}
+		if stored.DeletedAt.Valid || stored.FirstName != "Shared" || stored.Department != "" ||
+			stored.Password != b.user.Password || stored.LastLoginAt != nil || stored.FailedLogins != 0 ||
+			stored.Status != models.ActiveStatus || stored.DeletionScheduledAt == nil ||
+			stored.Username != "shared" || stored.NationalID == nil || *stored.NationalID != "1199080012345678" {
+			t.Errorf("another tenant's user was changed: %+v", stored)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		purged, err := repo.PurgeDeletedBefore(time.Now())
+		if err != nil || purged != 1 {
+			t.Errorf("PurgeDeletedBefore = %d, %v, want 1", purged, err)
+		}
+		var remaining int64
+		db.Unscoped().Model(&models.User{}).Where("id = ?", b.deleted.ID).Count(&remaining)
+		if remaining != 1 {
+			t.Errorf("PurgeDeletedBefore removed another tenant's deleted user")
+		}
+		for _, check := range []struct {
+			user tenant
+			want int64
+		}{{a, 0}, {b, 1}} {
+			var events int64
+			db.Model(&models.SecurityEvent{}).Where("user_id = ?", check.user.deleted.ID).Count(&events)
+			if events != check.want {
+				t.Errorf("%s has %d security events of its deleted user after the purge, want %d", check.user.organization.Name, events, check.want)
+			}
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		user := models.User{OrganizationID: b.organization.ID, Email: "new@example.com", Username: "new", Slug: "new"}
+		if err := repo.Create(&user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if user.OrganizationID != a.organization.ID {
+			t.Errorf("Create put the user in organization %s, want %s", user.OrganizationID, a.organization.ID)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookRepository interface {
+	FindAll() ([]models.WebhookEndpoint, error)
+	FindActive() ([]models.WebhookEndpoint, error)
+	FindByID(id string) (*models.WebhookEndpoint, error)
+	Create(endpoint *models.WebhookEndpoint) error
+	Update(endpoint *models.WebhookEndpoint) error
+	Delete(id string) error
+	CreateDeliveries(deliveries []models.WebhookDelivery) error
+	FindDeliveries(endpointID string, params models.GetWebhookDeliveriesRequest) ([]models.WebhookDelivery, int64, error)
+	FindDelivery(endpointID string, id string) (*models.WebhookDelivery, error)
+	ClaimDueDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	UpdateDelivery(delivery *models.WebhookDelivery) error
+	WithTenant(organizationID string) WebhookRepository
+	WithTx(tx *gorm.DB) WebhookRepository
+}
+
+type webhookRepository struct {
+	db             *gorm.DB
+	organizationID string
+}
+
+// NewWebhookRepository returns a repository over the webhooks of every organization. Request
+// handling must go through WithTenant.
+func NewWebhookRepository(db *gorm.DB) WebhookRepository {
+	return &webhookRepository{db: db}
+}
+
+// WithTenant returns a repository that only reads and writes the organization's webhooks.
+func (r *webhookRepository) WithTenant(organizationID string) WebhookRepository {
+	return &webhookRepository{db: r.db, organizationID: organizationID}
+}
+
+// WithTx returns the repository working inside the transaction.
+func (r *webhookRepository) WithTx(tx *gorm.DB) WebhookRepository {
+	return &webhookRepository{db: tx, organizationID: r.organizationID}
+}
+
+// scope limits a query to the endpoints this repository may see.
+func (r *webhookRepository) scope(db *gorm.DB) *gorm.DB {
+	return inOrganization(db, "webhook_endpoints", r.organizationID)
+}
+
+func (r *webhookRepository) FindAll() ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := r.scope(r.db).Order("created_at ASC").Find(&endpoints).Error
+	return endpoints, err
+}
+
+func (r *webhookRepository) FindActive() ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	err := r.scope(r.db).Where("active = ?", true).Find(&endpoints).Error
+	return endpoints, err
+}
+
+func (r *webhookRepository) FindByID(id string) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := r.scope(r.db).First(&endpoint, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (r *webhookRepository) Create(endpoint *models.WebhookEndpoint) error {
+	if r.organizationID != "" {
+		endpoint.OrganizationID = r.organizationID
+	}
+	if endpoint.OrganizationID == "" {
+		return errors.New("webhook endpoint has no organization")
+	}
+	return r.db.Create(endpoint).Error
+}
+
+func (r *webhookRepository) Update(endpoint *models.WebhookEndpoint) error {
+	result := r.scope(r.db).Model(endpoint).Select("url", "description", "events", "active").Updates(endpoint)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Delete removes the endpoint and its delivery log.
+func (r *webhookRepository) Delete(id string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := r.scope(tx).Delete(&models.WebhookEndpoint{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("endpoint_id = ?", id).Delete(&models.WebhookDelivery{}).Error
+	})
+}
+
+func (r *webhookRepository) CreateDeliveries(deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.db.Create(&deliveries).Error
+}
+
+func (r *webhookRepository) FindDeliveries(endpointID string, params models.GetWebhookDeliveriesRequest) ([]models.WebhookDelivery, int64, error) {
+	var deliveries []models.WebhookDelivery
+	var total int64
+
+	query := inOrganization(r.db.Model(&models.WebhookDelivery{}), "webhook_deliveries", r.organizationID).
+		Where("endpoint_id = ?", endpointID)
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.Event != "" {
+		query = query.Where("event = ?", params.Event)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	offset := (params.PageNumber - 1) * params.PageSize
+	query = query.Order("created_at DESC").Offset(offset).Limit(params.PageSize)
+
+	if err := query.Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+func (r *webhookRepository) FindDelivery(endpointID string, id string) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := inOrganization(r.db, "webhook_deliveries", r.organizationID).
+		First(&delivery, "endpoint_id = ? AND id = ?", endpointID, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ClaimDueDeliveries returns up to limit pending deliveries whose next attempt is due, oldest
+// first, and pushes their next attempt back by lease so that no other server picks them up
+// while they are being sent.
+func (r *webhookRepository) ClaimDueDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := inOrganization(tx, "webhook_deliveries", r.organizationID).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now).
+			Order("next_attempt_at ASC").Limit(limit).Find(&deliveries).Error
+		if err != nil || len(deliveries) == 0 {
+			return err
+		}
+		ids := make([]string, len(deliveries))
+		for i := range deliveries {
+			ids[i] = deliveries[i].ID
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(lease)).Error
+	})
+	return deliveries, err
+}
+
+func (r *webhookRepository) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Save(delivery).Error
+}
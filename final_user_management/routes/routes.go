@@ -3,6 +3,9 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/umwaribenie/final_user_management/controllers"
+	"github.com/umwaribenie/final_user_management/middleware"
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/services"
 )
 
 // SetupRouter connects all the user and auth endpoints.
@@ -10,18 +13,79 @@ func SetupRouter(
 	router *gin.Engine,
 	userController *controllers.UserController,
 	authController *controllers.AuthController,
+	impersonationController *controllers.ImpersonationController,
+	exportController *controllers.ExportController,
+	jobController *controllers.JobController,
+	encryptionController *controllers.EncryptionController,
+	roleController *controllers.RoleController,
+	groupController *controllers.GroupController,
+	organizationController *controllers.OrganizationController,
+	authzController *controllers.AuthzController,
+	auditController *controllers.AuditController,
+	webhookController *controllers.WebhookController,
+	scimController *controllers.ScimController,
+	importController *controllers.ImportController,
+	bulkController *controllers.BulkController,
+	invitationController *controllers.InvitationController,
+	sessionService services.SessionService,
+	impersonationService services.ImpersonationService,
+	organizationService services.OrganizationService,
+	authorizationService services.AuthorizationService,
+	scimService services.ScimService,
 ) {
+	// Every request gets an ID for tracing and is served from one organization
+	router.Use(middleware.RequestID())
+	router.Use(middleware.ResolveTenant(organizationService))
+
+	requireAuth := middleware.AuthMiddleware(sessionService, impersonationService)
+	optionalAuth := middleware.OptionalAuthMiddleware(sessionService, impersonationService)
+	// authorize checks the action against the user named by ":id" with the access policies
+	authorize := func(action models.Permission) gin.HandlerFunc {
+		return middleware.Authorize(authorizationService, action)
+	}
+
 	// User routes
 	u := router.Group("/users")
 	{
-		u.GET("/", userController.GetAllUsers)
+		u.GET("/", optionalAuth, userController.GetAllUsers)
 		u.POST("/register", userController.RegisterUser)
-		u.POST("/registerusersbyadmin", userController.RegisterUserByAdmin)
-		u.GET("/slug/:slug", userController.GetUserBySlug)
-		u.POST("/:id/update-password/admin", userController.UpdatePasswordByAdmin)
-		u.GET("/:id", userController.GetUserByID)
-		u.DELETE("/:id", userController.DeleteUser)
-		u.PATCH("/:id", userController.UpdateUser)
+		u.POST("/registerusersbyadmin", requireAuth, middleware.RequirePermission(models.PermUsersWrite), middleware.DenyImpersonation(), userController.RegisterUserByAdmin)
+		u.GET("/slug/:slug", optionalAuth, userController.GetUserBySlug)
+		u.POST("/import", requireAuth, middleware.RequirePermission(models.PermUsersWrite), middleware.DenyImpersonation(), importController.ImportUsers)
+		u.GET("/export", requireAuth, middleware.RequirePermission(models.PermUsersRead), middleware.DenyImpersonation(), exportController.ExportUsers)
+		u.POST("/bulk", requireAuth, middleware.RequirePermission(models.PermUsersWrite), middleware.DenyImpersonation(), middleware.RequireRecentAuth(middleware.ReauthMaxAge), bulkController.BulkUpdateUsers)
+		u.GET("/registrations", requireAuth, middleware.RequirePermission(models.PermUsersManageStatus), middleware.DenyImpersonation(), userController.GetPendingRegistrations)
+		u.GET("/deleted", requireAuth, middleware.RequirePermission(models.PermUsersRead), userController.GetDeletedUsers)
+		u.POST("/:id/update-password/admin", requireAuth, authorize(models.PermUsersResetPassword), middleware.DenyImpersonation(), userController.UpdatePasswordByAdmin)
+		u.POST("/:id/impersonate", requireAuth, authorize(models.PermUsersImpersonate), middleware.DenyImpersonation(), impersonationController.StartImpersonation)
+		u.POST("/:id/suspend", requireAuth, authorize(models.PermUsersManageStatus), middleware.DenyImpersonation(), userController.SuspendUser)
+		u.POST("/:id/reactivate", requireAuth, authorize(models.PermUsersManageStatus), middleware.DenyImpersonation(), userController.ReactivateUser)
+		u.POST("/:id/approve", requireAuth, authorize(models.PermUsersManageStatus), middleware.DenyImpersonation(), userController.ApproveRegistration)
+		u.POST("/:id/reject", requireAuth, authorize(models.PermUsersManageStatus), middleware.DenyImpersonation(), userController.RejectRegistration)
+		u.POST("/:id/unlock", requireAuth, authorize(models.PermUsersManageStatus), middleware.DenyImpersonation(), userController.UnlockUser)
+		u.POST("/:id/restore", requireAuth, authorize(models.PermUsersDelete), middleware.DenyImpersonation(), userController.RestoreUser)
+		u.GET("/:id/export", requireAuth, authorize(models.PermUsersReadPII), middleware.DenyImpersonation(), exportController.ExportUserData)
+		u.GET("/:id/roles", requireAuth, middleware.RequireSelfOrPermission(models.PermRolesManage), roleController.GetUserRoles)
+		u.POST("/:id/roles", requireAuth, middleware.RequirePermission(models.PermRolesManage), middleware.DenyImpersonation(), middleware.RequireRecentAuth(middleware.ReauthMaxAge), roleController.AssignRoles)
+		u.DELETE("/:id/roles/:roleId", requireAuth, middleware.RequirePermission(models.PermRolesManage), middleware.DenyImpersonation(), middleware.RequireRecentAuth(middleware.ReauthMaxAge), roleController.UnassignRole)
+		u.GET("/:id/groups", requireAuth, middleware.RequireSelfOrPermission(models.PermGroupsManage), groupController.GetUserGroups)
+		u.GET("/:id/login-history", requireAuth, authorize(models.PermUsersRead), userController.GetLoginHistory)
+		u.GET("/:id", optionalAuth, userController.GetUserByID)
+		u.DELETE("/me", requireAuth, middleware.DenyImpersonation(), userController.DeleteOwnAccount)
+		u.DELETE("/:id", requireAuth, authorize(models.PermUsersDelete), middleware.RequireRecentAuth(middleware.ReauthMaxAge), userController.DeleteUser)
+		u.PATCH("/:id", requireAuth, authorize(models.PermUsersWrite), userController.UpdateUser)
+	}
+
+	// Invitation routes; accepting an invitation is public, the invitee has no account to sign in with yet
+	i := router.Group("/invitations")
+	{
+		i.GET("/", requireAuth, middleware.RequirePermission(models.PermUsersRead), invitationController.GetInvitations)
+		i.POST("/", requireAuth, middleware.RequirePermission(models.PermUsersWrite), middleware.DenyImpersonation(), invitationController.InviteUser)
+		i.GET("/accept", invitationController.GetInvitationPreview)
+		i.POST("/accept", invitationController.AcceptInvitation)
+		i.POST("/:id/resend", requireAuth, middleware.RequirePermission(models.PermUsersWrite), middleware.DenyImpersonation(), invitationController.ResendInvitation)
+		i.POST("/:id/expire", requireAuth, middleware.RequirePermission(models.PermUsersWrite), middleware.DenyImpersonation(), invitationController.ExpireInvitation)
+		i.DELETE("/:id", requireAuth, middleware.RequirePermission(models.PermUsersWrite), middleware.DenyImpersonation(), invitationController.RevokeInvitation)
 	}
 
 	// Auth routes
@@ -31,9 +95,120 @@ func SetupRouter(
 		a.POST("/confirm-password-reset-otp", authController.ConfirmPasswordResetOtp)
 		a.POST("/login", authController.Login)
 		a.POST("/reset-password/email", authController.ResetPasswordViaEmail)
-		a.POST("/update-password", authController.UpdatePassword)
+		a.POST("/update-password", requireAuth, middleware.DenyImpersonation(), middleware.RequireRecentAuth(middleware.ReauthMaxAge), authController.UpdatePassword)
+		a.POST("/reauthenticate", requireAuth, middleware.DenyImpersonation(), authController.Reauthenticate)
+		a.POST("/reauthenticate/otp", requireAuth, middleware.DenyImpersonation(), authController.SendReauthOtp)
+		a.GET("/reset-password", authController.ShowPasswordResetForm)
 		a.POST("/reset-password", authController.ResetPasswordWithToken)
 		a.GET("/check", authController.CheckAuth)
+		a.GET("/introspect", requireAuth, authController.Introspect)
+		a.GET("/not-me", authController.ConfirmSuspiciousLogin)
+		a.POST("/not-me", authController.ReportSuspiciousLogin)
+		a.POST("/impersonation/stop", requireAuth, impersonationController.StopImpersonation)
+
+	}
+
+	// Role routes
+	r := router.Group("/roles", requireAuth, middleware.RequirePermission(models.PermRolesManage), middleware.DenyImpersonation())
+	{
+		r.GET("/", roleController.GetRoles)
+		r.POST("/", roleController.CreateRole)
+		r.GET("/:id", roleController.GetRole)
+		r.PATCH("/:id", roleController.UpdateRole)
+		r.DELETE("/:id", roleController.DeleteRole)
+		r.GET("/:id/users", roleController.GetRoleUsers)
+	}
+
+	// Group routes
+	g := router.Group("/groups", requireAuth, middleware.RequirePermission(models.PermGroupsManage), middleware.DenyImpersonation())
+	{
+		g.GET("/", groupController.GetGroups)
+		g.POST("/", groupController.CreateGroup)
+		g.GET("/:id", groupController.GetGroup)
+		g.PATCH("/:id", groupController.UpdateGroup)
+		g.DELETE("/:id", groupController.DeleteGroup)
+		g.GET("/:id/members", groupController.GetMembers)
+		g.POST("/:id/members", groupController.AddMembers)
+		g.DELETE("/:id/members/:userId", groupController.RemoveMember)
+		g.GET("/:id/roles", groupController.GetGroupRoles)
+		g.POST("/:id/roles", middleware.RequireRecentAuth(middleware.ReauthMaxAge), groupController.AddGroupRoles)
+		g.DELETE("/:id/roles/:roleId", middleware.RequireRecentAuth(middleware.ReauthMaxAge), groupController.RemoveGroupRole)
+	}
+
+	// Organization routes
+	o := router.Group("/organizations", requireAuth, middleware.RequirePermission(models.PermOrganizationsManage), middleware.DenyImpersonation())
+	{
+		o.GET("/", organizationController.GetOrganizations)
+		o.POST("/", organizationController.CreateOrganization)
+		o.GET("/:id", organizationController.GetOrganization)
+		o.PATCH("/:id", organizationController.UpdateOrganization)
+	}
+
+	// Authorization routes
+	az := router.Group("/authz", requireAuth, middleware.RequirePermission(models.PermRolesManage), middleware.DenyImpersonation())
+	{
+		az.POST("/check", authzController.Check)
+	}
+
+	// Audit routes
+	au := router.Group("/audit", requireAuth, middleware.RequirePermission(models.PermAuditRead), middleware.DenyImpersonation())
+	{
+		au.GET("/", auditController.GetAuditLog)
+		au.GET("/verify", auditController.VerifyAuditLog)
+	}
+
+	// Webhook routes
+	w := router.Group("/webhooks", requireAuth, middleware.RequirePermission(models.PermWebhooksManage), middleware.DenyImpersonation())
+	{
+		w.GET("/", webhookController.GetWebhooks)
+		w.POST("/", webhookController.CreateWebhook)
+		w.GET("/:id", webhookController.GetWebhook)
+		w.PATCH("/:id", webhookController.UpdateWebhook)
+		w.DELETE("/:id", webhookController.DeleteWebhook)
+		w.GET("/:id/deliveries", webhookController.GetDeliveries)
+		w.POST("/:id/deliveries/:deliveryId/redeliver", webhookController.Redeliver)
+	}
+
+	// SCIM token routes
+	st := router.Group("/scim/tokens", requireAuth, middleware.RequirePermission(models.PermScimManage), middleware.DenyImpersonation())
+	{
+		st.GET("/", scimController.GetTokens)
+		st.POST("/", scimController.CreateToken)
+		st.DELETE("/:id", scimController.RevokeToken)
+	}
+
+	// SCIM 2.0 provisioning routes, authenticated with SCIM tokens
+	sc := router.Group("/scim/v2", middleware.ScimAuth(scimService))
+	{
+		sc.GET("/ServiceProviderConfig", scimController.GetServiceProviderConfig)
+		sc.GET("/ResourceTypes", scimController.GetResourceTypes)
+		sc.GET("/ResourceTypes/:id", scimController.GetResourceType)
+		sc.GET("/Schemas", scimController.GetSchemas)
+		sc.GET("/Schemas/:id", scimController.GetSchema)
+		sc.GET("/Users", scimController.GetUsers)
+		sc.POST("/Users", scimController.CreateUser)
+		sc.GET("/Users/:id", scimController.GetUser)
+		sc.PUT("/Users/:id", scimController.ReplaceUser)
+		sc.PATCH("/Users/:id", scimController.PatchUser)
+		sc.DELETE("/Users/:id", scimController.DeleteUser)
+		sc.GET("/Groups", scimController.GetGroups)
+		sc.POST("/Groups", scimController.CreateGroup)
+		sc.GET("/Groups/:id", scimController.GetGroup)
+		sc.PUT("/Groups/:id", scimController.ReplaceGroup)
+		sc.PATCH("/Groups/:id", scimController.PatchGroup)
+		sc.DELETE("/Groups/:id", scimController.DeleteGroup)
+	}
 
+	// Background job routes
+	j := router.Group("/jobs", requireAuth)
+	{
+		j.GET("/:id", jobController.GetJob)
+		j.GET("/:id/download", jobController.DownloadJobFile)
+	}
+
+	// Admin routes
+	ad := router.Group("/admin", requireAuth, middleware.DenyImpersonation())
+	{
+		ad.POST("/encryption/rotate-key", middleware.RequirePermission(models.PermKeysRotate), middleware.RequireRecentAuth(middleware.ReauthMaxAge), encryptionController.RotateKey)
 	}
 }
@@ -0,0 +1,110 @@
+package scim
+
+import (
+	_ "embed"
+	"encoding/json"
+	"strings"
+)
+
+// schemasJSON describes the attributes of the User, enterprise User and Group schemas that the
+// service provider supports, and how they map to the user directory.
+//
+//go:embed schemas.json
+var schemasJSON []byte
+
+// ServiceProviderConfig describes the supported protocol features. baseURL is the SCIM root,
+// such as https://example.com/scim/v2.
+func ServiceProviderConfig(baseURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":          []string{ServiceProviderConfigSchema},
+		"documentationUri": "https://datatracker.ietf.org/doc/html/rfc7644",
+		"patch":            map[string]interface{}{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": MaxCount},
+		"changePassword":   map[string]interface{}{"supported": true},
+		"sort":             map[string]interface{}{"supported": false},
+		"etag":             map[string]interface{}{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A SCIM token issued to the organization, sent as Authorization: Bearer <token>",
+			"primary":     true,
+		}},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+// ResourceTypes describes the User and Group endpoints.
+func ResourceTypes(baseURL string) []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"schemas":     []string{ResourceTypeSchema},
+			"id":          "User",
+			"name":        "User",
+			"endpoint":    "/Users",
+			"description": "User Account",
+			"schema":      UserSchema,
+			"schemaExtensions": []map[string]interface{}{
+				{"schema": EnterpriseUserSchema, "required": false},
+			},
+			"meta": map[string]interface{}{"resourceType": "ResourceType", "location": baseURL + "/ResourceTypes/User"},
+		},
+		{
+			"schemas":     []string{ResourceTypeSchema},
+			"id":          "Group",
+			"name":        "Group",
+			"endpoint":    "/Groups",
+			"description": "Group",
+			"schema":      GroupSchema,
+			"meta":        map[string]interface{}{"resourceType": "ResourceType", "location": baseURL + "/ResourceTypes/Group"},
+		},
+	}
+}
+
+// ResourceType returns the resource type with the ID, User or Group.
+func ResourceType(baseURL string, id string) (map[string]interface{}, bool) {
+	for _, resourceType := range ResourceTypes(baseURL) {
+		if strings.EqualFold(resourceType["id"].(string), id) {
+			return resourceType, true
+		}
+	}
+	return nil, false
+}
+
+// Schemas returns the supported schemas.
+func Schemas(baseURL string) []map[string]interface{} {
+	var schemas []map[string]interface{}
+	if err := json.Unmarshal(schemasJSON, &schemas); err != nil {
+		panic("scim: invalid embedded schemas: " + err.Error())
+	}
+	for _, schema := range schemas {
+		schema["schemas"] = []string{SchemaSchema}
+		schema["meta"] = map[string]interface{}{
+			"resourceType": "Schema",
+			"location":     baseURL + "/Schemas/" + schema["id"].(string),
+		}
+	}
+	return schemas
+}
+
+// Schema returns the schema with the URN.
+func Schema(baseURL string, id string) (map[string]interface{}, bool) {
+	for _, schema := range Schemas(baseURL) {
+		if strings.EqualFold(schema["id"].(string), id) {
+			return schema, true
+		}
+	}
+	return nil, false
+}
+
+// Resources wraps discovery documents in a list response.
+func Resources[T any](items []T) *ListResponse {
+	resources := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		resources = append(resources, item)
+	}
+	return NewListResponse(resources, int64(len(resources)), 1)
+}
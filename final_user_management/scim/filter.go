@@ -0,0 +1,514 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a parsed SCIM filter (RFC 7644 section 3.4.2.2), such as
+//
+//	userName eq "bjensen"
+//	name.familyName co "O'Malley" and active eq true
+//	emails[type eq "work" and value ew "@example.com"] or not (title pr)
+//	meta.lastModified gt "2011-05-13T04:42:34Z"
+//
+// Attribute names and the operators are case-insensitive, as are string comparisons.
+type Filter interface {
+	// Matches evaluates the filter against a resource in its JSON form.
+	Matches(resource map[string]interface{}) bool
+}
+
+// AttrPath names an attribute, optionally with a sub-attribute and the URN of the schema that
+// defines it. Names are kept as written; compare them with Key.
+type AttrPath struct {
+	Schema string
+	Attr   string
+	Sub    string
+}
+
+// Key is the lowercased attribute path, prefixed with its schema URN unless the schema is a core
+// one. "urn:ietf:params:scim:schemas:core:2.0:User:name.givenName" has the key "name.givenname".
+func (p AttrPath) Key() string {
+	key := strings.ToLower(p.Attr)
+	if p.Sub != "" {
+		key += "." + strings.ToLower(p.Sub)
+	}
+	if p.Schema != "" && !isCoreSchema(p.Schema) {
+		key = strings.ToLower(p.Schema) + ":" + key
+	}
+	return key
+}
+
+func (p AttrPath) String() string {
+	path := p.Attr
+	if p.Sub != "" {
+		path += "." + p.Sub
+	}
+	if p.Schema != "" {
+		path = p.Schema + ":" + path
+	}
+	return path
+}
+
+// ParseAttrPath splits an attribute path such as "name.givenName" or
+// "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department".
+func ParseAttrPath(path string) (AttrPath, error) {
+	var parsed AttrPath
+	if strings.HasPrefix(strings.ToLower(path), "urn:") {
+		for _, schema := range resourceSchemas {
+			// A schema extension on its own is an attribute of the resource
+			if strings.EqualFold(path, schema) {
+				return AttrPath{Attr: schema}, nil
+			}
+		}
+		colon := strings.LastIndex(path, ":")
+		parsed.Schema, path = path[:colon], path[colon+1:]
+	}
+	parsed.Attr, parsed.Sub, _ = strings.Cut(path, ".")
+	if !validAttrName(parsed.Attr) || (parsed.Sub != "" && !validAttrName(parsed.Sub)) {
+		return AttrPath{}, fmt.Errorf("invalid attribute path %q", path)
+	}
+	return parsed, nil
+}
+
+// validAttrName allows the ATTRNAME characters of RFC 7643, and "$ref".
+func validAttrName(name string) bool {
+	if name == "$ref" {
+		return true
+	}
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || (i > 0 && (unicode.IsDigit(r) || r == '_' || r == '-'))) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// Comparison tests an attribute: "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt" or "le" against
+// Value, or "pr" for being present.
+type Comparison struct {
+	Path  AttrPath
+	Op    string
+	Value interface{}
+}
+
+// Logical joins two filters with "and" or "or".
+type Logical struct {
+	Op          string
+	Left, Right Filter
+}
+
+// Not negates a filter.
+type Not struct {
+	Filter Filter
+}
+
+// ValuePath applies a filter to the elements of a multi-valued attribute, as in
+// emails[type eq "work"]. It matches when any element does.
+type ValuePath struct {
+	Path   AttrPath
+	Filter Filter
+}
+
+// ParseFilter parses a filter expression. Syntax errors are returned as invalidFilter errors.
+func ParseFilter(source string) (Filter, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, NewError(400, InvalidFilter, err.Error())
+	}
+	p := &parser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = fmt.Errorf("unexpected %q at position %d", p.peek().text, p.peek().pos)
+	}
+	if err != nil {
+		return nil, NewError(400, InvalidFilter, err.Error())
+	}
+	return filter, nil
+}
+
+var comparisonOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// tokenize splits a filter into quoted strings, the brackets and parentheses, and words:
+// attribute paths, operators and the other literals.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			start := i
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start)
+			}
+			i++
+			var text string
+			if err := json.Unmarshal([]byte(string(runes[start:i])), &text); err != nil {
+				return nil, fmt.Errorf("invalid string at position %d", start)
+			}
+			tokens = append(tokens, token{tokenString, text, start})
+		case strings.ContainsRune("()[]", r):
+			tokens = append(tokens, token{tokenPunct, string(r), i})
+			i++
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("()[]\"", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[start:i]), start})
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the punctuation or (case-insensitively) the keyword.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenPunct || t.kind == tokenWord) && strings.EqualFold(t.text, text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return fmt.Errorf("expected %q at position %d", text, p.peek().pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (Filter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Logical{"or", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Filter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = Logical{"and", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Filter, error) {
+	if p.accept("not") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return Not{inner}, p.expect(")")
+	}
+	if p.accept("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	return p.parseAttrExpression()
+}
+
+func (p *parser) parseAttrExpression() (Filter, error) {
+	t := p.next()
+	if t.kind != tokenWord {
+		if t.kind == tokenEOF {
+			return nil, fmt.Errorf("unexpected end of filter")
+		}
+		return nil, fmt.Errorf("expected an attribute at position %d", t.pos)
+	}
+	path, err := ParseAttrPath(t.text)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.accept("[") {
+		if path.Sub != "" {
+			return nil, fmt.Errorf("unexpected sub-attribute before [ at position %d", t.pos)
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return ValuePath{path, inner}, p.expect("]")
+	}
+
+	op := p.next()
+	operator := strings.ToLower(op.text)
+	if op.kind == tokenWord && operator == "pr" {
+		return Comparison{Path: path, Op: "pr"}, nil
+	}
+	if op.kind != tokenWord || !comparisonOperators[operator] {
+		return nil, fmt.Errorf("expected an operator at position %d", op.pos)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return Comparison{path, operator, value}, nil
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenWord:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if number, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return number, nil
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	return nil, fmt.Errorf("invalid value %q at position %d", t.text, t.pos)
+}
+
+// Matches reports whether the attribute satisfies the comparison. A multi-valued attribute
+// matches when any of its values does; for complex values that is their "value" sub-attribute.
+func (c Comparison) Matches(resource map[string]interface{}) bool {
+	for _, value := range lookup(resource, c.Path) {
+		if compare(value, c.Op, c.Value) {
+			return true
+		}
+	}
+	// Comparing with null matches attributes that have no value
+	if c.Value == nil && c.Op == "eq" {
+		return len(lookup(resource, c.Path)) == 0
+	}
+	if c.Value == nil && c.Op == "ne" {
+		return len(lookup(resource, c.Path)) > 0
+	}
+	return false
+}
+
+func (l Logical) Matches(resource map[string]interface{}) bool {
+	if l.Op == "and" {
+		return l.Left.Matches(resource) && l.Right.Matches(resource)
+	}
+	return l.Left.Matches(resource) || l.Right.Matches(resource)
+}
+
+func (n Not) Matches(resource map[string]interface{}) bool {
+	return !n.Filter.Matches(resource)
+}
+
+func (v ValuePath) Matches(resource map[string]interface{}) bool {
+	for _, element := range elements(resource, v.Path) {
+		if v.Filter.Matches(element) {
+			return true
+		}
+	}
+	return false
+}
+
+// elements returns the complex values of a multi-valued attribute, or the attribute itself when
+// it is a single complex value.
+func elements(resource map[string]interface{}, path AttrPath) []map[string]interface{} {
+	var found []map[string]interface{}
+	switch value := attribute(resource, path).(type) {
+	case []interface{}:
+		for _, item := range value {
+			if element, ok := item.(map[string]interface{}); ok {
+				found = append(found, element)
+			}
+		}
+	case map[string]interface{}:
+		found = append(found, value)
+	}
+	return found
+}
+
+// attribute finds the top-level attribute of path, looking inside the schema extension when the
+// path names one.
+func attribute(resource map[string]interface{}, path AttrPath) interface{} {
+	if path.Schema != "" && !isCoreSchema(path.Schema) {
+		extension, _ := get(resource, path.Schema).(map[string]interface{})
+		return get(extension, path.Attr)
+	}
+	return get(resource, path.Attr)
+}
+
+// lookup returns the non-null values at path, flattening multi-valued attributes.
+func lookup(resource map[string]interface{}, path AttrPath) []interface{} {
+	var values []interface{}
+	add := func(value interface{}) {
+		if element, ok := value.(map[string]interface{}); ok {
+			sub := path.Sub
+			if sub == "" {
+				sub = "value"
+			}
+			value = get(element, sub)
+		}
+		if value != nil && value != "" {
+			values = append(values, value)
+		}
+	}
+	switch value := attribute(resource, path).(type) {
+	case []interface{}:
+		for _, item := range value {
+			add(item)
+		}
+	case map[string]interface{}:
+		if path.Sub != "" {
+			add(value)
+		}
+	default:
+		add(value)
+	}
+	return values
+}
+
+// get reads a key case-insensitively.
+func get(object map[string]interface{}, key string) interface{} {
+	if value, ok := object[key]; ok {
+		return value
+	}
+	for name, value := range object {
+		if strings.EqualFold(name, key) {
+			return value
+		}
+	}
+	return nil
+}
+
+func compare(actual interface{}, op string, expected interface{}) bool {
+	if op == "pr" {
+		return true
+	}
+	switch a := actual.(type) {
+	case string:
+		e, ok := expected.(string)
+		if !ok {
+			return false
+		}
+		// Timestamps compare as instants rather than text
+		if at, err := time.Parse(time.RFC3339, a); err == nil {
+			if et, err := time.Parse(time.RFC3339, e); err == nil {
+				return compareOrdered(at.Compare(et), op)
+			}
+		}
+		a, e = strings.ToLower(a), strings.ToLower(e)
+		switch op {
+		case "co":
+			return strings.Contains(a, e)
+		case "sw":
+			return strings.HasPrefix(a, e)
+		case "ew":
+			return strings.HasSuffix(a, e)
+		}
+		return compareOrdered(strings.Compare(a, e), op)
+	case bool:
+		e, ok := expected.(bool)
+		if !ok {
+			return false
+		}
+		return (op == "eq" && a == e) || (op == "ne" && a != e)
+	case float64:
+		e, ok := expected.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case a < e:
+			return compareOrdered(-1, op)
+		case a > e:
+			return compareOrdered(1, op)
+		}
+		return compareOrdered(0, op)
+	}
+	return false
+}
+
+func compareOrdered(order int, op string) bool {
+	switch op {
+	case "eq":
+		return order == 0
+	case "ne":
+		return order != 0
+	case "gt":
+		return order > 0
+	case "ge":
+		return order >= 0
+	case "lt":
+		return order < 0
+	case "le":
+		return order <= 0
+	}
+	return false
+}
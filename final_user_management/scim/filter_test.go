@@ -0,0 +1,205 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// bjensen is the full User representation of RFC 7643 section 8.2, trimmed to the attributes
+// the filter examples use.
+const bjensen = `{
+	"schemas": [
+		"urn:ietf:params:scim:schemas:core:2.0:User",
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"
+	],
+	"id": "2819c223-7f76-453a-919d-413861904646",
+	"externalId": "701984",
+	"userName": "bjensen@example.com",
+	"name": {
+		"formatted": "Ms. Barbara J Jensen, III",
+		"familyName": "Jensen",
+		"givenName": "Barbara",
+		"middleName": "Jane"
+	},
+	"displayName": "Babs Jensen",
+	"title": "Tour Guide",
+	"userType": "Employee",
+	"active": true,
+	"emails": [
+		{"value": "bjensen@example.com", "type": "work", "primary": true},
+		{"value": "babs@jensen.org", "type": "home"}
+	],
+	"ims": [{"value": "someaimhandle", "type": "aim"}],
+	"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {
+		"employeeNumber": "701984",
+		"department": "Tour Operations",
+		"manager": {"value": "26118915-6090-4610-87e4-49d8ca9f808d", "displayName": "John Smith"}
+	},
+	"meta": {
+		"resourceType": "User",
+		"created": "2010-01-23T04:56:22Z",
+		"lastModified": "2011-05-13T04:42:34Z"
+	}
+}`
+
+func decodeResource(t *testing.T, source string) map[string]interface{} {
+	t.Helper()
+	var resource map[string]interface{}
+	if err := json.Unmarshal([]byte(source), &resource); err != nil {
+		t.Fatalf("invalid test resource: %v", err)
+	}
+	return resource
+}
+
+// TestFilterRFC7644Examples evaluates the filter examples of RFC 7644 section 3.4.2.2 against
+// the RFC 7643 example user.
+func TestFilterRFC7644Examples(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{`userName eq "bjensen"`, false},
+		{`userName eq "bjensen@example.com"`, true},
+		{`userName Eq "BJENSEN@EXAMPLE.COM"`, true},
+		{`name.familyName co "O'Malley"`, false},
+		{`name.familyName co "ens"`, true},
+		{`userName sw "J"`, false},
+		{`userName sw "bj"`, true},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "J"`, false},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "B"`, true},
+		{`title pr`, true},
+		{`nickName pr`, false},
+		{`meta.lastModified gt "2011-05-13T04:42:34Z"`, false},
+		{`meta.lastModified ge "2011-05-13T04:42:34Z"`, true},
+		{`meta.lastModified lt "2011-05-13T04:42:34Z"`, false},
+		{`meta.lastModified le "2011-05-13T04:42:34Z"`, true},
+		{`meta.lastModified gt "2011-05-13T06:42:34+02:00"`, false},
+		{`title pr and userType eq "Employee"`, true},
+		{`title pr or userType eq "Intern"`, true},
+		{`schemas eq "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User"`, true},
+		{`userType eq "Employee" and (emails co "example.com" or emails.value co "example.org")`, true},
+		{`userType ne "Employee" and not (emails co "example.com" or emails.value co "example.org")`, false},
+		{`userType eq "Employee" and (emails.type eq "work")`, true},
+		{`userType eq "Employee" and emails[type eq "work" and value co "@example.com"]`, true},
+		{`emails[type eq "work" and value co "@example.com"] or ims[type eq "xmpp" and value co "@foo.com"]`, true},
+		{`emails[type eq "home" and value co "@example.com"]`, false},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department eq "tour operations"`, true},
+		{`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:manager.displayName sw "John"`, true},
+		{`active eq true`, true},
+		{`active ne true`, false},
+		{`nickName eq null`, true},
+		{`title eq null`, false},
+		{`not (title pr)`, false},
+	}
+
+	resource := decodeResource(t, bjensen)
+	for _, test := range tests {
+		filter, err := ParseFilter(test.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s) = %v", test.filter, err)
+			continue
+		}
+		if got := filter.Matches(resource); got != test.want {
+			t.Errorf("%s matched %v, want %v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestParseFilterStructure(t *testing.T) {
+	userType := Comparison{AttrPath{Attr: "userType"}, "eq", "Employee"}
+	title := Comparison{Path: AttrPath{Attr: "title"}, Op: "pr"}
+	tests := []struct {
+		filter string
+		want   Filter
+	}{
+		{`title PR`, title},
+		{`userType eq "Employee"`, userType},
+		{`age gt 30`, Comparison{AttrPath{Attr: "age"}, "gt", float64(30)}},
+		{`active eq false`, Comparison{AttrPath{Attr: "active"}, "eq", false}},
+		{`name.familyName eq "O\"Malley"`, Comparison{AttrPath{Attr: "name", Sub: "familyName"}, "eq", `O"Malley`}},
+		{
+			`urn:ietf:params:scim:schemas:core:2.0:User:name.givenName eq "Barbara"`,
+			Comparison{AttrPath{Schema: UserSchema, Attr: "name", Sub: "givenName"}, "eq", "Barbara"},
+		},
+		// "and" binds tighter than "or"
+		{
+			`title pr or userType eq "Employee" and active eq true`,
+			Logical{"or", title, Logical{"and", userType, Comparison{AttrPath{Attr: "active"}, "eq", true}}},
+		},
+		{
+			`(title pr or userType eq "Employee") and active eq true`,
+			Logical{"and", Logical{"or", title, userType}, Comparison{AttrPath{Attr: "active"}, "eq", true}},
+		},
+		{`not (title pr)`, Not{title}},
+		{
+			`emails[type eq "work" and value co "@example.com"]`,
+			ValuePath{AttrPath{Attr: "emails"}, Logical{"and", Comparison{AttrPath{Attr: "type"}, "eq", "work"}, Comparison{AttrPath{Attr: "value"}, "co", "@example.com"}}},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseFilter(test.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%s) = %v", test.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseFilter(%s) = %#v, want %#v", test.filter, got, test.want)
+		}
+	}
+}
+
+func TestParseFilterRejectsInvalidFilters(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName xx "bjensen"`,
+		`userName eq bjensen`,
+		`userName eq "unterminated`,
+		`userName eq "bad \escape"`,
+		`(userName eq "bjensen"`,
+		`userName eq "bjensen")`,
+		`userName eq "bjensen" and`,
+		`userName eq "bjensen" or or title pr`,
+		`not userName eq "bjensen"`,
+		`emails[type eq "work"`,
+		`emails.value[type eq "work"]`,
+		`name.familyName.extra eq "x"`,
+		`1userName eq "x"`,
+		`"userName" eq "x"`,
+		`userName eq "a" "b"`,
+		`[type eq "work"]`,
+	} {
+		_, err := ParseFilter(filter)
+		var scimErr *Error
+		if !errors.As(err, &scimErr) || scimErr.ScimType != InvalidFilter || scimErr.StatusCode() != 400 {
+			t.Errorf("ParseFilter(%s) = %v, want an invalidFilter error", filter, err)
+		}
+	}
+}
+
+func TestAttrPathKey(t *testing.T) {
+	tests := []struct {
+		path string
+		key  string
+	}{
+		{"userName", "username"},
+		{"name.givenName", "name.givenname"},
+		{"urn:ietf:params:scim:schemas:core:2.0:User:name.givenName", "name.givenname"},
+		{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:user:department"},
+		{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:user"},
+		{"members.$ref", "members.$ref"},
+	}
+	for _, test := range tests {
+		path, err := ParseAttrPath(test.path)
+		if err != nil {
+			t.Errorf("ParseAttrPath(%s) = %v", test.path, err)
+			continue
+		}
+		if path.Key() != test.key {
+			t.Errorf("ParseAttrPath(%s).Key() = %q, want %q", test.path, path.Key(), test.key)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH (RFC 7644 section 3.5.2).
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation adds, replaces or removes the value at Path. Without a path, Value is an object
+// whose keys are attribute paths.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Apply applies the operations in order to a resource in its JSON form. Paths may select elements
+// of multi-valued attributes with a filter, as in emails[type eq "work"].value. Replacing or
+// adding to a selection that matches nothing creates the element when the filter only tests
+// equality, which is how several identity providers set a work email or phone number.
+func (r PatchRequest) Apply(resource map[string]interface{}) error {
+	if !containsFold(r.Schemas, PatchOpSchema) {
+		return NewError(400, InvalidSyntax, "request must use the "+PatchOpSchema+" schema")
+	}
+	if len(r.Operations) == 0 {
+		return NewError(400, InvalidSyntax, "no operations")
+	}
+	for _, operation := range r.Operations {
+		if err := operation.apply(resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o PatchOperation) apply(resource map[string]interface{}) error {
+	op := strings.ToLower(o.Op)
+	if op != "add" && op != "replace" && op != "remove" {
+		return NewError(400, InvalidSyntax, fmt.Sprintf("unknown operation %q", o.Op))
+	}
+	if o.Path == "" {
+		if op == "remove" {
+			return NewError(400, NoTarget, "remove requires a path")
+		}
+		values, ok := o.Value.(map[string]interface{})
+		if !ok {
+			return NewError(400, InvalidValue, "an operation without a path needs an object value")
+		}
+		for key, value := range values {
+			if err := (PatchOperation{Op: op, Path: key, Value: value}).apply(resource); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parsePatchPath(o.Path)
+	if err != nil {
+		return err
+	}
+	if name := strings.ToLower(path.attr.Attr); path.attr.Schema == "" && (name == "id" || name == "meta" || name == "schemas") {
+		return NewError(400, Mutability, path.attr.Attr+" cannot be modified")
+	}
+	container := resource
+	if path.attr.Schema != "" && !isCoreSchema(path.attr.Schema) {
+		extension := keyOf(resource, path.attr.Schema)
+		if _, ok := resource[extension].(map[string]interface{}); !ok {
+			if op == "remove" {
+				return nil
+			}
+			resource[extension] = map[string]interface{}{}
+		}
+		container = resource[extension].(map[string]interface{})
+	}
+
+	switch {
+	case op == "remove":
+		return path.remove(container, o.Value)
+	case path.filter != nil:
+		return path.setSelected(container, op, o.Value)
+	default:
+		path.set(container, op, o.Value)
+		return nil
+	}
+}
+
+// patchPath is a parsed PATCH path: an attribute, an optional filter selecting some of its
+// values, and a sub-attribute of the attribute or of the selected values.
+type patchPath struct {
+	attr   AttrPath
+	filter Filter
+	sub    string
+}
+
+func parsePatchPath(path string) (patchPath, error) {
+	open := strings.Index(path, "[")
+	if open < 0 {
+		attr, err := ParseAttrPath(path)
+		if err != nil {
+			return patchPath{}, NewError(400, InvalidPath, err.Error())
+		}
+		return patchPath{attr: AttrPath{Schema: attr.Schema, Attr: attr.Attr}, sub: attr.Sub}, nil
+	}
+
+	closing := strings.LastIndex(path, "]")
+	attr, err := ParseAttrPath(path[:open])
+	if closing < open || err != nil || attr.Sub != "" {
+		return patchPath{}, NewError(400, InvalidPath, fmt.Sprintf("invalid path %q", path))
+	}
+	filter, err := ParseFilter(path[open+1 : closing])
+	if err != nil {
+		return patchPath{}, NewError(400, InvalidPath, err.Error())
+	}
+	parsed := patchPath{attr: attr, filter: filter}
+	if rest := path[closing+1:]; rest != "" {
+		if !strings.HasPrefix(rest, ".") || !validAttrName(rest[1:]) {
+			return patchPath{}, NewError(400, InvalidPath, fmt.Sprintf("invalid path %q", path))
+		}
+		parsed.sub = rest[1:]
+	}
+	return parsed, nil
+}
+
+// set adds or replaces an attribute, or a sub-attribute of a complex one. Adding to a
+// multi-valued attribute appends the values it does not have yet; replacing one replaces all of
+// its values. A complex value is merged with the existing one.
+func (p patchPath) set(container map[string]interface{}, op string, value interface{}) {
+	key := keyOf(container, p.attr.Attr)
+	existing := container[key]
+
+	if p.sub != "" {
+		switch current := existing.(type) {
+		case []interface{}:
+			if len(current) == 0 {
+				container[key] = []interface{}{map[string]interface{}{p.sub: value}}
+			}
+			for _, item := range current {
+				if element, ok := item.(map[string]interface{}); ok {
+					element[keyOf(element, p.sub)] = value
+				}
+			}
+		case map[string]interface{}:
+			current[keyOf(current, p.sub)] = value
+		default:
+			container[key] = map[string]interface{}{p.sub: value}
+		}
+		return
+	}
+
+	values, isList := value.([]interface{})
+	if current, ok := existing.([]interface{}); ok || isList {
+		if !isList {
+			values = []interface{}{value}
+		}
+		if op == "add" {
+			values = appendNew(current, values)
+		}
+		container[key] = withSinglePrimary(values)
+		return
+	}
+	if current, ok := existing.(map[string]interface{}); ok {
+		if fields, ok := value.(map[string]interface{}); ok {
+			merge(current, fields)
+			return
+		}
+	}
+	container[key] = value
+}
+
+// setSelected adds or replaces the values selected by the filter, or a sub-attribute of them.
+func (p patchPath) setSelected(container map[string]interface{}, op string, value interface{}) error {
+	key := keyOf(container, p.attr.Attr)
+	current, _ := container[key].([]interface{})
+
+	matched := false
+	for i, item := range current {
+		element, ok := item.(map[string]interface{})
+		if !ok || !p.filter.Matches(element) {
+			continue
+		}
+		matched = true
+		switch fields, isObject := value.(map[string]interface{}); {
+		case p.sub != "":
+			element[keyOf(element, p.sub)] = value
+		case op == "replace" && isObject:
+			current[i] = fields
+		case isObject:
+			merge(element, fields)
+		default:
+			return NewError(400, InvalidValue, "a selected value must be replaced with an object")
+		}
+	}
+	if !matched {
+		element, ok := equalities(p.filter)
+		if !ok {
+			return NewError(400, NoTarget, fmt.Sprintf("no value of %s matches the filter", p.attr.Attr))
+		}
+		if p.sub != "" {
+			element[p.sub] = value
+		} else if fields, ok := value.(map[string]interface{}); ok {
+			merge(element, fields)
+		} else {
+			return NewError(400, InvalidValue, "a selected value must be replaced with an object")
+		}
+		current = append(current, element)
+	}
+	container[key] = withSinglePrimary(current)
+	return nil
+}
+
+// remove deletes an attribute, a sub-attribute, or the values selected by the filter. Removing
+// from a multi-valued attribute with a value removes the listed values, as some identity
+// providers do for group members.
+func (p patchPath) remove(container map[string]interface{}, value interface{}) error {
+	key := keyOf(container, p.attr.Attr)
+	current, isList := container[key].([]interface{})
+
+	switch {
+	case p.filter == nil && p.sub == "" && isList && value != nil:
+		listed, ok := value.([]interface{})
+		if !ok {
+			listed = []interface{}{value}
+		}
+		kept := current[:0]
+		for _, item := range current {
+			if !containsValue(listed, item) {
+				kept = append(kept, item)
+			}
+		}
+		current = kept
+	case p.filter == nil && p.sub == "":
+		delete(container, key)
+		return nil
+	case p.filter == nil:
+		if object, ok := container[key].(map[string]interface{}); ok {
+			delete(object, keyOf(object, p.sub))
+			return nil
+		}
+		for _, item := range current {
+			if element, ok := item.(map[string]interface{}); ok {
+				delete(element, keyOf(element, p.sub))
+			}
+		}
+	default:
+		kept := current[:0]
+		for _, item := range current {
+			element, ok := item.(map[string]interface{})
+			if !ok || !p.filter.Matches(element) {
+				kept = append(kept, item)
+				continue
+			}
+			if p.sub != "" {
+				delete(element, keyOf(element, p.sub))
+				kept = append(kept, element)
+			}
+		}
+		current = kept
+	}
+
+	if len(current) == 0 {
+		delete(container, key)
+	} else {
+		container[key] = current
+	}
+	return nil
+}
+
+// equalities turns a filter made only of eq comparisons joined by "and" into the value it
+// describes, such as {"type": "work"} for type eq "work".
+func equalities(filter Filter) (map[string]interface{}, bool) {
+	switch f := filter.(type) {
+	case Comparison:
+		if f.Op != "eq" || f.Path.Sub != "" {
+			return nil, false
+		}
+		return map[string]interface{}{f.Path.Attr: f.Value}, true
+	case Logical:
+		if f.Op != "and" {
+			return nil, false
+		}
+		left, ok := equalities(f.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := equalities(f.Right)
+		if !ok {
+			return nil, false
+		}
+		merge(left, right)
+		return left, true
+	}
+	return nil, false
+}
+
+// keyOf returns the key the object already uses for the attribute, matched case-insensitively,
+// or name when it has none.
+func keyOf(object map[string]interface{}, name string) string {
+	if _, ok := object[name]; ok {
+		return name
+	}
+	for key := range object {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+func merge(object map[string]interface{}, fields map[string]interface{}) {
+	for name, value := range fields {
+		object[keyOf(object, name)] = value
+	}
+}
+
+// appendNew appends the values that are not in current yet.
+func appendNew(current []interface{}, values []interface{}) []interface{} {
+	merged := append([]interface{}{}, current...)
+	for _, value := range values {
+		if !containsValue(merged, value) {
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+// containsValue reports whether the list holds the value, comparing complex values by their
+// "value" sub-attribute.
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if fmt.Sprint(valueOf(item)) == fmt.Sprint(valueOf(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func valueOf(item interface{}) interface{} {
+	if element, ok := item.(map[string]interface{}); ok {
+		return get(element, "value")
+	}
+	return item
+}
+
+// withSinglePrimary keeps only the last value marked primary as primary.
+func withSinglePrimary(values []interface{}) []interface{} {
+	primary := -1
+	for i, item := range values {
+		if element, ok := item.(map[string]interface{}); ok {
+			if isTrue(get(element, "primary")) {
+				primary = i
+			}
+		}
+	}
+	if primary < 0 {
+		return values
+	}
+	for i, item := range values {
+		if element, ok := item.(map[string]interface{}); ok && i != primary {
+			if key := keyOf(element, "primary"); element[key] != nil {
+				element[key] = false
+			}
+		}
+	}
+	return values
+}
+
+func isTrue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
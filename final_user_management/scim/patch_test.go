@@ -0,0 +1,336 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// tourGuides is the group the PATCH examples of RFC 7644 section 3.5.2 modify.
+const tourGuides = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+	"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+	"displayName": "Tour Guides",
+	"members": [
+		{"value": "2819c223-7f76-453a-919d-413861904646", "display": "Babs Jensen"},
+		{"value": "902c246b-6245-4190-8e05-00816be7344a", "display": "Mandy Pepperidge"}
+	]
+}`
+
+// barbara is the user the PATCH examples of RFC 7644 section 3.5.2 modify.
+const barbara = `{
+	"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+	"id": "2819c223-7f76-453a-919d-413861904646",
+	"userName": "bjensen",
+	"emails": [
+		{"value": "bjensen@example.com", "type": "work", "primary": true}
+	],
+	"addresses": [
+		{"type": "work", "streetAddress": "100 Universal City Plaza", "locality": "Hollywood", "primary": true},
+		{"type": "home", "streetAddress": "456 Hollywood Blvd", "locality": "Hollywood"}
+	],
+	"x509Certificates": [{"value": "MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx"}]
+}`
+
+func applyPatch(t *testing.T, resource string, patch string) (map[string]interface{}, error) {
+	t.Helper()
+	var request PatchRequest
+	if err := json.Unmarshal([]byte(patch), &request); err != nil {
+		t.Fatalf("invalid test patch: %v", err)
+	}
+	object := decodeResource(t, resource)
+	return object, request.Apply(object)
+}
+
+// TestPatchRFC7644Examples applies the PATCH examples of RFC 7644 section 3.5.2 and compares
+// the result with the whole expected resource.
+func TestPatchRFC7644Examples(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+		patch    string
+		want     string
+	}{
+		{
+			name:     "add a member (3.5.2.1)",
+			resource: tourGuides,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "add", "path": "members", "value": [{"display": "Babs Jensen", "$ref": "https://example.com/v2/Users/2819c223-7f76-453a-919d-413861904646", "value": "2819c223-7f76-453a-919d-413861904646"}, {"value": "08e1d05d-121c-4561-8b96-473d93df9210"}]}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+				"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+				"displayName": "Tour Guides",
+				"members": [
+					{"value": "2819c223-7f76-453a-919d-413861904646", "display": "Babs Jensen"},
+					{"value": "902c246b-6245-4190-8e05-00816be7344a", "display": "Mandy Pepperidge"},
+					{"value": "08e1d05d-121c-4561-8b96-473d93df9210"}
+				]
+			}`,
+		},
+		{
+			name:     "add without a path (3.5.2.1)",
+			resource: barbara,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "add", "value": {"emails": [{"value": "babs@jensen.org", "type": "home"}], "nickname": "Babs"}}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+				"id": "2819c223-7f76-453a-919d-413861904646",
+				"userName": "bjensen",
+				"nickname": "Babs",
+				"emails": [
+					{"value": "bjensen@example.com", "type": "work", "primary": true},
+					{"value": "babs@jensen.org", "type": "home"}
+				],
+				"addresses": [
+					{"type": "work", "streetAddress": "100 Universal City Plaza", "locality": "Hollywood", "primary": true},
+					{"type": "home", "streetAddress": "456 Hollywood Blvd", "locality": "Hollywood"}
+				],
+				"x509Certificates": [{"value": "MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx"}]
+			}`,
+		},
+		{
+			name:     "remove a single member (3.5.2.2)",
+			resource: tourGuides,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "remove", "path": "members[value eq \"2819c223-7f76-453a-919d-413861904646\"]"}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+				"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+				"displayName": "Tour Guides",
+				"members": [{"value": "902c246b-6245-4190-8e05-00816be7344a", "display": "Mandy Pepperidge"}]
+			}`,
+		},
+		{
+			name:     "remove all members (3.5.2.2)",
+			resource: tourGuides,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "remove", "path": "members"}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+				"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+				"displayName": "Tour Guides"
+			}`,
+		},
+		{
+			name:     "remove multiple members (3.5.2.2)",
+			resource: tourGuides,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "remove", "path": "members[value eq \"2819c223-7f76-453a-919d-413861904646\"]"},
+				{"op": "remove", "path": "members[value eq \"902c246b-6245-4190-8e05-00816be7344a\"]"}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+				"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+				"displayName": "Tour Guides"
+			}`,
+		},
+		{
+			name:     "remove members listed in the value",
+			resource: tourGuides,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "remove", "path": "members", "value": [{"value": "2819c223-7f76-453a-919d-413861904646"}]}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+				"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+				"displayName": "Tour Guides",
+				"members": [{"value": "902c246b-6245-4190-8e05-00816be7344a", "display": "Mandy Pepperidge"}]
+			}`,
+		},
+		{
+			name:     "replace all members with remove and add (3.5.2.2)",
+			resource: tourGuides,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "remove", "path": "members"},
+				{"op": "add", "path": "members", "value": [{"display": "James Smith", "value": "08e1d05d-121c-4561-8b96-473d93df9210"}]}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+				"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+				"displayName": "Tour Guides",
+				"members": [{"display": "James Smith", "value": "08e1d05d-121c-4561-8b96-473d93df9210"}]
+			}`,
+		},
+		{
+			name:     "remove a certificate (3.5.2.2)",
+			resource: barbara,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "remove", "path": "x509Certificates[value eq \"MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx\"]"}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+				"id": "2819c223-7f76-453a-919d-413861904646",
+				"userName": "bjensen",
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}],
+				"addresses": [
+					{"type": "work", "streetAddress": "100 Universal City Plaza", "locality": "Hollywood", "primary": true},
+					{"type": "home", "streetAddress": "456 Hollywood Blvd", "locality": "Hollywood"}
+				]
+			}`,
+		},
+		{
+			name:     "replace all members (3.5.2.3)",
+			resource: tourGuides,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "replace", "path": "members", "value": [{"display": "Babs Jensen", "value": "2819c223-7f76-453a-919d-413861904646"}, {"display": "James Smith", "value": "08e1d05d-121c-4561-8b96-473d93df9210"}]}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:Group"],
+				"id": "acbf3ae7-8463-4692-b4fd-9b4da3f908ce",
+				"displayName": "Tour Guides",
+				"members": [
+					{"display": "Babs Jensen", "value": "2819c223-7f76-453a-919d-413861904646"},
+					{"display": "James Smith", "value": "08e1d05d-121c-4561-8b96-473d93df9210"}
+				]
+			}`,
+		},
+		{
+			name:     "replace a selected address (3.5.2.3)",
+			resource: barbara,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "replace", "path": "addresses[type eq \"work\"]", "value": {"type": "work", "streetAddress": "911 Universal City Plaza", "locality": "Hollywood", "region": "CA", "postalCode": "91608", "country": "US", "primary": true}}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+				"id": "2819c223-7f76-453a-919d-413861904646",
+				"userName": "bjensen",
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}],
+				"addresses": [
+					{"type": "work", "streetAddress": "911 Universal City Plaza", "locality": "Hollywood", "region": "CA", "postalCode": "91608", "country": "US", "primary": true},
+					{"type": "home", "streetAddress": "456 Hollywood Blvd", "locality": "Hollywood"}
+				],
+				"x509Certificates": [{"value": "MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx"}]
+			}`,
+		},
+		{
+			name:     "replace a sub-attribute of a selected address (3.5.2.3)",
+			resource: barbara,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "replace", "path": "addresses[type eq \"work\"].streetAddress", "value": "1010 Broadway Ave"}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+				"id": "2819c223-7f76-453a-919d-413861904646",
+				"userName": "bjensen",
+				"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}],
+				"addresses": [
+					{"type": "work", "streetAddress": "1010 Broadway Ave", "locality": "Hollywood", "primary": true},
+					{"type": "home", "streetAddress": "456 Hollywood Blvd", "locality": "Hollywood"}
+				],
+				"x509Certificates": [{"value": "MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx"}]
+			}`,
+		},
+		{
+			name:     "replace without a path (3.5.2.3)",
+			resource: barbara,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "replace", "value": {"emails": [{"value": "bjensen@example.com", "type": "work", "primary": true}, {"value": "babs@jensen.org", "type": "home"}], "nickname": "Babs"}}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+				"id": "2819c223-7f76-453a-919d-413861904646",
+				"userName": "bjensen",
+				"nickname": "Babs",
+				"emails": [
+					{"value": "bjensen@example.com", "type": "work", "primary": true},
+					{"value": "babs@jensen.org", "type": "home"}
+				],
+				"addresses": [
+					{"type": "work", "streetAddress": "100 Universal City Plaza", "locality": "Hollywood", "primary": true},
+					{"type": "home", "streetAddress": "456 Hollywood Blvd", "locality": "Hollywood"}
+				],
+				"x509Certificates": [{"value": "MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx"}]
+			}`,
+		},
+		{
+			name:     "replace a selection that matches nothing creates it",
+			resource: `{"userName": "bjensen"}`,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "replace", "path": "phoneNumbers[type eq \"work\"].value", "value": "555-555-8377"}
+			]}`,
+			want: `{"userName": "bjensen", "phoneNumbers": [{"type": "work", "value": "555-555-8377"}]}`,
+		},
+		{
+			name:     "a new primary value demotes the others",
+			resource: barbara,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "add", "path": "emails", "value": [{"value": "babs@jensen.org", "type": "home", "primary": true}]}
+			]}`,
+			want: `{
+				"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+				"id": "2819c223-7f76-453a-919d-413861904646",
+				"userName": "bjensen",
+				"emails": [
+					{"value": "bjensen@example.com", "type": "work", "primary": false},
+					{"value": "babs@jensen.org", "type": "home", "primary": true}
+				],
+				"addresses": [
+					{"type": "work", "streetAddress": "100 Universal City Plaza", "locality": "Hollywood", "primary": true},
+					{"type": "home", "streetAddress": "456 Hollywood Blvd", "locality": "Hollywood"}
+				],
+				"x509Certificates": [{"value": "MIIDQzCCAqygAwIBAgICEAAwDQYJKoZIhvcNAQEFBQAwTjELMAkGA1UEBhMCVVMx"}]
+			}`,
+		},
+		{
+			name:     "extension attributes and case-insensitive names",
+			resource: `{"userName": "bjensen", "Name": {"givenName": "Barbara"}}`,
+			patch: `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+				{"op": "Replace", "path": "name.GIVENNAME", "value": "Babs"},
+				{"op": "add", "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", "value": "Tour Operations"}
+			]}`,
+			want: `{
+				"userName": "bjensen",
+				"Name": {"givenName": "Babs"},
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"department": "Tour Operations"}
+			}`,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := applyPatch(t, test.resource, test.patch)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if want := decodeResource(t, test.want); !reflect.DeepEqual(got, want) {
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(want)
+			t.Errorf("%s:\n got %s\nwant %s", test.name, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestPatchRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		patch    string
+		scimType string
+	}{
+		{"missing schema", `{"schemas": [], "Operations": [{"op": "add", "path": "nickName", "value": "Babs"}]}`, InvalidSyntax},
+		{"no operations", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": []}`, InvalidSyntax},
+		{"unknown operation", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "move", "path": "nickName"}]}`, InvalidSyntax},
+		{"remove without a path", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "remove"}]}`, NoTarget},
+		{"no path and no object", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "add", "value": "Babs"}]}`, InvalidValue},
+		{"read-only id", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "id", "value": "x"}]}`, Mutability},
+		{"read-only meta", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "remove", "path": "meta"}]}`, Mutability},
+		{"read-only id without a path", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "value": {"id": "x"}}]}`, Mutability},
+		{"invalid attribute", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "add", "path": "nick name", "value": "x"}]}`, InvalidPath},
+		{"unclosed filter", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "remove", "path": "emails[type eq \"work\""}]}`, InvalidPath},
+		{"invalid filter", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "remove", "path": "emails[type xx \"work\"]"}]}`, InvalidPath},
+		{"invalid sub-attribute", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "emails[type eq \"work\"]value", "value": "x"}]}`, InvalidPath},
+		{"no match for a non-equality filter", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "emails[value co \"@nowhere\"].type", "value": "home"}]}`, NoTarget},
+		{"selected value replaced with a string", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "emails[type eq \"work\"]", "value": "x"}]}`, InvalidValue},
+	}
+	for _, test := range tests {
+		_, err := applyPatch(t, barbara, test.patch)
+		var scimErr *Error
+		if !errors.As(err, &scimErr) || scimErr.ScimType != test.scimType || scimErr.StatusCode() != 400 {
+			t.Errorf("%s: got %v, want a %s error", test.name, err, test.scimType)
+		}
+	}
+}
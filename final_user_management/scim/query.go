@@ -0,0 +1,217 @@
+package scim
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultCount is the page size when a query does not ask for one.
+	DefaultCount = 100
+	// MaxCount is the largest page a query returns, as advertised in the ServiceProviderConfig.
+	MaxCount = 1000
+)
+
+// ListQuery is the filter, pagination and attribute selection of a GET on a resource endpoint.
+type ListQuery struct {
+	Filter     Filter
+	StartIndex int
+	Count      int
+	Projection Projection
+}
+
+// ParseListQuery reads the filter, startIndex, count, attributes and excludedAttributes query
+// parameters. Out-of-range pagination values are clamped as RFC 7644 section 3.4.2.4 requires.
+func ParseListQuery(filter, startIndex, count, attributes, excludedAttributes string) (ListQuery, error) {
+	query := ListQuery{
+		StartIndex: 1,
+		Count:      DefaultCount,
+		Projection: NewProjection(attributes, excludedAttributes),
+	}
+	if strings.TrimSpace(filter) != "" {
+		parsed, err := ParseFilter(filter)
+		if err != nil {
+			return ListQuery{}, err
+		}
+		query.Filter = parsed
+	}
+	if startIndex != "" {
+		index, err := strconv.Atoi(startIndex)
+		if err != nil {
+			return ListQuery{}, NewError(400, InvalidValue, "startIndex must be an integer")
+		}
+		if index > 1 {
+			query.StartIndex = index
+		}
+	}
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return ListQuery{}, NewError(400, InvalidValue, "count must be an integer")
+		}
+		query.Count = min(max(n, 0), MaxCount)
+	}
+	return query, nil
+}
+
+// Offset is the number of results to skip.
+func (q ListQuery) Offset() int {
+	return q.StartIndex - 1
+}
+
+// Projection is the attributes a response is limited to, or excludes. Attributes that are always
+// returned (schemas, id and meta) are kept either way.
+type Projection struct {
+	include []projected
+	exclude []projected
+}
+
+// projected is a top-level attribute of a resource, lowercased, and optionally one of its
+// sub-attributes. Attributes of a schema extension are sub-attributes of the extension.
+type projected struct {
+	attr string
+	sub  string
+}
+
+// NewProjection parses the comma-separated attributes and excludedAttributes parameters.
+// When both are given, attributes wins.
+func NewProjection(attributes, excludedAttributes string) Projection {
+	if include := splitAttributes(attributes); len(include) > 0 {
+		return Projection{include: include}
+	}
+	return Projection{exclude: splitAttributes(excludedAttributes)}
+}
+
+func splitAttributes(list string) []projected {
+	var attributes []projected
+	for _, attribute := range strings.Split(list, ",") {
+		path, err := ParseAttrPath(strings.TrimSpace(attribute))
+		if err != nil {
+			continue
+		}
+		if path.Schema != "" && !isCoreSchema(path.Schema) {
+			attributes = append(attributes, projected{strings.ToLower(path.Schema), strings.ToLower(path.Attr)})
+		} else {
+			attributes = append(attributes, projected{strings.ToLower(path.Attr), strings.ToLower(path.Sub)})
+		}
+	}
+	return attributes
+}
+
+// Excludes reports whether the top-level attribute is left out of responses, so that it need not
+// be loaded at all.
+func (p Projection) Excludes(attribute string) bool {
+	attribute = strings.ToLower(attribute)
+	if len(p.include) > 0 {
+		for _, included := range p.include {
+			if included.attr == attribute {
+				return false
+			}
+		}
+		return true
+	}
+	for _, excluded := range p.exclude {
+		if excluded.attr == attribute && excluded.sub == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the resource limited to the projection.
+func (p Projection) Apply(resource interface{}) (interface{}, error) {
+	if len(p.include) == 0 && len(p.exclude) == 0 {
+		return resource, nil
+	}
+	object, err := ToMap(resource)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range object {
+		key := strings.ToLower(name)
+		if key == "schemas" || key == "id" || key == "meta" {
+			continue
+		}
+		if len(p.include) > 0 {
+			sub, keep := p.included(key)
+			if !keep {
+				delete(object, name)
+			} else if len(sub) > 0 {
+				object[name] = onlySubAttributes(value, sub)
+			}
+			continue
+		}
+		for _, excluded := range p.exclude {
+			if excluded.attr != key {
+				continue
+			}
+			if excluded.sub == "" {
+				delete(object, name)
+			} else {
+				removeSubAttribute(value, excluded.sub)
+			}
+		}
+	}
+	return object, nil
+}
+
+// included reports whether the attribute is requested, and which of its sub-attributes when only
+// some are.
+func (p Projection) included(key string) ([]string, bool) {
+	var sub []string
+	for _, requested := range p.include {
+		if requested.attr != key {
+			continue
+		}
+		if requested.sub == "" {
+			return nil, true
+		}
+		sub = append(sub, requested.sub)
+	}
+	return sub, len(sub) > 0
+}
+
+func onlySubAttributes(value interface{}, sub []string) interface{} {
+	keep := func(object map[string]interface{}) map[string]interface{} {
+		kept := map[string]interface{}{}
+		for name, v := range object {
+			for _, s := range sub {
+				if strings.EqualFold(name, s) {
+					kept[name] = v
+				}
+			}
+		}
+		return kept
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return keep(v)
+	case []interface{}:
+		for i, item := range v {
+			if object, ok := item.(map[string]interface{}); ok {
+				v[i] = keep(object)
+			}
+		}
+	}
+	return value
+}
+
+func removeSubAttribute(value interface{}, sub string) {
+	remove := func(object map[string]interface{}) {
+		for name := range object {
+			if strings.EqualFold(name, sub) {
+				delete(object, name)
+			}
+		}
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		remove(v)
+	case []interface{}:
+		for _, item := range v {
+			if object, ok := item.(map[string]interface{}); ok {
+				remove(object)
+			}
+		}
+	}
+}
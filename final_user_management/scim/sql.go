@@ -0,0 +1,142 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AttrType is how a filterable attribute's values are compared in SQL.
+type AttrType int
+
+const (
+	StringAttr AttrType = iota
+	BooleanAttr
+	DateTimeAttr
+)
+
+// Column maps a filterable attribute, by its AttrPath key, to SQL. Expr is a column or an
+// expression over the resource's table. Values kept in another table are reached through Exists,
+// an EXISTS subquery in which %s is replaced by the condition on Expr.
+type Column struct {
+	Expr      string
+	Type      AttrType
+	CaseExact bool
+	Exists    string
+}
+
+// SQL translates the filter into a WHERE condition with placeholders and its arguments.
+// Attributes missing from columns cannot be filtered on and give an invalidFilter error.
+// A complex attribute compared as a whole, such as emails eq "x", compares its "value".
+func SQL(filter Filter, columns map[string]Column) (string, []interface{}, error) {
+	return toSQL(filter, columns, "")
+}
+
+func toSQL(filter Filter, columns map[string]Column, parent string) (string, []interface{}, error) {
+	switch f := filter.(type) {
+	case Logical:
+		left, leftArgs, err := toSQL(f.Left, columns, parent)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := toSQL(f.Right, columns, parent)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + left + " " + strings.ToUpper(f.Op) + " " + right + ")", append(leftArgs, rightArgs...), nil
+	case Not:
+		inner, args, err := toSQL(f.Filter, columns, parent)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + inner + ")", args, nil
+	case ValuePath:
+		if parent != "" {
+			return "", nil, NewError(400, InvalidFilter, "filters on multi-valued attributes cannot be nested")
+		}
+		return toSQL(f.Filter, columns, f.Path.Key())
+	case Comparison:
+		key := f.Path.Key()
+		if parent != "" {
+			key = parent + "." + strings.ToLower(f.Path.Attr)
+		}
+		column, ok := columns[key]
+		if !ok {
+			column, ok = columns[key+".value"]
+		}
+		if !ok {
+			return "", nil, NewError(400, InvalidFilter, fmt.Sprintf("filtering on %s is not supported", f.Path))
+		}
+		condition, args, err := comparisonSQL(column, f.Op, f.Value)
+		if err != nil {
+			return "", nil, NewError(400, InvalidFilter, fmt.Sprintf("%s: %v", f.Path, err))
+		}
+		if column.Exists != "" {
+			condition = fmt.Sprintf(column.Exists, condition)
+		}
+		return condition, args, nil
+	}
+	return "", nil, NewError(400, InvalidFilter, "unsupported filter")
+}
+
+var sqlOperators = map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+func comparisonSQL(column Column, op string, value interface{}) (string, []interface{}, error) {
+	expr := column.Expr
+	if op == "pr" || (value == nil && (op == "eq" || op == "ne")) {
+		present := expr + " IS NOT NULL"
+		if column.Type == StringAttr {
+			present = "(" + expr + " IS NOT NULL AND " + expr + " <> '')"
+		}
+		if op == "eq" {
+			return "NOT " + present, nil, nil
+		}
+		return present, nil, nil
+	}
+
+	switch column.Type {
+	case BooleanAttr:
+		b, ok := value.(bool)
+		if !ok || (op != "eq" && op != "ne") {
+			return "", nil, fmt.Errorf("only eq and ne with true or false are supported")
+		}
+		return expr + " " + sqlOperators[op] + " ?", []interface{}{b}, nil
+
+	case DateTimeAttr:
+		s, ok := value.(string)
+		if !ok {
+			return "", nil, fmt.Errorf("expected a timestamp")
+		}
+		at, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid timestamp %q", s)
+		}
+		operator, ok := sqlOperators[op]
+		if !ok {
+			return "", nil, fmt.Errorf("operator %s does not apply to timestamps", op)
+		}
+		return expr + " " + operator + " ?", []interface{}{at}, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", nil, fmt.Errorf("expected a string")
+	}
+	if !column.CaseExact {
+		expr, s = "LOWER("+expr+")", strings.ToLower(s)
+	}
+	switch op {
+	case "co":
+		return expr + " LIKE ?", []interface{}{"%" + escapeLike(s) + "%"}, nil
+	case "sw":
+		return expr + " LIKE ?", []interface{}{escapeLike(s) + "%"}, nil
+	case "ew":
+		return expr + " LIKE ?", []interface{}{"%" + escapeLike(s)}, nil
+	}
+	return expr + " " + sqlOperators[op] + " ?", []interface{}{s}, nil
+}
+
+// escapeLike makes LIKE treat the value's wildcards and backslashes literally.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,119 @@
+package scim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testColumns maps the attributes of the RFC 7644 filter examples the way the repositories map
+// users, with group membership kept in another table.
+var testColumns = map[string]Column{
+	"id":                {Expr: "users.id::text", CaseExact: true},
+	"username":          {Expr: "users.username"},
+	"name.familyname":   {Expr: "users.last_name"},
+	"title":             {Expr: "users.title"},
+	"emails.value":      {Expr: "users.email"},
+	"emails.type":       {Expr: "'work'"},
+	"usertype":          {Expr: "users.user_type"},
+	"active":            {Expr: "(users.status = 'active')", Type: BooleanAttr},
+	"meta.lastmodified": {Expr: "users.updated_at", Type: DateTimeAttr},
+	AttrPath{Schema: EnterpriseUserSchema, Attr: "department"}.Key(): {Expr: "users.department"},
+	"groups.value": {
+		Expr:      "group_members.group_id::text",
+		CaseExact: true,
+		Exists:    "EXISTS (SELECT 1 FROM group_members WHERE group_members.user_id = users.id AND %s)",
+	},
+}
+
+func TestSQLRFC7644Examples(t *testing.T) {
+	modified := time.Date(2011, 5, 13, 4, 42, 34, 0, time.UTC)
+	tests := []struct {
+		filter string
+		want   string
+		args   []interface{}
+	}{
+		{`userName eq "bjensen"`, "LOWER(users.username) = ?", []interface{}{"bjensen"}},
+		{`name.familyName co "O'Malley"`, "LOWER(users.last_name) LIKE ?", []interface{}{"%o'malley%"}},
+		{`userName sw "J"`, "LOWER(users.username) LIKE ?", []interface{}{"j%"}},
+		{`userName ew "sen"`, "LOWER(users.username) LIKE ?", []interface{}{"%sen"}},
+		{`title pr`, "(users.title IS NOT NULL AND users.title <> '')", nil},
+		{`meta.lastModified gt "2011-05-13T04:42:34Z"`, "users.updated_at > ?", []interface{}{modified}},
+		{`meta.lastModified le "2011-05-13T06:42:34+02:00"`, "users.updated_at <= ?", []interface{}{modified.In(time.FixedZone("", 2*60*60))}},
+		{`title pr and userType eq "Employee"`, "((users.title IS NOT NULL AND users.title <> '') AND LOWER(users.user_type) = ?)", []interface{}{"employee"}},
+		{`title pr or userType eq "Intern"`, "((users.title IS NOT NULL AND users.title <> '') OR LOWER(users.user_type) = ?)", []interface{}{"intern"}},
+		{
+			`userType eq "Employee" and (emails co "example.com" or emails.value co "example.org")`,
+			"(LOWER(users.user_type) = ? AND (LOWER(users.email) LIKE ? OR LOWER(users.email) LIKE ?))",
+			[]interface{}{"employee", "%example.com%", "%example.org%"},
+		},
+		{
+			`userType ne "Employee" and not (emails co "example.com" or emails.value co "example.org")`,
+			"(LOWER(users.user_type) <> ? AND NOT ((LOWER(users.email) LIKE ? OR LOWER(users.email) LIKE ?)))",
+			[]interface{}{"employee", "%example.com%", "%example.org%"},
+		},
+		{
+			`emails[type eq "work" and value co "@example.com"]`,
+			"(LOWER('work') = ? AND LOWER(users.email) LIKE ?)",
+			[]interface{}{"work", "%@example.com%"},
+		},
+		{
+			`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department eq "Tour Operations"`,
+			"LOWER(users.department) = ?",
+			[]interface{}{"tour operations"},
+		},
+		{`id eq "2819c223-7f76-453a-919d-413861904646"`, "users.id::text = ?", []interface{}{"2819c223-7f76-453a-919d-413861904646"}},
+		{`active eq true`, "(users.status = 'active') = ?", []interface{}{true}},
+		{`active ne false`, "(users.status = 'active') <> ?", []interface{}{false}},
+		{`title eq null`, "NOT (users.title IS NOT NULL AND users.title <> '')", nil},
+		{`meta.lastModified ne null`, "users.updated_at IS NOT NULL", nil},
+		{`userName co "50%_\\off"`, "LOWER(users.username) LIKE ?", []interface{}{`%50\%\_\\off%`}},
+		{
+			`groups eq "e9e30dba-f08f-4109-8486-d5c6a331660a"`,
+			"EXISTS (SELECT 1 FROM group_members WHERE group_members.user_id = users.id AND group_members.group_id::text = ?)",
+			[]interface{}{"e9e30dba-f08f-4109-8486-d5c6a331660a"},
+		},
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test.filter)
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %v", test.filter, err)
+			continue
+		}
+		got, args, err := SQL(filter, testColumns)
+		if err != nil {
+			t.Errorf("SQL(%q): %v", test.filter, err)
+			continue
+		}
+		if got != test.want || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("SQL(%q) = %q %#v, want %q %#v", test.filter, got, args, test.want, test.args)
+		}
+	}
+}
+
+func TestSQLRejectsUnsupportedFilters(t *testing.T) {
+	tests := []string{
+		`nickName eq "Babs"`,
+		`emails[type eq "work" and addresses[type eq "home"]]`,
+		`meta.lastModified gt "yesterday"`,
+		`meta.lastModified co "2011"`,
+		`meta.lastModified gt 2011`,
+		`active eq "true"`,
+		`active gt false`,
+		`userName eq 42`,
+	}
+	for _, test := range tests {
+		filter, err := ParseFilter(test)
+		if err != nil {
+			// Filters the parser already refuses are covered by the parser's own tests.
+			t.Errorf("ParseFilter(%q): %v", test, err)
+			continue
+		}
+		_, _, err = SQL(filter, testColumns)
+		var scimErr *Error
+		if !errors.As(err, &scimErr) || scimErr.ScimType != InvalidFilter || scimErr.StatusCode() != 400 {
+			t.Errorf("SQL(%q) = %v, want an invalidFilter error", test, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+// deletionGracePeriodFromEnv returns how long a self-service deletion waits before it is carried out.
+func deletionGracePeriodFromEnv() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS"))
+	if err != nil || days <= 0 {
+		days = 14
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ScheduleAccountDeletion confirms the user's password, schedules their account for deletion
+// after the grace period and signs them out everywhere. Logging back in cancels it.
+func (s *userService) ScheduleAccountDeletion(id string, request models.DeleteAccountRequest, meta models.RequestMeta) (models.SuccessResponse, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("user not found")
+	}
+	if !utils.CheckPasswordHash(request.Password, user.Password) {
+		return models.SuccessResponse{}, errors.New("password is incorrect")
+	}
+	// The deletion is checked again when it is carried out, since admins may change meanwhile
+	if err := s.userRepo.CheckCanRemove(user.ID); err != nil {
+		return models.SuccessResponse{}, err
+	}
+
+	deleteAt := time.Now().Add(deletionGracePeriodFromEnv())
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).SetDeletionSchedule(user.ID, &deleteAt); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditUserDeletionScheduled, models.AuditTargetUser, user.ID, nil, nil, map[string]interface{}{"deleteAt": deleteAt.UTC()})
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if err := s.sessionService.RevokeAll(user.ID); err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", user.ID, err)
+	}
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventDeletionScheduled, models.OutcomeSuccess, "", meta)
+
+	body := fmt.Sprintf(
+		"Your account is scheduled to be deleted on <strong>%s</strong>.<br>"+
+			"If you change your mind, simply log in before then and the deletion will be cancelled.",
+		deleteAt.UTC().Format("2 January 2006"),
+	)
+	if err := utils.SendEmail(emailConfigFromEnv(), user.Email, "Your account is scheduled for deletion", body); err != nil {
+		log.Printf("Failed to send deletion confirmation to %s: %v", user.Email, err)
+	}
+
+	return models.SuccessResponse{Message: fmt.Sprintf("Account scheduled for deletion on %s", deleteAt.UTC().Format(time.RFC3339))}, nil
+}
+
+// ProcessScheduledDeletions anonymizes and deletes every account whose grace period has ended.
+// Each account is anonymized and deleted in one transaction, so a failed deletion leaves it as
+// it was. It returns how many accounts were deleted.
+func (s *userService) ProcessScheduledDeletions() (int, error) {
+	users, err := s.userRepo.FindDueForDeletion(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for i := range users {
+		if err := s.processScheduledDeletion(&users[i]); err != nil {
+			log.Printf("Failed to delete user %s: %v", users[i].ID, err)
+			continue
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (s *userService) processScheduledDeletion(user *models.User) error {
+	return s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		// The job runs across tenants, so name the user's organization
+		audit := s.audit.forRequest(models.AuditContext{OrganizationID: user.OrganizationID}).withTx(tx)
+		userRepo := s.userRepo.WithTx(tx)
+		if err := userRepo.Anonymize(user.ID); err != nil {
+			return fmt.Errorf("anonymize: %w", err)
+		}
+		if err := audit.record(models.AuditUserAnonymized, models.AuditTargetUser, user.ID, nil, nil, nil); err != nil {
+			return err
+		}
+
+		// Reload the user so that the deletion event carries no personal data
+		anonymized, err := userRepo.FindByID(user.ID)
+		if err != nil {
+			return err
+		}
+		events := newOutbox(repositories.NewTransactor(tx), s.outbox.repo)
+		if err := changeUserStatus(userRepo, audit, events, anonymized, models.DeletedStatus, "self-service deletion", nil, nil); err != nil {
+			return err
+		}
+		return userRepo.SetDeletionSchedule(user.ID, nil)
+	})
+}
+
+// cancelScheduledDeletion is called on a successful login by a user with a pending deletion.
+func (s *authService) cancelScheduledDeletion(user *models.User, meta models.RequestMeta) {
+	if err := s.userRepo.SetDeletionSchedule(user.ID, nil); err != nil {
+		log.Printf("Failed to cancel deletion for user %s: %v", user.ID, err)
+		return
+	}
+	user.DeletionScheduledAt = nil
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventDeletionCancelled, models.OutcomeSuccess, "", meta)
+
+	go func() {
+		body := "You logged back in, so the scheduled deletion of your account has been cancelled."
+		if err := utils.SendEmail(emailConfigFromEnv(), user.Email, "Account deletion cancelled", body); err != nil {
+			log.Printf("Failed to send deletion cancellation to %s: %v", user.Email, err)
+		}
+	}()
+}
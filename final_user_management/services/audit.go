@@ -0,0 +1,153 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+
+	"gorm.io/gorm"
+)
+
+// auditIgnoredFields change on every write and would only add noise to diffs.
+var auditIgnoredFields = map[string]bool{"updatedAt": true}
+
+// auditor appends entries to the audit log for one request. Services get one scoped to the
+// request with ForRequest; outside a request (background jobs, startup) it records the system
+// as the actor. Changes are audited inside their transaction through withTx, so a change is
+// committed exactly when its entry is.
+type auditor struct {
+	repo    repositories.AuditRepository
+	context models.AuditContext
+}
+
+func newAuditor(repo repositories.AuditRepository) auditor {
+	return auditor{repo: repo}
+}
+
+// forRequest returns the auditor recording the request's actor and client.
+func (a auditor) forRequest(context models.AuditContext) auditor {
+	return auditor{repo: a.repo, context: context}
+}
+
+// withTx returns the auditor appending its entries inside the transaction.
+func (a auditor) withTx(tx *gorm.DB) auditor {
+	if a.repo == nil {
+		return a
+	}
+	return auditor{repo: a.repo.WithTx(tx), context: a.context}
+}
+
+// record appends an entry about the target. before and after are the target as it was and as it
+// is now (either may be nil), and their differing fields are stored with secrets redacted.
+// An action that cannot be audited must not happen: callers record inside the transaction of
+// the change, or before acting when there is nothing to roll back, and give up on an error.
+func (a auditor) record(action models.AuditAction, targetType models.AuditTargetType, targetID string, before, after interface{}, details map[string]interface{}) error {
+	if a.repo == nil {
+		return nil
+	}
+	entry := &models.AuditEntry{
+		OrganizationID: a.context.OrganizationID,
+		Action:         action,
+		TargetType:     targetType,
+		TargetID:       targetID,
+		Changes:        auditChanges(targetType, before, after),
+		Details:        details,
+		IP:             a.context.IP,
+		UserAgent:      a.context.UserAgent,
+		RequestID:      a.context.RequestID,
+	}
+	if a.context.ActorID != "" {
+		entry.ActorID = &a.context.ActorID
+	}
+	if a.context.ImpersonatorID != "" {
+		entry.ImpersonatorID = &a.context.ImpersonatorID
+	}
+	// System actions run unscoped; they belong to the organization of what they act on
+	if entry.OrganizationID == "" {
+		entry.OrganizationID = auditOrganization(before, after)
+	}
+	return a.repo.Append(entry)
+}
+
+// auditOrganization finds the organization of a user, role or group being audited.
+func auditOrganization(records ...interface{}) string {
+	for _, record := range records {
+		switch r := record.(type) {
+		case *models.User:
+			if r != nil {
+				return r.OrganizationID
+			}
+		case *models.Role:
+			if r != nil {
+				return r.OrganizationID
+			}
+		case *models.Group:
+			if r != nil {
+				return r.OrganizationID
+			}
+		}
+	}
+	return ""
+}
+
+// auditChanges compares the JSON fields of before and after. User fields that staff without
+// users:read-pii only see masked, or that are never shown, are recorded as changed without
+// their values.
+func auditChanges(targetType models.AuditTargetType, before, after interface{}) map[string]models.AuditChange {
+	old, current := auditFields(before), auditFields(after)
+	changes := map[string]models.AuditChange{}
+	for name := range mergeKeys(old, current) {
+		if auditIgnoredFields[name] || reflect.DeepEqual(old[name], current[name]) {
+			continue
+		}
+		change := models.AuditChange{From: old[name], To: current[name]}
+		if targetType == models.AuditTargetUser && auditRedactsUserField(name) {
+			change = models.AuditChange{From: redacted(old[name]), To: redacted(current[name])}
+		}
+		changes[name] = change
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return changes
+}
+
+func auditRedactsUserField(name string) bool {
+	rule, listed := models.UserFieldPolicy[name]
+	return !listed || rule.Admin != models.FieldVisible
+}
+
+func redacted(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	return models.AuditRedacted
+}
+
+// auditFields turns a record into its JSON fields, or nil when there is no record.
+func auditFields(record interface{}) map[string]interface{} {
+	if value := reflect.ValueOf(record); !value.IsValid() || value.Kind() == reflect.Ptr && value.IsNil() {
+		return nil
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+func mergeKeys(maps ...map[string]interface{}) map[string]bool {
+	keys := map[string]bool{}
+	for _, m := range maps {
+		for key := range m {
+			keys[key] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+// auditVerifyBatchSize is how many entries are read at a time while verifying a chain.
+const auditVerifyBatchSize = 500
+
+// AuditService reads an organization's audit log. Entries are written by the other services.
+type AuditService interface {
+	GetAuditLog(params models.GetAuditLogRequest) (models.PaginatedResponse, error)
+	Verify() (models.AuditVerification, error)
+	ForTenant(organizationID string) AuditService
+}
+
+type auditService struct {
+	auditRepo      repositories.AuditRepository
+	organizationID string
+}
+
+func NewAuditService(auditRepo repositories.AuditRepository) AuditService {
+	return &auditService{auditRepo: auditRepo}
+}
+
+// ForTenant returns the service working on the organization's audit log.
+func (s *auditService) ForTenant(organizationID string) AuditService {
+	return &auditService{auditRepo: s.auditRepo.WithTenant(organizationID), organizationID: organizationID}
+}
+
+func (s *auditService) GetAuditLog(params models.GetAuditLogRequest) (models.PaginatedResponse, error) {
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	entries, total, err := s.auditRepo.FindAll(params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+	return newPaginatedResponse(entries, total, params.PageNumber, params.PageSize), nil
+}
+
+// Verify walks the organization's chain from the first entry, checking that sequence numbers
+// have no gaps, that each entry points at the previous entry's hash and that each hash matches
+// the entry's contents.
+func (s *auditService) Verify() (models.AuditVerification, error) {
+	if s.organizationID == "" {
+		return models.AuditVerification{}, errors.New("no organization to verify")
+	}
+
+	result := models.AuditVerification{Valid: true}
+	var previous *models.AuditEntry
+	for {
+		after := int64(0)
+		if previous != nil {
+			after = previous.Sequence
+		}
+		entries, err := s.auditRepo.FindChain(s.organizationID, after, auditVerifyBatchSize)
+		if err != nil {
+			return models.AuditVerification{}, err
+		}
+		if len(entries) == 0 {
+			return result, nil
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			expectedSequence, expectedPrevHash := int64(1), ""
+			if previous != nil {
+				expectedSequence, expectedPrevHash = previous.Sequence+1, previous.Hash
+			}
+			reason := ""
+			switch {
+			case entry.Sequence != expectedSequence:
+				reason = fmt.Sprintf("expected entry %d, found %d", expectedSequence, entry.Sequence)
+			case entry.PrevHash != expectedPrevHash:
+				reason = "entry does not link to the previous entry"
+			case entry.Hash != entry.ComputeHash():
+				reason = "entry contents do not match its hash"
+			}
+			if reason != "" {
+				sequence := expectedSequence
+				return models.AuditVerification{Checked: result.Checked, FirstInvalidSequence: &sequence, Reason: reason}, nil
+			}
+			result.Checked++
+			previous = entry
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
-	"os"
 	"strconv"
 	"time"
 
@@ -14,34 +13,82 @@ import (
 	"github.com/umwaribenie/final_user_management/models"
 	"github.com/umwaribenie/final_user_management/repositories"
 	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
 )
 
 var ctx = context.Background()
 
 type AuthService interface {
 	CheckAuth() (models.SuccessResponse, error)
-	ConfirmPasswordResetOtp(request models.ConfirmOtpRequest) (models.SuccessResponse, error)
-	Login(request models.LoginRequest) (models.LoginResponse, error)
-	RequestPasswordReset(request models.PasswordResetRequest) (models.SuccessResponse, error)
-	ResetPasswordViaEmail(request models.ResetPasswordRequest) (models.SuccessResponse, error)
-	UpdatePassword(userID string, request models.UpdatePasswordRequest) (models.SuccessResponse, error)
-	ResetPasswordWithToken(tokenString string, newPassword string) (models.SuccessResponse, error)
+	ConfirmPasswordResetOtp(request models.ConfirmOtpRequest, meta models.RequestMeta) (models.SuccessResponse, error)
+	Login(request models.LoginRequest, meta models.RequestMeta) (models.LoginResponse, error)
+	RequestPasswordReset(request models.PasswordResetRequest, meta models.RequestMeta) (models.SuccessResponse, error)
+	ResetPasswordViaEmail(request models.ResetPasswordRequest, meta models.RequestMeta) (models.SuccessResponse, error)
+	UpdatePassword(userID string, request models.UpdatePasswordRequest, meta models.RequestMeta) (models.SuccessResponse, error)
+	ResetPasswordWithToken(tokenString string, newPassword string, meta models.RequestMeta) (models.SuccessResponse, error)
+	ReportSuspiciousLogin(tokenString string, meta models.RequestMeta) (models.SuccessResponse, error)
+	SendReauthOtp(userID string, meta models.RequestMeta) (models.SuccessResponse, error)
+	Reauthenticate(userID string, sessionID string, request models.ReauthenticateRequest, meta models.RequestMeta) (models.LoginResponse, error)
+	Introspect(userID string) (models.IntrospectionResponse, error)
+	ForTenant(organizationID string) AuthService
+	ForRequest(audit models.AuditContext) AuthService
 }
 
 type authService struct {
-	userRepo    repositories.UserRepository
-	redisClient *redis.Client
+	userRepo          repositories.UserRepository
+	securityEventRepo repositories.SecurityEventRepository
+	sessionRepo       repositories.SessionRepository
+	sessionService    SessionService
+	roleRepo          repositories.RoleRepository
+	groupRepo         repositories.GroupRepository
+	redisClient       *redis.Client
+	audit             auditor
+	outbox            outbox
 }
 
 // NewAuthService constructor
-func NewAuthService(userRepo repositories.UserRepository, redisClient *redis.Client) AuthService {
+func NewAuthService(userRepo repositories.UserRepository, securityEventRepo repositories.SecurityEventRepository, sessionRepo repositories.SessionRepository, sessionService SessionService, roleRepo repositories.RoleRepository, groupRepo repositories.GroupRepository, redisClient *redis.Client, auditRepo repositories.AuditRepository, transactor repositories.Transactor, outboxRepo repositories.OutboxRepository) AuthService {
 	return &authService{
-		userRepo:    userRepo,
-		redisClient: redisClient,
+		userRepo:          userRepo,
+		securityEventRepo: securityEventRepo,
+		sessionRepo:       sessionRepo,
+		sessionService:    sessionService,
+		roleRepo:          roleRepo,
+		groupRepo:         groupRepo,
+		redisClient:       redisClient,
+		audit:             newAuditor(auditRepo),
+		outbox:            newOutbox(transactor, outboxRepo),
 	}
 }
 
-func (s *authService) ResetPasswordViaEmail(request models.ResetPasswordRequest) (models.SuccessResponse, error) {
+// ForTenant returns the service authenticating the organization's users.
+func (s *authService) ForTenant(organizationID string) AuthService {
+	scoped := *s
+	scoped.userRepo = s.userRepo.WithTenant(organizationID)
+	scoped.roleRepo = s.roleRepo.WithTenant(organizationID)
+	scoped.groupRepo = s.groupRepo.WithTenant(organizationID)
+	scoped.audit = s.audit.forRequest(models.AuditContext{OrganizationID: organizationID})
+	return &scoped
+}
+
+// ForRequest returns the service working in the request's organization and recording its actor
+// in the audit log.
+func (s *authService) ForRequest(audit models.AuditContext) AuthService {
+	scoped := s.ForTenant(audit.OrganizationID).(*authService)
+	scoped.audit = s.audit.forRequest(audit)
+	return scoped
+}
+
+// forLinkTenant returns the service working in the organization an email link was issued for,
+// whichever host the link was opened on.
+func (s *authService) forLinkTenant(organizationID string) *authService {
+	audit := s.audit.context
+	audit.OrganizationID = organizationID
+	return s.ForRequest(audit).(*authService)
+}
+
+func (s *authService) ResetPasswordViaEmail(request models.ResetPasswordRequest, meta models.RequestMeta) (models.SuccessResponse, error) {
 	// 1. Find user
 	var (
 		user *models.User
@@ -67,23 +114,17 @@ func (s *authService) ResetPasswordViaEmail(request models.ResetPasswordRequest)
 
 	// 3. Store OTP two ways
 	redisOTPKey := "otp:" + otp
-	redisEmailKey := "email:" + user.Email
+	redisUserKey := "otp-user:" + user.ID
 	pipe := s.redisClient.TxPipeline()
-	pipe.Set(ctx, redisOTPKey, user.Email, 5*time.Minute)
-	pipe.Set(ctx, redisEmailKey, otp, 5*time.Minute)
+	pipe.Set(ctx, redisOTPKey, user.ID, 5*time.Minute)
+	pipe.Set(ctx, redisUserKey, otp, 5*time.Minute)
 	if _, err = pipe.Exec(ctx); err != nil {
 		log.Printf("Redis error: %v", err)
 		return models.SuccessResponse{}, errors.New("failed to store OTP")
 	}
 
 	// 4. Send email
-	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
-	emailCfg := utils.EmailConfig{
-		SMTPHost: os.Getenv("SMTP_HOST"), SMTPPort: smtpPort,
-		SMTPUsername: os.Getenv("SMTP_USERNAME"),
-		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
-		FromEmail:    os.Getenv("FROM_EMAIL"),
-	}
+	emailCfg := emailConfigFromEnv()
 	body := fmt.Sprintf("Your OTP for password reset is: <strong>%s</strong><br>It expires in 5 minutes.", otp)
 	if err := utils.SendPasswordResetEmail(emailCfg, user.Email, body); err != nil {
 		log.Printf("Email send error: %v", err)
@@ -91,6 +132,7 @@ func (s *authService) ResetPasswordViaEmail(request models.ResetPasswordRequest)
 	}
 
 	log.Printf("OTP %s emailed to %s", otp, user.Email)
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordResetRequested, models.OutcomeSuccess, "", meta)
 	return models.SuccessResponse{Message: "Password reset OTP sent via email"}, nil
 }
 
@@ -99,29 +141,73 @@ func (s *authService) CheckAuth() (models.SuccessResponse, error) {
 	return models.SuccessResponse{Message: "User is authenticated"}, nil
 }
 
-func (s *authService) ConfirmPasswordResetOtp(req models.ConfirmOtpRequest) (models.SuccessResponse, error) {
-	// 1. Lookup email by OTP
-	email, err := s.redisClient.Get(ctx, "otp:"+req.Otp).Result()
+// Introspect resolves the user's current roles, groups and permissions. Group membership is
+// not carried in the access token, so services that need it call this instead.
+func (s *authService) Introspect(userID string) (models.IntrospectionResponse, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return models.IntrospectionResponse{}, errors.New("user not found")
+	}
+	roles, err := s.roleRepo.FindEffectiveByUserID(userID)
+	if err != nil {
+		return models.IntrospectionResponse{}, err
+	}
+	groups, err := s.groupRepo.FindAllByUserID(userID)
+	if err != nil {
+		return models.IntrospectionResponse{}, err
+	}
+	permissions, err := userPermissions(s.roleRepo, userID)
+	if err != nil {
+		return models.IntrospectionResponse{}, err
+	}
+
+	response := models.IntrospectionResponse{
+		Active:      user.Status == models.ActiveStatus,
+		UserID:      user.ID,
+		Username:    user.Username,
+		Role:        string(user.Role),
+		Roles:       make([]string, 0, len(roles)),
+		Groups:      make([]string, 0, len(groups)),
+		Permissions: permissions,
+	}
+	for _, role := range roles {
+		response.Roles = append(response.Roles, role.Name)
+	}
+	for _, group := range groups {
+		response.Groups = append(response.Groups, group.Name)
+	}
+	return response, nil
+}
+
+func (s *authService) ConfirmPasswordResetOtp(req models.ConfirmOtpRequest, meta models.RequestMeta) (models.SuccessResponse, error) {
+	// 1. Lookup user ID by OTP
+	userID, err := s.redisClient.Get(ctx, "otp:"+req.Otp).Result()
 	if err == redis.Nil {
+		recordSecurityEvent(s.securityEventRepo, nil, "", models.EventPasswordResetCompleted, models.OutcomeFailure, "invalid or expired OTP", meta)
 		return models.SuccessResponse{}, errors.New("invalid or expired OTP")
 	} else if err != nil {
 		return models.SuccessResponse{}, err
 	}
 
-	// 2. Find user by email
-	user, err := s.userRepo.FindByEmail(email)
+	// 2. Find the user. The OTP holds the user's ID rather than their email, which can be
+	// shared by accounts in different organizations.
+	user, err := s.userRepo.FindByID(userID)
 	if err != nil {
 		return models.SuccessResponse{}, errors.New("user not found")
 	}
 
 	// 3. Hash and update password
 	hashed, _ := utils.HashPassword(req.Password)
-	if err := s.userRepo.UpdatePassword(user.ID, hashed); err != nil {
+	err = updateUserPassword(s.userRepo, s.outbox, user, hashed, "otp", func(tx *gorm.DB) error {
+		return s.audit.withTx(tx).record(models.AuditUserPasswordReset, models.AuditTargetUser, user.ID, nil, nil, map[string]interface{}{"method": "otp"})
+	})
+	if err != nil {
 		return models.SuccessResponse{}, err
 	}
 
 	// 4. Cleanup Redis keys
-	s.redisClient.Del(ctx, "otp:"+req.Otp, "email:"+email)
+	s.redisClient.Del(ctx, "otp:"+req.Otp, "otp-user:"+userID)
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordResetCompleted, models.OutcomeSuccess, "", meta)
 
 	return models.SuccessResponse{Message: "Password reset successful"}, nil
 }
@@ -212,7 +298,7 @@ func (s *authService) ConfirmPasswordResetOtp(req models.ConfirmOtpRequest) (mod
 
 // services/auth_service.go
 
-func (s *authService) RequestPasswordReset(request models.PasswordResetRequest) (models.SuccessResponse, error) {
+func (s *authService) RequestPasswordReset(request models.PasswordResetRequest, meta models.RequestMeta) (models.SuccessResponse, error) {
 	// 1. Find user
 	var (
 		user *models.User
@@ -238,23 +324,17 @@ func (s *authService) RequestPasswordReset(request models.PasswordResetRequest)
 
 	// 3. Store OTP both ways in Redis (5-minute TTL)
 	redisOTPKey := "otp:" + otp
-	redisEmailKey := "email:" + user.Email
+	redisUserKey := "otp-user:" + user.ID
 	pipe := s.redisClient.TxPipeline()
-	pipe.Set(ctx, redisOTPKey, user.Email, 5*time.Minute)
-	pipe.Set(ctx, redisEmailKey, otp, 5*time.Minute)
+	pipe.Set(ctx, redisOTPKey, user.ID, 5*time.Minute)
+	pipe.Set(ctx, redisUserKey, otp, 5*time.Minute)
 	if _, err = pipe.Exec(ctx); err != nil {
 		log.Printf("Redis error: %v", err)
 		return models.SuccessResponse{}, errors.New("failed to store OTP")
 	}
 
 	// 4. Email the OTP
-	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
-	emailCfg := utils.EmailConfig{
-		SMTPHost: os.Getenv("SMTP_HOST"), SMTPPort: smtpPort,
-		SMTPUsername: os.Getenv("SMTP_USERNAME"),
-		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
-		FromEmail:    os.Getenv("FROM_EMAIL"),
-	}
+	emailCfg := emailConfigFromEnv()
 	body := fmt.Sprintf("Your OTP for password reset is: <strong>%s</strong><br>It expires in 5 minutes.", otp)
 	if err := utils.SendPasswordResetEmail(emailCfg, user.Email, body); err != nil {
 		log.Printf("Email send error: %v", err)
@@ -262,16 +342,18 @@ func (s *authService) RequestPasswordReset(request models.PasswordResetRequest)
 	}
 
 	log.Printf("OTP %s emailed to %s", otp, user.Email)
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordResetRequested, models.OutcomeSuccess, "", meta)
 	return models.SuccessResponse{Message: "Password reset OTP sent via email"}, nil
 }
 
-func (s *authService) UpdatePassword(userID string, request models.UpdatePasswordRequest) (models.SuccessResponse, error) {
+func (s *authService) UpdatePassword(userID string, request models.UpdatePasswordRequest, meta models.RequestMeta) (models.SuccessResponse, error) {
 	user, err := s.userRepo.FindByID(userID)
 	if err != nil {
 		return models.SuccessResponse{}, err
 	}
 
 	if !utils.CheckPasswordHash(request.OldPassword, user.Password) {
+		recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordChanged, models.OutcomeFailure, "old password is incorrect", meta)
 		return models.SuccessResponse{}, errors.New("old password is incorrect")
 	}
 
@@ -280,21 +362,27 @@ func (s *authService) UpdatePassword(userID string, request models.UpdatePasswor
 		return models.SuccessResponse{}, err
 	}
 
-	if err := s.userRepo.UpdatePassword(userID, hashedPassword); err != nil {
+	err = updateUserPassword(s.userRepo, s.outbox, user, hashedPassword, "self", func(tx *gorm.DB) error {
+		return s.audit.withTx(tx).record(models.AuditUserPasswordChanged, models.AuditTargetUser, user.ID, nil, nil, nil)
+	})
+	if err != nil {
 		return models.SuccessResponse{}, err
 	}
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordChanged, models.OutcomeSuccess, "", meta)
 
 	return models.SuccessResponse{Message: "Password updated successfully"}, nil
 }
 
-func (s *authService) ResetPasswordWithToken(tokenString string, newPassword string) (models.SuccessResponse, error) {
+func (s *authService) ResetPasswordWithToken(tokenString string, newPassword string, meta models.RequestMeta) (models.SuccessResponse, error) {
 	// 1. Verify token and extract email
-	email, err := utils.VerifyPasswordResetToken(tokenString)
+	organizationID, email, err := utils.VerifyPasswordResetToken(tokenString)
 	if err != nil {
+		recordSecurityEvent(s.securityEventRepo, nil, "", models.EventPasswordResetCompleted, models.OutcomeFailure, "invalid or expired reset token", meta)
 		return models.SuccessResponse{}, errors.New("invalid or expired reset token")
 	}
+	s = s.forLinkTenant(organizationID)
 
-	// 2. Find the user by email
+	// 2. Find the user by email in the organization the link was sent from
 	user, err := s.userRepo.FindByEmail(email)
 	if err != nil {
 		return models.SuccessResponse{}, errors.New("user not found")
@@ -307,9 +395,13 @@ func (s *authService) ResetPasswordWithToken(tokenString string, newPassword str
 	}
 
 	// 4. Update the user’s password in the DB
-	if err := s.userRepo.UpdatePassword(user.ID, hashedPwd); err != nil {
+	err = updateUserPassword(s.userRepo, s.outbox, user, hashedPwd, "link", func(tx *gorm.DB) error {
+		return s.audit.withTx(tx).record(models.AuditUserPasswordReset, models.AuditTargetUser, user.ID, nil, nil, map[string]interface{}{"method": "link"})
+	})
+	if err != nil {
 		return models.SuccessResponse{}, err
 	}
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordResetCompleted, models.OutcomeSuccess, "", meta)
 
 	return models.SuccessResponse{Message: "Password has been reset successfully"}, nil
 }
@@ -318,7 +410,7 @@ func GenerateOTP() string {
 	rand.Seed(time.Now().UnixNano())
 	return strconv.Itoa(rand.Intn(999999-100000) + 100000)
 }
-func (s *authService) Login(request models.LoginRequest) (models.LoginResponse, error) {
+func (s *authService) Login(request models.LoginRequest, meta models.RequestMeta) (models.LoginResponse, error) {
 	var user *models.User
 	var err error
 
@@ -337,21 +429,98 @@ func (s *authService) Login(request models.LoginRequest) (models.LoginResponse,
 		return models.LoginResponse{}, errors.New("username or clientID is required for login")
 	}
 
+	identifier := request.Username
+	if identifier == "" {
+		identifier = request.ClientID
+	}
+
 	// If user is still not found after checking all methods, credentials are invalid
 	if err != nil || user == nil {
+		recordSecurityEvent(s.securityEventRepo, nil, identifier, models.EventLogin, models.OutcomeFailure, "unknown user", meta)
 		return models.LoginResponse{}, errors.New("invalid credentials")
 	}
 
+	// Lift a suspension or lockout whose time is up, then refuse locked accounts
+	// before checking the password so they cannot be brute-forced
+	if err := releaseExpiredRestriction(s.userRepo, s.audit, s.outbox, user); err != nil {
+		log.Printf("Failed to release expired restriction for user %s: %v", user.ID, err)
+	}
+	if user.Status == models.LockedStatus {
+		recordSecurityEvent(s.securityEventRepo, user, identifier, models.EventLogin, models.OutcomeFailure, "account locked", meta)
+		return models.LoginResponse{}, errors.New("account is locked")
+	}
+
 	// Verify the password
 	if !utils.CheckPasswordHash(request.Password, user.Password) {
+		recordSecurityEvent(s.securityEventRepo, user, identifier, models.EventLogin, models.OutcomeFailure, "invalid password", meta)
+		s.registerFailedLogin(user, meta)
 		return models.LoginResponse{}, errors.New("invalid credentials")
 	}
 
+	// Only active accounts may sign in
+	if user.Status != models.ActiveStatus {
+		reason := fmt.Sprintf("account %s", user.Status)
+		recordSecurityEvent(s.securityEventRepo, user, identifier, models.EventLogin, models.OutcomeFailure, reason, meta)
+		return models.LoginResponse{}, fmt.Errorf("account is %s", user.Status)
+	}
+
+	// Recognise the device from its signed token, or issue a new one
+	deviceID, known := utils.VerifyDeviceToken(meta.DeviceToken)
+	var newDeviceToken string
+	if !known {
+		deviceID, newDeviceToken, err = utils.NewDeviceToken()
+		if err != nil {
+			return models.LoginResponse{}, errors.New("failed to generate device token")
+		}
+	}
+
+	permissions, err := userPermissions(s.roleRepo, user.ID)
+	if err != nil {
+		return models.LoginResponse{}, errors.New("failed to resolve permissions")
+	}
+
+	// Open a server-side session and bind the JWT to it
+	session, err := s.sessionService.Create(user.ID, deviceID, meta)
+	if err != nil {
+		return models.LoginResponse{}, errors.New("failed to create session")
+	}
+
 	// Generate JWT token
-	token, err := utils.GenerateJWT(user.ID, user.Username, string(user.Role))
+	token, err := utils.GenerateJWT(user.ID, user.OrganizationID, user.Username, string(user.Role), permissions, session.ID, session.ExpiresAt, time.Now(), []string{utils.AMRPassword})
 	if err != nil {
 		return models.LoginResponse{}, errors.New("failed to generate token")
 	}
 
-	return models.LoginResponse{AccessToken: token}, nil
+	if err := s.userRepo.UpdateLastLogin(user.ID, time.Now(), meta.IP); err != nil {
+		log.Printf("Failed to update last login for user %s: %v", user.ID, err)
+	}
+	recordSecurityEvent(s.securityEventRepo, user, identifier, models.EventLogin, models.OutcomeSuccess, "", meta)
+	s.checkLoginDevice(user, deviceID, meta)
+	if user.DeletionScheduledAt != nil {
+		s.cancelScheduledDeletion(user, meta)
+	}
+
+	return models.LoginResponse{AccessToken: token, DeviceToken: newDeviceToken}, nil
+}
+
+// registerFailedLogin counts a wrong password and locks the account once the limit is reached.
+func (s *authService) registerFailedLogin(user *models.User, meta models.RequestMeta) {
+	attempts, err := s.userRepo.IncrementFailedLogins(user.ID)
+	if err != nil {
+		log.Printf("Failed to count failed login for user %s: %v", user.ID, err)
+		return
+	}
+
+	maxAttempts, lockout := lockoutPolicyFromEnv()
+	if attempts < maxAttempts || user.Status != models.ActiveStatus {
+		return
+	}
+
+	until := time.Now().Add(lockout)
+	reason := fmt.Sprintf("%d consecutive failed logins", attempts)
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.LockedStatus, reason, nil, &until); err != nil {
+		log.Printf("Failed to lock user %s: %v", user.ID, err)
+		return
+	}
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventAccountLocked, models.OutcomeSuccess, reason, meta)
 }
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/policy"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+var (
+	ErrSubjectNotFound  = errors.New("subject not found")
+	ErrResourceNotFound = errors.New("user not found")
+)
+
+// AuthorizationService decides whether a user may act on another user, combining the
+// permissions their roles grant with the attribute-based rules of the policy engine.
+type AuthorizationService interface {
+	// Check decides for the authenticated viewer, using the permissions in their token.
+	Check(viewer models.Viewer, action string, resourceID string) (models.AuthorizationDecision, error)
+	// CheckFor decides for any user in the organization, using their current permissions.
+	CheckFor(subjectID string, action string, resourceID string) (models.AuthorizationDecision, error)
+	ForTenant(organizationID string) AuthorizationService
+}
+
+type authorizationService struct {
+	engine    *policy.Engine
+	userRepo  repositories.UserRepository
+	roleRepo  repositories.RoleRepository
+	groupRepo repositories.GroupRepository
+}
+
+func NewAuthorizationService(engine *policy.Engine, userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, groupRepo repositories.GroupRepository) AuthorizationService {
+	return &authorizationService{engine, userRepo, roleRepo, groupRepo}
+}
+
+// ForTenant returns the service working on the organization's users.
+func (s *authorizationService) ForTenant(organizationID string) AuthorizationService {
+	return &authorizationService{s.engine, s.userRepo.WithTenant(organizationID), s.roleRepo.WithTenant(organizationID), s.groupRepo.WithTenant(organizationID)}
+}
+
+func (s *authorizationService) Check(viewer models.Viewer, action string, resourceID string) (models.AuthorizationDecision, error) {
+	user, err := s.userRepo.FindByID(viewer.UserID)
+	if err != nil {
+		return models.AuthorizationDecision{}, ErrSubjectNotFound
+	}
+	subject, err := s.attributes(user)
+	if err != nil {
+		return models.AuthorizationDecision{}, err
+	}
+	// The token's permissions are what the request was authenticated with
+	subject["permissions"] = viewer.Permissions
+	return s.decide(subject, action, resourceID)
+}
+
+func (s *authorizationService) CheckFor(subjectID string, action string, resourceID string) (models.AuthorizationDecision, error) {
+	user, err := s.userRepo.FindByID(subjectID)
+	if err != nil {
+		return models.AuthorizationDecision{}, ErrSubjectNotFound
+	}
+	subject, err := s.attributes(user)
+	if err != nil {
+		return models.AuthorizationDecision{}, err
+	}
+	return s.decide(subject, action, resourceID)
+}
+
+func (s *authorizationService) decide(subject map[string]interface{}, action string, resourceID string) (models.AuthorizationDecision, error) {
+	// Deleted users are still the target of restores
+	target, err := s.userRepo.FindByID(resourceID)
+	if err != nil {
+		if target, err = s.userRepo.FindDeletedByID(resourceID); err != nil {
+			return models.AuthorizationDecision{}, ErrResourceNotFound
+		}
+	}
+	resource, err := s.attributes(target)
+	if err != nil {
+		return models.AuthorizationDecision{}, err
+	}
+
+	decision := s.engine.Evaluate(policy.Input{Action: action, Subject: subject, Resource: resource})
+	return models.AuthorizationDecision{
+		Allowed:  decision.Allowed,
+		Effect:   string(decision.Effect),
+		PolicyID: decision.PolicyID,
+		Reason:   decision.Reason,
+		Action:   action,
+		Subject:  subject,
+		Resource: resource,
+	}, nil
+}
+
+// attributes describes a user to the policy engine: their identity, department and status, the
+// names of their effective roles and groups, and the permissions those roles grant.
+func (s *authorizationService) attributes(user *models.User) (map[string]interface{}, error) {
+	roles, err := s.roleRepo.FindEffectiveByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.groupRepo.FindAllByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := userPermissions(s.roleRepo, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	roleNames := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+	}
+	groupNames := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupNames = append(groupNames, group.Name)
+	}
+	return map[string]interface{}{
+		"id":             user.ID,
+		"organizationId": user.OrganizationID,
+		"username":       user.Username,
+		"role":           string(user.Role),
+		"roles":          roleNames,
+		"groups":         groupNames,
+		"department":     user.Department,
+		"status":         string(user.Status),
+		"permissions":    permissions,
+	}, nil
+}
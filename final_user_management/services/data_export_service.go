@@ -0,0 +1,217 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+// DataExportService assembles everything held about a user into a ZIP of JSON files
+// for data-subject access requests.
+type DataExportService interface {
+	NeedsBackgroundExport(userID string) (bool, error)
+	WriteUserExport(userID string, requesterID string, meta models.RequestMeta, w io.Writer) error
+	StartUserExport(userID string, requesterID string, meta models.RequestMeta) (*models.Job, error)
+	ForTenant(organizationID string) DataExportService
+	ForRequest(audit models.AuditContext) DataExportService
+}
+
+type dataExportService struct {
+	userRepo          repositories.UserRepository
+	sessionRepo       repositories.SessionRepository
+	securityEventRepo repositories.SecurityEventRepository
+	jobService        JobService
+	audit             auditor
+}
+
+func NewDataExportService(userRepo repositories.UserRepository, sessionRepo repositories.SessionRepository, securityEventRepo repositories.SecurityEventRepository, jobService JobService, auditRepo repositories.AuditRepository) DataExportService {
+	return &dataExportService{userRepo, sessionRepo, securityEventRepo, jobService, newAuditor(auditRepo)}
+}
+
+// ForTenant returns the service exporting the organization's users.
+func (s *dataExportService) ForTenant(organizationID string) DataExportService {
+	return &dataExportService{s.userRepo.WithTenant(organizationID), s.sessionRepo, s.securityEventRepo, s.jobService.ForTenant(organizationID), s.audit.forRequest(models.AuditContext{OrganizationID: organizationID})}
+}
+
+// ForRequest returns the service working in the request's organization and recording its actor
+// in the audit log.
+func (s *dataExportService) ForRequest(audit models.AuditContext) DataExportService {
+	scoped := s.ForTenant(audit.OrganizationID).(*dataExportService)
+	scoped.audit = s.audit.forRequest(audit)
+	return scoped
+}
+
+// exportSyncLimitFromEnv is the number of security events above which an export is
+// generated in the background instead of streamed in the response.
+func exportSyncLimitFromEnv() int64 {
+	limit, err := strconv.ParseInt(os.Getenv("EXPORT_SYNC_EVENT_LIMIT"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = 5000
+	}
+	return limit
+}
+
+func (s *dataExportService) NeedsBackgroundExport(userID string) (bool, error) {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return false, errors.New("user not found")
+	}
+	events, err := s.securityEventRepo.CountByUserID(userID)
+	if err != nil {
+		return false, err
+	}
+	return events > exportSyncLimitFromEnv(), nil
+}
+
+// WriteUserExport streams the archive to w. It is audited before anything is sent, since a
+// stream that fails halfway has still disclosed part of the data.
+func (s *dataExportService) WriteUserExport(userID string, requesterID string, meta models.RequestMeta, w io.Writer) error {
+	if err := s.audit.record(models.AuditUserDataExported, models.AuditTargetUser, userID, nil, nil, nil); err != nil {
+		return err
+	}
+	if err := s.writeArchive(userID, w); err != nil {
+		return err
+	}
+	s.recordExport(userID, requesterID, meta)
+	return nil
+}
+
+func (s *dataExportService) StartUserExport(userID string, requesterID string, meta models.RequestMeta) (*models.Job, error) {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	return s.jobService.Start(models.JobTypeDataExport, requesterID, &userID, func(job *models.Job) (JobOutput, error) {
+		if err := os.MkdirAll(JobDir(), 0o700); err != nil {
+			return JobOutput{}, err
+		}
+		path := filepath.Join(JobDir(), job.ID+".zip")
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return JobOutput{}, err
+		}
+		defer file.Close()
+
+		output := JobOutput{FilePath: path, FileName: exportFileName(userID)}
+		if err := s.writeArchive(userID, file); err != nil {
+			return output, err
+		}
+		// A failed job's file is removed, so the archive is never downloadable unaudited
+		if err := s.audit.record(models.AuditUserDataExported, models.AuditTargetUser, userID, nil, nil, nil); err != nil {
+			return output, err
+		}
+		s.recordExport(userID, requesterID, meta)
+		return output, nil
+	})
+}
+
+// exportFileName is the suggested download name for a user's data export.
+func exportFileName(userID string) string {
+	return fmt.Sprintf("user-%s-export-%s.zip", userID, time.Now().UTC().Format("20060102"))
+}
+
+// exportNotHeld names the personal data an export might be expected to contain that this system
+// does not store, so that its absence is not mistaken for an omission.
+var exportNotHeld = []string{
+	"consents: not stored by this system",
+	"linked identities: not stored by this system",
+	"notifications: not stored by this system",
+}
+
+// writeArchive writes the ZIP: one JSON file per kind of data, plus a manifest.
+func (s *dataExportService) writeArchive(userID string, w io.Writer) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	sessions, err := s.sessionRepo.FindByUserID(userID)
+	if err != nil {
+		return err
+	}
+	devices, err := s.sessionRepo.FindKnownDevices(userID)
+	if err != nil {
+		return err
+	}
+	events, err := s.securityEventRepo.FindAllByUserID(userID)
+	if err != nil {
+		return err
+	}
+	statusChanges, err := s.userRepo.FindStatusChanges(userID)
+	if err != nil {
+		return err
+	}
+	auditEntries := []models.AuditEntry{}
+	if s.audit.repo != nil {
+		if auditEntries, err = s.audit.repo.WithTenant(user.OrganizationID).FindByUser(userID); err != nil {
+			return err
+		}
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"sessions.json", sessions},
+		{"known_devices.json", devices},
+		{"login_history.json", events},
+		{"status_changes.json", statusChanges},
+		{"audit_events.json", auditEntries},
+	}
+
+	archive := zip.NewWriter(w)
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if err := writeZipJSON(archive, file.name, file.data); err != nil {
+			return err
+		}
+		names = append(names, file.name)
+	}
+	manifest := map[string]interface{}{
+		"userId":      userID,
+		"generatedAt": time.Now().UTC(),
+		"files":       names,
+		"notHeld":     exportNotHeld,
+	}
+	if err := writeZipJSON(archive, "manifest.json", manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writeZipJSON(archive *zip.Writer, name string, data interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// recordExport writes the export to the subject's security feed, naming the requester when
+// it was someone else (an admin).
+func (s *dataExportService) recordExport(userID string, requesterID string, meta models.RequestMeta) {
+	event := &models.SecurityEvent{
+		UserID:    &userID,
+		Type:      models.EventDataExported,
+		Outcome:   models.OutcomeSuccess,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+	}
+	if requesterID != userID {
+		event.ActorID = &requesterID
+	}
+	if err := s.securityEventRepo.Create(event); err != nil {
+		log.Printf("Failed to record %s security event: %v", event.Type, err)
+	}
+}
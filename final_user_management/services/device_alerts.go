@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/utils"
+)
+
+// emailConfigFromEnv reads the SMTP settings used for all outgoing email.
+func emailConfigFromEnv() utils.EmailConfig {
+	smtpPort, _ := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	return utils.EmailConfig{
+		SMTPHost: os.Getenv("SMTP_HOST"), SMTPPort: smtpPort,
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		FromEmail:    os.Getenv("FROM_EMAIL"),
+	}
+}
+
+// appBaseURL is the externally reachable base URL used when building links in emails.
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return base
+	}
+	return "http://localhost:8080"
+}
+
+// checkLoginDevice remembers the device and network range of a successful login and
+// emails the user when either has never been seen before. The very first login of an
+// account only seeds the list, since there is nothing to compare it against.
+func (s *authService) checkLoginDevice(user *models.User, deviceID string, meta models.RequestMeta) {
+	prefix := utils.IPNetworkPrefix(meta.IP)
+
+	known, err := s.sessionRepo.FindKnownDevices(user.ID)
+	if err != nil {
+		log.Printf("Failed to load known devices for user %s: %v", user.ID, err)
+		return
+	}
+
+	deviceKnown, prefixKnown := false, false
+	for _, device := range known {
+		if device.DeviceID == deviceID {
+			deviceKnown = true
+		}
+		if device.IPPrefix == prefix {
+			prefixKnown = true
+		}
+	}
+
+	if err := s.sessionRepo.TouchKnownDevice(&models.KnownDevice{
+		UserID:     user.ID,
+		DeviceID:   deviceID,
+		IPPrefix:   prefix,
+		UserAgent:  meta.UserAgent,
+		LastSeenAt: time.Now(),
+	}); err != nil {
+		log.Printf("Failed to record known device for user %s: %v", user.ID, err)
+	}
+
+	if len(known) == 0 || (deviceKnown && prefixKnown) {
+		return
+	}
+
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventNewDeviceLogin, models.OutcomeSuccess, "", meta)
+	go s.sendNewDeviceEmail(user, meta)
+}
+
+func (s *authService) sendNewDeviceEmail(user *models.User, meta models.RequestMeta) {
+	if user.Email == "" {
+		return
+	}
+	token, err := utils.GenerateSecurityAlertToken(user.OrganizationID, user.ID)
+	if err != nil {
+		log.Printf("Failed to generate security alert token for user %s: %v", user.ID, err)
+		return
+	}
+	link := fmt.Sprintf("%s/auth/not-me?token=%s", appBaseURL(), token)
+	body := fmt.Sprintf(
+		"We noticed a new sign-in to your account.<br><br>"+
+			"<strong>Time:</strong> %s<br><strong>IP address:</strong> %s<br><strong>Device:</strong> %s<br><br>"+
+			"If this was you, you can ignore this email.<br>"+
+			"If this wasn't you, <a href=\"%s\">click here</a> to sign out everywhere and reset your password.",
+		time.Now().UTC().Format(time.RFC1123), meta.IP, meta.UserAgent, link,
+	)
+	if err := utils.SendEmail(emailConfigFromEnv(), user.Email, "New sign-in to your account", body); err != nil {
+		log.Printf("Failed to send new device email to %s: %v", user.Email, err)
+	}
+}
+
+func (s *authService) ReportSuspiciousLogin(tokenString string, meta models.RequestMeta) (models.SuccessResponse, error) {
+	// 1. Verify the link token
+	organizationID, userID, err := utils.VerifySecurityAlertToken(tokenString)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("invalid or expired link")
+	}
+	s = s.forLinkTenant(organizationID)
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("user not found")
+	}
+
+	// 2. Sign the account out everywhere
+	if err := s.sessionService.RevokeAll(user.ID); err != nil {
+		return models.SuccessResponse{}, errors.New("failed to revoke sessions")
+	}
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventSuspiciousLoginReported, models.OutcomeSuccess, "", meta)
+
+	// 3. Start a password reset
+	resetToken, err := utils.GeneratePasswordResetToken(user.OrganizationID, user.Email)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("failed to start password reset")
+	}
+	link := fmt.Sprintf("%s/auth/reset-password?token=%s", appBaseURL(), resetToken)
+	body := fmt.Sprintf(
+		"All sessions on your account have been signed out.<br>"+
+			"<a href=\"%s\">Choose a new password</a> to sign in again. The link expires in 1 hour.",
+		link,
+	)
+	if err := utils.SendEmail(emailConfigFromEnv(), user.Email, "Reset your password", body); err != nil {
+		return models.SuccessResponse{}, errors.New("failed to send password reset email")
+	}
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordResetRequested, models.OutcomeSuccess, "", meta)
+
+	return models.SuccessResponse{Message: "All sessions have been signed out and a password reset email has been sent"}, nil
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+// defaultMasterKeyFile is where a master key is generated when none is configured.
+const defaultMasterKeyFile = "field_encryption.key"
+
+// FieldEncryptionService manages the data keys behind encrypted user columns.
+// Data keys are random AES-256 keys stored wrapped by a master key that never leaves config.
+type FieldEncryptionService interface {
+	LoadKeys() error
+	RotateDataKey(requestedBy string) (*models.Job, error)
+	ReencryptUsers() (int, error)
+	ForRequest(audit models.AuditContext) FieldEncryptionService
+}
+
+type fieldEncryptionService struct {
+	dataKeyRepo repositories.DataKeyRepository
+	userRepo    repositories.UserRepository
+	jobService  JobService
+	masterKey   []byte
+	audit       auditor
+	transactor  repositories.Transactor
+}
+
+func NewFieldEncryptionService(dataKeyRepo repositories.DataKeyRepository, userRepo repositories.UserRepository, jobService JobService, auditRepo repositories.AuditRepository, transactor repositories.Transactor) FieldEncryptionService {
+	return &fieldEncryptionService{dataKeyRepo: dataKeyRepo, userRepo: userRepo, jobService: jobService, audit: newAuditor(auditRepo), transactor: transactor}
+}
+
+// ForRequest returns the service recording the request's actor in the audit log.
+func (s *fieldEncryptionService) ForRequest(audit models.AuditContext) FieldEncryptionService {
+	scoped := *s
+	scoped.audit = s.audit.forRequest(audit)
+	return &scoped
+}
+
+// masterKeysFromEnv returns the current master key and, while a master key rotation is in
+// progress, the previous one. The current key comes from FIELD_ENCRYPTION_MASTER_KEY (base64)
+// or else from the key file at FIELD_ENCRYPTION_MASTER_KEY_FILE, which is created if missing.
+func masterKeysFromEnv() (current []byte, previous []byte, err error) {
+	if encoded := os.Getenv("FIELD_ENCRYPTION_MASTER_KEY"); encoded != "" {
+		if current, err = decodeMasterKey(encoded); err != nil {
+			return nil, nil, fmt.Errorf("FIELD_ENCRYPTION_MASTER_KEY: %w", err)
+		}
+	} else {
+		path := os.Getenv("FIELD_ENCRYPTION_MASTER_KEY_FILE")
+		if path == "" {
+			path = defaultMasterKeyFile
+		}
+		if current, err = loadOrCreateMasterKeyFile(path); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if encoded := os.Getenv("FIELD_ENCRYPTION_PREVIOUS_MASTER_KEY"); encoded != "" {
+		if previous, err = decodeMasterKey(encoded); err != nil {
+			return nil, nil, fmt.Errorf("FIELD_ENCRYPTION_PREVIOUS_MASTER_KEY: %w", err)
+		}
+	}
+	return current, previous, nil
+}
+
+func decodeMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("master key must be 32 bytes")
+	}
+	return key, nil
+}
+
+func loadOrCreateMasterKeyFile(path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if err == nil {
+		return decodeMasterKey(string(contents))
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := utils.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated a new field encryption master key at %s; back it up, data encrypted with it cannot be recovered without it", path)
+	return key, nil
+}
+
+// fieldReencryptBatchSizeFromEnv returns how many users are re-encrypted per batch.
+func fieldReencryptBatchSizeFromEnv() int {
+	size, err := strconv.Atoi(os.Getenv("FIELD_REENCRYPT_BATCH_SIZE"))
+	if err != nil || size <= 0 {
+		size = 500
+	}
+	return size
+}
+
+// LoadKeys unwraps every data key and installs them for the encrypted serializer, creating the
+// first encryption and blind index keys on a fresh database. Keys still wrapped by the previous
+// master key are rewrapped with the current one.
+func (s *fieldEncryptionService) LoadKeys() error {
+	current, previous, err := masterKeysFromEnv()
+	if err != nil {
+		return err
+	}
+	s.masterKey = current
+
+	keys, err := s.dataKeyRepo.FindAll()
+	if err != nil {
+		return err
+	}
+
+	dataKeys := make(map[string][]byte, len(keys))
+	var activeID string
+	var indexKey []byte
+	for i := range keys {
+		key, err := s.unwrap(&keys[i], previous)
+		if err != nil {
+			return err
+		}
+		switch keys[i].Purpose {
+		case models.DataKeyEncryption:
+			dataKeys[keys[i].ID] = key
+			if keys[i].Active {
+				activeID = keys[i].ID
+			}
+		case models.DataKeyBlindIndex:
+			indexKey = key
+		}
+	}
+
+	if activeID == "" {
+		created, key, err := s.createDataKey(models.DataKeyEncryption, true)
+		if err != nil {
+			return err
+		}
+		activeID = created.ID
+		dataKeys[activeID] = key
+	}
+	if indexKey == nil {
+		if _, indexKey, err = s.createDataKey(models.DataKeyBlindIndex, true); err != nil {
+			return err
+		}
+	}
+
+	utils.SetFieldKeyring(activeID, dataKeys, indexKey, s.loadDataKey)
+	return nil
+}
+
+// unwrap decrypts a stored data key, rewrapping it with the current master key if it was
+// wrapped by the previous one.
+func (s *fieldEncryptionService) unwrap(dataKey *models.DataKey, previous []byte) ([]byte, error) {
+	if dataKey.MasterKeyID == utils.MasterKeyID(s.masterKey) {
+		return utils.UnwrapDataKey(s.masterKey, dataKey.WrappedKey)
+	}
+	if previous == nil || dataKey.MasterKeyID != utils.MasterKeyID(previous) {
+		return nil, fmt.Errorf("data key %s is wrapped by unknown master key %s", dataKey.ID, dataKey.MasterKeyID)
+	}
+
+	key, err := utils.UnwrapDataKey(previous, dataKey.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := utils.WrapDataKey(s.masterKey, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.dataKeyRepo.Rewrap(dataKey.ID, wrapped, utils.MasterKeyID(s.masterKey)); err != nil {
+		return nil, err
+	}
+	log.Printf("Rewrapped data key %s with the current master key", dataKey.ID)
+	return key, nil
+}
+
+// loadDataKey fetches a key created after LoadKeys ran, e.g. by a rotation on another instance.
+func (s *fieldEncryptionService) loadDataKey(keyID string) ([]byte, error) {
+	dataKey, err := s.dataKeyRepo.FindByID(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return s.unwrap(dataKey, nil)
+}
+
+func (s *fieldEncryptionService) createDataKey(purpose models.DataKeyPurpose, active bool) (*models.DataKey, []byte, error) {
+	key, err := utils.GenerateDataKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := utils.WrapDataKey(s.masterKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := &models.DataKey{
+		Purpose:     purpose,
+		WrappedKey:  wrapped,
+		MasterKeyID: utils.MasterKeyID(s.masterKey),
+		Active:      active,
+	}
+	if err := s.dataKeyRepo.Create(dataKey); err != nil {
+		return nil, nil, err
+	}
+	return dataKey, key, nil
+}
+
+// RotateDataKey makes a fresh encryption key active and re-encrypts existing users with it
+// in a background job. Older keys are kept so values not yet re-encrypted stay readable.
+func (s *fieldEncryptionService) RotateDataKey(requestedBy string) (*models.Job, error) {
+	dataKey, _, err := s.createDataKey(models.DataKeyEncryption, false)
+	if err != nil {
+		return nil, err
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.dataKeyRepo.WithTx(tx).Activate(dataKey.ID); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditEncryptionKeyRotated, models.AuditTargetDataKey, dataKey.ID, nil, nil, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.LoadKeys(); err != nil {
+		return nil, err
+	}
+
+	return s.jobService.Start(models.JobTypeKeyRotation, requestedBy, nil, func(job *models.Job) (JobOutput, error) {
+		count, err := s.ReencryptUsers()
+		if err != nil {
+			return JobOutput{}, err
+		}
+		return JobOutput{Result: map[string]interface{}{"keyId": dataKey.ID, "reencrypted": count}}, nil
+	})
+}
+
+// ReencryptUsers walks the users table in batches, encrypting plaintext values, moving values
+// encrypted with retired keys onto the active key and filling in missing blind indexes.
+// Users that fail (e.g. a duplicate ID among legacy rows) are logged and skipped.
+func (s *fieldEncryptionService) ReencryptUsers() (int, error) {
+	batchSize := fieldReencryptBatchSizeFromEnv()
+	activeID := utils.ActiveFieldKeyID()
+	count := 0
+	afterID := ""
+	for {
+		users, err := s.userRepo.FindNeedingReencryption(activeID, afterID, batchSize)
+		if err != nil {
+			return count, err
+		}
+		for i := range users {
+			if err := s.userRepo.UpdateEncryptedFields(&users[i]); err != nil {
+				log.Printf("Failed to re-encrypt user %s: %v", users[i].ID, err)
+				continue
+			}
+			count++
+		}
+		if len(users) < batchSize {
+			return count, nil
+		}
+		afterID = users[len(users)-1].ID
+	}
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+
+	"gorm.io/gorm"
+)
+
+type GroupService interface {
+	GetGroups(params models.GetAllGroupsRequest) (models.PaginatedResponse, error)
+	GetGroup(id string) (*models.Group, error)
+	CreateGroup(request models.CreateGroupRequest, actorID string) (*models.Group, error)
+	UpdateGroup(id string, request models.UpdateGroupRequest, actorID string) (*models.Group, error)
+	DeleteGroup(id string, actorID string) (models.SuccessResponse, error)
+	GetMembers(id string, params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error)
+	AddMembers(id string, request models.AddGroupMembersRequest, actorID string) (models.SuccessResponse, error)
+	RemoveMember(id string, userID string, actorID string) (models.SuccessResponse, error)
+	GetGroupRoles(id string) ([]models.Role, error)
+	AddGroupRoles(id string, request models.AssignRolesRequest, actorID string) ([]models.Role, error)
+	RemoveGroupRole(id string, roleID string, actorID string) ([]models.Role, error)
+	GetUserGroups(userID string, params models.GetAllGroupsRequest) (models.PaginatedResponse, error)
+	ForTenant(organizationID string) GroupService
+	ForRequest(audit models.AuditContext) GroupService
+}
+
+type groupService struct {
+	groupRepo      repositories.GroupRepository
+	roleRepo       repositories.RoleRepository
+	userRepo       repositories.UserRepository
+	sessionService SessionService
+	audit          auditor
+	transactor     repositories.Transactor
+}
+
+func NewGroupService(groupRepo repositories.GroupRepository, roleRepo repositories.RoleRepository, userRepo repositories.UserRepository, sessionService SessionService, auditRepo repositories.AuditRepository, transactor repositories.Transactor) GroupService {
+	return &groupService{groupRepo, roleRepo, userRepo, sessionService, newAuditor(auditRepo), transactor}
+}
+
+// ForTenant returns the service working on the organization's groups, roles and users.
+func (s *groupService) ForTenant(organizationID string) GroupService {
+	return &groupService{s.groupRepo.WithTenant(organizationID), s.roleRepo.WithTenant(organizationID), s.userRepo.WithTenant(organizationID), s.sessionService, s.audit.forRequest(models.AuditContext{OrganizationID: organizationID}), s.transactor}
+}
+
+// ForRequest returns the service working in the request's organization and recording its actor
+// in the audit log.
+func (s *groupService) ForRequest(audit models.AuditContext) GroupService {
+	scoped := s.ForTenant(audit.OrganizationID).(*groupService)
+	scoped.audit = s.audit.forRequest(audit)
+	return scoped
+}
+
+func (s *groupService) GetGroups(params models.GetAllGroupsRequest) (models.PaginatedResponse, error) {
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	groups, total, err := s.groupRepo.FindAll(params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+	return newPaginatedResponse(groups, total, params.PageNumber, params.PageSize), nil
+}
+
+func (s *groupService) GetGroup(id string) (*models.Group, error) {
+	group, err := s.groupRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("group not found")
+	}
+	return group, nil
+}
+
+func (s *groupService) CreateGroup(request models.CreateGroupRequest, actorID string) (*models.Group, error) {
+	if request.ParentID != nil {
+		if _, err := s.groupRepo.FindByID(*request.ParentID); err != nil {
+			return nil, errors.New("parent group not found")
+		}
+	}
+	group := &models.Group{
+		Name:        request.Name,
+		Description: request.Description,
+		ParentID:    request.ParentID,
+	}
+	err := s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).Create(group); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupCreated, models.AuditTargetGroup, group.ID, nil, group, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// UpdateGroup renames, describes or moves a group. Moving it changes the roles its members
+// inherit, so the actor must hold the permissions of both the old and the new parent chain.
+func (s *groupService) UpdateGroup(id string, request models.UpdateGroupRequest, actorID string) (*models.Group, error) {
+	group, err := s.groupRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("group not found")
+	}
+	original := *group
+	if request.Name != nil {
+		group.Name = *request.Name
+	}
+	if request.Description != nil {
+		group.Description = *request.Description
+	}
+
+	moved := false
+	switch {
+	case request.MoveToTop:
+		moved = group.ParentID != nil
+		group.ParentID = nil
+	case request.ParentID != nil && (group.ParentID == nil || *group.ParentID != *request.ParentID):
+		if _, err := s.groupRepo.FindByID(*request.ParentID); err != nil {
+			return nil, errors.New("parent group not found")
+		}
+		// A group cannot be nested under itself or one of its own subgroups
+		cycle, err := s.groupRepo.IsInSubtree(*request.ParentID, group.ID)
+		if err != nil {
+			return nil, err
+		}
+		if cycle {
+			return nil, errors.New("a group cannot be moved under itself or one of its subgroups")
+		}
+		moved = true
+		group.ParentID = request.ParentID
+	}
+
+	if moved {
+		before, err := s.groupRepo.FindInheritedRoles(group.ID)
+		if err != nil {
+			return nil, err
+		}
+		after := []models.Role{}
+		if group.ParentID != nil {
+			if after, err = s.groupRepo.FindInheritedRoles(*group.ParentID); err != nil {
+				return nil, err
+			}
+		}
+		if err := checkCanGrant(s.roleRepo, actorID, append(before, after...)); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).Update(group); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupUpdated, models.AuditTargetGroup, group.ID, &original, group, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moved {
+		s.endSessionsForSubtree(group.ID)
+	}
+	return group, nil
+}
+
+// DeleteGroup removes a group that has no subgroups, along with its memberships and roles.
+func (s *groupService) DeleteGroup(id string, actorID string) (models.SuccessResponse, error) {
+	group, err := s.groupRepo.FindByID(id)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("group not found")
+	}
+	hasSubgroups, err := s.groupRepo.HasSubgroups(id)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if hasSubgroups {
+		return models.SuccessResponse{}, errors.New("move or delete the group's subgroups first")
+	}
+	inherited, err := s.groupRepo.FindInheritedRoles(id)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, inherited); err != nil {
+		return models.SuccessResponse{}, err
+	}
+
+	memberIDs, err := s.groupRepo.FindSubtreeMemberIDs(id)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).Delete(id); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupDeleted, models.AuditTargetGroup, id, group, nil, nil)
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if len(inherited) > 0 {
+		for _, userID := range memberIDs {
+			s.endSessions(userID)
+		}
+	}
+	return models.SuccessResponse{Message: "Group deleted successfully"}, nil
+}
+
+func (s *groupService) GetMembers(id string, params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error) {
+	if _, err := s.groupRepo.FindByID(id); err != nil {
+		return models.PaginatedResponse{}, errors.New("group not found")
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	users, total, err := s.groupRepo.FindMembers(id, params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+	return newPaginatedResponse(NewUserViews(users, viewer), total, params.PageNumber, params.PageSize), nil
+}
+
+// AddMembers adds users to the group. Members inherit the group's roles, so the actor must
+// hold every permission those roles grant.
+func (s *groupService) AddMembers(id string, request models.AddGroupMembersRequest, actorID string) (models.SuccessResponse, error) {
+	if _, err := s.groupRepo.FindByID(id); err != nil {
+		return models.SuccessResponse{}, errors.New("group not found")
+	}
+	for _, userID := range request.UserIDs {
+		if _, err := s.userRepo.FindByID(userID); err != nil {
+			return models.SuccessResponse{}, errors.New("user " + userID + " not found")
+		}
+	}
+	inherited, err := s.groupRepo.FindInheritedRoles(id)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, inherited); err != nil {
+		return models.SuccessResponse{}, err
+	}
+
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).AddMembers(id, request.UserIDs, &actorID); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupMembersChanged, models.AuditTargetGroup, id, nil, nil, map[string]interface{}{"added": request.UserIDs})
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if len(inherited) > 0 {
+		for _, userID := range request.UserIDs {
+			s.endSessions(userID)
+		}
+	}
+	return models.SuccessResponse{Message: "Members added successfully"}, nil
+}
+
+func (s *groupService) RemoveMember(id string, userID string, actorID string) (models.SuccessResponse, error) {
+	if _, err := s.groupRepo.FindByID(id); err != nil {
+		return models.SuccessResponse{}, errors.New("group not found")
+	}
+	inherited, err := s.groupRepo.FindInheritedRoles(id)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, inherited); err != nil {
+		return models.SuccessResponse{}, err
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).RemoveMember(id, userID); err != nil {
+			if errors.Is(err, repositories.ErrLastAdmin) {
+				return err
+			}
+			return errors.New("user is not a member of this group")
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupMembersChanged, models.AuditTargetGroup, id, nil, nil, map[string]interface{}{"removed": []string{userID}})
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	if len(inherited) > 0 {
+		s.endSessions(userID)
+	}
+	return models.SuccessResponse{Message: "Member removed successfully"}, nil
+}
+
+func (s *groupService) GetGroupRoles(id string) ([]models.Role, error) {
+	if _, err := s.groupRepo.FindByID(id); err != nil {
+		return nil, errors.New("group not found")
+	}
+	return s.groupRepo.FindRoles(id)
+}
+
+// AddGroupRoles grants roles to the group, and so to all members of it and its subgroups.
+func (s *groupService) AddGroupRoles(id string, request models.AssignRolesRequest, actorID string) ([]models.Role, error) {
+	if _, err := s.groupRepo.FindByID(id); err != nil {
+		return nil, errors.New("group not found")
+	}
+	roles, err := findRolesByName(s.roleRepo, request.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, roles); err != nil {
+		return nil, err
+	}
+
+	roleIDs := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.ID)
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).AddRoles(id, roleIDs, &actorID); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupRolesChanged, models.AuditTargetGroup, id, nil, nil, map[string]interface{}{"added": request.Roles})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.endSessionsForSubtree(id)
+	return s.groupRepo.FindRoles(id)
+}
+
+func (s *groupService) RemoveGroupRole(id string, roleID string, actorID string) ([]models.Role, error) {
+	if _, err := s.groupRepo.FindByID(id); err != nil {
+		return nil, errors.New("group not found")
+	}
+	role, err := s.roleRepo.FindByID(roleID)
+	if err != nil {
+		return nil, errors.New("role not found")
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, []models.Role{*role}); err != nil {
+		return nil, err
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).RemoveRole(id, roleID); err != nil {
+			if errors.Is(err, repositories.ErrLastAdmin) {
+				return err
+			}
+			return errors.New("group does not have this role")
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupRolesChanged, models.AuditTargetGroup, id, nil, nil, map[string]interface{}{"removed": []string{role.Name}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.endSessionsForSubtree(id)
+	return s.groupRepo.FindRoles(id)
+}
+
+func (s *groupService) GetUserGroups(userID string, params models.GetAllGroupsRequest) (models.PaginatedResponse, error) {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return models.PaginatedResponse{}, errors.New("user not found")
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	groups, total, err := s.groupRepo.FindByUserID(userID, params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+	return newPaginatedResponse(groups, total, params.PageNumber, params.PageSize), nil
+}
+
+// endSessions signs the user out everywhere after the roles they inherit changed.
+func (s *groupService) endSessions(userID string) {
+	if err := s.sessionService.RevokeAll(userID); err != nil {
+		log.Printf("Failed to revoke sessions for user %s after a group change: %v", userID, err)
+	}
+}
+
+// endSessionsForSubtree signs out every member of the group and its subgroups.
+func (s *groupService) endSessionsForSubtree(groupID string) {
+	userIDs, err := s.groupRepo.FindSubtreeMemberIDs(groupID)
+	if err != nil {
+		log.Printf("Failed to find members of group %s: %v", groupID, err)
+		return
+	}
+	for _, userID := range userIDs {
+		s.endSessions(userID)
+	}
+}
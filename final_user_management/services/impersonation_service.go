@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+// ImpersonationLifetime is how long an impersonation token stays valid.
+const ImpersonationLifetime = 15 * time.Minute
+
+type ImpersonationService interface {
+	Start(adminID string, targetID string, meta models.RequestMeta) (models.ImpersonationResponse, error)
+	Stop(sessionID string, userID string, impersonatorID string, meta models.RequestMeta) (models.SuccessResponse, error)
+	RecordRequest(userID string, impersonatorID string, method string, path string, status int, meta models.RequestMeta)
+	ForTenant(organizationID string) ImpersonationService
+	ForRequest(audit models.AuditContext) ImpersonationService
+}
+
+type impersonationService struct {
+	userRepo          repositories.UserRepository
+	securityEventRepo repositories.SecurityEventRepository
+	sessionService    SessionService
+	roleRepo          repositories.RoleRepository
+	audit             auditor
+	transactor        repositories.Transactor
+}
+
+func NewImpersonationService(userRepo repositories.UserRepository, securityEventRepo repositories.SecurityEventRepository, sessionService SessionService, roleRepo repositories.RoleRepository, auditRepo repositories.AuditRepository, transactor repositories.Transactor) ImpersonationService {
+	return &impersonationService{userRepo, securityEventRepo, sessionService, roleRepo, newAuditor(auditRepo), transactor}
+}
+
+// ForTenant returns the service for impersonating the organization's users.
+func (s *impersonationService) ForTenant(organizationID string) ImpersonationService {
+	return &impersonationService{s.userRepo.WithTenant(organizationID), s.securityEventRepo, s.sessionService, s.roleRepo.WithTenant(organizationID), s.audit.forRequest(models.AuditContext{OrganizationID: organizationID}), s.transactor}
+}
+
+// ForRequest returns the service working in the request's organization and recording its actor
+// in the audit log.
+func (s *impersonationService) ForRequest(audit models.AuditContext) ImpersonationService {
+	scoped := s.ForTenant(audit.OrganizationID).(*impersonationService)
+	scoped.audit = s.audit.forRequest(audit)
+	return scoped
+}
+
+func (s *impersonationService) Start(adminID string, targetID string, meta models.RequestMeta) (models.ImpersonationResponse, error) {
+	if adminID == targetID {
+		return models.ImpersonationResponse{}, errors.New("cannot impersonate yourself")
+	}
+
+	admin, err := s.userRepo.FindByID(adminID)
+	if err != nil {
+		return models.ImpersonationResponse{}, errors.New("user not found")
+	}
+	adminPermissions, err := userPermissions(s.roleRepo, admin.ID)
+	if err != nil || !models.HasPermission(adminPermissions, models.PermUsersImpersonate) {
+		return models.ImpersonationResponse{}, errors.New("you are not allowed to impersonate users")
+	}
+	target, err := s.userRepo.FindByID(targetID)
+	if err != nil {
+		return models.ImpersonationResponse{}, errors.New("user not found")
+	}
+	// Accounts holding any permission are staff; impersonating them would hand out their access
+	targetPermissions, err := userPermissions(s.roleRepo, target.ID)
+	if err != nil {
+		return models.ImpersonationResponse{}, errors.New("failed to resolve permissions")
+	}
+	if len(targetPermissions) > 0 {
+		return models.ImpersonationResponse{}, errors.New("staff accounts cannot be impersonated")
+	}
+
+	var session *models.Session
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if session, err = s.sessionService.WithTx(tx).CreateImpersonation(target.ID, admin.ID, ImpersonationLifetime, meta); err != nil {
+			return errors.New("failed to create session")
+		}
+		return s.audit.withTx(tx).record(models.AuditImpersonationStarted, models.AuditTargetUser, target.ID, nil, nil, map[string]interface{}{"sessionId": session.ID})
+	})
+	if err != nil {
+		return models.ImpersonationResponse{}, err
+	}
+	token, err := utils.GenerateImpersonationJWT(target.ID, target.OrganizationID, target.Username, string(target.Role), targetPermissions, session.ID, admin.ID, admin.Username, ImpersonationLifetime)
+	if err != nil {
+		return models.ImpersonationResponse{}, errors.New("failed to generate token")
+	}
+
+	s.record(target.ID, admin.ID, models.EventImpersonationStarted, models.OutcomeSuccess, "", "", meta)
+
+	return models.ImpersonationResponse{
+		AccessToken:    token,
+		ExpiresAt:      session.ExpiresAt,
+		ImpersonatorID: admin.ID,
+		UserID:         target.ID,
+	}, nil
+}
+
+func (s *impersonationService) Stop(sessionID string, userID string, impersonatorID string, meta models.RequestMeta) (models.SuccessResponse, error) {
+	if impersonatorID == "" {
+		return models.SuccessResponse{}, errors.New("not an impersonation session")
+	}
+	err := s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.sessionService.WithTx(tx).Revoke(sessionID); err != nil {
+			return errors.New("failed to end impersonation")
+		}
+		return s.audit.withTx(tx).record(models.AuditImpersonationStopped, models.AuditTargetUser, userID, nil, nil, map[string]interface{}{"sessionId": sessionID})
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	s.record(userID, impersonatorID, models.EventImpersonationStopped, models.OutcomeSuccess, "", "", meta)
+	return models.SuccessResponse{Message: "Impersonation ended"}, nil
+}
+
+// RecordRequest writes an entry for a single request made with an impersonation token.
+func (s *impersonationService) RecordRequest(userID string, impersonatorID string, method string, path string, status int, meta models.RequestMeta) {
+	outcome := models.OutcomeSuccess
+	reason := ""
+	if status >= 400 {
+		outcome = models.OutcomeFailure
+		reason = fmt.Sprintf("HTTP %d", status)
+	}
+	s.record(userID, impersonatorID, models.EventImpersonatedRequest, outcome, method+" "+path, reason, meta)
+}
+
+func (s *impersonationService) record(userID string, impersonatorID string, eventType models.SecurityEventType, outcome models.SecurityEventOutcome, identifier string, reason string, meta models.RequestMeta) {
+	event := &models.SecurityEvent{
+		UserID:     &userID,
+		ActorID:    &impersonatorID,
+		Identifier: identifier,
+		Type:       eventType,
+		Outcome:    outcome,
+		IP:         meta.IP,
+		UserAgent:  meta.UserAgent,
+	}
+	if reason != "" {
+		event.FailureReason = &reason
+	}
+	if err := s.securityEventRepo.Create(event); err != nil {
+		log.Printf("Failed to record %s security event: %v", eventType, err)
+	}
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+// jobFileLifetime is how long a job's output file stays available for download.
+const jobFileLifetime = 7 * 24 * time.Hour
+
+// A running job's heartbeat is refreshed every jobHeartbeatInterval. Jobs are run by the
+// process that started them, so one whose heartbeat is older than jobStaleAfter will never
+// finish.
+const (
+	jobHeartbeatInterval = time.Minute
+	jobStaleAfter        = 5 * time.Minute
+)
+
+// JobOutput is what a job's work function hands back: an optional file written under
+// the job directory and an optional JSON-serializable result summary.
+type JobOutput struct {
+	FilePath string
+	FileName string
+	Result   interface{}
+}
+
+// JobFunc does a job's work. It is given the job so it can name output files after it.
+type JobFunc func(job *models.Job) (JobOutput, error)
+
+type JobService interface {
+	Start(jobType models.JobType, requestedBy string, subjectID *string, run JobFunc) (*models.Job, error)
+	GetJob(id string, callerID string, canReadAll bool) (*models.Job, error)
+	FilePath(job *models.Job) string
+	CleanupExpired() (int, error)
+	FailInterrupted() (int64, error)
+	ForTenant(organizationID string) JobService
+}
+
+type jobService struct {
+	jobRepo        repositories.JobRepository
+	organizationID string
+}
+
+func NewJobService(jobRepo repositories.JobRepository) JobService {
+	return &jobService{jobRepo: jobRepo}
+}
+
+// ForTenant returns the service for jobs working on the organization's data.
+func (s *jobService) ForTenant(organizationID string) JobService {
+	return &jobService{jobRepo: s.jobRepo, organizationID: organizationID}
+}
+
+// JobDir is where job output files are written.
+func JobDir() string {
+	if dir := os.Getenv("JOB_OUTPUT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "user-management-jobs")
+}
+
+// Start records a pending job and runs it in the background.
+func (s *jobService) Start(jobType models.JobType, requestedBy string, subjectID *string, run JobFunc) (*models.Job, error) {
+	job := &models.Job{
+		Type:        jobType,
+		Status:      models.JobPending,
+		RequestedBy: requestedBy,
+		SubjectID:   subjectID,
+	}
+	if s.organizationID != "" {
+		job.OrganizationID = &s.organizationID
+	}
+	if err := s.jobRepo.Create(job); err != nil {
+		return nil, err
+	}
+
+	queued := *job
+	go s.run(&queued, run)
+	return job, nil
+}
+
+func (s *jobService) run(job *models.Job, run JobFunc) {
+	started := time.Now()
+	job.Status = models.JobProcessing
+	job.HeartbeatAt = &started
+	if err := s.jobRepo.Save(job); err != nil {
+		log.Printf("Failed to mark job %s as processing: %v", job.ID, err)
+	}
+
+	stop := make(chan struct{})
+	beating := make(chan struct{})
+	go s.heartbeat(job.ID, stop, beating)
+	output, err := runRecovered(job, run)
+	close(stop)
+	<-beating
+
+	now := time.Now()
+	job.CompletedAt = &now
+	if err != nil {
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+		if output.FilePath != "" {
+			os.Remove(output.FilePath)
+		}
+	} else {
+		job.Status = models.JobCompleted
+		job.FilePath = output.FilePath
+		job.FileName = output.FileName
+		if output.Result != nil {
+			if encoded, err := json.Marshal(output.Result); err == nil {
+				job.Result = string(encoded)
+			}
+		}
+		if output.FilePath != "" {
+			expiresAt := now.Add(jobFileLifetime)
+			job.ExpiresAt = &expiresAt
+		}
+	}
+
+	if err := s.jobRepo.Save(job); err != nil {
+		log.Printf("Failed to save job %s: %v", job.ID, err)
+	}
+}
+
+// runRecovered calls run, turning a panic into an error so the job is marked failed rather than
+// left processing.
+func runRecovered(job *models.Job, run JobFunc) (output JobOutput, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v\n%s", job.ID, r, debug.Stack())
+			err = fmt.Errorf("job stopped unexpectedly: %v", r)
+		}
+	}()
+	return run(job)
+}
+
+// heartbeat refreshes the job's heartbeat until stop is closed, then closes done.
+func (s *jobService) heartbeat(jobID string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case at := <-ticker.C:
+			if err := s.jobRepo.Heartbeat(jobID, at); err != nil {
+				log.Printf("Failed to record heartbeat of job %s: %v", jobID, err)
+			}
+		}
+	}
+}
+
+// FailInterrupted marks failed the jobs that stopped running without finishing, such as those
+// of an instance that restarted. Their work cannot be resumed, so they have to be started again.
+func (s *jobService) FailInterrupted() (int64, error) {
+	now := time.Now()
+	return s.jobRepo.FailStale(now.Add(-jobStaleAfter), "the job was interrupted, start it again", now)
+}
+
+// GetJob returns the job if the caller started it or may read every job of their organization.
+// Data exports are only returned to whoever requested them.
+func (s *jobService) GetJob(id string, callerID string, canReadAll bool) (*models.Job, error) {
+	job, err := s.jobRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("job not found")
+	}
+	if job.RequestedBy == callerID {
+		return job, nil
+	}
+	// A data export holds everything about its subject, so only whoever requested it sees it
+	if job.Type == models.JobTypeDataExport {
+		return nil, errors.New("job not found")
+	}
+	if !canReadAll || job.OrganizationID == nil || *job.OrganizationID != s.organizationID {
+		return nil, errors.New("job not found")
+	}
+	return job, nil
+}
+
+// FilePath returns the job's downloadable file, or "" when there is none or it has expired.
+func (s *jobService) FilePath(job *models.Job) string {
+	if job.Status != models.JobCompleted || job.FilePath == "" {
+		return ""
+	}
+	if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+		return ""
+	}
+	return job.FilePath
+}
+
+// CleanupExpired removes jobs whose output has expired, along with their files.
+func (s *jobService) CleanupExpired() (int, error) {
+	jobs, err := s.jobRepo.FindExpired(time.Now())
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		if job.FilePath != "" {
+			if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove file for job %s: %v", job.ID, err)
+				continue
+			}
+		}
+		if err := s.jobRepo.Delete(job.ID); err != nil {
+			log.Printf("Failed to delete job %s: %v", job.ID, err)
+		}
+	}
+	return len(jobs), nil
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+
+	"gorm.io/gorm"
+)
+
+// organizationSlugPattern keeps slugs usable as DNS labels.
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// emailDomainPattern is a lowercase domain name with at least two labels.
+var emailDomainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+var (
+	ErrOrganizationNotFound = errors.New("organization not found")
+	ErrOrganizationInactive = errors.New("organization is inactive")
+)
+
+type OrganizationService interface {
+	GetOrganizations() ([]models.Organization, error)
+	GetOrganization(id string) (*models.Organization, error)
+	CreateOrganization(request models.CreateOrganizationRequest, actorID string) (*models.Organization, error)
+	UpdateOrganization(id string, request models.UpdateOrganizationRequest) (*models.Organization, error)
+	Resolve(identifier string) (*models.Organization, error)
+	Default() (*models.Organization, error)
+	ForRequest(audit models.AuditContext) OrganizationService
+}
+
+type organizationService struct {
+	organizationRepo repositories.OrganizationRepository
+	userService      UserService
+	audit            auditor
+	transactor       repositories.Transactor
+}
+
+func NewOrganizationService(organizationRepo repositories.OrganizationRepository, userService UserService, auditRepo repositories.AuditRepository, transactor repositories.Transactor) OrganizationService {
+	return &organizationService{organizationRepo, userService, newAuditor(auditRepo), transactor}
+}
+
+// ForRequest returns the service recording the request's actor in the audit log.
+func (s *organizationService) ForRequest(audit models.AuditContext) OrganizationService {
+	return &organizationService{s.organizationRepo, s.userService, s.audit.forRequest(audit), s.transactor}
+}
+
+func (s *organizationService) GetOrganizations() ([]models.Organization, error) {
+	return s.organizationRepo.FindAll()
+}
+
+func (s *organizationService) GetOrganization(id string) (*models.Organization, error) {
+	organization, err := s.organizationRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+	return organization, nil
+}
+
+// CreateOrganization sets up a tenant with the built-in roles and an admin account, so that
+// someone can sign in to it. Both are created in one transaction: if the admin cannot be
+// created, neither is.
+func (s *organizationService) CreateOrganization(request models.CreateOrganizationRequest, actorID string) (*models.Organization, error) {
+	if !organizationSlugPattern.MatchString(request.Slug) {
+		return nil, errors.New("slug must be lowercase letters, digits and hyphens")
+	}
+	if _, err := s.organizationRepo.FindBySlug(request.Slug); err == nil {
+		return nil, errors.New("slug is already taken")
+	}
+
+	organization := &models.Organization{Name: request.Name, Slug: request.Slug, Active: true}
+	admin := models.CreateUserByAdminRequest{
+		Email:     request.Admin.Email,
+		FirstName: request.Admin.FirstName,
+		LastName:  request.Admin.LastName,
+		Password:  request.Admin.Password,
+		Phone:     request.Admin.Phone,
+		Role:      models.RoleAdmin,
+		Username:  request.Admin.Username,
+	}
+	err := s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.organizationRepo.WithTx(tx).Create(organization); err != nil {
+			return err
+		}
+		// The new tenant's log starts with its admin being created by the platform admin
+		audit := s.audit.context
+		audit.OrganizationID = organization.ID
+		if _, err := s.userService.ForRequest(audit).WithTx(tx).RegisterUserByAdmin(admin, actorID); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditOrganizationCreated, models.AuditTargetOrganization, organization.ID, nil, organization, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// UpdateOrganization renames a tenant, turns it on or off or changes its registration policy.
+// Requests for an inactive tenant are refused, including requests with tokens issued before it
+// was deactivated.
+func (s *organizationService) UpdateOrganization(id string, request models.UpdateOrganizationRequest) (*models.Organization, error) {
+	organization, err := s.organizationRepo.FindByID(id)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+	before := *organization
+	if request.Name != nil {
+		organization.Name = *request.Name
+	}
+	if request.Active != nil {
+		if !*request.Active && organization.Slug == models.DefaultOrganizationSlug {
+			return nil, errors.New("the default organization cannot be deactivated")
+		}
+		organization.Active = *request.Active
+	}
+	if request.RegistrationMode != nil {
+		organization.RegistrationMode = *request.RegistrationMode
+	}
+	if request.AllowedEmailDomains != nil {
+		domains, err := normalizeEmailDomains(*request.AllowedEmailDomains)
+		if err != nil {
+			return nil, err
+		}
+		organization.AllowedEmailDomains = domains
+	}
+	if request.ReservedNames != nil {
+		organization.ReservedNames = normalizeReservedNames(*request.ReservedNames)
+	}
+	if organization.RegistrationMode == models.RegistrationDomainRestricted && len(organization.AllowedEmailDomains) == 0 {
+		return nil, errors.New("domain-restricted registration needs at least one allowed email domain")
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.organizationRepo.WithTx(tx).Update(organization); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditOrganizationUpdated, models.AuditTargetOrganization, organization.ID, &before, organization, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return organization, nil
+}
+
+// normalizeEmailDomains lowercases the domains and drops a leading "@" and duplicates.
+func normalizeEmailDomains(domains []string) ([]string, error) {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+		if !emailDomainPattern.MatchString(domain) {
+			return nil, fmt.Errorf("%q is not a valid email domain", domain)
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeReservedNames lowercases the names and drops blanks and duplicates.
+func normalizeReservedNames(names []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized
+}
+
+// Resolve finds an active organization by slug or ID.
+func (s *organizationService) Resolve(identifier string) (*models.Organization, error) {
+	var organization *models.Organization
+	var err error
+	if uuidPattern.MatchString(identifier) {
+		organization, err = s.organizationRepo.FindByID(identifier)
+	} else {
+		organization, err = s.organizationRepo.FindBySlug(identifier)
+	}
+	if err != nil {
+		return nil, ErrOrganizationNotFound
+	}
+	if !organization.Active {
+		return nil, ErrOrganizationInactive
+	}
+	return organization, nil
+}
+
+// Default returns the organization that serves requests which do not name one.
+func (s *organizationService) Default() (*models.Organization, error) {
+	return s.Resolve(models.DefaultOrganizationSlug)
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+
+	"gorm.io/gorm"
+)
+
+// adminViewer is who event payloads are rendered for: an administrator, who sees identity
+// documents and phone numbers masked.
+var adminViewer = models.Viewer{Permissions: []string{string(models.PermUsersRead)}}
+
+// outbox writes domain events in the same transaction as the change they describe, so an event
+// exists exactly when its change was committed. The relay publishes them afterwards.
+type outbox struct {
+	transactor repositories.Transactor
+	repo       repositories.OutboxRepository
+}
+
+func newOutbox(transactor repositories.Transactor, repo repositories.OutboxRepository) outbox {
+	return outbox{transactor, repo}
+}
+
+// transaction runs change in a transaction and appends the events it returns before committing.
+// Repositories used by change must join the transaction with WithTx(tx). The events are written
+// after the change, whose row locks keep events about one aggregate in commit order.
+func (o outbox) transaction(change func(tx *gorm.DB) ([]models.OutboxEvent, error)) error {
+	return o.transactor.Transaction(func(tx *gorm.DB) error {
+		events, err := change(tx)
+		if err != nil {
+			return err
+		}
+		return o.repo.WithTx(tx).Append(events)
+	})
+}
+
+// userEvent describes a change to the user. The payload holds the user as it is after the change,
+// as an administrator sees them, plus data.
+func userEvent(eventType models.DomainEventType, user *models.User, data map[string]interface{}) models.OutboxEvent {
+	payload := map[string]interface{}{"user": NewUserView(user, adminViewer)}
+	for key, value := range data {
+		payload[key] = value
+	}
+	return models.OutboxEvent{
+		OrganizationID: user.OrganizationID,
+		AggregateType:  models.AggregateUser,
+		AggregateID:    user.ID,
+		Type:           eventType,
+		Payload:        payload,
+		OccurredAt:     time.Now().UTC(),
+	}
+}
+
+// changedFields lists the JSON fields that differ between before and after.
+func changedFields(before, after interface{}) []string {
+	old, current := auditFields(before), auditFields(after)
+	changed := []string{}
+	for name := range mergeKeys(old, current) {
+		if !auditIgnoredFields[name] && !reflect.DeepEqual(old[name], current[name]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"math"
+
+	"github.com/umwaribenie/final_user_management/models"
+)
+
+// newPaginatedResponse builds the standard paginated envelope for a page of results.
+func newPaginatedResponse(list interface{}, total int64, pageNumber, pageSize int) models.PaginatedResponse {
+	lastPage := int(math.Ceil(float64(total) / float64(pageSize)))
+	if lastPage == 0 && total > 0 {
+		lastPage = 1
+	}
+
+	var nextPage, previousPage *int
+	if pageNumber < lastPage {
+		next := pageNumber + 1
+		nextPage = &next
+	}
+	if pageNumber > 1 {
+		prev := pageNumber - 1
+		previousPage = &prev
+	}
+
+	return models.PaginatedResponse{
+		CurrentPage:  pageNumber,
+		LastPage:     lastPage,
+		List:         list,
+		NextPage:     nextPage,
+		PreviousPage: previousPage,
+		Status:       "success",
+		Total:        total,
+	}
+}
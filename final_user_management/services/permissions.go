@@ -0,0 +1,30 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+// userPermissions resolves the permissions granted by all of the user's roles, including those
+// inherited through groups, sorted and without duplicates, ready for the "perms" claim.
+func userPermissions(roleRepo repositories.RoleRepository, userID string) ([]string, error) {
+	roles, err := roleRepo.FindEffectiveByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[models.Permission]bool{}
+	permissions := []string{}
+	for _, role := range roles {
+		for _, permission := range role.Permissions {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, string(permission))
+			}
+		}
+	}
+	sort.Strings(permissions)
+	return permissions, nil
+}
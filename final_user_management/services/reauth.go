@@ -0,0 +1,128 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/utils"
+)
+
+const (
+	reauthOtpTTL = 5 * time.Minute
+	// reauthOtpMaxAttempts wrong guesses invalidate a re-authentication code.
+	reauthOtpMaxAttempts = 3
+)
+
+func reauthOtpKey(userID string) string {
+	return "reauth-otp:" + userID
+}
+
+func reauthOtpAttemptsKey(userID string) string {
+	return "reauth-otp-attempts:" + userID
+}
+
+// SendReauthOtp emails a one-time code the user can use to re-authenticate instead of a password.
+func (s *authService) SendReauthOtp(userID string, meta models.RequestMeta) (models.SuccessResponse, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("user not found")
+	}
+	if user.Email == "" {
+		return models.SuccessResponse{}, errors.New("user has no email")
+	}
+
+	otp := utils.GenerateOTP()
+	pipe := s.redisClient.TxPipeline()
+	pipe.Set(ctx, reauthOtpKey(user.ID), otp, reauthOtpTTL)
+	pipe.Del(ctx, reauthOtpAttemptsKey(user.ID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Redis error: %v", err)
+		return models.SuccessResponse{}, errors.New("failed to store OTP")
+	}
+
+	body := fmt.Sprintf("Your verification code is: <strong>%s</strong><br>It expires in 5 minutes.", otp)
+	if err := utils.SendEmail(emailConfigFromEnv(), user.Email, "Verification code", body); err != nil {
+		return models.SuccessResponse{}, errors.New("failed to send OTP email")
+	}
+	return models.SuccessResponse{Message: "Verification code sent via email"}, nil
+}
+
+// Reauthenticate verifies the user's password or emailed OTP again and issues a fresh token
+// for the same session with an updated auth_time and amr. Wrong answers count towards the same
+// lockout as failed logins, and a code stops working after reauthOtpMaxAttempts wrong guesses.
+func (s *authService) Reauthenticate(userID string, sessionID string, request models.ReauthenticateRequest, meta models.RequestMeta) (models.LoginResponse, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return models.LoginResponse{}, errors.New("user not found")
+	}
+	if user.Status != models.ActiveStatus {
+		return models.LoginResponse{}, fmt.Errorf("account is %s", user.Status)
+	}
+	session, err := s.sessionRepo.FindByID(sessionID)
+	if err != nil || session.UserID != user.ID {
+		return models.LoginResponse{}, errors.New("session not found")
+	}
+
+	var method string
+	switch {
+	case request.Password != "":
+		method = utils.AMRPassword
+		if !utils.CheckPasswordHash(request.Password, user.Password) {
+			recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventReauthentication, models.OutcomeFailure, "invalid password", meta)
+			s.registerFailedLogin(user, meta)
+			return models.LoginResponse{}, errors.New("invalid credentials")
+		}
+	case request.Otp != "":
+		method = utils.AMROTP
+		stored, err := s.redisClient.Get(ctx, reauthOtpKey(user.ID)).Result()
+		if err == redis.Nil {
+			recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventReauthentication, models.OutcomeFailure, "invalid or expired OTP", meta)
+			return models.LoginResponse{}, errors.New("invalid or expired OTP")
+		} else if err != nil {
+			return models.LoginResponse{}, err
+		}
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(request.Otp)) != 1 {
+			recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventReauthentication, models.OutcomeFailure, "invalid or expired OTP", meta)
+			s.registerFailedReauthOtp(user.ID)
+			s.registerFailedLogin(user, meta)
+			return models.LoginResponse{}, errors.New("invalid or expired OTP")
+		}
+		s.redisClient.Del(ctx, reauthOtpKey(user.ID), reauthOtpAttemptsKey(user.ID))
+	default:
+		return models.LoginResponse{}, errors.New("password or otp is required")
+	}
+
+	permissions, err := userPermissions(s.roleRepo, user.ID)
+	if err != nil {
+		return models.LoginResponse{}, errors.New("failed to resolve permissions")
+	}
+	token, err := utils.GenerateJWT(user.ID, user.OrganizationID, user.Username, string(user.Role), permissions, session.ID, session.ExpiresAt, time.Now(), []string{method})
+	if err != nil {
+		return models.LoginResponse{}, errors.New("failed to generate token")
+	}
+
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventReauthentication, models.OutcomeSuccess, "", meta)
+	return models.LoginResponse{AccessToken: token}, nil
+}
+
+// registerFailedReauthOtp counts a wrong re-authentication code and deletes the code once
+// reauthOtpMaxAttempts have been used up, so it cannot be guessed within its lifetime.
+func (s *authService) registerFailedReauthOtp(userID string) {
+	attempts, err := s.redisClient.Incr(ctx, reauthOtpAttemptsKey(userID)).Result()
+	if err != nil {
+		log.Printf("Redis error: %v", err)
+		s.redisClient.Del(ctx, reauthOtpKey(userID))
+		return
+	}
+	if attempts == 1 {
+		s.redisClient.Expire(ctx, reauthOtpAttemptsKey(userID), reauthOtpTTL)
+	}
+	if attempts >= reauthOtpMaxAttempts {
+		s.redisClient.Del(ctx, reauthOtpKey(userID), reauthOtpAttemptsKey(userID))
+	}
+}
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoleService interface {
+	GetRoles() ([]models.Role, error)
+	GetRole(id string) (*models.Role, error)
+	CreateRole(request models.CreateRoleRequest, actorID string) (*models.Role, error)
+	UpdateRole(id string, request models.UpdateRoleRequest, actorID string) (*models.Role, error)
+	DeleteRole(id string, actorID string) (models.SuccessResponse, error)
+	GetRoleUsers(id string, params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error)
+	GetUserRoles(userID string) ([]models.Role, error)
+	AssignRoles(userID string, request models.AssignRolesRequest, actorID string) ([]models.Role, error)
+	UnassignRole(userID string, roleID string, actorID string) ([]models.Role, error)
+	ForTenant(organizationID string) RoleService
+	ForRequest(audit models.AuditContext) RoleService
+}
+
+type roleService struct {
+	roleRepo       repositories.RoleRepository
+	userRepo       repositories.UserRepository
+	sessionService SessionService
+	audit          auditor
+	outbox         outbox
+}
+
+func NewRoleService(roleRepo repositories.RoleRepository, userRepo repositories.UserRepository, sessionService SessionService, auditRepo repositories.AuditRepository, transactor repositories.Transactor, outboxRepo repositories.OutboxRepository) RoleService {
+	return &roleService{roleRepo, userRepo, sessionService, newAuditor(auditRepo), newOutbox(transactor, outboxRepo)}
+}
+
+// ForTenant returns the service working on the organization's roles and users.
+func (s *roleService) ForTenant(organizationID string) RoleService {
+	return &roleService{s.roleRepo.WithTenant(organizationID), s.userRepo.WithTenant(organizationID), s.sessionService, s.audit.forRequest(models.AuditContext{OrganizationID: organizationID}), s.outbox}
+}
+
+// ForRequest returns the service working in the request's organization and recording its actor
+// in the audit log.
+func (s *roleService) ForRequest(audit models.AuditContext) RoleService {
+	scoped := s.ForTenant(audit.OrganizationID).(*roleService)
+	scoped.audit = s.audit.forRequest(audit)
+	return scoped
+}
+
+func (s *roleService) GetRoles() ([]models.Role, error) {
+	return s.roleRepo.FindAll()
+}
+
+func (s *roleService) GetRole(id string) (*models.Role, error) {
+	role, err := s.roleRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("role not found")
+	}
+	return role, nil
+}
+
+func (s *roleService) CreateRole(request models.CreateRoleRequest, actorID string) (*models.Role, error) {
+	if err := validatePermissions(request.Permissions); err != nil {
+		return nil, err
+	}
+	role := &models.Role{
+		Name:        request.Name,
+		Description: request.Description,
+		Permissions: request.Permissions,
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, []models.Role{*role}); err != nil {
+		return nil, err
+	}
+	err := s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.roleRepo.WithTx(tx).Create(role); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditRoleCreated, models.AuditTargetRole, role.ID, nil, role, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// UpdateRole changes a role's name, description or permissions. System roles keep their name,
+// and the admin role always holds every permission.
+func (s *roleService) UpdateRole(id string, request models.UpdateRoleRequest, actorID string) (*models.Role, error) {
+	role, err := s.roleRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("role not found")
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, []models.Role{*role}); err != nil {
+		return nil, err
+	}
+	before := *role
+
+	if request.Name != nil && *request.Name != role.Name {
+		if role.System {
+			return nil, errors.New("system roles cannot be renamed")
+		}
+		role.Name = *request.Name
+	}
+	if request.Description != nil {
+		role.Description = *request.Description
+	}
+	permissionsChanged := request.Permissions != nil
+	if permissionsChanged {
+		if role.Name == string(models.RoleAdmin) {
+			return nil, errors.New("the admin role's permissions cannot be changed")
+		}
+		if err := validatePermissions(request.Permissions); err != nil {
+			return nil, err
+		}
+		role.Permissions = request.Permissions
+		if err := checkCanGrant(s.roleRepo, actorID, []models.Role{*role}); err != nil {
+			return nil, err
+		}
+	}
+
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.roleRepo.WithTx(tx).Update(role); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditRoleUpdated, models.AuditTargetRole, role.ID, &before, role, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if permissionsChanged {
+		s.endSessionsForRole(role.ID)
+	}
+	return role, nil
+}
+
+func (s *roleService) DeleteRole(id string, actorID string) (models.SuccessResponse, error) {
+	role, err := s.roleRepo.FindByID(id)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("role not found")
+	}
+	if role.System {
+		return models.SuccessResponse{}, errors.New("system roles cannot be deleted")
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, []models.Role{*role}); err != nil {
+		return models.SuccessResponse{}, err
+	}
+
+	userIDs, err := s.roleRepo.FindUserIDsByRole(role.ID)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.roleRepo.WithTx(tx).Delete(role.ID); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditRoleDeleted, models.AuditTargetRole, role.ID, role, nil, map[string]interface{}{"affectedUsers": len(userIDs)})
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	for _, userID := range userIDs {
+		s.endSessions(userID)
+	}
+	return models.SuccessResponse{Message: "Role deleted successfully"}, nil
+}
+
+func (s *roleService) GetRoleUsers(id string, params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error) {
+	if _, err := s.roleRepo.FindByID(id); err != nil {
+		return models.PaginatedResponse{}, errors.New("role not found")
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	users, total, err := s.roleRepo.FindUsersByRole(id, params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+	return newPaginatedResponse(NewUserViews(users, viewer), total, params.PageNumber, params.PageSize), nil
+}
+
+func (s *roleService) GetUserRoles(userID string) ([]models.Role, error) {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return nil, errors.New("user not found")
+	}
+	return s.roleRepo.FindByUserID(userID)
+}
+
+// AssignRoles adds the named roles to the user's existing ones.
+func (s *roleService) AssignRoles(userID string, request models.AssignRolesRequest, actorID string) ([]models.Role, error) {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return nil, errors.New("user not found")
+	}
+	roles, err := findRolesByName(s.roleRepo, request.Roles)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, roles); err != nil {
+		return nil, err
+	}
+
+	roleIDs := make([]string, 0, len(roles))
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.ID)
+	}
+	err = s.outbox.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		if err := s.roleRepo.WithTx(tx).AddUserRoles(userID, roleIDs, &actorID); err != nil {
+			return nil, err
+		}
+		if err := s.audit.withTx(tx).record(models.AuditUserRolesChanged, models.AuditTargetUser, userID, nil, nil, map[string]interface{}{"added": request.Roles}); err != nil {
+			return nil, err
+		}
+		return s.rolesChangedEvent(tx, userID, map[string]interface{}{"added": request.Roles})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.endSessions(userID)
+	return s.roleRepo.FindByUserID(userID)
+}
+
+func (s *roleService) UnassignRole(userID string, roleID string, actorID string) ([]models.Role, error) {
+	current, err := s.roleRepo.FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed *models.Role
+	remaining := make([]models.Role, 0, len(current))
+	for i := range current {
+		if current[i].ID == roleID {
+			removed = &current[i]
+			continue
+		}
+		remaining = append(remaining, current[i])
+	}
+	if removed == nil {
+		return nil, errors.New("user does not have this role")
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, []models.Role{*removed}); err != nil {
+		return nil, err
+	}
+
+	err = s.outbox.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		if err := s.roleRepo.WithTx(tx).RemoveUserRole(userID, roleID); err != nil {
+			return nil, err
+		}
+		if err := s.audit.withTx(tx).record(models.AuditUserRolesChanged, models.AuditTargetUser, userID, nil, nil, map[string]interface{}{"removed": []string{removed.Name}}); err != nil {
+			return nil, err
+		}
+		return s.rolesChangedEvent(tx, userID, map[string]interface{}{"removed": []string{removed.Name}})
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.endSessions(userID)
+	return remaining, nil
+}
+
+// rolesChangedEvent describes the user's roles as they are within the transaction. Role changes
+// leave the user's row untouched, so it is locked here to keep concurrent changes' events in
+// commit order, and the roles are read once the lock is held.
+func (s *roleService) rolesChangedEvent(tx *gorm.DB, userID string, data map[string]interface{}) ([]models.OutboxEvent, error) {
+	user, err := s.userRepo.WithTx(tx.Clauses(clause.Locking{Strength: "UPDATE"})).FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.roleRepo.WithTx(tx).FindByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	data["roles"] = names
+	return []models.OutboxEvent{userEvent(models.DomainUserRolesChanged, user, data)}, nil
+}
+
+// endSessions signs the user out everywhere after their permissions changed, since their
+// tokens still carry the old ones.
+func (s *roleService) endSessions(userID string) {
+	if err := s.sessionService.RevokeAll(userID); err != nil {
+		log.Printf("Failed to revoke sessions for user %s after a role change: %v", userID, err)
+	}
+}
+
+func (s *roleService) endSessionsForRole(roleID string) {
+	userIDs, err := s.roleRepo.FindUserIDsByRole(roleID)
+	if err != nil {
+		log.Printf("Failed to find users of role %s: %v", roleID, err)
+		return
+	}
+	for _, userID := range userIDs {
+		s.endSessions(userID)
+	}
+}
+
+func validatePermissions(permissions []models.Permission) error {
+	for _, permission := range permissions {
+		if !permission.IsValid() {
+			return fmt.Errorf("unknown permission %q", permission)
+		}
+	}
+	return nil
+}
+
+// findRolesByName loads the named roles, failing if any of them does not exist.
+func findRolesByName(roleRepo repositories.RoleRepository, names []string) ([]models.Role, error) {
+	roles, err := roleRepo.FindByNames(names)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		found := false
+		for _, role := range roles {
+			if role.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("role %q does not exist", name)
+		}
+	}
+	return roles, nil
+}
+
+// checkCanGrant stops privilege escalation: the actor may only create, change, assign or
+// remove roles whose permissions they hold themselves.
+func checkCanGrant(roleRepo repositories.RoleRepository, actorID string, roles []models.Role) error {
+	held, err := userPermissions(roleRepo, actorID)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		for _, permission := range role.Permissions {
+			if !models.HasPermission(held, permission) {
+				return fmt.Errorf("role %q grants %s, which you do not have", role.Name, permission)
+			}
+		}
+	}
+	return nil
+}
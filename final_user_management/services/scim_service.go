@@ -0,0 +1,765 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/scim"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+// scimUserColumns are the user columns a SCIM User resource sets; replacing a user clears the
+// ones the resource leaves out.
+var scimUserColumns = []string{"username", "first_name", "last_name", "email", "phone", "profile_picture", "department", "external_id"}
+
+// ScimService provisions an organization's users and groups for an identity provider over SCIM
+// 2.0, and manages the tokens the identity provider authenticates with. Protocol errors are
+// returned as *scim.Error.
+//
+// Requests are made by a token, which acts for the administrator who created it: the audit log
+// records them as the actor, and group changes can only pass on roles they hold.
+type ScimService interface {
+	Authenticate(token string) (*models.ScimToken, error)
+	GetTokens() ([]models.ScimToken, error)
+	CreateToken(request models.CreateScimTokenRequest, actorID string) (*models.CreatedScimToken, error)
+	RevokeToken(id string) (models.SuccessResponse, error)
+	GetUsers(query scim.ListQuery) ([]scim.User, int64, error)
+	GetUser(id string) (*scim.User, error)
+	CreateUser(resource scim.User) (*scim.User, error)
+	ReplaceUser(id string, resource scim.User) (*scim.User, error)
+	PatchUser(id string, patch scim.PatchRequest) (*scim.User, error)
+	DeleteUser(id string) error
+	GetGroups(query scim.ListQuery) ([]scim.Group, int64, error)
+	GetGroup(id string, projection scim.Projection) (*scim.Group, error)
+	CreateGroup(resource scim.Group) (*scim.Group, error)
+	ReplaceGroup(id string, resource scim.Group) (*scim.Group, error)
+	PatchGroup(id string, patch scim.PatchRequest) (*scim.Group, error)
+	DeleteGroup(id string) error
+	ForRequest(audit models.AuditContext) ScimService
+	ForToken(token *models.ScimToken, audit models.AuditContext) ScimService
+}
+
+type scimService struct {
+	tokenRepo      repositories.ScimTokenRepository
+	userRepo       repositories.UserRepository
+	groupRepo      repositories.GroupRepository
+	roleRepo       repositories.RoleRepository
+	sessionService SessionService
+	audit          auditor
+	outbox         outbox
+	token          *models.ScimToken
+}
+
+func NewScimService(tokenRepo repositories.ScimTokenRepository, userRepo repositories.UserRepository, groupRepo repositories.GroupRepository, roleRepo repositories.RoleRepository, sessionService SessionService, auditRepo repositories.AuditRepository, transactor repositories.Transactor, outboxRepo repositories.OutboxRepository) ScimService {
+	return &scimService{tokenRepo, userRepo, groupRepo, roleRepo, sessionService, newAuditor(auditRepo), newOutbox(transactor, outboxRepo), nil}
+}
+
+// ForRequest returns the service managing the tokens of the request's organization.
+func (s *scimService) ForRequest(audit models.AuditContext) ScimService {
+	organizationID := audit.OrganizationID
+	return &scimService{s.tokenRepo.WithTenant(organizationID), s.userRepo.WithTenant(organizationID), s.groupRepo.WithTenant(organizationID), s.roleRepo.WithTenant(organizationID), s.sessionService, s.audit.forRequest(audit), s.outbox, nil}
+}
+
+// ForToken returns the service provisioning the token's organization. The token's creator is
+// recorded as the actor of the request.
+func (s *scimService) ForToken(token *models.ScimToken, audit models.AuditContext) ScimService {
+	audit.OrganizationID = token.OrganizationID
+	audit.ActorID = token.CreatedBy
+	scoped := s.ForRequest(audit).(*scimService)
+	scoped.token = token
+	return scoped
+}
+
+// Authenticate returns the usable token with the value. When it was last used is only updated
+// once a minute, so that a provisioning run does not write on every request.
+func (s *scimService) Authenticate(value string) (*models.ScimToken, error) {
+	token, err := s.tokenRepo.FindByHash(utils.HashScimToken(value))
+	now := time.Now()
+	if err != nil || !token.IsUsable(now) {
+		return nil, errors.New("invalid token")
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		if err := s.tokenRepo.TouchLastUsed(token.ID, now); err != nil {
+			log.Printf("Failed to record use of SCIM token %s: %v", token.ID, err)
+		}
+	}
+	return token, nil
+}
+
+func (s *scimService) GetTokens() ([]models.ScimToken, error) {
+	return s.tokenRepo.FindAll()
+}
+
+// CreateToken issues a token acting for actorID. Its value is returned only here.
+func (s *scimService) CreateToken(request models.CreateScimTokenRequest, actorID string) (*models.CreatedScimToken, error) {
+	value, hash, err := utils.GenerateScimToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &models.ScimToken{Name: request.Name, TokenHash: hash, CreatedBy: actorID}
+	if request.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.tokenRepo.WithTx(tx).Create(token); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditScimTokenCreated, models.AuditTargetScimToken, token.ID, nil, token, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.CreatedScimToken{ScimToken: *token, Token: value}, nil
+}
+
+func (s *scimService) RevokeToken(id string) (models.SuccessResponse, error) {
+	token, err := s.tokenRepo.FindByID(id)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("SCIM token not found")
+	}
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.tokenRepo.WithTx(tx).Revoke(id, time.Now()); err != nil {
+			return errors.New("SCIM token is already revoked")
+		}
+		return s.audit.withTx(tx).record(models.AuditScimTokenRevoked, models.AuditTargetScimToken, id, token, nil, nil)
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	return models.SuccessResponse{Message: "SCIM token revoked successfully"}, nil
+}
+
+// actorID is the administrator the request's token acts for.
+func (s *scimService) actorID() string {
+	return s.token.CreatedBy
+}
+
+// details marks audit entries and events as made through SCIM, and by which token.
+func (s *scimService) details(extra map[string]interface{}) map[string]interface{} {
+	details := map[string]interface{}{"source": "scim", "scimTokenId": s.token.ID}
+	for key, value := range extra {
+		details[key] = value
+	}
+	return details
+}
+
+func (s *scimService) GetUsers(query scim.ListQuery) ([]scim.User, int64, error) {
+	users, total, err := s.userRepo.FindByScimFilter(query.Filter, query.Offset(), query.Count)
+	if err != nil {
+		return nil, 0, err
+	}
+	resources := make([]scim.User, 0, len(users))
+	for i := range users {
+		var groups []models.Group
+		if !query.Projection.Excludes("groups") {
+			if groups, err = s.groupRepo.FindAllByUserID(users[i].ID); err != nil {
+				return nil, 0, err
+			}
+		}
+		resources = append(resources, *toScimUser(&users[i], groups))
+	}
+	return resources, total, nil
+}
+
+func (s *scimService) GetUser(id string) (*scim.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, scim.NotFound("User", id)
+	}
+	return s.userResource(user)
+}
+
+// userResource returns the user as a SCIM resource, with the groups they belong to.
+func (s *scimService) userResource(user *models.User) (*scim.User, error) {
+	groups, err := s.groupRepo.FindAllByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return toScimUser(user, groups), nil
+}
+
+// CreateUser provisions a user with the user role. Without a password they cannot log in with
+// one, which suits users who sign in through the identity provider.
+func (s *scimService) CreateUser(resource scim.User) (*scim.User, error) {
+	user := &models.User{Role: models.RoleUser, Status: models.ActiveStatus}
+	if err := applyScimUser(user, resource); err != nil {
+		return nil, err
+	}
+	if resource.Active != nil && !*resource.Active {
+		user.Status = models.InactiveStatus
+	}
+	if err := s.checkUserUnique(user); err != nil {
+		return nil, err
+	}
+	password := resource.Password
+	if password == "" {
+		password = randomHex(32)
+	}
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hashedPassword
+	user.Slug = s.userSlug(user)
+
+	actorID := s.actorID()
+	err = s.outbox.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		if err := s.userRepo.WithTx(tx).Create(user); err != nil {
+			return nil, err
+		}
+		if err := s.roleRepo.WithTx(tx).SetUserRoles(user.ID, []string{string(user.Role)}, &actorID); err != nil {
+			return nil, err
+		}
+		if err := s.audit.withTx(tx).record(models.AuditUserCreated, models.AuditTargetUser, user.ID, nil, user, s.details(nil)); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{userEvent(models.DomainUserCreated, user, s.details(nil))}, nil
+	})
+	if err != nil {
+		return nil, uniquenessError(err)
+	}
+	return s.userResource(user)
+}
+
+func (s *scimService) ReplaceUser(id string, resource scim.User) (*scim.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, scim.NotFound("User", id)
+	}
+	return s.replaceUser(user, resource)
+}
+
+// PatchUser applies the operations to the user's current resource and saves the result as a
+// replacement.
+func (s *scimService) PatchUser(id string, patch scim.PatchRequest) (*scim.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, scim.NotFound("User", id)
+	}
+	current, err := s.userResource(user)
+	if err != nil {
+		return nil, err
+	}
+	object, err := scim.ToMap(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := patch.Apply(object); err != nil {
+		return nil, err
+	}
+	var patched scim.User
+	if err := scim.FromMap(object, &patched); err != nil {
+		return nil, err
+	}
+	return s.replaceUser(user, patched)
+}
+
+// replaceUser saves the writable attributes of the resource, then its password and whether the
+// user is active. Read-only attributes such as groups and roles are ignored.
+func (s *scimService) replaceUser(user *models.User, resource scim.User) (*scim.User, error) {
+	before := *user
+	if err := applyScimUser(user, resource); err != nil {
+		return nil, err
+	}
+	if err := s.checkUserUnique(user); err != nil {
+		return nil, err
+	}
+
+	if changed := changedFields(&before, user); len(changed) > 0 {
+		err := s.outbox.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+			if err := s.userRepo.WithTx(tx).UpdateFields(user, scimUserColumns...); err != nil {
+				return nil, err
+			}
+			if err := s.audit.withTx(tx).record(models.AuditUserUpdated, models.AuditTargetUser, user.ID, &before, user, s.details(nil)); err != nil {
+				return nil, err
+			}
+			return []models.OutboxEvent{userEvent(models.DomainUserUpdated, user, s.details(map[string]interface{}{"changedFields": changed}))}, nil
+		})
+		if err != nil {
+			*user = before
+			return nil, uniquenessError(err)
+		}
+	}
+
+	if resource.Password != "" {
+		hashedPassword, err := utils.HashPassword(resource.Password)
+		if err != nil {
+			return nil, err
+		}
+		err = updateUserPassword(s.userRepo, s.outbox, user, hashedPassword, "scim", func(tx *gorm.DB) error {
+			return s.audit.withTx(tx).record(models.AuditUserPasswordResetAdmin, models.AuditTargetUser, user.ID, nil, nil, s.details(nil))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resource.Active != nil {
+		if err := s.setActive(user, bool(*resource.Active)); err != nil {
+			return nil, err
+		}
+	}
+	return s.userResource(user)
+}
+
+// setActive deactivates an active user, or reactivates one the identity provider deactivated.
+// Suspensions and lockouts are left to administrators: a user who is neither active nor
+// inactive keeps their status.
+func (s *scimService) setActive(user *models.User, active bool) error {
+	actorID := s.actorID()
+	switch {
+	case !active && user.Status == models.ActiveStatus:
+		if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.InactiveStatus, "deactivated by the identity provider", &actorID, nil); err != nil {
+			return err
+		}
+		s.endSessions(user.ID)
+	case active && (user.Status == models.InactiveStatus || user.Status == models.PendingStatus):
+		return changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.ActiveStatus, "activated by the identity provider", &actorID, nil)
+	}
+	return nil
+}
+
+// DeleteUser soft-deletes the user, like an administrator deleting them, and signs them out.
+func (s *scimService) DeleteUser(id string) error {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return scim.NotFound("User", id)
+	}
+	actorID := s.actorID()
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.DeletedStatus, "deleted by the identity provider", &actorID, nil); err != nil {
+		if errors.Is(err, repositories.ErrLastAdmin) {
+			return scim.NewError(400, scim.Mutability, err.Error())
+		}
+		return err
+	}
+	s.endSessions(user.ID)
+	return nil
+}
+
+// checkUserUnique refuses a username or email that belongs to another user.
+func (s *scimService) checkUserUnique(user *models.User) error {
+	if existing, err := s.userRepo.FindByUsername(user.Username); err == nil && existing.ID != user.ID {
+		return scim.NewError(409, scim.Uniqueness, "userName is already taken")
+	}
+	if existing, err := s.userRepo.FindByEmail(user.Email); err == nil && existing.ID != user.ID {
+		return scim.NewError(409, scim.Uniqueness, "email is already registered")
+	}
+	return nil
+}
+
+// userSlug returns a slug from the user's name, made unique with a random suffix when another
+// user already has it.
+func (s *scimService) userSlug(user *models.User) string {
+	slug := utils.GenerateSlug(user.FirstName + " " + user.LastName)
+	if _, err := s.userRepo.FindBySlug(slug); err == nil {
+		slug += "-" + randomHex(4)
+	}
+	return slug
+}
+
+func (s *scimService) GetGroups(query scim.ListQuery) ([]scim.Group, int64, error) {
+	groups, total, err := s.groupRepo.FindByScimFilter(query.Filter, query.Offset(), query.Count)
+	if err != nil {
+		return nil, 0, err
+	}
+	resources := make([]scim.Group, 0, len(groups))
+	for i := range groups {
+		var members []models.User
+		if !query.Projection.Excludes("members") {
+			if members, err = s.groupRepo.FindAllMembers(groups[i].ID); err != nil {
+				return nil, 0, err
+			}
+		}
+		resources = append(resources, *toScimGroup(&groups[i], members))
+	}
+	return resources, total, nil
+}
+
+// GetGroup returns the group, leaving out its members when the projection does not need them.
+func (s *scimService) GetGroup(id string, projection scim.Projection) (*scim.Group, error) {
+	group, err := s.groupRepo.FindByID(id)
+	if err != nil {
+		return nil, scim.NotFound("Group", id)
+	}
+	if projection.Excludes("members") {
+		return toScimGroup(group, nil), nil
+	}
+	return s.groupResource(group)
+}
+
+func (s *scimService) groupResource(group *models.Group) (*scim.Group, error) {
+	members, err := s.groupRepo.FindAllMembers(group.ID)
+	if err != nil {
+		return nil, err
+	}
+	return toScimGroup(group, members), nil
+}
+
+// CreateGroup creates a top-level group with the members. It has no roles yet, so adding them
+// grants nothing.
+func (s *scimService) CreateGroup(resource scim.Group) (*scim.Group, error) {
+	if strings.TrimSpace(resource.DisplayName) == "" {
+		return nil, scim.NewError(400, scim.InvalidValue, "displayName is required")
+	}
+	if _, err := s.groupRepo.FindByName(resource.DisplayName); err == nil {
+		return nil, scim.NewError(409, scim.Uniqueness, "displayName is already taken")
+	}
+	memberIDs, err := s.memberIDs(resource.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &models.Group{Name: resource.DisplayName, ExternalID: optional(resource.ExternalID)}
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		groupRepo, audit := s.groupRepo.WithTx(tx), s.audit.withTx(tx)
+		if err := groupRepo.Create(group); err != nil {
+			return uniquenessError(err)
+		}
+		if err := audit.record(models.AuditGroupCreated, models.AuditTargetGroup, group.ID, nil, group, s.details(nil)); err != nil {
+			return err
+		}
+		if len(memberIDs) == 0 {
+			return nil
+		}
+		actorID := s.actorID()
+		if err := groupRepo.AddMembers(group.ID, memberIDs, &actorID); err != nil {
+			return err
+		}
+		return audit.record(models.AuditGroupMembersChanged, models.AuditTargetGroup, group.ID, nil, nil, s.details(map[string]interface{}{"added": memberIDs}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.groupResource(group)
+}
+
+func (s *scimService) ReplaceGroup(id string, resource scim.Group) (*scim.Group, error) {
+	group, err := s.groupRepo.FindByID(id)
+	if err != nil {
+		return nil, scim.NotFound("Group", id)
+	}
+	return s.replaceGroup(group, resource)
+}
+
+// PatchGroup applies the operations to the group's current resource, members included, and
+// saves the result as a replacement.
+func (s *scimService) PatchGroup(id string, patch scim.PatchRequest) (*scim.Group, error) {
+	group, err := s.groupRepo.FindByID(id)
+	if err != nil {
+		return nil, scim.NotFound("Group", id)
+	}
+	current, err := s.groupResource(group)
+	if err != nil {
+		return nil, err
+	}
+	object, err := scim.ToMap(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := patch.Apply(object); err != nil {
+		return nil, err
+	}
+	var patched scim.Group
+	if err := scim.FromMap(object, &patched); err != nil {
+		return nil, err
+	}
+	return s.replaceGroup(group, patched)
+}
+
+// replaceGroup renames the group and sets its direct members to those of the resource. Members
+// inherit the group's roles, so changing them requires the token's creator to hold those roles'
+// permissions, and members whose roles change are signed out.
+func (s *scimService) replaceGroup(group *models.Group, resource scim.Group) (*scim.Group, error) {
+	if strings.TrimSpace(resource.DisplayName) == "" {
+		return nil, scim.NewError(400, scim.InvalidValue, "displayName is required")
+	}
+	if existing, err := s.groupRepo.FindByName(resource.DisplayName); err == nil && existing.ID != group.ID {
+		return nil, scim.NewError(409, scim.Uniqueness, "displayName is already taken")
+	}
+	memberIDs, err := s.memberIDs(resource.Members)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.groupRepo.FindAllMembers(group.ID)
+	if err != nil {
+		return nil, err
+	}
+	added, removed := membershipChanges(current, memberIDs)
+
+	inherited := []models.Role{}
+	if len(added) > 0 || len(removed) > 0 {
+		if inherited, err = s.groupRepo.FindInheritedRoles(group.ID); err != nil {
+			return nil, err
+		}
+		if err := checkCanGrant(s.roleRepo, s.actorID(), inherited); err != nil {
+			return nil, scim.NewError(403, "", err.Error())
+		}
+	}
+
+	before := *group
+	group.Name = resource.DisplayName
+	group.ExternalID = optional(resource.ExternalID)
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		groupRepo, audit := s.groupRepo.WithTx(tx), s.audit.withTx(tx)
+		if len(changedFields(&before, group)) > 0 {
+			if err := groupRepo.Update(group); err != nil {
+				return uniquenessError(err)
+			}
+			if err := audit.record(models.AuditGroupUpdated, models.AuditTargetGroup, group.ID, &before, group, s.details(nil)); err != nil {
+				return err
+			}
+		}
+		if len(added) == 0 && len(removed) == 0 {
+			return nil
+		}
+		if len(added) > 0 {
+			actorID := s.actorID()
+			if err := groupRepo.AddMembers(group.ID, added, &actorID); err != nil {
+				return err
+			}
+		}
+		for _, userID := range removed {
+			if err := groupRepo.RemoveMember(group.ID, userID); err != nil {
+				if errors.Is(err, repositories.ErrLastAdmin) {
+					return scim.NewError(400, scim.Mutability, err.Error())
+				}
+				return err
+			}
+		}
+		return audit.record(models.AuditGroupMembersChanged, models.AuditTargetGroup, group.ID, nil, nil, s.details(map[string]interface{}{"added": added, "removed": removed}))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(inherited) > 0 {
+		for _, userID := range append(added, removed...) {
+			s.endSessions(userID)
+		}
+	}
+	return s.groupResource(group)
+}
+
+// DeleteGroup deletes a group that has no subgroups, along with its memberships and roles.
+func (s *scimService) DeleteGroup(id string) error {
+	group, err := s.groupRepo.FindByID(id)
+	if err != nil {
+		return scim.NotFound("Group", id)
+	}
+	hasSubgroups, err := s.groupRepo.HasSubgroups(id)
+	if err != nil {
+		return err
+	}
+	if hasSubgroups {
+		return scim.NewError(400, scim.Mutability, "the group has subgroups")
+	}
+	inherited, err := s.groupRepo.FindInheritedRoles(id)
+	if err != nil {
+		return err
+	}
+	if err := checkCanGrant(s.roleRepo, s.actorID(), inherited); err != nil {
+		return scim.NewError(403, "", err.Error())
+	}
+	memberIDs, err := s.groupRepo.FindSubtreeMemberIDs(id)
+	if err != nil {
+		return err
+	}
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.groupRepo.WithTx(tx).Delete(id); err != nil {
+			if errors.Is(err, repositories.ErrLastAdmin) {
+				return scim.NewError(400, scim.Mutability, err.Error())
+			}
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditGroupDeleted, models.AuditTargetGroup, id, group, nil, s.details(nil))
+	})
+	if err != nil {
+		return err
+	}
+	if len(inherited) > 0 {
+		for _, userID := range memberIDs {
+			s.endSessions(userID)
+		}
+	}
+	return nil
+}
+
+// memberIDs returns the user IDs of the members, which must all be users of the organization.
+func (s *scimService) memberIDs(members []scim.MultiValue) ([]string, error) {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, member := range members {
+		if member.Type != "" && !strings.EqualFold(member.Type, "User") {
+			return nil, scim.NewError(400, scim.InvalidValue, "only users can be group members")
+		}
+		if seen[member.Value] {
+			continue
+		}
+		if _, err := s.userRepo.FindByID(member.Value); err != nil {
+			return nil, scim.NewError(400, scim.InvalidValue, "user "+member.Value+" not found")
+		}
+		seen[member.Value] = true
+		ids = append(ids, member.Value)
+	}
+	return ids, nil
+}
+
+// endSessions signs the user out everywhere after they were deactivated or their roles changed.
+func (s *scimService) endSessions(userID string) {
+	if err := s.sessionService.RevokeAll(userID); err != nil {
+		log.Printf("Failed to revoke sessions for user %s after a SCIM change: %v", userID, err)
+	}
+}
+
+// membershipChanges returns the IDs in wanted that are not current members, and the current
+// members not in wanted.
+func membershipChanges(current []models.User, wanted []string) (added []string, removed []string) {
+	isMember := map[string]bool{}
+	for _, user := range current {
+		isMember[user.ID] = true
+	}
+	isWanted := map[string]bool{}
+	for _, id := range wanted {
+		isWanted[id] = true
+		if !isMember[id] {
+			added = append(added, id)
+		}
+	}
+	for _, user := range current {
+		if !isWanted[user.ID] {
+			removed = append(removed, user.ID)
+		}
+	}
+	return added, removed
+}
+
+// applyScimUser copies the writable attributes of the resource onto the user. Users have one
+// email address, phone number and photo each, taken from the primary value. When the resource
+// has no email, a userName that is an email address is used instead.
+func applyScimUser(user *models.User, resource scim.User) error {
+	if strings.TrimSpace(resource.UserName) == "" {
+		return scim.NewError(400, scim.InvalidValue, "userName is required")
+	}
+	user.Username = resource.UserName
+	user.ExternalID = optional(resource.ExternalID)
+
+	user.FirstName, user.LastName = "", ""
+	if resource.Name != nil && (resource.Name.GivenName != "" || resource.Name.FamilyName != "") {
+		user.FirstName, user.LastName = resource.Name.GivenName, resource.Name.FamilyName
+	} else if resource.DisplayName != "" {
+		user.FirstName, user.LastName, _ = strings.Cut(resource.DisplayName, " ")
+	}
+
+	user.Email = ""
+	if email, ok := scim.Primary(resource.Emails); ok {
+		user.Email = email.Value
+	} else if strings.Contains(resource.UserName, "@") {
+		user.Email = resource.UserName
+	}
+	if user.Email == "" {
+		return scim.NewError(400, scim.InvalidValue, "an email address is required")
+	}
+	user.Phone = ""
+	if phone, ok := scim.Primary(resource.PhoneNumbers); ok {
+		user.Phone = phone.Value
+	}
+	user.ProfilePicture = nil
+	if photo, ok := scim.Primary(resource.Photos); ok {
+		user.ProfilePicture = optional(photo.Value)
+	}
+	user.Department = ""
+	if resource.Enterprise != nil {
+		user.Department = resource.Enterprise.Department
+	}
+	return nil
+}
+
+// toScimUser returns the user as a SCIM resource. Users are active only in the active status.
+func toScimUser(user *models.User, groups []models.Group) *scim.User {
+	active := scim.Boolean(user.Status == models.ActiveStatus)
+	displayName := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	resource := &scim.User{
+		Schemas:     []string{scim.UserSchema},
+		ID:          user.ID,
+		UserName:    user.Username,
+		Name:        &scim.Name{Formatted: displayName, GivenName: user.FirstName, FamilyName: user.LastName},
+		DisplayName: displayName,
+		Active:      &active,
+		Roles:       []scim.MultiValue{{Value: string(user.Role), Primary: true}},
+		Meta:        &scim.Meta{ResourceType: "User", Created: &user.CreatedAt, LastModified: &user.UpdatedAt},
+	}
+	if user.ExternalID != nil {
+		resource.ExternalID = *user.ExternalID
+	}
+	if user.Email != "" {
+		resource.Emails = []scim.MultiValue{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	if user.Phone != "" {
+		resource.PhoneNumbers = []scim.MultiValue{{Value: user.Phone, Type: "work", Primary: true}}
+	}
+	if user.ProfilePicture != nil && *user.ProfilePicture != "" {
+		resource.Photos = []scim.MultiValue{{Value: *user.ProfilePicture, Type: "photo", Primary: true}}
+	}
+	if user.Department != "" {
+		resource.Schemas = append(resource.Schemas, scim.EnterpriseUserSchema)
+		resource.Enterprise = &scim.EnterpriseUser{Department: user.Department}
+	}
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, scim.MultiValue{Value: group.ID, Display: group.Name})
+	}
+	return resource
+}
+
+// toScimGroup returns the group as a SCIM resource with its direct members.
+func toScimGroup(group *models.Group, members []models.User) *scim.Group {
+	resource := &scim.Group{
+		Schemas:     []string{scim.GroupSchema},
+		ID:          group.ID,
+		DisplayName: group.Name,
+		Meta:        &scim.Meta{ResourceType: "Group", Created: &group.CreatedAt, LastModified: &group.UpdatedAt},
+	}
+	if group.ExternalID != nil {
+		resource.ExternalID = *group.ExternalID
+	}
+	for _, member := range members {
+		resource.Members = append(resource.Members, scim.MultiValue{
+			Value:   member.ID,
+			Display: strings.TrimSpace(member.FirstName + " " + member.LastName),
+			Type:    "User",
+		})
+	}
+	return resource
+}
+
+// uniquenessError reports a unique constraint violation, from a concurrent request that got in
+// between the checks and the write, as a SCIM uniqueness error.
+func uniquenessError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return scim.NewError(409, scim.Uniqueness, "a resource with these values already exists")
+	}
+	return err
+}
+
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
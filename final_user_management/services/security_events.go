@@ -0,0 +1,29 @@
+package services
+
+import (
+	"log"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+// recordSecurityEvent persists an entry in the user's security feed.
+// Failures are logged rather than returned so that auditing never blocks the caller.
+func recordSecurityEvent(repo repositories.SecurityEventRepository, user *models.User, identifier string, eventType models.SecurityEventType, outcome models.SecurityEventOutcome, reason string, meta models.RequestMeta) {
+	event := &models.SecurityEvent{
+		Identifier: identifier,
+		Type:       eventType,
+		Outcome:    outcome,
+		IP:         meta.IP,
+		UserAgent:  meta.UserAgent,
+	}
+	if user != nil {
+		event.UserID = &user.ID
+	}
+	if reason != "" {
+		event.FailureReason = &reason
+	}
+	if err := repo.Create(event); err != nil {
+		log.Printf("Failed to record %s security event: %v", eventType, err)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+type SessionService interface {
+	Create(userID string, deviceID string, meta models.RequestMeta) (*models.Session, error)
+	CreateImpersonation(userID string, impersonatorID string, lifetime time.Duration, meta models.RequestMeta) (*models.Session, error)
+	Validate(claims *utils.Claims) error
+	Revoke(sessionID string) error
+	RevokeAll(userID string) error
+	WithTx(tx *gorm.DB) SessionService
+}
+
+type sessionService struct {
+	sessionRepo      repositories.SessionRepository
+	userRepo         repositories.UserRepository
+	organizationRepo repositories.OrganizationRepository
+	audit            auditor
+	outbox           outbox
+}
+
+func NewSessionService(sessionRepo repositories.SessionRepository, userRepo repositories.UserRepository, organizationRepo repositories.OrganizationRepository, auditRepo repositories.AuditRepository, transactor repositories.Transactor, outboxRepo repositories.OutboxRepository) SessionService {
+	return &sessionService{sessionRepo, userRepo, organizationRepo, newAuditor(auditRepo), newOutbox(transactor, outboxRepo)}
+}
+
+// WithTx returns the service working inside the transaction, so that the sessions it creates or
+// revokes commit or roll back with the caller's changes.
+func (s *sessionService) WithTx(tx *gorm.DB) SessionService {
+	return &sessionService{s.sessionRepo.WithTx(tx), s.userRepo.WithTx(tx), s.organizationRepo.WithTx(tx), s.audit.withTx(tx), newOutbox(repositories.NewTransactor(tx), s.outbox.repo)}
+}
+
+func (s *sessionService) Create(userID string, deviceID string, meta models.RequestMeta) (*models.Session, error) {
+	session := &models.Session{
+		UserID:    userID,
+		DeviceID:  deviceID,
+		IP:        meta.IP,
+		UserAgent: meta.UserAgent,
+		ExpiresAt: time.Now().Add(utils.TokenLifetime),
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *sessionService) CreateImpersonation(userID string, impersonatorID string, lifetime time.Duration, meta models.RequestMeta) (*models.Session, error) {
+	session := &models.Session{
+		UserID:         userID,
+		IP:             meta.IP,
+		UserAgent:      meta.UserAgent,
+		ImpersonatorID: &impersonatorID,
+		ExpiresAt:      time.Now().Add(lifetime),
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Validate checks that the token's session exists, belongs to the token's user and has not been
+// revoked, and that the user's account is still active.
+func (s *sessionService) Validate(claims *utils.Claims) error {
+	if claims.ID == "" {
+		return errors.New("token is not bound to a session")
+	}
+	session, err := s.sessionRepo.FindByID(claims.ID)
+	if err != nil {
+		return errors.New("session not found")
+	}
+	if session.UserID != claims.UserID {
+		return errors.New("session does not belong to user")
+	}
+	if session.RevokedAt != nil {
+		return errors.New("session has been revoked")
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return errors.New("session has expired")
+	}
+	if claims.Act != nil && (session.ImpersonatorID == nil || *session.ImpersonatorID != claims.Act.Sub) {
+		return errors.New("impersonation does not match session")
+	}
+
+	// Tokens issued before multi-tenancy carry no organization and must be replaced.
+	if claims.OrgID == "" {
+		return errors.New("token has no organization")
+	}
+	organization, err := s.organizationRepo.FindByID(claims.OrgID)
+	if err != nil {
+		return errors.New("organization not found")
+	}
+	if !organization.Active {
+		return errors.New("organization is inactive")
+	}
+	user, err := s.userRepo.WithTenant(claims.OrgID).FindByID(claims.UserID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if err := releaseExpiredRestriction(s.userRepo, s.audit, s.outbox, user); err != nil {
+		return err
+	}
+	if user.Status != models.ActiveStatus {
+		return fmt.Errorf("account is %s", user.Status)
+	}
+	return nil
+}
+
+func (s *sessionService) Revoke(sessionID string) error {
+	return s.sessionRepo.Revoke(sessionID)
+}
+
+func (s *sessionService) RevokeAll(userID string) error {
+	return s.sessionRepo.RevokeAllForUser(userID)
+}
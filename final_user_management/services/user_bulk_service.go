@@ -0,0 +1,331 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// bulkPermissions is the permission each bulk action needs on every user it changes, checked
+// with the access policies as the single-user endpoints do.
+var bulkPermissions = map[models.BulkAction]models.Permission{
+	models.BulkDeactivate:         models.PermUsersManageStatus,
+	models.BulkReactivate:         models.PermUsersManageStatus,
+	models.BulkDelete:             models.PermUsersDelete,
+	models.BulkAssignRole:         models.PermRolesManage,
+	models.BulkForcePasswordReset: models.PermUsersResetPassword,
+	models.BulkRevokeSessions:     models.PermUsersManageStatus,
+}
+
+// errDryRun rolls back a dry run's batch once every change in it has been made.
+var errDryRun = errors.New("dry run")
+
+// bulkLimitsFromEnv returns how many users one bulk action may select, how many users are
+// changed in each transaction, and above how many users the action runs in the background.
+func bulkLimitsFromEnv() (maxUsers int, batchSize int, syncLimit int) {
+	read := func(name string, fallback int) int {
+		value, err := strconv.Atoi(os.Getenv(name))
+		if err != nil || value <= 0 {
+			return fallback
+		}
+		return value
+	}
+	return read("BULK_MAX_USERS", 10000), read("BULK_BATCH_SIZE", 100), read("BULK_SYNC_LIMIT", 100)
+}
+
+// BulkUserService applies one administrative action to many users. Users are changed in
+// batches, each in its own transaction, with every user in a savepoint so that one failing
+// user does not undo the rest of the batch. Each change is audited like its single-user
+// counterpart, in the user's savepoint; sign-outs and emails wait until the batch has committed.
+type BulkUserService interface {
+	SelectUsers(request models.BulkUserRequest, actorID string) ([]string, error)
+	NeedsBackgroundRun(userIDs []string) bool
+	Apply(request models.BulkUserRequest, userIDs []string, viewer models.Viewer, meta models.RequestMeta) models.BulkUserReport
+	StartApply(request models.BulkUserRequest, userIDs []string, viewer models.Viewer, meta models.RequestMeta) (*models.Job, error)
+	ForRequest(audit models.AuditContext) BulkUserService
+}
+
+type bulkUserService struct {
+	userRepo             repositories.UserRepository
+	roleRepo             repositories.RoleRepository
+	securityEventRepo    repositories.SecurityEventRepository
+	sessionService       SessionService
+	authorizationService AuthorizationService
+	jobService           JobService
+	audit                auditor
+	transactor           repositories.Transactor
+	outboxRepo           repositories.OutboxRepository
+}
+
+func NewBulkUserService(userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, securityEventRepo repositories.SecurityEventRepository, sessionService SessionService, authorizationService AuthorizationService, jobService JobService, auditRepo repositories.AuditRepository, transactor repositories.Transactor, outboxRepo repositories.OutboxRepository) BulkUserService {
+	return &bulkUserService{userRepo, roleRepo, securityEventRepo, sessionService, authorizationService, jobService, newAuditor(auditRepo), transactor, outboxRepo}
+}
+
+// ForRequest returns the service working on the request's organization and recording its actor
+// in the audit log.
+func (s *bulkUserService) ForRequest(audit models.AuditContext) BulkUserService {
+	organizationID := audit.OrganizationID
+	return &bulkUserService{s.userRepo.WithTenant(organizationID), s.roleRepo.WithTenant(organizationID), s.securityEventRepo, s.sessionService, s.authorizationService.ForTenant(organizationID), s.jobService.ForTenant(organizationID), s.audit.forRequest(audit), s.transactor, s.outboxRepo}
+}
+
+// SelectUsers checks the request and returns the IDs of the users it selects, without
+// duplicates. IDs that name no user are kept and reported as failures.
+func (s *bulkUserService) SelectUsers(request models.BulkUserRequest, actorID string) ([]string, error) {
+	if (len(request.IDs) == 0) == (request.Filter == nil) {
+		return nil, errors.New("select users with either ids or filter")
+	}
+	if request.Action == models.BulkAssignRole {
+		if request.Role == "" {
+			return nil, errors.New("assign_role requires a role")
+		}
+		roles, err := findRolesByName(s.roleRepo, []string{request.Role})
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCanGrant(s.roleRepo, actorID, roles); err != nil {
+			return nil, err
+		}
+	}
+
+	userIDs := request.IDs
+	if request.Filter != nil {
+		var err error
+		if userIDs, err = s.userRepo.FindAllIDs(*request.Filter); err != nil {
+			return nil, err
+		}
+	}
+	seen := make(map[string]bool, len(userIDs))
+	unique := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	if maxUsers, _, _ := bulkLimitsFromEnv(); len(unique) > maxUsers {
+		return nil, fmt.Errorf("%d users are selected, more than the %d allowed in one bulk action", len(unique), maxUsers)
+	}
+	return unique, nil
+}
+
+func (s *bulkUserService) NeedsBackgroundRun(userIDs []string) bool {
+	_, _, syncLimit := bulkLimitsFromEnv()
+	return len(userIDs) > syncLimit
+}
+
+func (s *bulkUserService) StartApply(request models.BulkUserRequest, userIDs []string, viewer models.Viewer, meta models.RequestMeta) (*models.Job, error) {
+	return s.jobService.Start(models.JobTypeUserBulk, viewer.UserID, nil, func(job *models.Job) (JobOutput, error) {
+		return JobOutput{Result: s.Apply(request, userIDs, viewer, meta)}, nil
+	})
+}
+
+func (s *bulkUserService) Apply(request models.BulkUserRequest, userIDs []string, viewer models.Viewer, meta models.RequestMeta) models.BulkUserReport {
+	report := models.BulkUserReport{Action: request.Action, DryRun: request.DryRun, Total: len(userIDs), Results: make([]models.BulkUserResult, 0, len(userIDs))}
+	_, batchSize, _ := bulkLimitsFromEnv()
+
+	for start := 0; start < len(userIDs); start += batchSize {
+		end := start + batchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		for _, result := range s.applyBatch(request, userIDs[start:end], viewer, meta) {
+			if result.Status == models.BulkUserFailed {
+				report.Failed++
+			} else {
+				report.Succeeded++
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report
+}
+
+// applyBatch changes the users in one transaction. A dry run makes the changes without auditing
+// them, so that every check still runs, and then rolls them back. Otherwise each user's change
+// and its audit entries are saved or undone together; the work that cannot be rolled back
+// (sign-outs and emails) only happens once the batch commits.
+func (s *bulkUserService) applyBatch(request models.BulkUserRequest, userIDs []string, viewer models.Viewer, meta models.RequestMeta) []models.BulkUserResult {
+	results := make([]models.BulkUserResult, 0, len(userIDs))
+	var afterCommit []func()
+
+	err := s.transactor.Transaction(func(tx *gorm.DB) error {
+		for _, userID := range userIDs {
+			result := models.BulkUserResult{UserID: userID, Status: models.BulkUserApplied}
+			if request.DryRun {
+				result.Status = models.BulkUserValid
+			}
+
+			err := s.authorize(viewer, bulkPermissions[request.Action], userID)
+			if err == nil {
+				err = repositories.NewTransactor(tx).Transaction(func(userTx *gorm.DB) error {
+					// A dry run only runs the checks; nothing is changed, so nothing is audited
+					audit := auditor{context: s.audit.context}
+					if !request.DryRun {
+						audit = s.audit.withTx(userTx)
+					}
+					after, err := s.applyTo(userTx, audit, request, userID, viewer.UserID, meta)
+					if err == nil && after != nil {
+						afterCommit = append(afterCommit, after)
+					}
+					return err
+				})
+			}
+			if err != nil {
+				result.Status, result.Error = models.BulkUserFailed, err.Error()
+			}
+			results = append(results, result)
+		}
+		if request.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errDryRun) {
+		for i := range results {
+			if results[i].Status != models.BulkUserFailed {
+				results[i].Status, results[i].Error = models.BulkUserFailed, "the batch could not be saved: "+err.Error()
+			}
+		}
+		return results
+	}
+	if !request.DryRun {
+		for _, after := range afterCommit {
+			after()
+		}
+	}
+	return results
+}
+
+// authorize checks the action against the user with the access policies.
+func (s *bulkUserService) authorize(viewer models.Viewer, permission models.Permission, userID string) error {
+	decision, err := s.authorizationService.Check(viewer, string(permission), userID)
+	if errors.Is(err, ErrResourceNotFound) {
+		return errors.New("user not found")
+	}
+	if err != nil {
+		return err
+	}
+	if !decision.Allowed {
+		return errors.New("forbidden: " + decision.Reason)
+	}
+	return nil
+}
+
+// applyTo makes the change to one user inside the transaction, auditing it with audit, which must
+// write in the same transaction. It returns what must be done once the change has committed, if
+// anything.
+func (s *bulkUserService) applyTo(tx *gorm.DB, audit auditor, request models.BulkUserRequest, userID string, actorID string, meta models.RequestMeta) (func(), error) {
+	userRepo := s.userRepo.WithTx(tx)
+	roleRepo := s.roleRepo.WithTx(tx)
+	events := newOutbox(repositories.NewTransactor(tx), s.outboxRepo)
+	user, err := s.userRepo.WithTx(tx.Clauses(clause.Locking{Strength: "UPDATE"})).FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	switch request.Action {
+	case models.BulkDeactivate:
+		if userID == actorID {
+			return nil, errors.New("you cannot deactivate yourself")
+		}
+		return nil, changeUserStatus(userRepo, audit, events, user, models.InactiveStatus, request.Reason, &actorID, nil)
+
+	case models.BulkReactivate:
+		if user.Status != models.SuspendedStatus && user.Status != models.InactiveStatus {
+			return nil, fmt.Errorf("only suspended or inactive users can be reactivated, user is %s", user.Status)
+		}
+		return nil, changeUserStatus(userRepo, audit, events, user, models.ActiveStatus, request.Reason, &actorID, nil)
+
+	case models.BulkDelete:
+		if userID == actorID {
+			return nil, errors.New("you cannot delete yourself")
+		}
+		reason := request.Reason
+		if reason == "" {
+			reason = "deleted by admin"
+		}
+		users := &userService{userRepo, s.securityEventRepo, s.sessionService, roleRepo, nil, nil, audit, events}
+		return nil, users.deleteUser(user, reason, &actorID)
+
+	case models.BulkAssignRole:
+		roles, err := findRolesByName(roleRepo, []string{request.Role})
+		if err != nil {
+			return nil, err
+		}
+		assigner := &roleService{roleRepo, userRepo, s.sessionService, audit, events}
+		err = events.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+			if err := roleRepo.WithTx(tx).AddUserRoles(userID, []string{roles[0].ID}, &actorID); err != nil {
+				return nil, err
+			}
+			if err := audit.withTx(tx).record(models.AuditUserRolesChanged, models.AuditTargetUser, userID, nil, nil, map[string]interface{}{"added": []string{request.Role}}); err != nil {
+				return nil, err
+			}
+			return assigner.rolesChangedEvent(tx, userID, map[string]interface{}{"added": []string{request.Role}})
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func() { assigner.endSessions(userID) }, nil
+
+	case models.BulkForcePasswordReset:
+		// The random password is never known to anyone, so the user can only sign in again
+		// after choosing a new one
+		hashedPassword, err := utils.HashPassword(randomHex(32))
+		if err != nil {
+			return nil, err
+		}
+		err = updateUserPassword(userRepo, events, user, hashedPassword, "forced_reset", func(tx *gorm.DB) error {
+			return audit.withTx(tx).record(models.AuditUserPasswordForceReset, models.AuditTargetUser, userID, nil, nil, nil)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func() {
+			s.revokeSessions(userID)
+			recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordChangedByAdmin, models.OutcomeSuccess, "", meta)
+			if err := sendForcedPasswordReset(user); err != nil {
+				log.Printf("Failed to send password reset email to %s: %v", user.Email, err)
+			}
+		}, nil
+
+	case models.BulkRevokeSessions:
+		if err := audit.record(models.AuditUserSessionsRevoked, models.AuditTargetUser, userID, nil, nil, nil); err != nil {
+			return nil, err
+		}
+		return func() { s.revokeSessions(userID) }, nil
+	}
+	return nil, fmt.Errorf("unknown action %q", request.Action)
+}
+
+func (s *bulkUserService) revokeSessions(userID string) {
+	if err := s.sessionService.RevokeAll(userID); err != nil {
+		log.Printf("Failed to revoke sessions for user %s: %v", userID, err)
+	}
+}
+
+// sendForcedPasswordReset emails a user whose password was reset by an administrator a link to
+// choose a new one. Once it expires, they can ask for another from the sign-in page.
+func sendForcedPasswordReset(user *models.User) error {
+	token, err := utils.GeneratePasswordResetToken(user.OrganizationID, user.Email)
+	if err != nil {
+		return err
+	}
+	link := fmt.Sprintf("%s/auth/reset-password?token=%s", appBaseURL(), token)
+	body := fmt.Sprintf(
+		"Hello %s,<br>An administrator has reset your password and signed you out everywhere.<br>"+
+			"<a href=\"%s\">Choose a new password</a> to sign in again. The link expires in 1 hour.",
+		user.FirstName, link,
+	)
+	return utils.SendEmail(emailConfigFromEnv(), user.Email, "Your password has been reset", body)
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+)
+
+// exportColumns are the user fields an export can have, in the order they are written.
+var exportColumns = []string{
+	"id", "username", "slug", "firstName", "lastName", "email", "phone", "nationalId", "passportNumber",
+	"clientId", "externalId", "role", "department", "status", "statusReason", "suspendedUntil",
+	"lockedUntil", "deletionScheduledAt", "lastLoginAt", "lastLoginIp", "profilePicture", "createdAt", "updatedAt",
+}
+
+// userExportSyncLimitFromEnv is the number of users above which an export is generated in the
+// background instead of streamed in the response.
+func userExportSyncLimitFromEnv() int64 {
+	limit, err := strconv.ParseInt(os.Getenv("USER_EXPORT_SYNC_LIMIT"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = 10000
+	}
+	return limit
+}
+
+// UserExportService writes every user matching the GetAllUsers filters to a CSV, XLSX or NDJSON
+// file. Users are read from a database cursor and written as they are read, so exports of any
+// size use constant memory. Each user is rendered for the caller with NewUserView, so masked
+// fields stay masked and hidden fields cannot be exported.
+type UserExportService interface {
+	ExportColumns(request models.ExportUsersRequest, viewer models.Viewer) ([]string, error)
+	NeedsBackgroundExport(request models.ExportUsersRequest) (bool, error)
+	WriteExport(request models.ExportUsersRequest, viewer models.Viewer, w io.Writer) error
+	StartExport(request models.ExportUsersRequest, viewer models.Viewer, requesterID string) (*models.Job, error)
+	ForRequest(audit models.AuditContext) UserExportService
+}
+
+type userExportService struct {
+	userRepo   repositories.UserRepository
+	jobService JobService
+	audit      auditor
+}
+
+func NewUserExportService(userRepo repositories.UserRepository, jobService JobService, auditRepo repositories.AuditRepository) UserExportService {
+	return &userExportService{userRepo, jobService, newAuditor(auditRepo)}
+}
+
+// ForRequest returns the service exporting the request's organization and recording its actor
+// in the audit log.
+func (s *userExportService) ForRequest(audit models.AuditContext) UserExportService {
+	return &userExportService{s.userRepo.WithTenant(audit.OrganizationID), s.jobService.ForTenant(audit.OrganizationID), s.audit.forRequest(audit)}
+}
+
+// ExportColumns returns the columns the export will have: the requested ones, or every field the
+// viewer may see of other users. Asking for a field the viewer may not see is an error.
+func (s *userExportService) ExportColumns(request models.ExportUsersRequest, viewer models.Viewer) ([]string, error) {
+	audience := audienceFor(&models.User{}, viewer)
+	var visible []string
+	for _, column := range exportColumns {
+		if models.UserFieldPolicy[column].For(audience) != models.FieldHidden {
+			visible = append(visible, column)
+		}
+	}
+	if strings.TrimSpace(request.Columns) == "" {
+		return visible, nil
+	}
+
+	var columns []string
+	for _, requested := range strings.Split(request.Columns, ",") {
+		column := ""
+		for _, name := range visible {
+			if strings.EqualFold(name, strings.TrimSpace(requested)) {
+				column = name
+			}
+		}
+		if column == "" {
+			return nil, fmt.Errorf("unknown column %q", strings.TrimSpace(requested))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func (s *userExportService) NeedsBackgroundExport(request models.ExportUsersRequest) (bool, error) {
+	if request.Async {
+		return true, nil
+	}
+	total, err := s.userRepo.CountAll(request.GetAllUsersRequest)
+	if err != nil {
+		return false, err
+	}
+	return total > userExportSyncLimitFromEnv(), nil
+}
+
+func (s *userExportService) WriteExport(request models.ExportUsersRequest, viewer models.Viewer, w io.Writer) error {
+	columns, err := s.ExportColumns(request, viewer)
+	if err != nil {
+		return err
+	}
+	// The export is streamed, so it is audited before any of it is sent, with the rows that
+	// match now
+	rows, err := s.userRepo.CountAll(request.GetAllUsersRequest)
+	if err != nil {
+		return err
+	}
+	if err := s.recordExport(request, columns, rows); err != nil {
+		return err
+	}
+	_, err = s.writeUsers(request, columns, viewer, w)
+	return err
+}
+
+func (s *userExportService) StartExport(request models.ExportUsersRequest, viewer models.Viewer, requesterID string) (*models.Job, error) {
+	columns, err := s.ExportColumns(request, viewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.jobService.Start(models.JobTypeUserExport, requesterID, nil, func(job *models.Job) (JobOutput, error) {
+		if err := os.MkdirAll(JobDir(), 0o700); err != nil {
+			return JobOutput{}, err
+		}
+		path := filepath.Join(JobDir(), job.ID+"."+string(exportFormat(request)))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return JobOutput{}, err
+		}
+		defer file.Close()
+
+		output := JobOutput{FilePath: path, FileName: UserExportFileName(request)}
+		rows, err := s.writeUsers(request, columns, viewer, file)
+		if err != nil {
+			return output, err
+		}
+		// A failed job's file is removed, so the export is never downloadable unaudited
+		if err := s.recordExport(request, columns, rows); err != nil {
+			return output, err
+		}
+		output.Result = models.UserExportResult{Format: exportFormat(request), Columns: columns, Rows: rows}
+		return output, nil
+	})
+}
+
+// UserExportFileName is the suggested download name for an export.
+func UserExportFileName(request models.ExportUsersRequest) string {
+	return fmt.Sprintf("users-export-%s.%s", time.Now().UTC().Format("20060102"), exportFormat(request))
+}
+
+// exportFormat returns the request's format, CSV when it has none.
+func exportFormat(request models.ExportUsersRequest) models.ExportFormat {
+	if request.Format == "" {
+		return models.ExportCSV
+	}
+	return request.Format
+}
+
+// writeUsers writes the matching users in the request's format and returns how many it wrote.
+func (s *userExportService) writeUsers(request models.ExportUsersRequest, columns []string, viewer models.Viewer, w io.Writer) (int64, error) {
+	var rows int64
+	var writeRow func(view models.UserView) error
+	var finish func() error
+
+	switch exportFormat(request) {
+	case models.ExportNDJSON:
+		encoder := json.NewEncoder(w)
+		writeRow = func(view models.UserView) error {
+			row := make(map[string]interface{}, len(columns))
+			for _, column := range columns {
+				row[column] = view[column]
+			}
+			return encoder.Encode(row)
+		}
+		finish = func() error { return nil }
+
+	case models.ExportXLSX:
+		sheet, err := utils.NewXLSXWriter(w, "Users")
+		if err != nil {
+			return 0, err
+		}
+		if err := sheet.Write(columns); err != nil {
+			return 0, err
+		}
+		writeRow = func(view models.UserView) error { return sheet.Write(exportCells(view, columns, false)) }
+		finish = sheet.Close
+
+	default:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return 0, err
+		}
+		writeRow = func(view models.UserView) error { return writer.Write(exportCells(view, columns, true)) }
+		finish = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	}
+
+	err := s.userRepo.EachUser(request.GetAllUsersRequest, func(user *models.User) error {
+		rows++
+		return writeRow(NewUserView(user, viewer))
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, finish()
+}
+
+// exportCells returns the row's values as text. For CSV, values that a spreadsheet would read
+// as a formula are prefixed with a quote so that opening the file cannot run them.
+func exportCells(view models.UserView, columns []string, forCSV bool) []string {
+	cells := make([]string, len(columns))
+	for i, column := range columns {
+		value, ok := view[column]
+		if !ok || value == nil {
+			continue
+		}
+		cell := fmt.Sprint(value)
+		if forCSV && cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cell = "'" + cell
+		}
+		cells[i] = cell
+	}
+	return cells
+}
+
+// recordExport audits the export against the organization, with what was exported.
+func (s *userExportService) recordExport(request models.ExportUsersRequest, columns []string, rows int64) error {
+	filters := request.GetAllUsersRequest
+	return s.audit.record(models.AuditUsersExported, models.AuditTargetOrganization, s.audit.context.OrganizationID, nil, nil, map[string]interface{}{
+		"format":  exportFormat(request),
+		"columns": columns,
+		"rows":    rows,
+		"filters": map[string]string{"from": filters.From, "to": filters.To, "search": filters.Search, "role": filters.Role, "status": filters.Status},
+	})
+}
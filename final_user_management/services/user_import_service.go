@@ -0,0 +1,327 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// importFields are the CreateUserByAdminRequest fields an import file's columns can map to.
+var importFields = []string{"department", "email", "firstName", "lastName", "nationalId", "passportNumber", "password", "phone", "profilePicture", "role", "username"}
+
+// importMaxRowsFromEnv returns how many rows one import may have.
+func importMaxRowsFromEnv() int {
+	rows, err := strconv.Atoi(os.Getenv("IMPORT_MAX_ROWS"))
+	if err != nil || rows <= 0 {
+		return 10000
+	}
+	return rows
+}
+
+// UserImportService creates users in bulk from CSV, NDJSON or JSON files. Every row is checked
+// like a POST /users/registerusersbyadmin request and against the existing users and the other
+// rows, then created through UserService, so each user is audited and announced as if created
+// one by one. Imports run as background jobs whose result is a UserImportReport.
+type UserImportService interface {
+	StartImport(request models.ImportUsersRequest, fileName string, data []byte, actorID string) (*models.Job, error)
+	ForRequest(audit models.AuditContext) UserImportService
+}
+
+type userImportService struct {
+	userService UserService
+	userRepo    repositories.UserRepository
+	roleRepo    repositories.RoleRepository
+	jobService  JobService
+}
+
+func NewUserImportService(userService UserService, userRepo repositories.UserRepository, roleRepo repositories.RoleRepository, jobService JobService) UserImportService {
+	return &userImportService{userService, userRepo, roleRepo, jobService}
+}
+
+// ForRequest returns the service importing into the request's organization, with the created
+// users audited as created by the request's actor.
+func (s *userImportService) ForRequest(audit models.AuditContext) UserImportService {
+	organizationID := audit.OrganizationID
+	return &userImportService{s.userService.ForRequest(audit), s.userRepo.WithTenant(organizationID), s.roleRepo.WithTenant(organizationID), s.jobService.ForTenant(organizationID)}
+}
+
+// StartImport parses the file and starts the job checking and creating its rows. A file that
+// cannot be read at all is rejected here rather than failing the job.
+func (s *userImportService) StartImport(request models.ImportUsersRequest, fileName string, data []byte, actorID string) (*models.Job, error) {
+	mapping := map[string]string{}
+	if strings.TrimSpace(request.Mapping) != "" {
+		if err := json.Unmarshal([]byte(request.Mapping), &mapping); err != nil {
+			return nil, errors.New("mapping must be a JSON object from column names to fields")
+		}
+	}
+	for column, field := range mapping {
+		if importField(field) == "" {
+			return nil, fmt.Errorf("column %q is mapped to unknown field %q", column, field)
+		}
+	}
+
+	format := request.Format
+	if format == "" {
+		format = importFormatOf(fileName)
+	}
+	records, err := parseImport(format, data, mapping)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("the file has no rows")
+	}
+	if max := importMaxRowsFromEnv(); len(records) > max {
+		return nil, fmt.Errorf("the file has %d rows, more than the %d allowed in one import", len(records), max)
+	}
+
+	return s.jobService.Start(models.JobTypeUserImport, actorID, nil, func(job *models.Job) (JobOutput, error) {
+		return JobOutput{Result: s.importRecords(records, request, actorID)}, nil
+	})
+}
+
+// importRecords checks every row and, unless it is a dry run, creates the users of the rows that
+// pass.
+func (s *userImportService) importRecords(records []importRecord, request models.ImportUsersRequest, actorID string) models.UserImportReport {
+	report := models.UserImportReport{DryRun: request.DryRun, Total: len(records), Rows: make([]models.ImportRowResult, 0, len(records))}
+	seen := map[string]int{}
+	grantable := map[string]error{}
+
+	for _, record := range records {
+		result := s.importRecord(record, request, actorID, seen, grantable)
+		if result.Status == models.ImportRowFailed {
+			report.Failed++
+		} else {
+			report.Succeeded++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+	return report
+}
+
+func (s *userImportService) importRecord(record importRecord, request models.ImportUsersRequest, actorID string, seen map[string]int, grantable map[string]error) models.ImportRowResult {
+	result := models.ImportRowResult{Row: record.row, Status: models.ImportRowFailed}
+	fail := func(errs ...string) models.ImportRowResult {
+		result.Errors = append(result.Errors, errs...)
+		return result
+	}
+
+	var user models.CreateUserByAdminRequest
+	encoded, _ := json.Marshal(record.values)
+	if err := json.Unmarshal(encoded, &user); err != nil {
+		return fail(err.Error())
+	}
+	result.Email = user.Email
+
+	// Rows without a password become invitations, whose users choose their own
+	invite := request.SendInvitations && user.Password == ""
+	var validated interface{} = &user
+	if invite {
+		validated = inviteRequest(user)
+	}
+	if err := binding.Validator.ValidateStruct(validated); err != nil {
+		return fail(strings.Split(err.Error(), "\n")...)
+	}
+
+	if errs := s.checkDuplicates(record.row, user, seen); len(errs) > 0 {
+		return fail(errs...)
+	}
+	if err, checked := grantable[string(user.Role)]; checked {
+		if err != nil {
+			return fail(err.Error())
+		}
+	} else {
+		roles, err := findRolesByName(s.roleRepo, []string{string(user.Role)})
+		if err == nil {
+			err = checkCanGrant(s.roleRepo, actorID, roles)
+		}
+		grantable[string(user.Role)] = err
+		if err != nil {
+			return fail(err.Error())
+		}
+	}
+
+	if request.DryRun {
+		result.Status = models.ImportRowValid
+		return result
+	}
+	if invite {
+		invitation, err := s.userService.InviteUser(*inviteRequest(user), actorID)
+		if err != nil {
+			return fail(err.Error())
+		}
+		result.UserID, result.Status = invitation.UserID, models.ImportRowInvited
+		return result
+	}
+	created, err := s.userService.RegisterUserByAdmin(user, actorID)
+	if err != nil {
+		return fail(err.Error())
+	}
+	result.UserID, result.Status = created.ID, models.ImportRowCreated
+	return result
+}
+
+// inviteRequest is the invitation for a row without a password.
+func inviteRequest(user models.CreateUserByAdminRequest) *models.InviteUserRequest {
+	return &models.InviteUserRequest{
+		Department:     user.Department,
+		Email:          user.Email,
+		FirstName:      user.FirstName,
+		LastName:       user.LastName,
+		NationalID:     user.NationalID,
+		PassportNumber: user.PassportNumber,
+		Phone:          user.Phone,
+		ProfilePicture: user.ProfilePicture,
+		Role:           user.Role,
+		Username:       user.Username,
+	}
+}
+
+// checkDuplicates reports the unique values of the row that belong to an existing user or to an
+// earlier row. seen remembers the values of earlier rows and which row they were on.
+func (s *userImportService) checkDuplicates(row int, user models.CreateUserByAdminRequest, seen map[string]int) []string {
+	var errs []string
+	check := func(field string, value string, find func(string) (*models.User, error)) {
+		if value == "" {
+			return
+		}
+		key := field + ":" + strings.ToLower(value)
+		if earlier, ok := seen[key]; ok {
+			errs = append(errs, fmt.Sprintf("%s is the same as on row %d", field, earlier))
+			return
+		}
+		seen[key] = row
+		if _, err := find(value); err == nil {
+			errs = append(errs, field+" is already registered")
+		}
+	}
+	check("email", user.Email, s.userRepo.FindByEmail)
+	check("username", user.Username, s.userRepo.FindByUsername)
+	check("phone", user.Phone, s.userRepo.FindByPhone)
+	if user.NationalID != nil {
+		check("nationalId", *user.NationalID, s.userRepo.FindByNationalID)
+	}
+	if user.PassportNumber != nil {
+		check("passportNumber", *user.PassportNumber, s.userRepo.FindByPassportNumber)
+	}
+	return errs
+}
+
+// importRecord is one row of an import file: its line number and its values by field.
+type importRecord struct {
+	row    int
+	values map[string]interface{}
+}
+
+// importField returns the field a column is named after, matched case-insensitively, or "".
+func importField(column string) string {
+	for _, field := range importFields {
+		if strings.EqualFold(field, strings.TrimSpace(column)) {
+			return field
+		}
+	}
+	return ""
+}
+
+func importFormatOf(fileName string) models.ImportFormat {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".ndjson", ".jsonl":
+		return models.ImportNDJSON
+	case ".json":
+		return models.ImportJSON
+	}
+	return models.ImportCSV
+}
+
+// parseImport reads the rows of the file. Columns are renamed to fields with the mapping, and
+// columns that name no field are ignored, as are empty CSV cells.
+func parseImport(format models.ImportFormat, data []byte, mapping map[string]string) ([]importRecord, error) {
+	fieldOf := func(column string) string {
+		if field, ok := mapping[column]; ok {
+			return importField(field)
+		}
+		return importField(column)
+	}
+	mapValues := func(object map[string]interface{}) map[string]interface{} {
+		values := map[string]interface{}{}
+		for column, value := range object {
+			if field := fieldOf(column); field != "" && value != nil {
+				values[field] = value
+			}
+		}
+		return values
+	}
+
+	var records []importRecord
+	switch format {
+	case models.ImportCSV:
+		reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		reader.TrimLeadingSpace = true
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV header: %v", err)
+		}
+		for {
+			cells, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid CSV: %v", err)
+			}
+			line, _ := reader.FieldPos(0)
+			values := map[string]interface{}{}
+			for i, cell := range cells {
+				if i < len(header) && strings.TrimSpace(cell) != "" {
+					if field := fieldOf(header[i]); field != "" {
+						values[field] = strings.TrimSpace(cell)
+					}
+				}
+			}
+			records = append(records, importRecord{line, values})
+		}
+
+	case models.ImportNDJSON:
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var object map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+				return nil, fmt.Errorf("invalid JSON on line %d: %v", line, err)
+			}
+			records = append(records, importRecord{line, mapValues(object)})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("invalid NDJSON: %v", err)
+		}
+
+	case models.ImportJSON:
+		var objects []map[string]interface{}
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return nil, errors.New("a JSON import must be an array of objects")
+		}
+		for i, object := range objects {
+			records = append(records, importRecord{i + 1, mapValues(object)})
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+	return records, nil
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+// errInvitationInvalid is returned for invitation tokens that are unknown, or whose invitation
+// can no longer be accepted. The two are not told apart.
+var errInvitationInvalid = errors.New("this invitation is invalid or has expired")
+
+// invitationTTLFromEnv returns how long an invitation link works after it was last sent.
+func invitationTTLFromEnv() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("INVITATION_TTL_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = 7 * 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// InviteUser creates the user in the pending status, with a random password nobody knows, and
+// emails them a link to choose their own. A failed email is logged; the invitation can be resent.
+func (s *userService) InviteUser(request models.InviteUserRequest, actorID string) (*models.Invitation, error) {
+	token, tokenHash, err := utils.GenerateInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+	user := &models.User{
+		Department:     request.Department,
+		Email:          request.Email,
+		FirstName:      request.FirstName,
+		LastName:       request.LastName,
+		NationalID:     request.NationalID,
+		PassportNumber: request.PassportNumber,
+		Phone:          request.Phone,
+		ProfilePicture: request.ProfilePicture,
+		Username:       request.Username,
+		Role:           request.Role,
+		Status:         models.PendingStatus,
+		Slug:           utils.GenerateSlug(request.FirstName + " " + request.LastName),
+	}
+	now := time.Now()
+	invitation := &models.Invitation{
+		Email:      request.Email,
+		TokenHash:  tokenHash,
+		InvitedBy:  actorID,
+		SentCount:  1,
+		LastSentAt: &now,
+		ExpiresAt:  now.Add(invitationTTLFromEnv()),
+	}
+
+	err = s.createUserByAdmin(user, randomHex(32), actorID, func(tx *gorm.DB) error {
+		invitation.UserID, invitation.OrganizationID = user.ID, user.OrganizationID
+		if err := s.invitationRepo.WithTx(tx).Create(invitation); err != nil {
+			return err
+		}
+		invitation.Status = invitation.StatusAt(now)
+		return s.audit.withTx(tx).record(models.AuditInvitationCreated, models.AuditTargetInvitation, invitation.ID, nil, invitation, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sendInvitation(user, invitation, token); err != nil {
+		log.Printf("Failed to send invitation to %s: %v", user.Email, err)
+	}
+	return invitation, nil
+}
+
+func (s *userService) GetInvitations(params models.GetInvitationsRequest) (models.PaginatedResponse, error) {
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	now := time.Now()
+	invitations, total, err := s.invitationRepo.FindAll(params, now)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+	for i := range invitations {
+		invitations[i].Status = invitations[i].StatusAt(now)
+	}
+	return newPaginatedResponse(invitations, total, params.PageNumber, params.PageSize), nil
+}
+
+// ResendInvitation emails a new link, which works for another full period, to a pending or
+// expired invitation's user. Links sent earlier stop working.
+func (s *userService) ResendInvitation(id string) (*models.Invitation, error) {
+	invitation, err := s.invitationRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("invitation not found")
+	}
+	now := time.Now()
+	if status := invitation.StatusAt(now); status != models.InvitationPending && status != models.InvitationExpired {
+		return nil, fmt.Errorf("only pending or expired invitations can be resent, invitation is %s", status)
+	}
+	user, err := s.userRepo.FindByID(invitation.UserID)
+	if err != nil || user.Status != models.PendingStatus {
+		return nil, errors.New("the invited user is no longer waiting to activate their account")
+	}
+
+	token, tokenHash, err := utils.GenerateInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		invitationRepo := s.invitationRepo.WithTx(tx)
+		if err := invitationRepo.Resend(id, tokenHash, now.Add(invitationTTLFromEnv()), now); err != nil {
+			return err
+		}
+		if invitation, err = invitationRepo.FindByID(id); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditInvitationResent, models.AuditTargetInvitation, id, nil, nil, map[string]interface{}{"sentCount": invitation.SentCount})
+	})
+	if err != nil {
+		return nil, err
+	}
+	invitation.Status = invitation.StatusAt(now)
+
+	if err := sendInvitation(user, invitation, token); err != nil {
+		log.Printf("Failed to send invitation to %s: %v", user.Email, err)
+		return nil, errors.New("failed to send invitation email")
+	}
+	return invitation, nil
+}
+
+// ExpireInvitation makes a pending invitation's link stop working now. The user stays pending,
+// so the invitation can still be resent.
+func (s *userService) ExpireInvitation(id string) (*models.Invitation, error) {
+	before, err := s.invitationRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("invitation not found")
+	}
+	now := time.Now()
+	var invitation *models.Invitation
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		invitationRepo := s.invitationRepo.WithTx(tx)
+		if err := invitationRepo.Expire(id, now); err != nil {
+			return fmt.Errorf("only pending invitations can be expired, invitation is %s", before.StatusAt(now))
+		}
+		if invitation, err = invitationRepo.FindByID(id); err != nil {
+			return err
+		}
+		invitation.Status = invitation.StatusAt(now)
+		return s.audit.withTx(tx).record(models.AuditInvitationExpired, models.AuditTargetInvitation, id, before, invitation, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// RevokeInvitation withdraws an invitation that has not been accepted and deletes the pending user
+// it was for.
+func (s *userService) RevokeInvitation(id string, actorID string) (*models.Invitation, error) {
+	invitation, err := s.invitationRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("invitation not found")
+	}
+	now := time.Now()
+	if status := invitation.StatusAt(now); status == models.InvitationAccepted || status == models.InvitationRevoked {
+		return nil, fmt.Errorf("only pending or expired invitations can be revoked, invitation is %s", status)
+	}
+
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		audit := s.audit.withTx(tx)
+		if err := s.invitationRepo.WithTx(tx).Revoke(id, now); err != nil {
+			return err
+		}
+		if err := audit.record(models.AuditInvitationRevoked, models.AuditTargetInvitation, id, nil, nil, nil); err != nil {
+			return err
+		}
+		user, err := s.userRepo.WithTx(tx).FindByID(invitation.UserID)
+		if err != nil || user.Status != models.PendingStatus {
+			return nil
+		}
+		events := newOutbox(repositories.NewTransactor(tx), s.outbox.repo)
+		return changeUserStatus(s.userRepo.WithTx(tx), audit, events, user, models.DeletedStatus, "invitation revoked", &actorID, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	invitation.RevokedAt = &now
+	invitation.Status = invitation.StatusAt(now)
+	return invitation, nil
+}
+
+// GetInvitationPreview shows the invitee which account a pending invitation is for.
+func (s *userService) GetInvitationPreview(token string) (*models.InvitationPreview, error) {
+	_, invitation, user, err := s.openInvitation(token)
+	if err != nil {
+		return nil, err
+	}
+	return &models.InvitationPreview{
+		Email:     user.Email,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		ExpiresAt: invitation.ExpiresAt,
+	}, nil
+}
+
+// AcceptInvitation sets the invitee's password and activates their account. The invitation is
+// claimed in the same transaction, so a link can only be used once.
+func (s *userService) AcceptInvitation(request models.AcceptInvitationRequest) (models.SuccessResponse, error) {
+	s, invitation, user, err := s.openInvitation(request.Token)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	hashedPassword, err := utils.HashPassword(request.Password)
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+
+	// The invitee is not signed in; they are the actor of their own activation
+	context := s.audit.context
+	context.ActorID = user.ID
+	err = s.outbox.transactor.Transaction(func(tx *gorm.DB) error {
+		audit := s.audit.forRequest(context).withTx(tx)
+		if err := s.invitationRepo.WithTx(tx).Accept(invitation.ID, time.Now()); err != nil {
+			return errInvitationInvalid
+		}
+		if err := audit.record(models.AuditInvitationAccepted, models.AuditTargetInvitation, invitation.ID, nil, nil, nil); err != nil {
+			return err
+		}
+		userRepo := s.userRepo.WithTx(tx)
+		events := newOutbox(repositories.NewTransactor(tx), s.outbox.repo)
+		if err := updateUserPassword(userRepo, events, user, hashedPassword, "invitation", nil); err != nil {
+			return err
+		}
+		return changeUserStatus(userRepo, audit, events, user, models.ActiveStatus, "invitation accepted", &user.ID, nil)
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	return models.SuccessResponse{Message: "Your account is active. You can now sign in."}, nil
+}
+
+// openInvitation finds the pending invitation with the token, and its user, who must still be
+// pending. It also returns the service working in the invitation's organization, whichever
+// host the link was opened on.
+func (s *userService) openInvitation(token string) (*userService, *models.Invitation, *models.User, error) {
+	invitation, err := s.invitationRepo.FindByHash(utils.HashInvitationToken(token))
+	if err != nil || invitation.StatusAt(time.Now()) != models.InvitationPending {
+		return nil, nil, nil, errInvitationInvalid
+	}
+	context := s.audit.context
+	context.OrganizationID = invitation.OrganizationID
+	scoped := s.ForRequest(context).(*userService)
+
+	user, err := scoped.userRepo.FindByID(invitation.UserID)
+	if err != nil || user.Status != models.PendingStatus {
+		return nil, nil, nil, errInvitationInvalid
+	}
+	return scoped, invitation, user, nil
+}
+
+// sendInvitation emails the invitee their link.
+func sendInvitation(user *models.User, invitation *models.Invitation, token string) error {
+	link := fmt.Sprintf("%s/invitations/accept?token=%s", appBaseURL(), token)
+	body := fmt.Sprintf(
+		"Hello %s,<br>An account has been created for you with the username <strong>%s</strong>.<br>"+
+			"<a href=\"%s\">Choose your password</a> to activate it. The link expires on %s.",
+		user.FirstName, user.Username, link, invitation.ExpiresAt.UTC().Format("2 January 2006 at 15:04 UTC"),
+	)
+	return utils.SendEmail(emailConfigFromEnv(), user.Email, "You have been invited", body)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/utils"
+)
+
+var (
+	ErrRegistrationClosed    = errors.New("registration is by invitation only")
+	ErrEmailDomainNotAllowed = errors.New("registration is not open to this email domain")
+)
+
+// reservedNames can never be registered as usernames or email local parts, whatever the
+// organization reserves on top of them, so nobody can pass themselves off as staff.
+var reservedNames = []string{
+	"abuse", "admin", "administrator", "help", "hostmaster", "info", "no-reply", "noreply",
+	"postmaster", "root", "security", "support", "system", "webmaster",
+}
+
+// checkRegistration applies the organization's registration policy to a self-registration.
+func checkRegistration(organization *models.Organization, request models.CreateUserRequest) error {
+	local, domain, _ := strings.Cut(strings.ToLower(request.Email), "@")
+	local, _, _ = strings.Cut(local, "+")
+
+	switch organization.RegistrationMode {
+	case models.RegistrationInviteOnly:
+		return ErrRegistrationClosed
+	case models.RegistrationDomainRestricted:
+		allowed := false
+		for _, allowedDomain := range organization.AllowedEmailDomains {
+			allowed = allowed || domain == allowedDomain
+		}
+		if !allowed {
+			return ErrEmailDomainNotAllowed
+		}
+	}
+
+	if isReservedName(request.Username, organization.ReservedNames) {
+		return errors.New("this username is reserved")
+	}
+	if isReservedName(local, organization.ReservedNames) {
+		return errors.New("this email address is reserved")
+	}
+	return nil
+}
+
+func isReservedName(name string, reserved []string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, list := range [][]string{reservedNames, reserved} {
+		for _, reservedName := range list {
+			if name == reservedName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generateClientID returns a new user's client ID.
+func generateClientID() string {
+	return randomHex(12)
+}
+
+// GetPendingRegistrations lists the self-registered users waiting for an admin's approval.
+func (s *userService) GetPendingRegistrations(params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error) {
+	params.Status = string(models.PendingApprovalStatus)
+	return s.GetAllUsers(params, viewer)
+}
+
+// ApproveRegistration activates a self-registered user and tells them they can sign in.
+func (s *userService) ApproveRegistration(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error) {
+	user, err := s.pendingRegistration(id)
+	if err != nil {
+		return nil, err
+	}
+	reason := request.Reason
+	if reason == "" {
+		reason = "registration approved"
+	}
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.ActiveStatus, reason, &actorID, nil); err != nil {
+		return nil, err
+	}
+
+	body := fmt.Sprintf("Hello %s,<br>Your account <strong>%s</strong> has been approved. <a href=\"%s\">Sign in</a> to get started.", user.FirstName, user.Username, appBaseURL())
+	if err := utils.SendEmail(emailConfigFromEnv(), user.Email, "Your account has been approved", body); err != nil {
+		log.Printf("Failed to send registration approval to %s: %v", user.Email, err)
+	}
+	return user, nil
+}
+
+// RejectRegistration deletes a self-registered user who was not approved and lets them know.
+// Their username and email can be registered again.
+func (s *userService) RejectRegistration(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error) {
+	user, err := s.pendingRegistration(id)
+	if err != nil {
+		return nil, err
+	}
+	reason := request.Reason
+	if reason == "" {
+		reason = "registration rejected"
+	}
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.DeletedStatus, reason, &actorID, nil); err != nil {
+		return nil, err
+	}
+
+	body := fmt.Sprintf("Hello %s,<br>Your registration for the account <strong>%s</strong> was not approved.", user.FirstName, user.Username)
+	if err := utils.SendEmail(emailConfigFromEnv(), user.Email, "Your registration was not approved", body); err != nil {
+		log.Printf("Failed to send registration rejection to %s: %v", user.Email, err)
+	}
+	return user, nil
+}
+
+func (s *userService) pendingRegistration(id string) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.Status != models.PendingApprovalStatus {
+		return nil, fmt.Errorf("only registrations pending approval can be approved or rejected, user is %s", user.Status)
+	}
+	return user, nil
+}
@@ -2,33 +2,82 @@ package services
 
 import (
 	"errors"
-	"math"
 
 	"github.com/umwaribenie/final_user_management/models"
 	"github.com/umwaribenie/final_user_management/repositories"
 	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
 )
 
 type UserService interface {
-	GetAllUsers(params models.GetAllUsersRequest) (models.PaginatedResponse, error)
+	GetAllUsers(params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error)
 	RegisterUser(request models.CreateUserRequest) (*models.User, error)
-	RegisterUserByAdmin(request models.CreateUserByAdminRequest) (*models.User, error)
+	GetPendingRegistrations(params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error)
+	ApproveRegistration(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error)
+	RejectRegistration(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error)
+	RegisterUserByAdmin(request models.CreateUserByAdminRequest, actorID string) (*models.User, error)
+	InviteUser(request models.InviteUserRequest, actorID string) (*models.Invitation, error)
+	GetInvitations(params models.GetInvitationsRequest) (models.PaginatedResponse, error)
+	ResendInvitation(id string) (*models.Invitation, error)
+	ExpireInvitation(id string) (*models.Invitation, error)
+	RevokeInvitation(id string, actorID string) (*models.Invitation, error)
+	GetInvitationPreview(token string) (*models.InvitationPreview, error)
+	AcceptInvitation(request models.AcceptInvitationRequest) (models.SuccessResponse, error)
 	GetUserBySlug(slug string) (*models.User, error)
-	UpdatePasswordByAdmin(id string, request models.UpdatePasswordRequest) (models.SuccessResponse, error)
+	UpdatePasswordByAdmin(id string, request models.UpdatePasswordRequest, meta models.RequestMeta) (models.SuccessResponse, error)
 	GetUserByID(id string) (*models.User, error)
-	DeleteUser(id string) (models.SuccessResponse, error)
-	UpdateUser(id string, request models.UpdateUserRequest) (*models.User, error)
+	DeleteUser(id string, actorID string) (models.SuccessResponse, error)
+	UpdateUser(id string, request models.UpdateUserRequest, actorID string) (*models.User, error)
+	GetLoginHistory(id string, params models.GetLoginHistoryRequest) (models.PaginatedResponse, error)
+	SuspendUser(id string, request models.SuspendUserRequest, actorID string) (*models.User, error)
+	ReactivateUser(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error)
+	UnlockUser(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error)
+	GetDeletedUsers(params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error)
+	RestoreUser(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error)
+	PurgeDeletedUsers() (int64, error)
+	ScheduleAccountDeletion(id string, request models.DeleteAccountRequest, meta models.RequestMeta) (models.SuccessResponse, error)
+	ProcessScheduledDeletions() (int, error)
+	ForTenant(organizationID string) UserService
+	ForRequest(audit models.AuditContext) UserService
+	WithTx(tx *gorm.DB) UserService
 }
 
 type userService struct {
-	userRepo repositories.UserRepository
+	userRepo          repositories.UserRepository
+	securityEventRepo repositories.SecurityEventRepository
+	sessionService    SessionService
+	roleRepo          repositories.RoleRepository
+	invitationRepo    repositories.InvitationRepository
+	organizationRepo  repositories.OrganizationRepository
+	audit             auditor
+	outbox            outbox
+}
+
+func NewUserService(userRepo repositories.UserRepository, securityEventRepo repositories.SecurityEventRepository, sessionService SessionService, roleRepo repositories.RoleRepository, invitationRepo repositories.InvitationRepository, organizationRepo repositories.OrganizationRepository, auditRepo repositories.AuditRepository, transactor repositories.Transactor, outboxRepo repositories.OutboxRepository) UserService {
+	return &userService{userRepo, securityEventRepo, sessionService, roleRepo, invitationRepo, organizationRepo, newAuditor(auditRepo), newOutbox(transactor, outboxRepo)}
+}
+
+// ForTenant returns the service working on the organization's users and roles.
+func (s *userService) ForTenant(organizationID string) UserService {
+	return &userService{s.userRepo.WithTenant(organizationID), s.securityEventRepo, s.sessionService, s.roleRepo.WithTenant(organizationID), s.invitationRepo.WithTenant(organizationID), s.organizationRepo, s.audit.forRequest(models.AuditContext{OrganizationID: organizationID}), s.outbox}
 }
 
-func NewUserService(userRepo repositories.UserRepository) UserService {
-	return &userService{userRepo}
+// ForRequest returns the service working in the request's organization and recording its actor
+// in the audit log.
+func (s *userService) ForRequest(audit models.AuditContext) UserService {
+	scoped := s.ForTenant(audit.OrganizationID).(*userService)
+	scoped.audit = s.audit.forRequest(audit)
+	return scoped
 }
 
-func (s *userService) GetAllUsers(params models.GetAllUsersRequest) (models.PaginatedResponse, error) {
+// WithTx returns the service working inside the transaction, so that its changes, their audit
+// entries and their events commit or roll back with the caller's.
+func (s *userService) WithTx(tx *gorm.DB) UserService {
+	return &userService{s.userRepo.WithTx(tx), s.securityEventRepo, s.sessionService, s.roleRepo.WithTx(tx), s.invitationRepo.WithTx(tx), s.organizationRepo.WithTx(tx), s.audit.withTx(tx), newOutbox(repositories.NewTransactor(tx), s.outbox.repo)}
+}
+
+func (s *userService) GetAllUsers(params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error) {
 	if params.PageNumber == 0 {
 		params.PageNumber = 1
 	}
@@ -41,40 +90,34 @@ func (s *userService) GetAllUsers(params models.GetAllUsersRequest) (models.Pagi
 		return models.PaginatedResponse{}, err
 	}
 
-	lastPage := int(math.Ceil(float64(total) / float64(params.PageSize)))
-	if lastPage == 0 && total > 0 {
-		lastPage = 1
-	}
+	return newPaginatedResponse(NewUserViews(users, viewer), total, params.PageNumber, params.PageSize), nil
+}
 
-	var nextPage, previousPage *int
-	if params.PageNumber < lastPage {
-		next := params.PageNumber + 1
-		nextPage = &next
+// RegisterUser signs a user up under the organization's registration policy. Under the approval
+// mode the account is created pending approval and cannot be used until an admin approves it.
+func (s *userService) RegisterUser(request models.CreateUserRequest) (*models.User, error) {
+	organization, err := s.organizationRepo.FindByID(s.audit.context.OrganizationID)
+	if err != nil {
+		return nil, ErrOrganizationNotFound
 	}
-	if params.PageNumber > 1 {
-		prev := params.PageNumber - 1
-		previousPage = &prev
+	if err := checkRegistration(organization, request); err != nil {
+		return nil, err
+	}
+	status := models.ActiveStatus
+	if organization.RegistrationMode == models.RegistrationApproval {
+		status = models.PendingApprovalStatus
 	}
 
-	return models.PaginatedResponse{
-		CurrentPage:  params.PageNumber,
-		LastPage:     lastPage,
-		List:         users,
-		NextPage:     nextPage,
-		PreviousPage: previousPage,
-		Status:       "success",
-		Total:        total,
-	}, nil
-}
-
-func (s *userService) RegisterUser(request models.CreateUserRequest) (*models.User, error) {
+	if err := s.checkIdentityDocuments(request.NationalID, request.PassportNumber, ""); err != nil {
+		return nil, err
+	}
 	hashedPassword, err := utils.HashPassword(request.Password)
 	if err != nil {
 		return nil, err
 	}
 
 	user := &models.User{
-		ClientID:       request.ClientID,
+		ClientID:       generateClientID(),
 		Email:          request.Email,
 		FirstName:      request.FirstName,
 		LastName:       request.LastName,
@@ -85,29 +128,36 @@ func (s *userService) RegisterUser(request models.CreateUserRequest) (*models.Us
 		ProfilePicture: request.ProfilePicture,
 		Username:       request.Username,
 		Role:           models.RoleUser,
-		Status:         models.ActiveStatus,
+		Status:         status,
 		Slug:           utils.GenerateSlug(request.FirstName + " " + request.LastName),
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
+	err = s.outbox.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		if err := s.userRepo.WithTx(tx).Create(user); err != nil {
+			return nil, err
+		}
+		if err := s.roleRepo.WithTx(tx).SetUserRoles(user.ID, []string{string(user.Role)}, nil); err != nil {
+			return nil, err
+		}
+		if err := s.audit.withTx(tx).record(models.AuditUserRegistered, models.AuditTargetUser, user.ID, nil, user, nil); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{userEvent(models.DomainUserRegistered, user, nil)}, nil
+	})
+	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *userService) RegisterUserByAdmin(request models.CreateUserByAdminRequest) (*models.User, error) {
-	hashedPassword, err := utils.HashPassword(request.Password)
-	if err != nil {
-		return nil, err
-	}
-
+func (s *userService) RegisterUserByAdmin(request models.CreateUserByAdminRequest, actorID string) (*models.User, error) {
 	user := &models.User{
+		Department:     request.Department,
 		Email:          request.Email,
 		FirstName:      request.FirstName,
 		LastName:       request.LastName,
 		NationalID:     request.NationalID,
 		PassportNumber: request.PassportNumber,
-		Password:       hashedPassword,
 		Phone:          request.Phone,
 		ProfilePicture: request.ProfilePicture,
 		Username:       request.Username,
@@ -115,48 +165,146 @@ func (s *userService) RegisterUserByAdmin(request models.CreateUserByAdminReques
 		Status:         models.ActiveStatus,
 		Slug:           utils.GenerateSlug(request.FirstName + " " + request.LastName),
 	}
-
-	if err := s.userRepo.Create(user); err != nil {
+	if err := s.createUserByAdmin(user, request.Password, actorID, nil); err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
+// createUserByAdmin checks that the actor may grant the user's role and that their identity
+// documents are not registered yet, then creates the user with the password and role. within,
+// when given, runs in the same transaction once the user has been created.
+func (s *userService) createUserByAdmin(user *models.User, password string, actorID string, within func(tx *gorm.DB) error) error {
+	roles, err := findRolesByName(s.roleRepo, []string{string(user.Role)})
+	if err != nil {
+		return err
+	}
+	if err := checkCanGrant(s.roleRepo, actorID, roles); err != nil {
+		return err
+	}
+	if err := s.checkIdentityDocuments(user.NationalID, user.PassportNumber, ""); err != nil {
+		return err
+	}
+	if user.Password, err = utils.HashPassword(password); err != nil {
+		return err
+	}
+
+	err = s.outbox.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		if err := s.userRepo.WithTx(tx).Create(user); err != nil {
+			return nil, err
+		}
+		if err := s.roleRepo.WithTx(tx).SetUserRoles(user.ID, []string{string(user.Role)}, &actorID); err != nil {
+			return nil, err
+		}
+		if within != nil {
+			if err := within(tx); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.audit.withTx(tx).record(models.AuditUserCreated, models.AuditTargetUser, user.ID, nil, user, nil); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{userEvent(models.DomainUserCreated, user, nil)}, nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// checkIdentityDocuments rejects a national ID or passport number that already belongs to
+// another user. The values are encrypted, so the lookup goes through their blind indexes.
+func (s *userService) checkIdentityDocuments(nationalID, passportNumber *string, userID string) error {
+	if nationalID != nil {
+		if existing, err := s.userRepo.FindByNationalID(*nationalID); err == nil && existing.ID != userID {
+			return errors.New("national ID is already registered")
+		}
+	}
+	if passportNumber != nil {
+		if existing, err := s.userRepo.FindByPassportNumber(*passportNumber); err == nil && existing.ID != userID {
+			return errors.New("passport number is already registered")
+		}
+	}
+	return nil
+}
+
 func (s *userService) GetUserBySlug(slug string) (*models.User, error) {
 	return s.userRepo.FindBySlug(slug)
 }
 
-func (s *userService) UpdatePasswordByAdmin(id string, request models.UpdatePasswordRequest) (models.SuccessResponse, error) {
+func (s *userService) UpdatePasswordByAdmin(id string, request models.UpdatePasswordRequest, meta models.RequestMeta) (models.SuccessResponse, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("user not found")
+	}
 	hashedPassword, err := utils.HashPassword(request.NewPassword)
 	if err != nil {
 		return models.SuccessResponse{}, err
 	}
-	if err := s.userRepo.UpdatePassword(id, hashedPassword); err != nil {
+	err = updateUserPassword(s.userRepo, s.outbox, user, hashedPassword, "admin", func(tx *gorm.DB) error {
+		return s.audit.withTx(tx).record(models.AuditUserPasswordResetAdmin, models.AuditTargetUser, user.ID, nil, nil, nil)
+	})
+	if err != nil {
 		return models.SuccessResponse{}, err
 	}
+	recordSecurityEvent(s.securityEventRepo, user, user.Username, models.EventPasswordChangedByAdmin, models.OutcomeSuccess, "", meta)
 	return models.SuccessResponse{Message: "Password updated successfully"}, nil
 }
 
+// updateUserPassword stores the user's new password hash together with its password-changed
+// event. method says how the password was changed: "self", "admin", "otp" or "link". within,
+// when given, runs in the same transaction once the password has been stored.
+func updateUserPassword(userRepo repositories.UserRepository, events outbox, user *models.User, hashedPassword string, method string, within func(tx *gorm.DB) error) error {
+	return events.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		if err := userRepo.WithTx(tx).UpdatePassword(user.ID, hashedPassword); err != nil {
+			return nil, err
+		}
+		if within != nil {
+			if err := within(tx); err != nil {
+				return nil, err
+			}
+		}
+		return []models.OutboxEvent{userEvent(models.DomainUserPasswordChanged, user, map[string]interface{}{"method": method})}, nil
+	})
+}
+
 func (s *userService) GetUserByID(id string) (*models.User, error) {
 	return s.userRepo.FindByID(id)
 }
 
-func (s *userService) DeleteUser(id string) (models.SuccessResponse, error) {
-	if err := s.userRepo.Delete(id); err != nil {
+func (s *userService) DeleteUser(id string, actorID string) (models.SuccessResponse, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("user not found")
+	}
+	if err := s.deleteUser(user, "deleted by admin", &actorID); err != nil {
 		return models.SuccessResponse{}, err
 	}
 	return models.SuccessResponse{Message: "User deleted successfully"}, nil
 }
 
-func (s *userService) UpdateUser(id string, request models.UpdateUserRequest) (*models.User, error) {
+// deleteUser soft-deletes the user through the status lifecycle and clears any pending
+// self-service deletion. actorID is nil when the system deletes the account.
+func (s *userService) deleteUser(user *models.User, reason string, actorID *string) error {
+	return changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.DeletedStatus, reason, actorID, nil)
+}
+
+func (s *userService) UpdateUser(id string, request models.UpdateUserRequest, actorID string) (*models.User, error) {
 	// 1. Retrieve the existing user from the database.
 	user, err := s.userRepo.FindByID(id)
 	if err != nil {
 		return nil, errors.New("user not found")
 	}
+	before := *user
 
 	// 2. Apply the updates from the request to the existing user object.
+	if err := s.checkIdentityDocuments(request.NationalID, request.PassportNumber, id); err != nil {
+		return nil, err
+	}
 
+	if request.Department != nil {
+		user.Department = *request.Department
+	}
 	if request.Email != nil {
 		user.Email = *request.Email
 	}
@@ -178,18 +326,85 @@ func (s *userService) UpdateUser(id string, request models.UpdateUserRequest) (*
 	if request.ProfilePicture != nil {
 		user.ProfilePicture = request.ProfilePicture
 	}
-	if request.Role != nil {
+	roleChanged := request.Role != nil && *request.Role != user.Role
+	if roleChanged {
+		if err := s.checkRoleReplacement(id, *request.Role, actorID); err != nil {
+			return nil, err
+		}
 		user.Role = *request.Role
 	}
 	if request.Username != nil {
 		user.Username = *request.Username
 	}
 
-	// 3. Update the user in the database.
-	if err := s.userRepo.Update(id, user); err != nil {
+	// 3. Update the user in the database. A new role replaces the user's role assignments in the
+	// same transaction.
+	err = s.outbox.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		audit := s.audit.withTx(tx)
+		if err := s.userRepo.WithTx(tx).Update(id, user); err != nil {
+			return nil, err
+		}
+		if err := audit.record(models.AuditUserUpdated, models.AuditTargetUser, user.ID, &before, user, nil); err != nil {
+			return nil, err
+		}
+		events := []models.OutboxEvent{userEvent(models.DomainUserUpdated, user, map[string]interface{}{"changedFields": changedFields(&before, user)})}
+		if roleChanged {
+			if err := s.roleRepo.WithTx(tx).SetUserRoles(id, []string{string(user.Role)}, &actorID); err != nil {
+				return nil, err
+			}
+			if err := audit.record(models.AuditUserRolesChanged, models.AuditTargetUser, user.ID, nil, nil, map[string]interface{}{"replacedWith": []string{string(user.Role)}}); err != nil {
+				return nil, err
+			}
+			events = append(events, userEvent(models.DomainUserRolesChanged, user, map[string]interface{}{"roles": []string{string(user.Role)}}))
+		}
+		return events, nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// 4. Return the updated user object.
+	// 4. Their tokens still carry the old permissions, so end their sessions.
+	if roleChanged {
+		if err := s.sessionService.RevokeAll(id); err != nil {
+			return nil, err
+		}
+	}
+
+	// 5. Return the updated user object.
 	return user, nil
 }
+
+// checkRoleReplacement makes sure the actor may replace all of the user's roles with the
+// named one: the role must exist and the actor must hold the permissions of both the old and
+// new roles. The repository stops the last active admin from losing the admin role.
+func (s *userService) checkRoleReplacement(userID string, role models.UserRole, actorID string) error {
+	newRoles, err := findRolesByName(s.roleRepo, []string{string(role)})
+	if err != nil {
+		return err
+	}
+	current, err := s.roleRepo.FindByUserID(userID)
+	if err != nil {
+		return err
+	}
+	return checkCanGrant(s.roleRepo, actorID, append(current, newRoles...))
+}
+
+func (s *userService) GetLoginHistory(id string, params models.GetLoginHistoryRequest) (models.PaginatedResponse, error) {
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	if _, err := s.userRepo.FindByID(id); err != nil {
+		return models.PaginatedResponse{}, errors.New("user not found")
+	}
+
+	events, total, err := s.securityEventRepo.FindByUserID(id, params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+
+	return newPaginatedResponse(events, total, params.PageNumber, params.PageSize), nil
+}
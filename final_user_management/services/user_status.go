@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"gorm.io/gorm"
+)
+
+// ErrLastAdmin is returned when deleting, suspending or deactivating a user would leave their
+// organization with no active admin.
+var ErrLastAdmin = repositories.ErrLastAdmin
+
+// lockoutPolicyFromEnv returns how many consecutive failed logins lock an account and for how long.
+func lockoutPolicyFromEnv() (int, time.Duration) {
+	maxAttempts, err := strconv.Atoi(os.Getenv("MAX_FAILED_LOGINS"))
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = 5
+	}
+	minutes, err := strconv.Atoi(os.Getenv("LOCKOUT_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = 15
+	}
+	return maxAttempts, time.Duration(minutes) * time.Minute
+}
+
+// changeUserStatus validates and applies a status transition, clearing or setting the
+// status-specific fields, and records who made it and why. actorID is nil for system changes.
+// Deleting a user also cancels any deletion they had scheduled.
+func changeUserStatus(userRepo repositories.UserRepository, audit auditor, events outbox, user *models.User, to models.UserStatus, reason string, actorID *string, until *time.Time) error {
+	if !user.Status.CanTransitionTo(to) {
+		return fmt.Errorf("cannot change status from %s to %s", user.Status, to)
+	}
+	before := *user
+
+	updates := map[string]interface{}{
+		"status":          to,
+		"status_reason":   nil,
+		"suspended_until": nil,
+		"locked_until":    nil,
+	}
+	if reason != "" {
+		updates["status_reason"] = reason
+	}
+	// Deleting soft-deletes the row; moving out of deleted restores it
+	if to == models.DeletedStatus {
+		updates["deleted_at"] = time.Now()
+		updates["deletion_scheduled_at"] = nil
+	} else if user.Status == models.DeletedStatus {
+		updates["deleted_at"] = nil
+	}
+	switch to {
+	case models.SuspendedStatus:
+		updates["suspended_until"] = until
+	case models.LockedStatus:
+		updates["locked_until"] = until
+	case models.ActiveStatus:
+		updates["failed_logins"] = 0
+	}
+
+	change := &models.UserStatusChange{
+		UserID:     user.ID,
+		FromStatus: user.Status,
+		ToStatus:   to,
+		Reason:     reason,
+		ChangedBy:  actorID,
+	}
+	action, eventType := models.AuditUserStatusChanged, models.DomainUserStatusChanged
+	if to == models.DeletedStatus {
+		action, eventType = models.AuditUserDeleted, models.DomainUserDeleted
+	} else if before.Status == models.DeletedStatus {
+		action, eventType = models.AuditUserRestored, models.DomainUserRestored
+	}
+
+	err := events.transaction(func(tx *gorm.DB) ([]models.OutboxEvent, error) {
+		if err := userRepo.WithTx(tx).ChangeStatus(user.ID, updates, change); err != nil {
+			return nil, err
+		}
+
+		user.Status = to
+		user.StatusReason = nil
+		if reason != "" {
+			user.StatusReason = &reason
+		}
+		user.SuspendedUntil, user.LockedUntil = nil, nil
+		if to == models.SuspendedStatus {
+			user.SuspendedUntil = until
+		} else if to == models.LockedStatus {
+			user.LockedUntil = until
+		}
+		if to == models.DeletedStatus {
+			user.DeletionScheduledAt = nil
+		}
+		if err := audit.withTx(tx).record(action, models.AuditTargetUser, user.ID, &before, user, nil); err != nil {
+			return nil, err
+		}
+		return []models.OutboxEvent{userEvent(eventType, user, map[string]interface{}{"fromStatus": before.Status})}, nil
+	})
+	if err != nil {
+		*user = before
+		return err
+	}
+	return nil
+}
+
+// releaseExpiredRestriction reactivates a user whose time-limited suspension or lockout has run out.
+func releaseExpiredRestriction(userRepo repositories.UserRepository, audit auditor, events outbox, user *models.User) error {
+	now := time.Now()
+	switch {
+	case user.Status == models.SuspendedStatus && user.SuspendedUntil != nil && now.After(*user.SuspendedUntil):
+		return changeUserStatus(userRepo, audit, events, user, models.ActiveStatus, "suspension expired", nil, nil)
+	case user.Status == models.LockedStatus && user.LockedUntil != nil && now.After(*user.LockedUntil):
+		return changeUserStatus(userRepo, audit, events, user, models.ActiveStatus, "lockout expired", nil, nil)
+	}
+	return nil
+}
+
+func (s *userService) SuspendUser(id string, request models.SuspendUserRequest, actorID string) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if id == actorID {
+		return nil, errors.New("you cannot suspend yourself")
+	}
+	if request.Until != nil && request.Until.Before(time.Now()) {
+		return nil, errors.New("until must be in the future")
+	}
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.SuspendedStatus, request.Reason, &actorID, request.Until); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *userService) ReactivateUser(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.Status != models.SuspendedStatus && user.Status != models.InactiveStatus {
+		return nil, fmt.Errorf("only suspended or inactive users can be reactivated, user is %s", user.Status)
+	}
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.ActiveStatus, request.Reason, &actorID, nil); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *userService) UnlockUser(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error) {
+	user, err := s.userRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.Status != models.LockedStatus {
+		return nil, fmt.Errorf("only locked users can be unlocked, user is %s", user.Status)
+	}
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.ActiveStatus, request.Reason, &actorID, nil); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// deletedUserRetentionFromEnv returns how long soft-deleted users are kept before being purged.
+func deletedUserRetentionFromEnv() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("DELETED_USER_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// GetDeletedUsers lists soft-deleted users, each shaped for the viewer by NewUserView and
+// annotated with when it was deleted and when it will be purged.
+func (s *userService) GetDeletedUsers(params models.GetAllUsersRequest, viewer models.Viewer) (models.PaginatedResponse, error) {
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	users, total, err := s.userRepo.FindDeleted(params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+
+	retention := deletedUserRetentionFromEnv()
+	list := make([]models.UserView, 0, len(users))
+	for i := range users {
+		view := NewUserView(&users[i], viewer)
+		view["deletedAt"] = users[i].DeletedAt.Time
+		view["purgeAt"] = users[i].DeletedAt.Time.Add(retention)
+		list = append(list, view)
+	}
+
+	return newPaginatedResponse(list, total, params.PageNumber, params.PageSize), nil
+}
+
+func (s *userService) RestoreUser(id string, request models.ChangeStatusRequest, actorID string) (*models.User, error) {
+	user, err := s.userRepo.FindDeletedByID(id)
+	if err != nil {
+		return nil, errors.New("deleted user not found")
+	}
+
+	if user.IsAnonymized() {
+		return nil, errors.New("the user's personal data has been erased, so they cannot be restored")
+	}
+	if err := s.checkRestorable(user); err != nil {
+		return nil, err
+	}
+
+	if err := changeUserStatus(s.userRepo, s.audit, s.outbox, user, models.ActiveStatus, request.Reason, &actorID, nil); err != nil {
+		return nil, err
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	return user, nil
+}
+
+// checkRestorable fails if another user has taken any of the deleted user's unique values since
+// the deletion. Every column with a unique index over active users is checked, so restoring
+// fails with a message rather than a constraint violation.
+func (s *userService) checkRestorable(user *models.User) error {
+	lookups := []struct {
+		field string
+		value string
+		find  func(string) (*models.User, error)
+	}{
+		{"email", user.Email, s.userRepo.FindByEmail},
+		{"username", user.Username, s.userRepo.FindByUsername},
+		{"slug", user.Slug, s.userRepo.FindBySlug},
+		{"phone number", user.Phone, s.userRepo.FindByPhone},
+		{"client ID", user.ClientID, s.userRepo.FindByClientID},
+	}
+	for _, lookup := range lookups {
+		if lookup.value == "" {
+			continue
+		}
+		if existing, err := lookup.find(lookup.value); err == nil && existing.ID != user.ID {
+			return fmt.Errorf("%s is now used by another user", lookup.field)
+		}
+	}
+	return s.checkIdentityDocuments(user.NationalID, user.PassportNumber, user.ID)
+}
+
+// PurgeDeletedUsers permanently removes users whose retention period after soft deletion has passed.
+func (s *userService) PurgeDeletedUsers() (int64, error) {
+	return s.userRepo.PurgeDeletedBefore(time.Now().Add(-deletedUserRetentionFromEnv()))
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/umwaribenie/final_user_management/models"
+)
+
+// audienceFor works out which UserFieldPolicy audience the viewer falls into for this user.
+func audienceFor(user *models.User, viewer models.Viewer) models.Audience {
+	switch {
+	case viewer.UserID != "" && viewer.UserID == user.ID:
+		return models.AudienceOwner
+	case models.HasPermission(viewer.Permissions, models.PermUsersReadPII):
+		return models.AudiencePIIReader
+	case models.HasPermission(viewer.Permissions, models.PermUsersRead):
+		return models.AudienceAdmin
+	default:
+		return models.AudiencePublic
+	}
+}
+
+// NewUserView renders the user for the viewer, dropping or masking fields as UserFieldPolicy says.
+func NewUserView(user *models.User, viewer models.Viewer) models.UserView {
+	encoded, err := json.Marshal(user)
+	if err != nil {
+		return models.UserView{"id": user.ID}
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return models.UserView{"id": user.ID}
+	}
+
+	audience := audienceFor(user, viewer)
+	view := make(models.UserView, len(fields))
+	for name, value := range fields {
+		switch models.UserFieldPolicy[name].For(audience) {
+		case models.FieldVisible:
+			view[name] = value
+		case models.FieldMasked:
+			view[name] = maskValue(fmt.Sprint(value))
+		}
+	}
+	return view
+}
+
+// NewUserViews renders each user for the viewer.
+func NewUserViews(users []models.User, viewer models.Viewer) []models.UserView {
+	views := make([]models.UserView, 0, len(users))
+	for i := range users {
+		views = append(views, NewUserView(&users[i], viewer))
+	}
+	return views
+}
+
+// maskValue hides all but the last four characters, e.g. "****1234".
+func maskValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 4 {
+		return "****"
+	}
+	return "****" + string(runes[len(runes)-4:])
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+)
+
+// Changes to users reach webhooks through the outbox relay (see events.NewWebhookSink). Sign-ins
+// change no user data worth an outbox event, so they are taken from the security event feed.
+
+type webhookSecurityEvents struct {
+	repositories.SecurityEventRepository
+	webhooks WebhookService
+}
+
+// WebhookSecurityEvents returns repo, publishing user.login for each successful sign-in stored
+// in it. The security event's ID is the event ID.
+func WebhookSecurityEvents(repo repositories.SecurityEventRepository, webhooks WebhookService) repositories.SecurityEventRepository {
+	return &webhookSecurityEvents{repo, webhooks}
+}
+
+func (r *webhookSecurityEvents) Create(event *models.SecurityEvent) error {
+	if err := r.SecurityEventRepository.Create(event); err != nil {
+		return err
+	}
+	if event.Type == models.EventLogin && event.Outcome == models.OutcomeSuccess && event.UserID != nil {
+		r.webhooks.PublishUserEvent(models.WebhookUserLogin, event.ID, *event.UserID, map[string]interface{}{
+			"ip":        event.IP,
+			"userAgent": event.UserAgent,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,544 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+// Headers sent with every delivery. The signature is "sha256=" followed by
+// utils.SignWebhookPayload of the timestamp and the body.
+const (
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookEventIDHeader   = "X-Webhook-Event-Id"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	webhookDeliveryBatchSize = 20
+	// webhookDeliveryLease is how long a claimed delivery is hidden from other servers; it must
+	// outlast webhookRequestTimeout
+	webhookDeliveryLease     = 2 * time.Minute
+	webhookRequestTimeout    = 10 * time.Second
+	webhookFirstRetryDelay   = 30 * time.Second
+	webhookMaxRetryDelay     = 6 * time.Hour
+	webhookResponseBodyLimit = 1024
+)
+
+// webhookMaxAttemptsFromEnv returns how many times a delivery is tried before it is marked failed.
+func webhookMaxAttemptsFromEnv() int {
+	attempts, err := strconv.Atoi(os.Getenv("WEBHOOK_MAX_ATTEMPTS"))
+	if err != nil || attempts <= 0 {
+		return 8
+	}
+	return attempts
+}
+
+// webhookAllowPrivateFromEnv reports whether endpoints may be on private networks, which is only
+// meant for development, where receivers run on localhost.
+func webhookAllowPrivateFromEnv() bool {
+	allow, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS"))
+	return allow
+}
+
+// webhookBlockedPrefixes are the special-purpose ranges, other than loopback, private and
+// link-local ones, that webhooks may not be delivered to.
+var webhookBlockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// webhookAddressBlocked reports whether deliveries to the address are refused: loopback, private
+// and link-local addresses (which include the cloud metadata service at 169.254.169.254) would
+// let a tenant admin reach internal services and read their responses in the delivery log.
+func webhookAddressBlocked(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return true
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range webhookBlockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// newWebhookClient returns the client deliveries are sent with. The address is checked again
+// when connecting, after DNS resolution, so a name that resolved to a public address when the
+// endpoint was saved cannot be pointed at an internal one later.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookRequestTimeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || (!allowPrivate && webhookAddressBlocked(ip)) {
+				return fmt.Errorf("webhook address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would make the dialer check the proxy's address instead of the endpoint's
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookRequestTimeout,
+		Transport: transport,
+		// A redirect is reported as the response rather than followed to a URL nobody registered
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// WebhookService manages an organization's webhook endpoints and delivers user events to them.
+// Events are queued as deliveries when they are published and sent by a background job (see
+// jobs.StartWebhookDelivery), which retries failures with exponential backoff.
+type WebhookService interface {
+	GetEndpoints() ([]models.WebhookEndpoint, error)
+	GetEndpoint(id string) (*models.WebhookEndpoint, error)
+	CreateEndpoint(request models.CreateWebhookRequest, actorID string) (*models.CreatedWebhookEndpoint, error)
+	UpdateEndpoint(id string, request models.UpdateWebhookRequest) (*models.WebhookEndpoint, error)
+	DeleteEndpoint(id string) (models.SuccessResponse, error)
+	GetDeliveries(endpointID string, params models.GetWebhookDeliveriesRequest) (models.PaginatedResponse, error)
+	Redeliver(endpointID string, deliveryID string) (*models.WebhookDelivery, error)
+	Publish(organizationID string, event models.WebhookEvent, eventID string, data map[string]interface{}) error
+	PublishUserEvent(event models.WebhookEvent, eventID string, userID string, data map[string]interface{})
+	DeliverDue() (int, error)
+	Due() <-chan struct{}
+	ForTenant(organizationID string) WebhookService
+	ForRequest(audit models.AuditContext) WebhookService
+}
+
+type webhookService struct {
+	webhookRepo repositories.WebhookRepository
+	userRepo    repositories.UserRepository
+	audit       auditor
+	transactor  repositories.Transactor
+	client      *http.Client
+	due         chan struct{}
+}
+
+func NewWebhookService(webhookRepo repositories.WebhookRepository, userRepo repositories.UserRepository, auditRepo repositories.AuditRepository, transactor repositories.Transactor) WebhookService {
+	client := newWebhookClient(webhookAllowPrivateFromEnv())
+	return &webhookService{webhookRepo, userRepo, newAuditor(auditRepo), transactor, client, make(chan struct{}, 1)}
+}
+
+// ForTenant returns the service working on the organization's endpoints.
+func (s *webhookService) ForTenant(organizationID string) WebhookService {
+	return &webhookService{s.webhookRepo.WithTenant(organizationID), s.userRepo.WithTenant(organizationID), s.audit.forRequest(models.AuditContext{OrganizationID: organizationID}), s.transactor, s.client, s.due}
+}
+
+// ForRequest returns the service working in the request's organization and recording its actor
+// in the audit log.
+func (s *webhookService) ForRequest(audit models.AuditContext) WebhookService {
+	scoped := s.ForTenant(audit.OrganizationID).(*webhookService)
+	scoped.audit = s.audit.forRequest(audit)
+	return scoped
+}
+
+func (s *webhookService) GetEndpoints() ([]models.WebhookEndpoint, error) {
+	return s.webhookRepo.FindAll()
+}
+
+func (s *webhookService) GetEndpoint(id string) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.webhookRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("webhook not found")
+	}
+	return endpoint, nil
+}
+
+// CreateEndpoint registers an endpoint with a new signing secret, which is returned only here.
+func (s *webhookService) CreateEndpoint(request models.CreateWebhookRequest, actorID string) (*models.CreatedWebhookEndpoint, error) {
+	if err := validateWebhookURL(request.URL); err != nil {
+		return nil, err
+	}
+	if err := validateWebhookEvents(request.Events); err != nil {
+		return nil, err
+	}
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := &models.WebhookEndpoint{
+		URL:         request.URL,
+		Description: request.Description,
+		Events:      request.Events,
+		Secret:      secret,
+		Active:      true,
+	}
+	if actorID != "" {
+		endpoint.CreatedBy = &actorID
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.webhookRepo.WithTx(tx).Create(endpoint); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditWebhookCreated, models.AuditTargetWebhook, endpoint.ID, nil, endpoint, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.CreatedWebhookEndpoint{WebhookEndpoint: *endpoint, Secret: secret}, nil
+}
+
+func (s *webhookService) UpdateEndpoint(id string, request models.UpdateWebhookRequest) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.webhookRepo.FindByID(id)
+	if err != nil {
+		return nil, errors.New("webhook not found")
+	}
+	before := *endpoint
+
+	if request.URL != nil {
+		if err := validateWebhookURL(*request.URL); err != nil {
+			return nil, err
+		}
+		endpoint.URL = *request.URL
+	}
+	if request.Description != nil {
+		endpoint.Description = *request.Description
+	}
+	if request.Events != nil {
+		if err := validateWebhookEvents(request.Events); err != nil {
+			return nil, err
+		}
+		endpoint.Events = request.Events
+	}
+	if request.Active != nil {
+		endpoint.Active = *request.Active
+	}
+
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.webhookRepo.WithTx(tx).Update(endpoint); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditWebhookUpdated, models.AuditTargetWebhook, endpoint.ID, &before, endpoint, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// DeleteEndpoint removes the endpoint along with its delivery log. Deliveries still waiting to be
+// sent are dropped.
+func (s *webhookService) DeleteEndpoint(id string) (models.SuccessResponse, error) {
+	endpoint, err := s.webhookRepo.FindByID(id)
+	if err != nil {
+		return models.SuccessResponse{}, errors.New("webhook not found")
+	}
+	err = s.transactor.Transaction(func(tx *gorm.DB) error {
+		if err := s.webhookRepo.WithTx(tx).Delete(id); err != nil {
+			return err
+		}
+		return s.audit.withTx(tx).record(models.AuditWebhookDeleted, models.AuditTargetWebhook, id, endpoint, nil, nil)
+	})
+	if err != nil {
+		return models.SuccessResponse{}, err
+	}
+	return models.SuccessResponse{Message: "Webhook deleted successfully"}, nil
+}
+
+func (s *webhookService) GetDeliveries(endpointID string, params models.GetWebhookDeliveriesRequest) (models.PaginatedResponse, error) {
+	if _, err := s.webhookRepo.FindByID(endpointID); err != nil {
+		return models.PaginatedResponse{}, errors.New("webhook not found")
+	}
+	if params.PageNumber == 0 {
+		params.PageNumber = 1
+	}
+	if params.PageSize == 0 {
+		params.PageSize = 10
+	}
+
+	deliveries, total, err := s.webhookRepo.FindDeliveries(endpointID, params)
+	if err != nil {
+		return models.PaginatedResponse{}, err
+	}
+	return newPaginatedResponse(deliveries, total, params.PageNumber, params.PageSize), nil
+}
+
+// Redeliver queues the delivery's payload to be sent again right away. The original stays in the
+// log as it was; the new delivery points back at it and keeps its event ID.
+func (s *webhookService) Redeliver(endpointID string, deliveryID string) (*models.WebhookDelivery, error) {
+	endpoint, err := s.webhookRepo.FindByID(endpointID)
+	if err != nil {
+		return nil, errors.New("webhook not found")
+	}
+	if !endpoint.Active {
+		return nil, errors.New("webhook is disabled")
+	}
+	original, err := s.webhookRepo.FindDelivery(endpointID, deliveryID)
+	if err != nil {
+		return nil, errors.New("delivery not found")
+	}
+
+	now := time.Now()
+	deliveries := []models.WebhookDelivery{{
+		OrganizationID: original.OrganizationID,
+		EndpointID:     original.EndpointID,
+		EventID:        original.EventID,
+		Event:          original.Event,
+		Payload:        original.Payload,
+		Status:         models.WebhookDeliveryPending,
+		NextAttemptAt:  &now,
+		RedeliveryOf:   &original.ID,
+	}}
+	if err := s.webhookRepo.CreateDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	s.wake()
+	return &deliveries[0], nil
+}
+
+// Publish queues the event for every active endpoint of the organization that subscribes to it,
+// with data as the payload's data.
+func (s *webhookService) Publish(organizationID string, event models.WebhookEvent, eventID string, data map[string]interface{}) error {
+	if organizationID == "" {
+		return errors.New("webhook event has no organization")
+	}
+	endpoints, err := s.webhookRepo.WithTenant(organizationID).FindActive()
+	if err != nil {
+		return err
+	}
+	var subscribed []models.WebhookEndpoint
+	for _, endpoint := range endpoints {
+		if endpoint.Subscribes(event) {
+			subscribed = append(subscribed, endpoint)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(models.WebhookPayload{
+		ID:             eventID,
+		Event:          event,
+		OrganizationID: organizationID,
+		OccurredAt:     time.Now().UTC(),
+		Data:           data,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]models.WebhookDelivery, 0, len(subscribed))
+	for _, endpoint := range subscribed {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			OrganizationID: organizationID,
+			EndpointID:     endpoint.ID,
+			EventID:        eventID,
+			Event:          event,
+			Payload:        string(body),
+			Status:         models.WebhookDeliveryPending,
+			NextAttemptAt:  &now,
+		})
+	}
+	if err := s.webhookRepo.CreateDeliveries(deliveries); err != nil {
+		return err
+	}
+	s.wake()
+	return nil
+}
+
+// PublishUserEvent publishes an event about the user, with the user as an administrator sees them
+// added to data. Failures are logged rather than returned: the event has already happened.
+func (s *webhookService) PublishUserEvent(event models.WebhookEvent, eventID string, userID string, data map[string]interface{}) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		log.Printf("Failed to publish %s webhook for user %s: %v", event, userID, err)
+		return
+	}
+	payload := map[string]interface{}{"user": NewUserView(user, adminViewer)}
+	for key, value := range data {
+		payload[key] = value
+	}
+	if err := s.Publish(user.OrganizationID, event, eventID, payload); err != nil {
+		log.Printf("Failed to publish %s webhook for user %s: %v", event, userID, err)
+	}
+}
+
+// DeliverDue sends a batch of the deliveries whose next attempt is due, in parallel, and returns
+// how many it attempted.
+func (s *webhookService) DeliverDue() (int, error) {
+	deliveries, err := s.webhookRepo.ClaimDueDeliveries(webhookDeliveryBatchSize, webhookDeliveryLease)
+	if err != nil {
+		return 0, err
+	}
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		wg.Add(1)
+		go func(delivery *models.WebhookDelivery) {
+			defer wg.Done()
+			s.attempt(delivery)
+		}(&deliveries[i])
+	}
+	wg.Wait()
+	return len(deliveries), nil
+}
+
+// Due signals when new deliveries have been queued, so the delivery job need not wait for its
+// next tick.
+func (s *webhookService) Due() <-chan struct{} {
+	return s.due
+}
+
+func (s *webhookService) wake() {
+	select {
+	case s.due <- struct{}{}:
+	default:
+	}
+}
+
+// attempt sends the delivery once and records the outcome. A failed attempt is retried after a
+// delay that doubles each time, until the attempts run out.
+func (s *webhookService) attempt(delivery *models.WebhookDelivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus, delivery.ResponseBody, delivery.Error = 0, "", ""
+
+	endpoint, err := s.webhookRepo.FindByID(delivery.EndpointID)
+	switch {
+	case err != nil:
+		delivery.Status, delivery.NextAttemptAt, delivery.Error = models.WebhookDeliveryFailed, nil, "webhook no longer exists"
+	case !endpoint.Active:
+		delivery.Status, delivery.NextAttemptAt, delivery.Error = models.WebhookDeliveryFailed, nil, "webhook is disabled"
+	default:
+		err = s.send(endpoint, delivery)
+		switch {
+		case err == nil:
+			delivery.Status, delivery.NextAttemptAt = models.WebhookDeliverySucceeded, nil
+		case delivery.Attempts >= webhookMaxAttemptsFromEnv():
+			delivery.Status, delivery.NextAttemptAt, delivery.Error = models.WebhookDeliveryFailed, nil, err.Error()
+		default:
+			next := now.Add(webhookRetryDelay(delivery.Attempts))
+			delivery.Status, delivery.NextAttemptAt, delivery.Error = models.WebhookDeliveryPending, &next, err.Error()
+		}
+	}
+
+	if err := s.webhookRepo.UpdateDelivery(delivery); err != nil {
+		log.Printf("Failed to record webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// send posts the payload and records the response on the delivery. Any status outside 2xx is an
+// error.
+func (s *webhookService) send(endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request, err := http.NewRequest(http.MethodPost, endpoint.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "user-management-webhooks/1.0")
+	request.Header.Set(WebhookEventHeader, string(delivery.Event))
+	request.Header.Set(WebhookEventIDHeader, delivery.EventID)
+	request.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	request.Header.Set(WebhookTimestampHeader, timestamp)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+utils.SignWebhookPayload(endpoint.Secret, timestamp, body))
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(response.Body, webhookResponseBodyLimit))
+	delivery.ResponseStatus, delivery.ResponseBody = response.StatusCode, string(responseBody)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded %s", response.Status)
+	}
+	return nil
+}
+
+// webhookRetryDelay is how long to wait after the given number of failed attempts: 30s, 1m,
+// 2m and so on, up to 6 hours.
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := webhookFirstRetryDelay
+	for i := 1; i < attempts && delay < webhookMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > webhookMaxRetryDelay {
+		return webhookMaxRetryDelay
+	}
+	return delay
+}
+
+// validateWebhookURL checks that the URL is absolute http(s) and, unless private networks are
+// allowed, that its host does not resolve to an address webhooks may not be delivered to.
+func validateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Hostname() == "" {
+		return errors.New("webhook url must be an absolute http or https URL")
+	}
+	if webhookAllowPrivateFromEnv() {
+		return nil
+	}
+
+	ips := []net.IP{net.ParseIP(parsed.Hostname())}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(parsed.Hostname()); err != nil {
+			return fmt.Errorf("webhook host %s could not be resolved", parsed.Hostname())
+		}
+	}
+	for _, ip := range ips {
+		if webhookAddressBlocked(ip) {
+			return errors.New("webhook url must not point at a loopback, private or link-local address")
+		}
+	}
+	return nil
+}
+
+func validateWebhookEvents(events []models.WebhookEvent) error {
+	for _, event := range events {
+		if !event.IsValid() {
+			return fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	return nil
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
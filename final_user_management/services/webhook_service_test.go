@@ -0,0 +1,539 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+	"github.com/umwaribenie/final_user_management/utils"
+
+	"gorm.io/gorm"
+)
+
+// fakeWebhookRepository keeps endpoints and deliveries in memory.
+type fakeWebhookRepository struct {
+	mu         sync.Mutex
+	endpoints  map[string]*models.WebhookEndpoint
+	deliveries []*models.WebhookDelivery
+}
+
+func newFakeWebhookRepository(endpoints ...models.WebhookEndpoint) *fakeWebhookRepository {
+	repo := &fakeWebhookRepository{endpoints: map[string]*models.WebhookEndpoint{}}
+	for i := range endpoints {
+		repo.endpoints[endpoints[i].ID] = &endpoints[i]
+	}
+	return repo
+}
+
+func (r *fakeWebhookRepository) FindAll() ([]models.WebhookEndpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var endpoints []models.WebhookEndpoint
+	for _, endpoint := range r.endpoints {
+		endpoints = append(endpoints, *endpoint)
+	}
+	return endpoints, nil
+}
+
+func (r *fakeWebhookRepository) FindActive() ([]models.WebhookEndpoint, error) {
+	all, _ := r.FindAll()
+	var active []models.WebhookEndpoint
+	for _, endpoint := range all {
+		if endpoint.Active {
+			active = append(active, endpoint)
+		}
+	}
+	return active, nil
+}
+
+func (r *fakeWebhookRepository) FindByID(id string) (*models.WebhookEndpoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	endpoint, ok := r.endpoints[id]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	copied := *endpoint
+	return &copied, nil
+}
+
+func (r *fakeWebhookRepository) Create(endpoint *models.WebhookEndpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	endpoint.ID = fmt.Sprintf("endpoint-%d", len(r.endpoints)+1)
+	r.endpoints[endpoint.ID] = endpoint
+	return nil
+}
+
+func (r *fakeWebhookRepository) Update(endpoint *models.WebhookEndpoint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endpoints[endpoint.ID] = endpoint
+	return nil
+}
+
+func (r *fakeWebhookRepository) Delete(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.endpoints, id)
+	return nil
+}
+
+func (r *fakeWebhookRepository) CreateDeliveries(deliveries []models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range deliveries {
+		deliveries[i].ID = fmt.Sprintf("delivery-%d", len(r.deliveries)+1)
+		copied := deliveries[i]
+		r.deliveries = append(r.deliveries, &copied)
+	}
+	return nil
+}
+
+func (r *fakeWebhookRepository) FindDeliveries(endpointID string, params models.GetWebhookDeliveriesRequest) ([]models.WebhookDelivery, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range r.deliveries {
+		if delivery.EndpointID == endpointID {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+	return deliveries, int64(len(deliveries)), nil
+}
+
+func (r *fakeWebhookRepository) FindDelivery(endpointID string, id string) (*models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, delivery := range r.deliveries {
+		if delivery.EndpointID == endpointID && delivery.ID == id {
+			copied := *delivery
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (r *fakeWebhookRepository) ClaimDueDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var due []models.WebhookDelivery
+	now := time.Now()
+	for _, delivery := range r.deliveries {
+		if len(due) < limit && delivery.Status == models.WebhookDeliveryPending && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) {
+			leased := now.Add(lease)
+			delivery.NextAttemptAt = &leased
+			due = append(due, *delivery)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeWebhookRepository) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, stored := range r.deliveries {
+		if stored.ID == delivery.ID {
+			copied := *delivery
+			r.deliveries[i] = &copied
+			return nil
+		}
+	}
+	return errors.New("record not found")
+}
+
+func (r *fakeWebhookRepository) WithTenant(string) repositories.WebhookRepository {
+	return r
+}
+
+func (r *fakeWebhookRepository) WithTx(*gorm.DB) repositories.WebhookRepository {
+	return r
+}
+
+func (r *fakeWebhookRepository) delivery(id string) models.WebhookDelivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, delivery := range r.deliveries {
+		if delivery.ID == id {
+			return *delivery
+		}
+	}
+	return models.WebhookDelivery{}
+}
+
+// newTestWebhookService delivers to receivers on localhost.
+func newTestWebhookService(repo repositories.WebhookRepository) *webhookService {
+	return &webhookService{webhookRepo: repo, client: newWebhookClient(true), due: make(chan struct{}, 1)}
+}
+
+// webhookReceiver records the requests it gets and answers with the given statuses in turn,
+// repeating the last one.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, receivedWebhook{r.Header.Clone(), body})
+	status := rc.statuses[len(rc.statuses)-1]
+	if len(rc.requests) <= len(rc.statuses) {
+		status = rc.statuses[len(rc.requests)-1]
+	}
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "received %d", len(rc.requests))
+}
+
+func (rc *webhookReceiver) received() []receivedWebhook {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]receivedWebhook(nil), rc.requests...)
+}
+
+func startWebhookReceiver(t *testing.T, statuses ...int) (*webhookReceiver, string) {
+	t.Helper()
+	receiver := &webhookReceiver{statuses: statuses}
+	server := httptest.NewServer(receiver)
+	t.Cleanup(server.Close)
+	return receiver, server.URL
+}
+
+func TestWebhookDeliverySignsTimestampAndBody(t *testing.T) {
+	receiver, url := startWebhookReceiver(t, http.StatusOK)
+	repo := newFakeWebhookRepository(models.WebhookEndpoint{ID: "endpoint-1", OrganizationID: "org-1", URL: url, Secret: "whsec_test", Active: true})
+	service := newTestWebhookService(repo)
+
+	if err := service.Publish("org-1", models.WebhookUserCreated, "event-1", map[string]interface{}{"user": "alice"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	before := time.Now().Unix()
+	if sent, err := service.DeliverDue(); err != nil || sent != 1 {
+		t.Fatalf("DeliverDue = %d, %v; want 1 delivery", sent, err)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 1 {
+		t.Fatalf("receiver got %d requests, want 1", len(requests))
+	}
+	got := requests[0]
+	timestamp := got.header.Get(WebhookTimestampHeader)
+	sentAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || sentAt < before-1 || sentAt > time.Now().Unix()+1 {
+		t.Errorf("%s = %q, want the current Unix time", WebhookTimestampHeader, timestamp)
+	}
+	if want := "sha256=" + utils.SignWebhookPayload("whsec_test", timestamp, got.body); got.header.Get(WebhookSignatureHeader) != want {
+		t.Errorf("%s = %q, want %q", WebhookSignatureHeader, got.header.Get(WebhookSignatureHeader), want)
+	}
+	if wrong := "sha256=" + utils.SignWebhookPayload("whsec_test", strconv.FormatInt(sentAt+60, 10), got.body); got.header.Get(WebhookSignatureHeader) == wrong {
+		t.Errorf("signature does not cover the timestamp")
+	}
+	for header, want := range map[string]string{
+		WebhookEventHeader:    string(models.WebhookUserCreated),
+		WebhookEventIDHeader:  "event-1",
+		WebhookDeliveryHeader: "delivery-1",
+		"Content-Type":        "application/json",
+	} {
+		if got.header.Get(header) != want {
+			t.Errorf("%s = %q, want %q", header, got.header.Get(header), want)
+		}
+	}
+	if !strings.Contains(string(got.body), `"event":"user.created"`) || !strings.Contains(string(got.body), `"user":"alice"`) {
+		t.Errorf("body = %s, want the event and its data", got.body)
+	}
+
+	delivery := repo.delivery("delivery-1")
+	if delivery.Status != models.WebhookDeliverySucceeded || delivery.ResponseStatus != http.StatusOK || delivery.ResponseBody != "received 1" {
+		t.Errorf("delivery = %s %d %q, want succeeded with the response recorded", delivery.Status, delivery.ResponseStatus, delivery.ResponseBody)
+	}
+}
+
+func TestWebhookRetryDelayDoublesUpToTheMaximum(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+	for _, test := range tests {
+		if got := webhookRetryDelay(test.attempts); got != test.want {
+			t.Errorf("webhookRetryDelay(%d) = %s, want %s", test.attempts, got, test.want)
+		}
+	}
+}
+
+func TestWebhookFailedDeliveryIsRetriedWithBackoff(t *testing.T) {
+	receiver, url := startWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent)
+	repo := newFakeWebhookRepository(models.WebhookEndpoint{ID: "endpoint-1", OrganizationID: "org-1", URL: url, Secret: "whsec_test", Active: true})
+	service := newTestWebhookService(repo)
+	if err := service.Publish("org-1", models.WebhookUserUpdated, "event-1", nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		delivery := repo.delivery("delivery-1")
+		start := time.Now()
+		service.attempt(&delivery)
+
+		delivery = repo.delivery("delivery-1")
+		if delivery.Status != models.WebhookDeliveryPending || delivery.Attempts != attempt || delivery.Error == "" {
+			t.Fatalf("after attempt %d: status %s, attempts %d, error %q; want pending with the error", attempt, delivery.Status, delivery.Attempts, delivery.Error)
+		}
+		wait := delivery.NextAttemptAt.Sub(start)
+		if want := webhookRetryDelay(attempt); wait < want || wait > want+time.Second {
+			t.Errorf("after attempt %d the next attempt is in %s, want %s", attempt, wait, want)
+		}
+	}
+
+	delivery := repo.delivery("delivery-1")
+	service.attempt(&delivery)
+	delivery = repo.delivery("delivery-1")
+	if delivery.Status != models.WebhookDeliverySucceeded || delivery.NextAttemptAt != nil || delivery.Error != "" {
+		t.Errorf("after attempt 3: status %s, error %q; want succeeded", delivery.Status, delivery.Error)
+	}
+	if len(receiver.received()) != 3 {
+		t.Errorf("receiver got %d requests, want 3", len(receiver.received()))
+	}
+}
+
+func TestWebhookDeliveryFailsWhenAttemptsRunOut(t *testing.T) {
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "2")
+	_, url := startWebhookReceiver(t, http.StatusServiceUnavailable)
+	repo := newFakeWebhookRepository(models.WebhookEndpoint{ID: "endpoint-1", OrganizationID: "org-1", URL: url, Secret: "whsec_test", Active: true})
+	service := newTestWebhookService(repo)
+	if err := service.Publish("org-1", models.WebhookUserDeleted, "event-1", nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		delivery := repo.delivery("delivery-1")
+		service.attempt(&delivery)
+	}
+	delivery := repo.delivery("delivery-1")
+	if delivery.Status != models.WebhookDeliveryFailed || delivery.NextAttemptAt != nil || delivery.ResponseStatus != http.StatusServiceUnavailable {
+		t.Errorf("delivery = %s, next %v, response %d; want failed with no further attempt", delivery.Status, delivery.NextAttemptAt, delivery.ResponseStatus)
+	}
+}
+
+func TestWebhookPublishOnlyQueuesSubscribedActiveEndpoints(t *testing.T) {
+	repo := newFakeWebhookRepository(
+		models.WebhookEndpoint{ID: "all", URL: "https://example.com/all", Active: true},
+		models.WebhookEndpoint{ID: "created", URL: "https://example.com/created", Events: []models.WebhookEvent{models.WebhookUserCreated}, Active: true},
+		models.WebhookEndpoint{ID: "login", URL: "https://example.com/login", Events: []models.WebhookEvent{models.WebhookUserLogin}, Active: true},
+		models.WebhookEndpoint{ID: "disabled", URL: "https://example.com/disabled", Events: []models.WebhookEvent{models.WebhookUserCreated}},
+	)
+	service := newTestWebhookService(repo)
+
+	if err := service.Publish("org-1", models.WebhookUserCreated, "event-1", nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	queued := map[string]bool{}
+	for _, delivery := range repo.deliveries {
+		queued[delivery.EndpointID] = true
+		if delivery.EventID != "event-1" || delivery.Event != models.WebhookUserCreated || delivery.Status != models.WebhookDeliveryPending {
+			t.Errorf("delivery to %s = %+v, want a pending user.created delivery of event-1", delivery.EndpointID, delivery)
+		}
+	}
+	if len(queued) != 2 || !queued["all"] || !queued["created"] {
+		t.Errorf("queued deliveries to %v, want the all-events and user.created endpoints", queued)
+	}
+
+	if err := service.Publish("", models.WebhookUserCreated, "event-2", nil); err == nil {
+		t.Errorf("Publish without an organization succeeded")
+	}
+}
+
+func TestWebhookRedeliverSendsTheOriginalEventAgain(t *testing.T) {
+	receiver, url := startWebhookReceiver(t, http.StatusOK)
+	repo := newFakeWebhookRepository(models.WebhookEndpoint{ID: "endpoint-1", OrganizationID: "org-1", URL: url, Secret: "whsec_test", Active: true})
+	service := newTestWebhookService(repo)
+	if err := service.Publish("org-1", models.WebhookUserPasswordChanged, "event-1", map[string]interface{}{"method": "link"}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if _, err := service.DeliverDue(); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+
+	redelivery, err := service.Redeliver("endpoint-1", "delivery-1")
+	if err != nil {
+		t.Fatalf("Redeliver: %v", err)
+	}
+	if redelivery.RedeliveryOf == nil || *redelivery.RedeliveryOf != "delivery-1" || redelivery.EventID != "event-1" {
+		t.Errorf("redelivery = %+v, want it to point back at delivery-1 and keep event-1", redelivery)
+	}
+	select {
+	case <-service.Due():
+	default:
+		t.Errorf("Redeliver did not wake the delivery job")
+	}
+	if sent, err := service.DeliverDue(); err != nil || sent != 1 {
+		t.Fatalf("DeliverDue = %d, %v; want the redelivery sent", sent, err)
+	}
+
+	requests := receiver.received()
+	if len(requests) != 2 {
+		t.Fatalf("receiver got %d requests, want 2", len(requests))
+	}
+	if string(requests[0].body) != string(requests[1].body) || requests[1].header.Get(WebhookEventIDHeader) != "event-1" {
+		t.Errorf("redelivery sent %s with event ID %q, want the original payload and event ID", requests[1].body, requests[1].header.Get(WebhookEventIDHeader))
+	}
+	if requests[1].header.Get(WebhookDeliveryHeader) != redelivery.ID {
+		t.Errorf("redelivery sent with delivery ID %q, want %q", requests[1].header.Get(WebhookDeliveryHeader), redelivery.ID)
+	}
+	if original := repo.delivery("delivery-1"); original.Attempts != 1 {
+		t.Errorf("original delivery has %d attempts, want it left as it was", original.Attempts)
+	}
+
+	if _, err := service.Redeliver("endpoint-1", "missing"); err == nil {
+		t.Errorf("Redeliver of an unknown delivery succeeded")
+	}
+	repo.endpoints["endpoint-1"].Active = false
+	if _, err := service.Redeliver("endpoint-1", "delivery-1"); err == nil {
+		t.Errorf("Redeliver to a disabled endpoint succeeded")
+	}
+}
+
+func TestValidateWebhookURLRefusesInternalAddresses(t *testing.T) {
+	for _, raw := range []string{
+		"ftp://example.com/hook",
+		"/relative",
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.0.0.5/hook",
+		"http://172.16.3.4/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hook",
+		"http://[fd00::1]/hook",
+		"http://[::ffff:127.0.0.1]/hook",
+		"http://0.0.0.0/hook",
+		"http://100.64.0.1/hook",
+	} {
+		if err := validateWebhookURL(raw); err == nil {
+			t.Errorf("validateWebhookURL(%q) succeeded, want an error", raw)
+		}
+	}
+	for _, raw := range []string{"https://93.184.216.34/hook", "http://[2606:2800:220:1::]/hook"} {
+		if err := validateWebhookURL(raw); err != nil {
+			t.Errorf("validateWebhookURL(%q) = %v, want it allowed", raw, err)
+		}
+	}
+
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	if err := validateWebhookURL("http://127.0.0.1:9000/hook"); err != nil {
+		t.Errorf("validateWebhookURL with private networks allowed = %v", err)
+	}
+}
+
+func TestWebhookClientRefusesInternalAddressesWhenConnecting(t *testing.T) {
+	receiver, url := startWebhookReceiver(t, http.StatusOK)
+	repo := newFakeWebhookRepository(models.WebhookEndpoint{ID: "endpoint-1", OrganizationID: "org-1", URL: url, Secret: "whsec_test", Active: true})
+	service := newTestWebhookService(repo)
+	service.client = newWebhookClient(false)
+
+	if err := service.Publish("org-1", models.WebhookUserCreated, "event-1", nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if _, err := service.DeliverDue(); err != nil {
+		t.Fatalf("DeliverDue: %v", err)
+	}
+	delivery := repo.delivery("delivery-1")
+	if !strings.Contains(delivery.Error, "not allowed") || len(receiver.received()) != 0 {
+		t.Errorf("delivery error %q with %d requests received, want the connection refused", delivery.Error, len(receiver.received()))
+	}
+}
+
+// fakeTransactor runs the work in a stand-in transaction and remembers it.
+type fakeTransactor struct {
+	tx *gorm.DB
+}
+
+func (t *fakeTransactor) Transaction(fn func(tx *gorm.DB) error) error {
+	t.tx = &gorm.DB{}
+	return fn(t.tx)
+}
+
+// fakeAuditRepository keeps appended entries along with the transaction they were written in,
+// or fails every append with err.
+type fakeAuditRepository struct {
+	repositories.AuditRepository
+	tx      *gorm.DB
+	entries *[]auditedEntry
+	err     error
+}
+
+type auditedEntry struct {
+	entry models.AuditEntry
+	tx    *gorm.DB
+}
+
+func (r *fakeAuditRepository) Append(entry *models.AuditEntry) error {
+	if r.err != nil {
+		return r.err
+	}
+	*r.entries = append(*r.entries, auditedEntry{*entry, r.tx})
+	return nil
+}
+
+func (r *fakeAuditRepository) WithTx(tx *gorm.DB) repositories.AuditRepository {
+	return &fakeAuditRepository{tx: tx, entries: r.entries, err: r.err}
+}
+
+func TestWebhookChangesAreAuditedInTheirTransaction(t *testing.T) {
+	entries := []auditedEntry{}
+	transactor := &fakeTransactor{}
+	service := newTestWebhookService(newFakeWebhookRepository())
+	service.transactor = transactor
+	service.audit = newAuditor(&fakeAuditRepository{entries: &entries}).forRequest(models.AuditContext{OrganizationID: "org-1"})
+
+	created, err := service.CreateEndpoint(models.CreateWebhookRequest{URL: "https://93.184.216.34/hook"}, "")
+	if err != nil {
+		t.Fatalf("CreateEndpoint: %v", err)
+	}
+	if len(entries) != 1 || entries[0].entry.Action != models.AuditWebhookCreated || entries[0].entry.TargetID != created.ID {
+		t.Fatalf("entries = %+v, want one %s entry for the endpoint", entries, models.AuditWebhookCreated)
+	}
+	if entries[0].tx == nil || entries[0].tx != transactor.tx {
+		t.Errorf("entry was not written in the endpoint's transaction")
+	}
+}
+
+func TestWebhookChangesFailWhenTheyCannotBeAudited(t *testing.T) {
+	repo := newFakeWebhookRepository(models.WebhookEndpoint{ID: "endpoint-1", URL: "https://93.184.216.34/hook", Active: true})
+	service := newTestWebhookService(repo)
+	service.transactor = &fakeTransactor{}
+	service.audit = newAuditor(&fakeAuditRepository{err: errors.New("audit log unavailable")}).forRequest(models.AuditContext{OrganizationID: "org-1"})
+
+	if _, err := service.CreateEndpoint(models.CreateWebhookRequest{URL: "https://93.184.216.34/hook"}, ""); err == nil {
+		t.Errorf("CreateEndpoint succeeded without an audit entry")
+	}
+	description := "renamed"
+	if _, err := service.UpdateEndpoint("endpoint-1", models.UpdateWebhookRequest{Description: &description}); err == nil {
+		t.Errorf("UpdateEndpoint succeeded without an audit entry")
+	}
+	if _, err := service.DeleteEndpoint("endpoint-1"); err == nil {
+		t.Errorf("DeleteEndpoint succeeded without an audit entry")
+	}
+}
@@ -0,0 +1,142 @@
+package siem
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+const (
+	defaultBufferSize     = 1024
+	defaultFileMaxSizeMB  = 100
+	defaultFileMaxBackups = 5
+)
+
+// Sink delivers events to one destination. Write is only ever called from the sink's own
+// goroutine, so sinks need no locking.
+type Sink interface {
+	Write(event Event) error
+	String() string
+}
+
+// Exporter fans events out to its sinks. Each sink has a bounded queue drained by a goroutine of
+// its own, so a slow or unreachable sink only holds up itself. Publish never blocks: when a
+// sink's queue is full the event is dropped for that sink and counted, and the count is logged
+// once the sink catches up.
+//
+// A nil Exporter is valid and discards everything.
+type Exporter struct {
+	queues []*sinkQueue
+}
+
+type sinkQueue struct {
+	sink    Sink
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// NewExporter starts a goroutine for each sink, each buffering up to bufferSize events.
+func NewExporter(bufferSize int, sinks ...Sink) *Exporter {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	exporter := &Exporter{}
+	for _, sink := range sinks {
+		queue := &sinkQueue{sink: sink, events: make(chan Event, bufferSize)}
+		exporter.queues = append(exporter.queues, queue)
+		go queue.run()
+	}
+	return exporter
+}
+
+// NewExporterFromEnv builds the sinks configured in the environment, or returns nil when none is:
+//
+//	SIEM_SYSLOG_ADDR        udp://host:514, tcp://host:601 or unix:///dev/log
+//	SIEM_SYSLOG_FORMAT      message body, json (default) or cef
+//	SIEM_FILE_PATH          JSON-lines file
+//	SIEM_FILE_FORMAT        line format, json (default) or cef
+//	SIEM_FILE_MAX_SIZE_MB   size at which the file is rotated (default 100)
+//	SIEM_FILE_MAX_BACKUPS   rotated files kept (default 5)
+//	SIEM_BUFFER_SIZE        events queued per sink before new ones are dropped (default 1024)
+func NewExporterFromEnv() (*Exporter, error) {
+	var sinks []Sink
+	if address := os.Getenv("SIEM_SYSLOG_ADDR"); address != "" {
+		format, err := FormatterNamed(os.Getenv("SIEM_SYSLOG_FORMAT"))
+		if err != nil {
+			return nil, err
+		}
+		sink, err := NewSyslogSink(address, format)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if path := os.Getenv("SIEM_FILE_PATH"); path != "" {
+		format, err := FormatterNamed(os.Getenv("SIEM_FILE_FORMAT"))
+		if err != nil {
+			return nil, err
+		}
+		maxSize := intFromEnv("SIEM_FILE_MAX_SIZE_MB", defaultFileMaxSizeMB, 1)
+		maxBackups := intFromEnv("SIEM_FILE_MAX_BACKUPS", defaultFileMaxBackups, 0)
+		sinks = append(sinks, NewFileSink(path, int64(maxSize)<<20, maxBackups, format))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	return NewExporter(intFromEnv("SIEM_BUFFER_SIZE", defaultBufferSize, 1), sinks...), nil
+}
+
+// Publish queues the event for every sink.
+func (e *Exporter) Publish(event Event) {
+	if e == nil {
+		return
+	}
+	for _, queue := range e.queues {
+		select {
+		case queue.events <- event:
+		default:
+			queue.dropped.Add(1)
+		}
+	}
+}
+
+// Sinks describes the configured sinks, for logging at startup.
+func (e *Exporter) Sinks() []string {
+	if e == nil {
+		return nil
+	}
+	names := make([]string, len(e.queues))
+	for i, queue := range e.queues {
+		names[i] = queue.sink.String()
+	}
+	return names
+}
+
+// run writes queued events. A failing sink is logged when it starts failing and when it
+// recovers, not on every event.
+func (q *sinkQueue) run() {
+	failing := false
+	for event := range q.events {
+		if dropped := q.dropped.Swap(0); dropped > 0 {
+			log.Printf("SIEM %s fell behind; dropped %d events", q.sink, dropped)
+		}
+		err := q.sink.Write(event)
+		switch {
+		case err != nil && !failing:
+			log.Printf("SIEM %s is failing, events are being lost: %v", q.sink, err)
+			failing = true
+		case err == nil && failing:
+			log.Printf("SIEM %s recovered", q.sink)
+			failing = false
+		}
+	}
+}
+
+func intFromEnv(name string, fallback, min int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < min {
+		return fallback
+	}
+	return value
+}
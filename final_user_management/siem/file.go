@@ -0,0 +1,86 @@
+package siem
+
+import (
+	"fmt"
+	"os"
+)
+
+// fileSink appends one event per line to a file. When the file would grow past maxSize it is
+// renamed to path.1 (shifting older files up to path.N, N being maxBackups) and a new file is
+// started.
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	format     Formatter
+	file       *os.File
+	size       int64
+}
+
+// NewFileSink writes events to path, encoded by format. The file is opened on first use.
+func NewFileSink(path string, maxSize int64, maxBackups int, format Formatter) Sink {
+	return &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups, format: format}
+}
+
+func (s *fileSink) String() string {
+	return "file " + s.path
+}
+
+func (s *fileSink) Write(event Event) error {
+	line, err := s.format(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	s.file.Close()
+	s.file = nil
+
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", s.path, n) }
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		os.Remove(backup(s.maxBackups))
+		for n := s.maxBackups - 1; n >= 1; n-- {
+			if err := os.Rename(backup(n), backup(n+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, backup(1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.open()
+}
@@ -0,0 +1,100 @@
+package siem
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Formatter encodes an event as the body of one message or line.
+type Formatter func(event Event) ([]byte, error)
+
+const (
+	cefVendor  = "umwaribenie"
+	cefProduct = "final_user_management"
+	cefVersion = "1.0"
+)
+
+// FormatterNamed returns the formatter called "json" or "cef". An empty name means JSON.
+func FormatterNamed(name string) (Formatter, error) {
+	switch strings.ToLower(name) {
+	case "", "json":
+		return JSON, nil
+	case "cef":
+		return CEF, nil
+	default:
+		return nil, fmt.Errorf("unknown SIEM format %q (expected json or cef)", name)
+	}
+}
+
+// JSON encodes the event as a single-line JSON object.
+func JSON(event Event) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// CEF encodes the event in ArcSight Common Event Format. The event type is the signature ID and
+// the fields without a standard CEF key go in the custom string fields cs1 to cs5.
+func CEF(event Event) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeader(cefVendor), cefHeader(cefProduct), cefHeader(cefVersion),
+		cefHeader(event.Type), cefHeader(event.Type+" "+event.Outcome), event.Severity)
+
+	extensions := [][2]string{
+		{"rt", strconv.FormatInt(event.Time.UnixMilli(), 10)},
+		{"externalId", event.ID},
+		{"cat", event.Category},
+		{"act", event.Type},
+		{"outcome", event.Outcome},
+		{"suid", event.ActorID},
+		{"duid", firstNonEmpty(event.UserID, event.TargetID)},
+		{"duser", event.Identifier},
+		{"reason", event.Reason},
+		{"src", event.IP},
+		{"requestClientApplication", event.UserAgent},
+		{"cs1Label", "organizationId"}, {"cs1", event.OrganizationID},
+		{"cs2Label", "requestId"}, {"cs2", event.RequestID},
+		{"cs3Label", "impersonatorId"}, {"cs3", event.ImpersonatorID},
+		{"cs4Label", "targetType"}, {"cs4", event.TargetType},
+		{"cs5Label", "changedFields"}, {"cs5", strings.Join(event.ChangedFields, ",")},
+	}
+	separator := ""
+	for i := 0; i < len(extensions); i++ {
+		key, value := extensions[i][0], extensions[i][1]
+		// A custom field's label is only written when the field itself has a value
+		if strings.HasSuffix(key, "Label") {
+			if extensions[i+1][1] == "" {
+				i++
+				continue
+			}
+		} else if value == "" {
+			continue
+		}
+		b.WriteString(separator + key + "=" + cefExtension(value))
+		separator = " "
+	}
+	return []byte(b.String()), nil
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+)
+
+func cefHeader(value string) string {
+	return cefHeaderEscaper.Replace(value)
+}
+
+func cefExtension(value string) string {
+	return cefExtensionEscaper.Replace(value)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package siem
+
+import (
+	"github.com/umwaribenie/final_user_management/models"
+	"github.com/umwaribenie/final_user_management/repositories"
+
+	"gorm.io/gorm"
+)
+
+// Every security event and audit entry is stored through one of these repositories, so wrapping
+// them exports everything the services record without the services knowing about the SIEM.
+
+type securityEventRepository struct {
+	repositories.SecurityEventRepository
+	exporter *Exporter
+}
+
+// SecurityEvents returns repo, publishing every event it stores to the exporter.
+func SecurityEvents(repo repositories.SecurityEventRepository, exporter *Exporter) repositories.SecurityEventRepository {
+	if exporter == nil {
+		return repo
+	}
+	return &securityEventRepository{repo, exporter}
+}
+
+func (r *securityEventRepository) Create(event *models.SecurityEvent) error {
+	if err := r.SecurityEventRepository.Create(event); err != nil {
+		return err
+	}
+	r.exporter.Publish(FromSecurityEvent(*event))
+	return nil
+}
+
+type auditRepository struct {
+	repositories.AuditRepository
+	exporter *Exporter
+	tx       *gorm.DB
+}
+
+// AuditLog returns repo, publishing every entry appended to it to the exporter.
+func AuditLog(repo repositories.AuditRepository, exporter *Exporter) repositories.AuditRepository {
+	if exporter == nil {
+		return repo
+	}
+	return &auditRepository{repo, exporter, nil}
+}
+
+// Append publishes the entry once it is stored: straight away, or for an entry appended inside
+// a transaction, once that commits. Entries of rolled-back changes are never published.
+func (r *auditRepository) Append(entry *models.AuditEntry) error {
+	if err := r.AuditRepository.Append(entry); err != nil {
+		return err
+	}
+	event := FromAuditEntry(*entry)
+	if r.tx == nil {
+		r.exporter.Publish(event)
+		return nil
+	}
+	repositories.AfterCommit(r.tx, func() { r.exporter.Publish(event) })
+	return nil
+}
+
+func (r *auditRepository) WithTenant(organizationID string) repositories.AuditRepository {
+	return &auditRepository{r.AuditRepository.WithTenant(organizationID), r.exporter, r.tx}
+}
+
+func (r *auditRepository) WithTx(tx *gorm.DB) repositories.AuditRepository {
+	return &auditRepository{r.AuditRepository.WithTx(tx), r.exporter, tx}
+}
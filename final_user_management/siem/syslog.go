@@ -0,0 +1,181 @@
+package siem
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syslogAppName      = "user-management"
+	syslogWriteTimeout = 5 * time.Second
+	// syslogFacility is authpriv, where syslog daemons expect security and authorization messages
+	syslogFacility = 10
+	// syslogSDID names the structured data element; 32473 is the enterprise number reserved for
+	// documentation (RFC 5612)
+	syslogSDID = "event@32473"
+)
+
+// syslogSink sends RFC 5424 messages over UDP, TCP or a unix socket. Over stream connections
+// messages are framed by octet counting (RFC 6587). The connection is opened on first use and
+// reopened after a failed write.
+type syslogSink struct {
+	network  string
+	address  string
+	format   Formatter
+	hostname string
+	conn     net.Conn
+}
+
+// NewSyslogSink parses an address such as udp://siem.example.com:514, tcp://siem:601 or
+// unix:///dev/log. Events are the message bodies, encoded by format.
+func NewSyslogSink(address string, format Formatter) (Sink, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address %q: %w", address, err)
+	}
+	sink := &syslogSink{network: parsed.Scheme, format: format, hostname: "-"}
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		sink.address = parsed.Host
+	case "unix":
+		sink.address = parsed.Path
+	default:
+		return nil, fmt.Errorf("invalid syslog address %q: scheme must be udp, tcp or unix", address)
+	}
+	if sink.address == "" {
+		return nil, fmt.Errorf("invalid syslog address %q: no host or path", address)
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		sink.hostname = hostname
+	}
+	return sink, nil
+}
+
+func (s *syslogSink) String() string {
+	return "syslog " + s.network + "://" + s.address
+}
+
+func (s *syslogSink) Write(event Event) error {
+	body, err := s.format(event)
+	if err != nil {
+		return err
+	}
+	message := s.message(event, body)
+
+	// A connection that failed since the last write is only noticed now, so retry once on a new one
+	for attempt := 0; ; attempt++ {
+		if err = s.send(message); err == nil || attempt == 1 {
+			return err
+		}
+	}
+}
+
+func (s *syslogSink) send(message []byte) error {
+	if s.conn == nil {
+		if err := s.dial(); err != nil {
+			return err
+		}
+	}
+	if s.stream() {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	if _, err := s.conn.Write(message); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects to the collector. Local syslog sockets are usually datagram sockets, so unix
+// addresses try that first, as log/syslog does.
+func (s *syslogSink) dial() error {
+	var err error
+	if s.network == "unix" {
+		if s.conn, err = net.DialTimeout("unixgram", s.address, syslogWriteTimeout); err == nil {
+			return nil
+		}
+	}
+	s.conn, err = net.DialTimeout(s.network, s.address, syslogWriteTimeout)
+	return err
+}
+
+func (s *syslogSink) stream() bool {
+	network := s.conn.LocalAddr().Network()
+	return network == "tcp" || network == "unix"
+}
+
+// message builds "<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG".
+func (s *syslogSink) message(event Event, body []byte) []byte {
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s ",
+		syslogFacility*8+syslogSeverity(event.Severity),
+		event.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(s.hostname, 255), syslogAppName, os.Getpid(), syslogField(event.Type, 32))
+	return append([]byte(header+structuredData(event)+" "), body...)
+}
+
+// syslogSeverity maps the CEF scale to syslog severities, from critical down to informational.
+func syslogSeverity(severity int) int {
+	switch {
+	case severity >= 9:
+		return 2
+	case severity >= 7:
+		return 3
+	case severity >= 5:
+		return 4
+	case severity >= 3:
+		return 5
+	default:
+		return 6
+	}
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// structuredData repeats the fields a collector filters on, so they can be searched without
+// parsing the message body.
+func structuredData(event Event) string {
+	params := [][2]string{
+		{"category", event.Category},
+		{"outcome", event.Outcome},
+		{"organizationId", event.OrganizationID},
+		{"actorId", event.ActorID},
+		{"userId", event.UserID},
+		{"targetId", event.TargetID},
+		{"ip", event.IP},
+		{"requestId", event.RequestID},
+	}
+	var b strings.Builder
+	b.WriteString("[" + syslogSDID)
+	for _, param := range params {
+		if param[1] != "" {
+			b.WriteString(" " + param[0] + `="` + sdValueEscaper.Replace(param[1]) + `"`)
+		}
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// syslogField makes a header field valid: printable ASCII without spaces, at most max long, and
+// "-" when empty.
+func syslogField(value string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, value)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+var deviceTokenKey []byte
+
+// SetDeviceTokenSecret sets the key used to sign long-lived device tokens.
+func SetDeviceTokenSecret(secret string) {
+	deviceTokenKey = []byte(secret)
+}
+
+// NewDeviceToken creates a random device ID and returns it together with its signed token.
+// The token is what the client stores (cookie or X-Device-Token header) and sends back on login.
+func NewDeviceToken() (deviceID string, token string, err error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	deviceID = hex.EncodeToString(raw)
+	return deviceID, deviceID + "." + signDeviceID(deviceID), nil
+}
+
+// VerifyDeviceToken checks the token's signature and returns the device ID it carries.
+func VerifyDeviceToken(token string) (string, bool) {
+	deviceID, signature, found := strings.Cut(token, ".")
+	if !found || deviceID == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(signDeviceID(deviceID))) {
+		return "", false
+	}
+	return deviceID, true
+}
+
+func signDeviceID(deviceID string) string {
+	mac := hmac.New(sha256.New, deviceTokenKey)
+	mac.Write([]byte(deviceID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// IPNetworkPrefix reduces an IP address to the network range used for login recognition:
+// the /24 for IPv4 and the /64 for IPv6. Unparseable input is returned unchanged.
+func IPNetworkPrefix(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
@@ -41,3 +41,30 @@ func SendPasswordResetEmail(config EmailConfig, toEmail string, otp string) erro
 
 	return nil
 }
+
+// SendEmail sends an HTML email with the given subject and body.
+func SendEmail(config EmailConfig, toEmail string, subject string, htmlBody string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", config.FromEmail)
+	m.SetHeader("To", toEmail)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", htmlBody)
+
+	d := gomail.NewDialer(
+		config.SMTPHost,
+		config.SMTPPort,
+		config.SMTPUsername,
+		config.SMTPPassword,
+	)
+
+	if config.SMTPHost == "localhost" {
+		d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	if err := d.DialAndSend(m); err != nil {
+		log.Printf("Failed to send email: %v", err)
+		return err
+	}
+
+	return nil
+}
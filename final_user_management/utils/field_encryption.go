@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// fieldCiphertextPrefix marks values written by EncryptField. Stored values without it
+// are legacy plaintext and are returned unchanged by DecryptField.
+const fieldCiphertextPrefix = "enc:v1:"
+
+// ErrFieldKeysNotLoaded is returned when field encryption is used before SetFieldKeyring.
+var ErrFieldKeysNotLoaded = errors.New("field encryption keys are not loaded")
+
+// FieldKeyLoader fetches a data key this process does not know yet, e.g. one created by
+// a key rotation on another instance.
+type FieldKeyLoader func(keyID string) ([]byte, error)
+
+var (
+	fieldKeysMu    sync.RWMutex
+	fieldActiveKey string
+	fieldDataKeys  map[string][]byte
+	fieldIndexKey  []byte
+	fieldKeyLoader FieldKeyLoader
+)
+
+// SetFieldKeyring installs the unwrapped data keys used for field encryption. New values are
+// encrypted with activeKeyID; any key in dataKeys can decrypt. indexKey keys the blind index.
+func SetFieldKeyring(activeKeyID string, dataKeys map[string][]byte, indexKey []byte, loader FieldKeyLoader) {
+	keys := make(map[string][]byte, len(dataKeys))
+	for id, key := range dataKeys {
+		keys[id] = key
+	}
+	fieldKeysMu.Lock()
+	defer fieldKeysMu.Unlock()
+	fieldActiveKey = activeKeyID
+	fieldDataKeys = keys
+	fieldIndexKey = indexKey
+	fieldKeyLoader = loader
+}
+
+// ActiveFieldKeyID returns the ID of the data key new values are encrypted with.
+func ActiveFieldKeyID() string {
+	fieldKeysMu.RLock()
+	defer fieldKeysMu.RUnlock()
+	return fieldActiveKey
+}
+
+// FieldCiphertextPrefix is the prefix of every value encrypted with the given data key.
+func FieldCiphertextPrefix(keyID string) string {
+	return fieldCiphertextPrefix + keyID + ":"
+}
+
+// EncryptField encrypts a value with the active data key using AES-GCM.
+// The result is "enc:v1:<key id>:<base64 nonce+ciphertext>".
+func EncryptField(plaintext string) (string, error) {
+	fieldKeysMu.RLock()
+	keyID, key := fieldActiveKey, fieldDataKeys[fieldActiveKey]
+	fieldKeysMu.RUnlock()
+	if key == nil {
+		return "", ErrFieldKeysNotLoaded
+	}
+
+	sealed, err := sealAESGCM(key, []byte(plaintext), []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	return FieldCiphertextPrefix(keyID) + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptField reverses EncryptField. Values that were never encrypted are returned as they are.
+func DecryptField(stored string) (string, error) {
+	if !strings.HasPrefix(stored, fieldCiphertextPrefix) {
+		return stored, nil
+	}
+	keyID, encoded, found := strings.Cut(strings.TrimPrefix(stored, fieldCiphertextPrefix), ":")
+	if !found {
+		return "", errors.New("malformed encrypted field")
+	}
+	key, err := fieldDataKey(keyID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted field: %w", err)
+	}
+	plaintext, err := openAESGCM(key, sealed, []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func fieldDataKey(keyID string) ([]byte, error) {
+	fieldKeysMu.RLock()
+	key, loader := fieldDataKeys[keyID], fieldKeyLoader
+	fieldKeysMu.RUnlock()
+	if key != nil {
+		return key, nil
+	}
+	if loader == nil {
+		return nil, fmt.Errorf("unknown field encryption key %s", keyID)
+	}
+
+	key, err := loader(keyID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown field encryption key %s: %w", keyID, err)
+	}
+	fieldKeysMu.Lock()
+	fieldDataKeys[keyID] = key
+	fieldKeysMu.Unlock()
+	return key, nil
+}
+
+// BlindIndex returns a keyed HMAC of the normalized value, so encrypted columns can still be
+// checked for uniqueness and searched by exact match.
+func BlindIndex(value string) (string, error) {
+	fieldKeysMu.RLock()
+	key := fieldIndexKey
+	fieldKeysMu.RUnlock()
+	if key == nil {
+		return "", ErrFieldKeysNotLoaded
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.ToUpper(strings.TrimSpace(value))))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// GenerateDataKey returns a new random 256-bit key.
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapDataKey encrypts a data key with the master key so it can be stored in the database.
+func WrapDataKey(masterKey, dataKey []byte) ([]byte, error) {
+	return sealAESGCM(masterKey, dataKey, nil)
+}
+
+// UnwrapDataKey reverses WrapDataKey.
+func UnwrapDataKey(masterKey, wrapped []byte) ([]byte, error) {
+	return openAESGCM(masterKey, wrapped, nil)
+}
+
+// MasterKeyID is a short fingerprint identifying which master key wrapped a data key.
+func MasterKeyID(masterKey []byte) string {
+	sum := sha256.Sum256(masterKey)
+	return hex.EncodeToString(sum[:8])
+}
+
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
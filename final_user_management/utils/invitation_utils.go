@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// GenerateInvitationToken returns a new invitation token, sent to the invitee in their link, and
+// the hash it is stored and looked up by.
+func GenerateInvitationToken() (token string, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(secret)
+	return token, HashInvitationToken(token), nil
+}
+
+// HashInvitationToken returns the hex SHA-256 of an invitation token. The tokens are random, so
+// an unsalted hash is enough to keep a database leak from exposing them.
+func HashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
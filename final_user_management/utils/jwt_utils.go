@@ -11,27 +11,80 @@ var jwtKey []byte
 
 // Claims defines the JWT claims, including user-specific data and standard claims.
 type Claims struct {
-	UserID   string `json:"userId"`
+	UserID string `json:"userId"`
+	// OrgID is the organization (tenant) the user belongs to. Requests made with the token
+	// are served from that organization.
+	OrgID    string `json:"org"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	// Perms lists the permissions granted by all of the user's roles when the token was issued.
+	Perms []string     `json:"perms,omitempty"`
+	Act   *ActorClaims `json:"act,omitempty"`
+	// AuthTime is when the user last actively authenticated (OIDC "auth_time"), and AMR lists
+	// the methods used to do so (RFC 8176 "amr"). Both are refreshed by re-authentication.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	AMR      []string         `json:"amr,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// Authentication method references carried in the "amr" claim.
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRWebAuthn = "webauthn"
+)
+
+// ActorClaims identifies the admin acting on behalf of the token's user (RFC 8693 "act" claim).
+// It is only present on impersonation tokens.
+type ActorClaims struct {
+	Sub      string `json:"sub"`
+	Username string `json:"username"`
+}
+
 // SetJWTSecret sets the JWT secret key from an external source (like an environment variable).
 func SetJWTSecret(secret string) {
 	jwtKey = []byte(secret)
 }
 
-// GenerateJWT creates a new JWT token for a given user.
-func GenerateJWT(userID, username, role string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token expires in 24 hours
+// TokenLifetime is how long an access token (and its server-side session) stays valid.
+const TokenLifetime = 24 * time.Hour
+
+// GenerateJWT creates a new JWT token for a given user of an organization, bound to the given session ID ("jti")
+// and expiring with it. authTime and amr record when and how the user last proved their identity.
+func GenerateJWT(userID, orgID, username, role string, perms []string, sessionID string, expiresAt, authTime time.Time, amr []string) (string, error) {
+	claims := &Claims{
+		UserID:   userID,
+		OrgID:    orgID,
+		Username: username,
+		Role:     role,
+		Perms:    perms,
+		AuthTime: jwt.NewNumericDate(authTime),
+		AMR:      amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtKey)
+}
 
+// GenerateImpersonationJWT creates a short-lived token for the target user that also carries
+// the acting admin in the "act" claim. It has no auth_time, so it never satisfies step-up checks.
+func GenerateImpersonationJWT(userID, orgID, username, role string, perms []string, sessionID, actorID, actorUsername string, lifetime time.Duration) (string, error) {
 	claims := &Claims{
 		UserID:   userID,
+		OrgID:    orgID,
 		Username: username,
 		Role:     role,
+		Perms:    perms,
+		Act:      &ActorClaims{Sub: actorID, Username: actorUsername},
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        sessionID,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(lifetime)),
 		},
 	}
 
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// GenerateScimToken returns a new SCIM bearer token and the hash it is stored and looked up by.
+func GenerateScimToken() (token string, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = "scim_" + hex.EncodeToString(secret)
+	return token, HashScimToken(token), nil
+}
+
+// HashScimToken returns the hex SHA-256 of a SCIM token. The tokens are random, so an unsalted
+// hash is enough to keep a database leak from exposing them.
+func HashScimToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
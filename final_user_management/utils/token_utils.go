@@ -6,32 +6,87 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// This secret should be different (and rotated independently) from your auth JWT secret.
-var passwordResetSecret = []byte("your-password-reset-secret-here")
+// passwordResetSecret signs the tokens sent in email links. It should be different (and rotated
+// independently) from the auth JWT secret.
+var passwordResetSecret []byte
 
-// GeneratePasswordResetToken issues a JWT whose subject is the user's email,
-// expiring in 1 hour. You embed this in the reset link.
-func GeneratePasswordResetToken(email string) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Subject:   email,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
+// SetPasswordResetSecret sets the key used to sign password reset and security alert tokens.
+func SetPasswordResetSecret(secret string) {
+	passwordResetSecret = []byte(secret)
+}
+
+// Token types carried in the "typ" claim, so that a token issued for one link cannot be used
+// for another.
+const (
+	passwordResetTokenType = "password-reset"
+	securityAlertTokenType = "security-alert"
+)
+
+// linkClaims are the claims of a token embedded in an email link. OrganizationID is the tenant
+// of the user the link was sent to, whose email and username are only unique within it.
+type linkClaims struct {
+	Type           string `json:"typ"`
+	OrganizationID string `json:"org"`
+	jwt.RegisteredClaims
+}
+
+func signLinkToken(tokenType string, organizationID string, subject string, lifetime time.Duration) (string, error) {
+	claims := linkClaims{
+		Type:           tokenType,
+		OrganizationID: organizationID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{tokenType},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(lifetime)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(passwordResetSecret)
 }
 
-// VerifyPasswordResetToken checks the token and returns the email if valid.
-func VerifyPasswordResetToken(tokenString string) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+func verifyLinkToken(tokenType string, tokenString string) (*linkClaims, error) {
+	claims := &linkClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		return passwordResetSecret, nil
-	})
+	}, jwt.WithAudience(tokenType), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.Type != tokenType || claims.OrganizationID == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// GeneratePasswordResetToken issues a JWT whose subject is the user's email in the organization,
+// expiring in 1 hour. You embed this in the reset link.
+func GeneratePasswordResetToken(organizationID string, email string) (string, error) {
+	return signLinkToken(passwordResetTokenType, organizationID, email, time.Hour)
+}
+
+// VerifyPasswordResetToken checks the token and returns the organization and email if valid.
+func VerifyPasswordResetToken(tokenString string) (string, string, error) {
+	claims, err := verifyLinkToken(passwordResetTokenType, tokenString)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok && token.Valid {
-		return claims.Subject, nil
+	return claims.OrganizationID, claims.Subject, nil
+}
+
+// GenerateSecurityAlertToken issues the token embedded in a new-device notification's
+// "this wasn't me" link. Its subject is the user ID and it expires in 7 days.
+func GenerateSecurityAlertToken(organizationID string, userID string) (string, error) {
+	return signLinkToken(securityAlertTokenType, organizationID, userID, 7*24*time.Hour)
+}
+
+// VerifySecurityAlertToken checks a "this wasn't me" token and returns the organization and
+// user ID if valid.
+func VerifySecurityAlertToken(tokenString string) (string, string, error) {
+	claims, err := verifyLinkToken(securityAlertTokenType, tokenString)
+	if err != nil {
+		return "", "", err
 	}
-	return "", jwt.ErrTokenInvalidClaims
+	return claims.OrganizationID, claims.Subject, nil
 }